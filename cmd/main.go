@@ -1,23 +1,122 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	// "database/sql"
+	"flag"
+	"fmt"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/bmg-c/product-diary/db"
+	"github.com/bmg-c/product-diary/db/currency_db"
 	"github.com/bmg-c/product-diary/db/item_db"
+	"github.com/bmg-c/product-diary/db/ledger_db"
 	"github.com/bmg-c/product-diary/db/product_db"
+	"github.com/bmg-c/product-diary/db/ratelimit_db"
+	"github.com/bmg-c/product-diary/db/tx_db"
 	"github.com/bmg-c/product-diary/db/user_db"
 	"github.com/bmg-c/product-diary/handlers"
 	"github.com/bmg-c/product-diary/logger"
+	"github.com/bmg-c/product-diary/mailer"
 	"github.com/bmg-c/product-diary/middleware"
+	"github.com/bmg-c/product-diary/ratelimit"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
 	"github.com/bmg-c/product-diary/services"
 	"github.com/bmg-c/product-diary/tests"
 	// "github.com/mattn/go-sqlite3"
 )
 
 func main() {
+	queryTimeout := flag.Duration("query-timeout", 5*time.Second,
+		"Maximum duration of a single database query, 0 disables the limit")
+	mailerKind := flag.String("mailer", "log",
+		"Mail delivery: \"smtp\", \"file\" (writes .eml files) or \"log\"")
+	mailFrom := flag.String("mail-from", "noreply@product-diary.local", "Sender address of mails")
+	mailDir := flag.String("mail-dir", "mail", "Directory of the file mailer")
+	smtpAddr := flag.String("smtp-addr", "localhost:25", "SMTP server address, host:port")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username, empty disables authentication")
+	sessionLifetime := flag.Duration("session-lifetime", services.DefaultSessionLifetime,
+		"Absolute lifetime of a login session")
+	sessionIdleTimeout := flag.Duration("session-idle-timeout", services.DefaultSessionIdleTimeout,
+		"Login sessions unused for this long expire, 0 disables the limit")
+	publicURL := flag.String("public-url", services.DefaultPublicURL,
+		"Scheme and host the site is reached at, used for links in mails")
+	rateLimitStore := flag.String("rate-limit-store", "memory",
+		"Where rate limits are kept: \"memory\" or \"sqlite\" (shared and kept across restarts)")
+	grantAdmin := flag.String("grant-admin", "",
+		"Email of a user to make admin at startup, for setting up the first admin")
+	flag.Parse()
+
 	err := tests.TestValidation()
+	if err == nil {
+		err = tests.TestTransactions()
+	}
+	if err == nil {
+		err = tests.TestMigrations()
+	}
+	if err == nil {
+		err = tests.TestSignin()
+	}
+	if err == nil {
+		err = tests.TestSessions()
+	}
+	if err == nil {
+		err = tests.TestAuthentication()
+	}
+	if err == nil {
+		err = tests.TestCSRF()
+	}
+	if err == nil {
+		err = tests.TestRateLimit()
+	}
+	if err == nil {
+		err = tests.TestPasswordReset()
+	}
+	if err == nil {
+		err = tests.TestProfile()
+	}
+	if err == nil {
+		err = tests.TestTOTP()
+	}
+	if err == nil {
+		err = tests.TestAPITokens()
+	}
+	if err == nil {
+		err = tests.TestRoles()
+	}
+	if err == nil {
+		err = tests.TestProductRevisions()
+	}
+	if err == nil {
+		err = tests.TestProductDetails()
+	}
+	if err == nil {
+		err = tests.TestDuplicateProducts()
+	}
+	if err == nil {
+		err = tests.TestSearch()
+	}
+	if err == nil {
+		err = tests.TestPagination()
+	}
+	if err == nil {
+		err = tests.TestUnits()
+	}
+	if err == nil {
+		err = tests.TestPrices()
+	}
+	if err == nil {
+		err = tests.TestCurrencies()
+	}
+	if err == nil {
+		err = tests.TestLedger()
+	}
+	if err == nil {
+		err = tests.TestSplits()
+	}
 	if err != nil {
 		logger.Error.Println(err.Error())
 	} else {
@@ -26,156 +125,313 @@ func main() {
 
 	router := http.NewServeMux()
 
-	userStore, err := db.NewStore("database.db", "users",
-		`CREATE TABLE IF NOT EXISTS users (
-        user_id INTEGER PRIMARY KEY AUTOINCREMENT,
-        username VARCHAR(64) NOT NULL,
-        email VARCHAR(255) NOT NULL UNIQUE,
-        password VARCHAR(255) NOT NULL,
-        created_at DATETIME default (datetime('now'))
-    );`)
+	database, err := db.NewDatabase("database.db", *queryTimeout)
+	if err != nil {
+		logger.Error.Println("Error opening database: " + err.Error())
+		panic(err.Error())
+	}
+	defer database.Close()
+
+	err = database.Migrate()
+	if err != nil {
+		logger.Error.Println("Error migrating database: " + err.Error())
+		panic(err.Error())
+	} else {
+		logger.Info.Println("Database schema is up to date")
+	}
+
+	userStore, err := database.NewStore("users")
 	if err != nil {
 		logger.Error.Println("Error creating user store: " + err.Error())
 		panic(err.Error())
 	} else {
 		logger.Info.Println("Successfully connected user store")
 	}
-	codeStore, err := db.NewStore("database.db", "codes",
-		`CREATE TABLE IF NOT EXISTS codes (
-        code_id INTEGER PRIMARY KEY AUTOINCREMENT,
-        email VARCHAR(255) NOT NULL UNIQUE,
-        code VARCHAR(6),
-        created_at DATETIME default (datetime('now'))
-    );`)
+	codeStore, err := database.NewStore("codes")
 	if err != nil {
 		logger.Error.Println("Error creating code store: " + err.Error())
 		panic(err.Error())
 	} else {
 		logger.Info.Println("Successfully connected code store")
 	}
-	sessionStore, err := db.NewStore("database.db", "sessions",
-		`CREATE TABLE IF NOT EXISTS sessions (
-        session_uuid VARCHAR(32) NOT NULL UNIQUE,
-        user_id INTEGER NOT NULL,
-        FOREIGN KEY (user_id) REFERENCES `+userStore.TableName+` (user_id) ON DELETE RESTRICT
-    );`)
+	sessionStore, err := database.NewStore("sessions")
 	if err != nil {
 		logger.Error.Println("Error creating session store: " + err.Error())
 		panic(err.Error())
 	} else {
 		logger.Info.Println("Successfully connected session store")
 	}
-	personStore, err := db.NewStore("database.db", "persons",
-		`CREATE TABLE IF NOT EXISTS persons (
-        person_id INTEGER PRIMARY KEY AUTOINCREMENT,
-        user_id INTEGER NOT NULL,
-        person_name VARCHAR(64) NOT NULL,
-        is_hidden INTEGER NOT NULL DEFAULT FALSE,
-        FOREIGN KEY (user_id) REFERENCES `+userStore.TableName+` (user_id) ON DELETE RESTRICT,
-        UNIQUE(user_id, person_name)
-    );`)
+	personStore, err := database.NewStore("persons")
 	if err != nil {
 		logger.Error.Println("Error creating person store: " + err.Error())
 		panic(err.Error())
 	} else {
 		logger.Info.Println("Successfully connected person store")
 	}
-	udb, err := user_db.NewUserDB(userStore, codeStore, sessionStore, personStore)
+	resetStore, err := database.NewStore("password_resets")
+	if err != nil {
+		logger.Error.Println("Error creating password reset store: " + err.Error())
+		panic(err.Error())
+	} else {
+		logger.Info.Println("Successfully connected password reset store")
+	}
+	totpStore, err := database.NewStore("totp_secrets")
+	if err != nil {
+		logger.Error.Println("Error creating TOTP store: " + err.Error())
+		panic(err.Error())
+	} else {
+		logger.Info.Println("Successfully connected TOTP store")
+	}
+	recoveryStore, err := database.NewStore("recovery_codes")
+	if err != nil {
+		logger.Error.Println("Error creating recovery code store: " + err.Error())
+		panic(err.Error())
+	} else {
+		logger.Info.Println("Successfully connected recovery code store")
+	}
+	apiTokenStore, err := database.NewStore("api_tokens")
+	if err != nil {
+		logger.Error.Println("Error creating API token store: " + err.Error())
+		panic(err.Error())
+	} else {
+		logger.Info.Println("Successfully connected API token store")
+	}
+	udb, err := user_db.NewUserDB(userStore, codeStore, sessionStore, personStore, resetStore, totpStore, recoveryStore,
+		apiTokenStore)
 	if err != nil {
 		logger.Error.Println("Error creating user database layer: " + err.Error())
 	}
-	us := services.NewUserService(udb)
-	uh := handlers.NewUserHandler(us)
-	router.HandleFunc("GET /users", uh.HandleUsersPage)
-	router.HandleFunc("GET /api/users/controls/index", uh.HandleControlsIndex)
-	router.HandleFunc("GET /api/users/userlist/index", uh.HandleGetUsersAll)
-	router.HandleFunc("GET /api/users/user/index", uh.HandleUserIndex)
-	router.HandleFunc("POST /api/users/user/getuser", uh.HandleGetUser)
-	router.HandleFunc("GET /api/users/signin/index", uh.HandleSigninIndex)
-	router.HandleFunc("POST /api/users/signin/signin", uh.HandleSigninSignin)
-	router.HandleFunc("GET /api/users/login/index", uh.HandleLoginIndex)
-	router.HandleFunc("POST /api/users/login/login", uh.HandleLoginLogin)
-	router.HandleFunc("GET /api/users/profile/index", uh.HandleProfileIndex)
-	router.HandleFunc("POST /api/users/logout/logout", uh.HandleLogout)
-	router.HandleFunc("POST /api/users/person/togglehidden", uh.HandleTogglePerson)
-	router.HandleFunc("POST /api/users/person/addperson", uh.HandleAddPerson)
-
-	productStore, err := db.NewStore("database.db", "products",
-		`CREATE TABLE IF NOT EXISTS products (
-        product_id INTEGER PRIMARY KEY AUTOINCREMENT,
-        product_title VARCHAR(128) NOT NULL,
-        product_calories REAL DEFAULT 0,
-        product_fats REAL DEFAULT 0,
-        product_carbs REAL DEFAULT 0,
-        product_proteins REAL DEFAULT 0,
-        user_id INTEGER NOT NULL,
-        is_deleted INTEGER NOT NULL DEFAULT FALSE,
-        CHECK (product_fats + product_carbs + product_proteins <= 100),
-        CHECK (length(product_title) >= 4 AND length(product_title) <= 128),
-        FOREIGN KEY (user_id) REFERENCES `+userStore.TableName+` (user_id) ON DELETE RESTRICT
-    );`)
+	var mail services.Mailer
+	switch *mailerKind {
+	case "smtp":
+		// The password is read from the environment to keep it out of the
+		// process list.
+		mail, err = mailer.NewSMTPMailer(*smtpAddr, *mailFrom, *smtpUsername, os.Getenv("SMTP_PASSWORD"))
+	case "file":
+		mail, err = mailer.NewFileMailer(*mailDir, *mailFrom)
+	case "log":
+		mail = mailer.NewLogMailer()
+	default:
+		err = fmt.Errorf("Unknown mailer %s", *mailerKind)
+	}
+	if err != nil {
+		logger.Error.Println("Error creating mailer: " + err.Error())
+		panic(err.Error())
+	} else {
+		logger.Info.Println("Using " + *mailerKind + " mailer")
+	}
+	if *grantAdmin != "" {
+		userDB, err := udb.GetUser(context.Background(), user_schemas.GetUser{Email: *grantAdmin})
+		if err == nil {
+			err = udb.SetUserRole(context.Background(), user_schemas.SetUserRole{UserID: userDB.UserID, Role: user_schemas.RoleAdmin})
+		}
+		if err != nil {
+			logger.Error.Printf("Error granting admin role to %s: %v", *grantAdmin, err)
+		} else {
+			logger.Info.Println("Granted admin role to " + *grantAdmin)
+		}
+	}
+	productStore, err := database.NewStore("products")
 	if err != nil {
 		logger.Error.Println("Error creating product store: " + err.Error())
 		panic(err.Error())
 	} else {
 		logger.Info.Println("Successfully connected product store")
 	}
-	pdb, err := product_db.NewProductDB(productStore)
+	revisionStore, err := database.NewStore("product_revisions")
+	if err != nil {
+		logger.Error.Println("Error creating product revision store: " + err.Error())
+		panic(err.Error())
+	} else {
+		logger.Info.Println("Successfully connected product revision store")
+	}
+	searchStore, err := database.NewStore("product_search")
+	if err != nil {
+		logger.Error.Println("Error creating product search store: " + err.Error())
+		panic(err.Error())
+	} else {
+		logger.Info.Println("Successfully connected product search store")
+	}
+	pdb, err := product_db.NewProductDB(productStore, revisionStore, userStore, searchStore)
 	if err != nil {
 		logger.Error.Println("Error creating product database layer: " + err.Error())
 	}
-	ps := services.NewProductService(pdb)
-	ph := handlers.NewProductHandler(ps, us)
-	router.HandleFunc("GET /products", ph.HandleProductsPage)
-	router.HandleFunc("POST /api/products/addproduct", ph.HandleAddProduct)
-	router.HandleFunc("POST /api/products/getproducts", ph.HandleGetProducts)
-	router.HandleFunc("POST /api/products/copyproduct", ph.HandleCopyProduct)
-	router.HandleFunc("POST /api/products/deleteproduct", ph.HandleDeleteProduct)
-
-	itemStore, err := db.NewStore("database.db", "items",
-		`CREATE TABLE IF NOT EXISTS items (
-        item_id INTEGER PRIMARY KEY AUTOINCREMENT,
-        user_id INTEGER NOT NULL,
-        product_id INTEGER NOT NULL,
-        item_date DATE NOT NULL,
-        item_cost REAL DEFAULT 0,
-        item_amount REAL DEFAULT 0,
-        item_type INTEGER NOT NULL DEFAULT 1,
-        person_id INTEGER DEFAULT NULL,
-        CHECK (item_type >= 1 AND item_type <= 3),
-        CHECK (item_cost >= 0),
-        CHECK (item_amount >= 0),
-        FOREIGN KEY (user_id) REFERENCES `+userStore.TableName+` (user_id) ON DELETE RESTRICT,
-        FOREIGN KEY (product_id) REFERENCES `+productStore.TableName+` (product_id) ON DELETE RESTRICT,
-        FOREIGN KEY (person_id) REFERENCES `+personStore.TableName+` (person_id) ON DELETE RESTRICT
-    );`)
+	itemStore, err := database.NewStore("items")
 	if err != nil {
 		logger.Error.Println("Error creating item store: " + err.Error())
 		panic(err.Error())
 	} else {
 		logger.Info.Println("Successfully connected item store")
 	}
-	idb, err := item_db.NewItemDB(itemStore, productStore, personStore)
+	splitStore, err := database.NewStore("item_splits")
+	if err != nil {
+		logger.Error.Println("Error creating item split store: " + err.Error())
+		panic(err.Error())
+	} else {
+		logger.Info.Println("Successfully connected item split store")
+	}
+	idb, err := item_db.NewItemDB(itemStore, revisionStore, personStore, searchStore, splitStore)
 	if err != nil {
 		logger.Error.Println("Error creating item database layer: " + err.Error())
 	}
-	is := services.NewItemService(idb)
+	rateStore, err := database.NewStore("currency_rates")
+	if err != nil {
+		logger.Error.Println("Error creating currency rate store: " + err.Error())
+		panic(err.Error())
+	} else {
+		logger.Info.Println("Successfully connected currency rate store")
+	}
+	cdb, err := currency_db.NewCurrencyDB(rateStore)
+	if err != nil {
+		logger.Error.Println("Error creating currency database layer: " + err.Error())
+	}
+	paymentStore, err := database.NewStore("payments")
+	if err != nil {
+		logger.Error.Println("Error creating payment store: " + err.Error())
+		panic(err.Error())
+	} else {
+		logger.Info.Println("Successfully connected payment store")
+	}
+	ldb, err := ledger_db.NewLedgerDB(paymentStore, personStore)
+	if err != nil {
+		logger.Error.Println("Error creating ledger database layer: " + err.Error())
+	}
+	tdb, err := tx_db.NewTxDB(database, udb, pdb, idb, cdb, ldb)
+	if err != nil {
+		logger.Error.Println("Error creating transaction database layer: " + err.Error())
+	}
+	us := services.NewUserService(udb, tdb, mail)
+	us.SessionLifetime = *sessionLifetime
+	us.SessionIdleTimeout = *sessionIdleTimeout
+	us.PublicURL = *publicURL
+	var limitStore ratelimit.Store
+	switch *rateLimitStore {
+	case "memory":
+		limitStore = ratelimit.NewMemoryStore()
+	case "sqlite":
+		var rateLimitTable, lockoutTable *db.Store
+		rateLimitTable, err = database.NewStore("rate_limits")
+		if err == nil {
+			lockoutTable, err = database.NewStore("lockouts")
+		}
+		if err == nil {
+			limitStore, err = ratelimit_db.NewRateLimitDB(rateLimitTable, lockoutTable)
+		}
+	default:
+		err = fmt.Errorf("Unknown rate limit store %s", *rateLimitStore)
+	}
+	if err != nil {
+		logger.Error.Println("Error creating rate limit store: " + err.Error())
+		panic(err.Error())
+	} else {
+		logger.Info.Println("Keeping rate limits in " + *rateLimitStore)
+	}
+	lockoutPolicy := ratelimit.LockoutPolicy{
+		Threshold: 5,
+		Base:      time.Minute,
+		Max:       time.Hour,
+		Reset:     24 * time.Hour,
+	}
+	loginLimiter := ratelimit.NewGuard(limitStore, ratelimit.Limit{Burst: 10, Every: time.Minute}, lockoutPolicy)
+	signinLimiter := ratelimit.NewGuard(limitStore, ratelimit.Limit{Burst: 5, Every: 2 * time.Minute}, lockoutPolicy)
+	uh := handlers.NewUserHandler(us, loginLimiter, signinLimiter)
+	router.HandleFunc("GET /users", uh.HandleUsersPage)
+	router.HandleFunc("GET /api/users/controls/index", middleware.OptionalUser(uh.HandleControlsIndex))
+	router.HandleFunc("GET /api/users/userlist/index", middleware.RequirePermission(user_schemas.PermManageUsers, uh.HandleGetUsersAll))
+	router.HandleFunc("GET /api/users/user/index", middleware.RequirePermission(user_schemas.PermManageUsers, uh.HandleUserIndex))
+	router.HandleFunc("POST /api/users/user/getuser", middleware.RequirePermission(user_schemas.PermManageUsers, uh.HandleGetUser))
+	router.HandleFunc("POST /api/users/admin/setrole", middleware.RequirePermission(user_schemas.PermManageUsers, uh.HandleSetUserRole))
+	router.HandleFunc("POST /api/users/admin/setdisabled", middleware.RequirePermission(user_schemas.PermManageUsers, uh.HandleSetUserDisabled))
+	router.HandleFunc("POST /api/users/admin/logout", middleware.RequirePermission(user_schemas.PermManageUsers, uh.HandleForceLogout))
+	router.HandleFunc("GET /api/users/signin/index", uh.HandleSigninIndex)
+	router.HandleFunc("POST /api/users/signin/signin", uh.HandleSigninSignin)
+	router.HandleFunc("GET /api/users/login/index", uh.HandleLoginIndex)
+	router.HandleFunc("POST /api/users/login/login", uh.HandleLoginLogin)
+	router.HandleFunc("POST /api/users/login/2fa", uh.HandleLogin2FA)
+	router.HandleFunc("GET /api/users/reset/index", uh.HandlePasswordResetIndex)
+	router.HandleFunc("POST /api/users/reset/request", uh.HandlePasswordResetRequest)
+	router.HandleFunc("GET "+services.PasswordResetPath, uh.HandlePasswordResetPage)
+	router.HandleFunc("POST /api/users/reset/reset", uh.HandlePasswordResetReset)
+	router.HandleFunc("GET /api/users/profile/index", middleware.RequireUser(uh.HandleProfileIndex))
+	router.HandleFunc("POST /api/users/logout/logout", middleware.OptionalUser(uh.HandleLogout))
+	router.HandleFunc("POST /api/users/profile/username", middleware.RequireUser(uh.HandleUpdateUsername))
+	router.HandleFunc("POST /api/users/profile/currency", middleware.RequireUser(uh.HandleSetBaseCurrency))
+	router.HandleFunc("POST /api/users/profile/email", middleware.RequireUser(uh.HandleChangeEmail))
+	router.HandleFunc("POST /api/users/profile/password", middleware.RequireUser(uh.HandleChangePassword))
+	router.HandleFunc("POST /api/users/2fa/begin", middleware.RequireUser(uh.HandleBeginTOTP))
+	router.HandleFunc("POST /api/users/2fa/confirm", middleware.RequireUser(uh.HandleConfirmTOTP))
+	router.HandleFunc("POST /api/users/2fa/recovery", middleware.RequireUser(uh.HandleRegenerateRecoveryCodes))
+	router.HandleFunc("POST /api/users/2fa/disable", middleware.RequireUser(uh.HandleDisableTOTP))
+	router.HandleFunc("POST /api/users/token/create", middleware.RequireUser(uh.HandleCreateAPIToken))
+	router.HandleFunc("POST /api/users/token/revoke", middleware.RequireUser(uh.HandleRevokeAPIToken))
+	router.HandleFunc("POST /api/users/session/revoke", middleware.RequireUser(uh.HandleRevokeSession))
+	router.HandleFunc("POST /api/users/session/revokeall", middleware.RequireUser(uh.HandleLogoutEverywhere))
+	router.HandleFunc("POST /api/users/person/togglehidden", middleware.RequireUser(uh.HandleTogglePerson))
+	router.HandleFunc("POST /api/users/person/addperson", middleware.RequireUser(uh.HandleAddPerson))
+
+	ps := services.NewProductService(pdb)
+	ph := handlers.NewProductHandler(ps, us)
+	router.HandleFunc("GET /products", ph.HandleProductsPage)
+	router.HandleFunc("POST /api/products/addproduct", middleware.RequireScope(user_schemas.ScopeProductsWrite, ph.HandleAddProduct))
+	router.HandleFunc("POST /api/products/getproducts", middleware.OptionalUser(ph.HandleGetProducts))
+	router.HandleFunc("POST /api/products/copyproduct", middleware.OptionalUser(ph.HandleCopyProduct))
+	router.HandleFunc("POST /api/products/addrow", ph.HandleProductAddRow)
+	router.HandleFunc("POST /api/products/getproduct", middleware.OptionalUser(ph.HandleGetProduct))
+	router.HandleFunc("POST /api/products/editproduct", middleware.RequireScope(user_schemas.ScopeProductsWrite, ph.HandleEditProduct))
+	router.HandleFunc("POST /api/products/changeproduct", middleware.RequireScope(user_schemas.ScopeProductsWrite, ph.HandleChangeProduct))
+	router.HandleFunc("POST /api/products/history", middleware.OptionalUser(ph.HandleProductHistory))
+	router.HandleFunc("POST /api/products/diff", middleware.OptionalUser(ph.HandleRevisionDiff))
+	router.HandleFunc("POST /api/products/sethidden", middleware.RequirePermission(user_schemas.PermModerateProducts, ph.HandleSetProductHidden))
+	router.HandleFunc("POST /api/products/deleteproduct", middleware.RequireScope(user_schemas.ScopeProductsWrite, ph.HandleDeleteProduct))
+
+	is := services.NewItemService(idb, cdb, tdb)
 	ih := handlers.NewItemHandler(is, us)
 	router.HandleFunc("GET /analytics", ih.HandleAnalyticsPage)
-	router.HandleFunc("POST /api/items/getitems", ih.HandleGetItems)
-	router.HandleFunc("POST /api/items/additem", ih.HandleAddItem)
-	router.HandleFunc("POST /api/items/deleteitem", ih.HandleDeleteItem)
-	router.HandleFunc("POST /api/items/changeitem", ih.HandleChangeItem)
-	router.HandleFunc("POST /api/items/getanalyticsrange", ih.HandleGetAnalyticsRange)
+	router.HandleFunc("POST /api/items/getitems", middleware.RequireScope(user_schemas.ScopeItemsRead, ih.HandleGetItems))
+	router.HandleFunc("POST /api/items/additem", middleware.RequireScope(user_schemas.ScopeItemsWrite, ih.HandleAddItem))
+	router.HandleFunc("POST /api/items/deleteitem", middleware.RequireScope(user_schemas.ScopeItemsWrite, ih.HandleDeleteItem))
+	router.HandleFunc("POST /api/items/changeitem", middleware.RequireScope(user_schemas.ScopeItemsWrite, ih.HandleChangeItem))
+	router.HandleFunc("POST /api/items/setsplits", middleware.RequireScope(user_schemas.ScopeItemsWrite, ih.HandleSetItemSplits))
+	router.HandleFunc("POST /api/items/getanalyticsrange", middleware.RequireScope(user_schemas.ScopeItemsRead, ih.HandleGetAnalyticsRange))
+
+	cs := services.NewCurrencyService(cdb, tdb)
+	ch := handlers.NewCurrencyHandler(cs)
+	router.HandleFunc("POST /api/currencies/getrates", middleware.RequireScope(user_schemas.ScopeItemsRead, ch.HandleGetRates))
+	router.HandleFunc("POST /api/currencies/addrate", middleware.RequireScope(user_schemas.ScopeItemsWrite, ch.HandleAddRate))
+	router.HandleFunc("POST /api/currencies/deleterate", middleware.RequireScope(user_schemas.ScopeItemsWrite, ch.HandleDeleteRate))
+	router.HandleFunc("POST /api/currencies/importrates", middleware.RequireScope(user_schemas.ScopeItemsWrite, ch.HandleImportRates))
+
+	ls := services.NewLedgerService(ldb, idb, cdb, udb)
+	lh := handlers.NewLedgerHandler(ls)
+	router.HandleFunc("GET /ledger", lh.HandleLedgerPage)
+	router.HandleFunc("GET /ledger/person", lh.HandleStatementPage)
+	router.HandleFunc("POST /api/ledger/getbalances", middleware.RequireScope(user_schemas.ScopeItemsRead, lh.HandleGetBalances))
+	router.HandleFunc("POST /api/ledger/getstatement", middleware.RequireScope(user_schemas.ScopeItemsRead, lh.HandleGetStatement))
+	router.HandleFunc("POST /api/ledger/addpayment", middleware.RequireScope(user_schemas.ScopeItemsWrite, lh.HandleAddPayment))
+	router.HandleFunc("POST /api/ledger/deletepayment", middleware.RequireScope(user_schemas.ScopeItemsWrite, lh.HandleDeletePayment))
 
 	mh := handlers.NewMainHandler()
 	router.HandleFunc("GET /api/locale/index", mh.HandleLocale)
 	router.HandleFunc("POST /api/locale/setlocale", mh.HandleSetLocale)
 
+	// A random secret invalidates the CSRF tokens of open pages on restart
+	csrfSecret := []byte(os.Getenv("CSRF_SECRET"))
+	if len(csrfSecret) == 0 {
+		csrfSecret = make([]byte, 32)
+		_, err = rand.Read(csrfSecret)
+		if err != nil {
+			logger.Error.Println("Error generating CSRF secret: " + err.Error())
+			panic(err.Error())
+		}
+		logger.Info.Println("CSRF_SECRET is not set, using a random secret")
+	}
+
 	port := ":1323"
 	middlewareStack := middleware.CreateStack(
 		middleware.Logging,
 		middleware.StripSlash,
+		middleware.Authenticate(us),
+		middleware.CSRF(csrfSecret),
 	)
 	server := http.Server{
 		Addr:    port,
@@ -0,0 +1,137 @@
+package currency_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/bmg-c/product-diary/db"
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/schemas/currency_schemas"
+	"github.com/bmg-c/product-diary/util"
+	"github.com/mattn/go-sqlite3"
+)
+
+type CurrencyDB struct {
+	rateStore *db.Store
+}
+
+func NewCurrencyDB(rateStore *db.Store) (*CurrencyDB, error) {
+	if rateStore == nil {
+		return nil, fmt.Errorf("Error creating CurrencyDB instance, rate store is nil")
+	}
+	return &CurrencyDB{
+		rateStore: rateStore,
+	}, nil
+}
+
+// WithTx returns a copy of the CurrencyDB that runs every query inside tx.
+func (cdb *CurrencyDB) WithTx(tx *sql.Tx) *CurrencyDB {
+	return &CurrencyDB{
+		rateStore: cdb.rateStore.WithTx(tx),
+	}
+}
+
+// AddRate replaces the rate of the pair on the same date, so that importing
+// the same rates twice changes nothing.
+func (cdb *CurrencyDB) AddRate(ctx context.Context, data currency_schemas.AddRate) (currency_schemas.RateDB, error) {
+	ctx, cancel := cdb.rateStore.Context(ctx)
+	defer cancel()
+
+	query := `INSERT INTO ` + cdb.rateStore.TableName + `
+        (user_id, rate_date, currency_from, currency_to, rate)
+        VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT (user_id, currency_from, currency_to, rate_date) DO UPDATE SET rate = excluded.rate
+        RETURNING rate_id, user_id, rate_date, currency_from, currency_to, rate`
+
+	stmt, err := cdb.rateStore.DB.PrepareContext(ctx, query)
+	if err != nil {
+		return currency_schemas.RateDB{}, E.ErrInternalServer
+	}
+	defer stmt.Close()
+
+	rateDB := currency_schemas.RateDB{}
+	err = stmt.QueryRowContext(ctx,
+		data.UserID,
+		data.RateDate.Format("2006-01-02"),
+		data.CurrencyFrom,
+		data.CurrencyTo,
+		data.Rate,
+	).Scan(
+		&rateDB.RateID,
+		&rateDB.UserID,
+		&rateDB.RateDate,
+		&rateDB.CurrencyFrom,
+		&rateDB.CurrencyTo,
+		&rateDB.Rate,
+	)
+	if err != nil {
+		if util.IsErrorSQL(err, sqlite3.ErrConstraint) {
+			return currency_schemas.RateDB{}, E.ErrUnprocessableEntity
+		}
+		return currency_schemas.RateDB{}, E.ErrInternalServer
+	}
+
+	return rateDB, nil
+}
+
+// DeleteRate returns E.ErrNotFound when the user has no such rate.
+func (cdb *CurrencyDB) DeleteRate(ctx context.Context, data currency_schemas.DeleteRate) error {
+	ctx, cancel := cdb.rateStore.Context(ctx)
+	defer cancel()
+
+	query := `DELETE FROM ` + cdb.rateStore.TableName + `
+        WHERE rate_id = ? AND user_id = ?`
+
+	res, err := cdb.rateStore.DB.ExecContext(ctx, query, data.RateID, data.UserID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	if affected == 0 {
+		return E.ErrNotFound
+	}
+	return nil
+}
+
+// GetRates returns the rates of the user by pair, the newest first.
+func (cdb *CurrencyDB) GetRates(ctx context.Context, data currency_schemas.GetRates) ([]currency_schemas.RateDB, error) {
+	ctx, cancel := cdb.rateStore.Context(ctx)
+	defer cancel()
+
+	query := `SELECT rate_id, user_id, rate_date, currency_from, currency_to, rate
+        FROM ` + cdb.rateStore.TableName + `
+        WHERE user_id = ?
+        ORDER BY currency_from, currency_to, rate_date DESC`
+
+	rows, err := cdb.rateStore.DB.QueryContext(ctx, query, data.UserID)
+	if err != nil {
+		return []currency_schemas.RateDB{}, E.ErrInternalServer
+	}
+	defer rows.Close()
+
+	rates := []currency_schemas.RateDB{}
+	for rows.Next() {
+		rateDB := currency_schemas.RateDB{}
+		err = rows.Scan(
+			&rateDB.RateID,
+			&rateDB.UserID,
+			&rateDB.RateDate,
+			&rateDB.CurrencyFrom,
+			&rateDB.CurrencyTo,
+			&rateDB.Rate,
+		)
+		if err != nil {
+			return []currency_schemas.RateDB{}, E.ErrInternalServer
+		}
+		rates = append(rates, rateDB)
+	}
+	if rows.Err() != nil {
+		return []currency_schemas.RateDB{}, E.ErrInternalServer
+	}
+
+	return rates, nil
+}
@@ -1,48 +1,149 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/bmg-c/product-diary/db/migrations"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Querier is implemented by both *sql.DB and *sql.Tx, so stores can run
+// against the shared pool or inside a transaction.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Compile time checks
+var (
+	_ Querier = new(sql.DB)
+	_ Querier = new(sql.Tx)
+)
+
+// TimeFormat matches datetime('now') of SQLite. Times written from Go are
+// formatted with it so that they compare correctly with times written in SQL.
+const TimeFormat = time.DateTime
+
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
 type Store struct {
-	DB        *sql.DB
-	TableName string
+	DB           Querier
+	TableName    string
+	QueryTimeout time.Duration
+}
+
+// WithTx returns a copy of the store that runs its queries inside tx.
+func (s *Store) WithTx(tx *sql.Tx) *Store {
+	return &Store{
+		DB:           tx,
+		TableName:    s.TableName,
+		QueryTimeout: s.QueryTimeout,
+	}
+}
+
+// Context bounds ctx by the query timeout of the store. A zero timeout only
+// keeps the cancellation of the parent context.
+func (s *Store) Context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.QueryTimeout)
+}
+
+// Database owns the single connection pool of the application and hands out
+// table scoped stores that share it.
+type Database struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	mu           sync.Mutex
+	stores       map[string]*Store
 }
 
-func NewStore(dbName string, tableName string, createQuery string) (*Store, error) {
+// NewDatabase opens the database file. Every query made through the stores of
+// the database is cancelled after queryTimeout, zero disables the limit.
+func NewDatabase(dbName string, queryTimeout time.Duration) (*Database, error) {
 	db, err := getDB(dbName)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to connect to the database (%s)", err.Error())
 	}
-
-	if err := newTable(db, createQuery); err != nil {
-		return nil, fmt.Errorf("Failed to create table (%s)", err.Error())
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Failed to connect to the database (%s)", err.Error())
+	}
+	if ok, err := hasFTS5(db); err != nil || !ok {
+		db.Close()
+		return nil, fmt.Errorf("SQLite is built without FTS5, build with -tags sqlite_fts5")
 	}
 
-	return &Store{
-		DB:        db,
-		TableName: tableName,
+	return &Database{
+		DB:           db,
+		QueryTimeout: queryTimeout,
+		stores:       map[string]*Store{},
 	}, nil
 }
 
-func getDB(dbName string) (*sql.DB, error) {
-	// Init SQLite3 database
-	db, err := sql.Open("sqlite3", dbName)
+// Migrate brings the database schema up to the version expected by the
+// application. It fails if the database was created by a newer version.
+func (d *Database) Migrate() error {
+	if err := migrations.Migrate(d.DB); err != nil {
+		return fmt.Errorf("Failed to migrate the database (%s)", err.Error())
+	}
+	return nil
+}
+
+// NewStore returns the store of an existing table. Stores are cached, asking
+// for the same table twice returns the same store.
+func (d *Database) NewStore(tableName string) (*Store, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if store, exists := d.stores[tableName]; exists {
+		return store, nil
+	}
+
+	var name string
+	err := d.DB.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`,
+		tableName).Scan(&name)
 	if err != nil {
-		return nil, err
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("Table %s does not exist", tableName)
+		}
+		return nil, fmt.Errorf("Failed to look up table %s (%s)", tableName, err.Error())
 	}
 
-	return db, nil
+	store := &Store{
+		DB:           d.DB,
+		TableName:    tableName,
+		QueryTimeout: d.QueryTimeout,
+	}
+	d.stores[tableName] = store
+	return store, nil
+}
+
+func (d *Database) Begin() (*sql.Tx, error) {
+	return d.DB.Begin()
+}
+
+func (d *Database) Close() error {
+	return d.DB.Close()
 }
 
-func newTable(db *sql.DB, createQuery string) error {
-	_, err := db.Exec(createQuery)
+func getDB(dbName string) (*sql.DB, error) {
+	// Init SQLite3 database. Pragmas are passed through the DSN so that every
+	// connection of the pool gets them, not only the first one.
+	dsn := "file:" + dbName + "?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000"
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return db, nil
 }
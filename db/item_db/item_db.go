@@ -1,6 +1,7 @@
 package item_db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -15,24 +16,49 @@ import (
 	"github.com/mattn/go-sqlite3"
 )
 
+// ItemDB reads products through their revisions, see product_db.
 type ItemDB struct {
-	itemStore    *db.Store
-	productStore *db.Store
-	personStore  *db.Store
+	itemStore     *db.Store
+	revisionStore *db.Store
+	personStore   *db.Store
+	searchStore   *db.Store
+	splitStore    *db.Store
 }
 
-func NewItemDB(itemStore *db.Store, productStore *db.Store, personStore *db.Store) (*ItemDB, error) {
-	if itemStore == nil || productStore == nil || personStore == nil {
+func NewItemDB(itemStore *db.Store, revisionStore *db.Store, personStore *db.Store, searchStore *db.Store, splitStore *db.Store) (*ItemDB, error) {
+	if itemStore == nil || revisionStore == nil || personStore == nil || searchStore == nil || splitStore == nil {
 		return nil, fmt.Errorf("Error creating ItemDB instance, one of the stores is nil")
 	}
 	return &ItemDB{
-		itemStore:    itemStore,
-		productStore: productStore,
-		personStore:  personStore,
+		itemStore:     itemStore,
+		revisionStore: revisionStore,
+		personStore:   personStore,
+		searchStore:   searchStore,
+		splitStore:    splitStore,
 	}, nil
 }
 
-func (idb *ItemDB) AddItem(data item_schemas.AddItem) (item_schemas.ItemDB, error) {
+// WithTx returns a copy of the ItemDB that runs every query inside tx.
+func (idb *ItemDB) WithTx(tx *sql.Tx) *ItemDB {
+	return &ItemDB{
+		itemStore:     idb.itemStore.WithTx(tx),
+		revisionStore: idb.revisionStore.WithTx(tx),
+		personStore:   idb.personStore.WithTx(tx),
+		searchStore:   idb.searchStore.WithTx(tx),
+		splitStore:    idb.splitStore.WithTx(tx),
+	}
+}
+
+// latestRevision selects the current revision of the product given as
+// parameter, new and changed items are logged with it.
+func (idb *ItemDB) latestRevision() string {
+	return `(SELECT MAX(revision_id) FROM ` + idb.revisionStore.TableName + ` WHERE product_id = ?)`
+}
+
+func (idb *ItemDB) AddItem(ctx context.Context, data item_schemas.AddItem) (item_schemas.ItemDB, error) {
+	ctx, cancel := idb.itemStore.Context(ctx)
+	defer cancel()
+
 	cols := []string{}
 	argsStr := []string{}
 	args := []any{}
@@ -43,6 +69,9 @@ func (idb *ItemDB) AddItem(data item_schemas.AddItem) (item_schemas.ItemDB, erro
 	cols = append(cols, "product_id")
 	args = append(args, data.ProductID)
 	argsStr = append(argsStr, "?")
+	cols = append(cols, "revision_id")
+	args = append(args, data.ProductID)
+	argsStr = append(argsStr, idb.latestRevision())
 	cols = append(cols, "item_date")
 	args = append(args, data.ItemDate.Format("2006-01-02"))
 	argsStr = append(argsStr, "?")
@@ -66,13 +95,28 @@ func (idb *ItemDB) AddItem(data item_schemas.AddItem) (item_schemas.ItemDB, erro
 		args = append(args, data.PersonID)
 		argsStr = append(argsStr, "?")
 	}
+	if !schemas.IsZero(data.ItemUnit) {
+		cols = append(cols, "item_unit")
+		args = append(args, data.ItemUnit)
+		argsStr = append(argsStr, "?")
+	}
+	if !schemas.IsZero(data.PriceMode) {
+		cols = append(cols, "price_mode")
+		args = append(args, data.PriceMode)
+		argsStr = append(argsStr, "?")
+	}
+	if !schemas.IsZero(data.Currency) {
+		cols = append(cols, "currency")
+		args = append(args, data.Currency)
+		argsStr = append(argsStr, "?")
+	}
 
 	query := `INSERT INTO ` + idb.itemStore.TableName + `
         (` + strings.Join(cols, ", ") + `)
         VALUES (` + strings.Join(argsStr, ", ") + `)
         RETURNING *`
 
-	stmt, err := idb.itemStore.DB.Prepare(query)
+	stmt, err := idb.itemStore.DB.PrepareContext(ctx, query)
 	defer stmt.Close()
 	if err != nil {
 		return item_schemas.ItemDB{}, E.ErrInternalServer
@@ -80,7 +124,7 @@ func (idb *ItemDB) AddItem(data item_schemas.AddItem) (item_schemas.ItemDB, erro
 
 	nullPersonID := sql.NullInt64{}
 	itemDB := item_schemas.ItemDB{}
-	err = stmt.QueryRow(
+	err = stmt.QueryRowContext(ctx,
 		args...,
 	).Scan(
 		&itemDB.ItemID,
@@ -91,6 +135,10 @@ func (idb *ItemDB) AddItem(data item_schemas.AddItem) (item_schemas.ItemDB, erro
 		&itemDB.ItemAmount,
 		&itemDB.ItemType,
 		&nullPersonID,
+		&itemDB.RevisionID,
+		&itemDB.ItemUnit,
+		&itemDB.PriceMode,
+		&itemDB.Currency,
 	)
 	if err != nil {
 		if util.IsErrorSQL(err, sqlite3.ErrConstraint) {
@@ -102,8 +150,69 @@ func (idb *ItemDB) AddItem(data item_schemas.AddItem) (item_schemas.ItemDB, erro
 	return itemDB, nil
 }
 
-func (idb *ItemDB) GetItems(data item_schemas.GetItems) ([]item_schemas.ItemParsed, error) {
+// itemTotal is what was paid for an item in minor units, as told by its price
+// mode, in the format of the query of GetItems. It follows
+// services.ItemService.ItemTotal and services.UnitService.ToGrams, the numbers
+// are item_schemas.PriceModes and product_schemas.Units. Items that can not be
+// weighed count as 0.
+const itemTotal = `COALESCE(ROUND(CASE %[1]s.price_mode
+            WHEN 1 THEN %[1]s.item_cost * %[1]s.item_amount
+            WHEN 2 THEN %[1]s.item_cost * %[1]s.item_amount * (CASE %[1]s.item_unit
+                WHEN 1 THEN 1
+                WHEN 2 THEN %[2]s.density
+                WHEN 3 THEN 1000
+                WHEN 4 THEN 1000 * %[2]s.density
+                WHEN 5 THEN %[2]s.net_quantity * (CASE %[2]s.net_unit WHEN 1 THEN 1 WHEN 2 THEN %[2]s.density END)
+            END) / 1000
+            WHEN 3 THEN %[1]s.item_cost
+        END), 0)`
+
+// itemSort maps the sort fields of item_schemas.GetItems to the columns they
+// sort by, in the format of the query of GetItems. Costs sort by the total
+// paid, whatever the price mode.
+var itemSort = map[string]string{
+	"item_cost":        itemTotal,
+	"item_amount":      "%[1]s.item_amount",
+	"item_type":        "%[1]s.item_type",
+	"person_name":      "COALESCE(%[3]s.person_name, '')",
+	"product_title":    "%[2]s.product_title",
+	"product_calories": "%[2]s.product_calories",
+	"product_fats":     "%[2]s.product_fats",
+	"product_carbs":    "%[2]s.product_carbs",
+	"product_proteins": "%[2]s.product_proteins",
+}
+
+func (idb *ItemDB) GetItems(ctx context.Context, data item_schemas.GetItems) ([]item_schemas.ItemParsed, error) {
+	ctx, cancel := idb.itemStore.Context(ctx)
+	defer cancel()
+
 	var itemParsed item_schemas.ItemParsed = item_schemas.ItemParsed{}
+	sortColumn, ok := itemSort[data.SortField]
+	if data.SortField != "" && !ok {
+		return []item_schemas.ItemParsed{}, E.ErrUnprocessableEntity
+	}
+	if sortColumn == "" {
+		sortColumn = "%[1]s.item_id"
+	}
+
+	// Items match the revision they were logged with, see
+	// product_db.GetProducts
+	args := []any{data.UserID, data.ItemDate.Format("2006-01-02")}
+	searchJoin, searchWhere := "", ""
+	match := db.MatchQuery(data.SearchQuery)
+	if match != "" {
+		searchJoin = "INNER JOIN %[4]s ON %[4]s.rowid = %[1]s.revision_id"
+		searchWhere = "AND %[4]s MATCH ?"
+		if data.SortField == "" {
+			sortColumn = "bm25(%[4]s, 10.0, 5.0, 2.0, 1.0)"
+		}
+		args = append(args, match)
+	}
+	pageWhere, pageOrder, pageArgs, err := db.Paginate(sortColumn, "%[1]s.item_id", data.SortDirection == "desc", data.Cursor, data.Limit)
+	if err != nil {
+		return []item_schemas.ItemParsed{}, err
+	}
+	args = append(args, pageArgs...)
 	query := fmt.Sprintf(`
         SELECT
             %[1]s.item_id,
@@ -114,33 +223,32 @@ func (idb *ItemDB) GetItems(data item_schemas.GetItems) ([]item_schemas.ItemPars
             %[1]s.item_amount,
             %[1]s.item_type,
             %[1]s.person_id,
+            %[1]s.revision_id,
+            %[1]s.item_unit,
+            %[1]s.price_mode,
+            %[1]s.currency,
             %[2]s.product_title,
             %[2]s.product_calories,
             %[2]s.product_fats,
             %[2]s.product_carbs,
             %[2]s.product_proteins,
-            %[3]s.person_name
+            %[3]s.person_name,
+            %[2]s.net_quantity,
+            %[2]s.net_unit,
+            %[2]s.density,
+            `+sortColumn+`,
+            %[1]s.item_id
         FROM ((%[1]s
-            INNER JOIN %[2]s ON %[1]s.product_id = %[2]s.product_id) 
-            INNER JOIN %[3]s ON %[1]s.user_id = %[3]s.user_id)
-        WHERE (
-            (%[1]s.user_id = ? AND %[1]s.item_date = ?) AND 
-            (length(trim(
-                replace(lower(?), ' ', ''),
-                replace(lower(
-                    %[2]s.product_title ||
-                    %[2]s.product_calories ||
-                    %[2]s.product_fats ||
-                    %[2]s.product_carbs ||
-                    %[2]s.product_proteins), ' ', '')
-            )) < 3))
-        GROUP BY %[1]s.item_id`,
+            INNER JOIN %[2]s ON %[1]s.revision_id = %[2]s.revision_id) 
+            LEFT JOIN %[3]s ON %[1]s.person_id = %[3]s.person_id)
+            `+searchJoin+`
+        WHERE %[1]s.user_id = ? AND %[1]s.item_date = ? `+searchWhere+pageWhere+pageOrder,
 		idb.itemStore.TableName,
-		idb.productStore.TableName,
+		idb.revisionStore.TableName,
 		idb.personStore.TableName,
+		idb.searchStore.TableName,
 	)
-
-	rows, err := idb.itemStore.DB.Query(query, data.UserID, data.ItemDate.Format("2006-01-02"), data.SearchQuery)
+	rows, err := idb.itemStore.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return []item_schemas.ItemParsed{}, nil
@@ -152,8 +260,9 @@ func (idb *ItemDB) GetItems(data item_schemas.GetItems) ([]item_schemas.ItemPars
 	personIDNull := sql.NullInt64{}
 	personNameNull := sql.NullString{}
 	items := []item_schemas.ItemParsed{}
+	row := &db.PageRow{Rows: rows}
 	for rows.Next() {
-		err = rows.Scan(
+		err = row.Scan(
 			&itemParsed.ItemID,
 			&itemParsed.UserID,
 			&itemParsed.ProductID,
@@ -162,12 +271,19 @@ func (idb *ItemDB) GetItems(data item_schemas.GetItems) ([]item_schemas.ItemPars
 			&itemParsed.ItemAmount,
 			&itemParsed.ItemType,
 			&personIDNull,
+			&itemParsed.RevisionID,
+			&itemParsed.ItemUnit,
+			&itemParsed.PriceMode,
+			&itemParsed.Currency,
 			&itemParsed.ProductTitle,
 			&itemParsed.ProductCalories,
 			&itemParsed.ProductFats,
 			&itemParsed.ProductCarbs,
 			&itemParsed.ProductProteins,
 			&personNameNull,
+			&itemParsed.ProductMeasure.NetQuantity,
+			&itemParsed.ProductMeasure.NetUnit,
+			&itemParsed.ProductMeasure.Density,
 		)
 		if personIDNull.Valid {
 			itemParsed.PersonID = uint(personIDNull.Int64)
@@ -179,13 +295,22 @@ func (idb *ItemDB) GetItems(data item_schemas.GetItems) ([]item_schemas.ItemPars
 		if err != nil {
 			return []item_schemas.ItemParsed{}, E.ErrInternalServer
 		}
+		itemParsed.Cursor = row.Cursor()
 		items = append(items, itemParsed)
 	}
+	rows.Close()
 
+	err = idb.withSplits(ctx, items)
+	if err != nil {
+		return []item_schemas.ItemParsed{}, err
+	}
 	return items, nil
 }
 
-func (idb *ItemDB) GetItem(data item_schemas.GetItem) (item_schemas.ItemParsed, error) {
+func (idb *ItemDB) GetItem(ctx context.Context, data item_schemas.GetItem) (item_schemas.ItemParsed, error) {
+	ctx, cancel := idb.itemStore.Context(ctx)
+	defer cancel()
+
 	var itemParsed item_schemas.ItemParsed = item_schemas.ItemParsed{}
 	query := fmt.Sprintf(`
         SELECT
@@ -197,24 +322,31 @@ func (idb *ItemDB) GetItem(data item_schemas.GetItem) (item_schemas.ItemParsed,
             %[1]s.item_amount,
             %[1]s.item_type,
             %[1]s.person_id,
+            %[1]s.revision_id,
+            %[1]s.item_unit,
+            %[1]s.price_mode,
+            %[1]s.currency,
             %[2]s.product_title,
             %[2]s.product_calories,
             %[2]s.product_fats,
             %[2]s.product_carbs,
             %[2]s.product_proteins,
-            %[3]s.person_name
+            %[3]s.person_name,
+            %[2]s.net_quantity,
+            %[2]s.net_unit,
+            %[2]s.density
         FROM ((%[1]s
-            INNER JOIN %[2]s ON %[1]s.product_id = %[2]s.product_id) 
-            INNER JOIN %[3]s ON %[1]s.user_id = %[3]s.user_id)
+            INNER JOIN %[2]s ON %[1]s.revision_id = %[2]s.revision_id) 
+            LEFT JOIN %[3]s ON %[1]s.person_id = %[3]s.person_id)
         WHERE 
             (%[1]s.item_id = ? AND %[1]s.user_id = ?)
         GROUP BY %[1]s.item_id`,
 		idb.itemStore.TableName,
-		idb.productStore.TableName,
+		idb.revisionStore.TableName,
 		idb.personStore.TableName,
 	)
 
-	stmt, err := idb.itemStore.DB.Prepare(query)
+	stmt, err := idb.itemStore.DB.PrepareContext(ctx, query)
 	if err != nil {
 		return item_schemas.ItemParsed{}, E.ErrInternalServer
 	}
@@ -222,7 +354,7 @@ func (idb *ItemDB) GetItem(data item_schemas.GetItem) (item_schemas.ItemParsed,
 
 	personIDNull := sql.NullInt64{}
 	personNameNull := sql.NullString{}
-	err = stmt.QueryRow(
+	err = stmt.QueryRowContext(ctx,
 		data.ItemID,
 		data.UserID,
 	).Scan(
@@ -234,12 +366,19 @@ func (idb *ItemDB) GetItem(data item_schemas.GetItem) (item_schemas.ItemParsed,
 		&itemParsed.ItemAmount,
 		&itemParsed.ItemType,
 		&personIDNull,
+		&itemParsed.RevisionID,
+		&itemParsed.ItemUnit,
+		&itemParsed.PriceMode,
+		&itemParsed.Currency,
 		&itemParsed.ProductTitle,
 		&itemParsed.ProductCalories,
 		&itemParsed.ProductFats,
 		&itemParsed.ProductCarbs,
 		&itemParsed.ProductProteins,
 		&personNameNull,
+		&itemParsed.ProductMeasure.NetQuantity,
+		&itemParsed.ProductMeasure.NetUnit,
+		&itemParsed.ProductMeasure.Density,
 	)
 	if personIDNull.Valid {
 		itemParsed.PersonID = uint(personIDNull.Int64)
@@ -255,16 +394,24 @@ func (idb *ItemDB) GetItem(data item_schemas.GetItem) (item_schemas.ItemParsed,
 		return item_schemas.ItemParsed{}, E.ErrInternalServer
 	}
 
-	return itemParsed, nil
+	items := []item_schemas.ItemParsed{itemParsed}
+	err = idb.withSplits(ctx, items)
+	if err != nil {
+		return item_schemas.ItemParsed{}, err
+	}
+	return items[0], nil
 }
 
-func (idb *ItemDB) ChangeItem(data item_schemas.ChangeItem) (item_schemas.ItemDB, error) {
+func (idb *ItemDB) ChangeItem(ctx context.Context, data item_schemas.ChangeItem) (item_schemas.ItemDB, error) {
+	ctx, cancel := idb.itemStore.Context(ctx)
+	defer cancel()
+
 	var itemDB item_schemas.ItemDB = item_schemas.ItemDB{}
 	setOptions := []string{}
 	args := []any{}
 	if !schemas.IsZero(data.ProductID) {
-		setOptions = append(setOptions, "product_id = ?")
-		args = append(args, data.ProductID)
+		setOptions = append(setOptions, "product_id = ?", "revision_id = "+idb.latestRevision())
+		args = append(args, data.ProductID, data.ProductID)
 	}
 	if !schemas.IsZero(data.ItemCost) {
 		setOptions = append(setOptions, "item_cost = ?")
@@ -282,6 +429,18 @@ func (idb *ItemDB) ChangeItem(data item_schemas.ChangeItem) (item_schemas.ItemDB
 		setOptions = append(setOptions, "person_id = ?")
 		args = append(args, data.PersonID)
 	}
+	if !schemas.IsZero(data.ItemUnit) {
+		setOptions = append(setOptions, "item_unit = ?")
+		args = append(args, data.ItemUnit)
+	}
+	if !schemas.IsZero(data.PriceMode) {
+		setOptions = append(setOptions, "price_mode = ?")
+		args = append(args, data.PriceMode)
+	}
+	if !schemas.IsZero(data.Currency) {
+		setOptions = append(setOptions, "currency = ?")
+		args = append(args, data.Currency)
+	}
 	query := `UPDATE ` + idb.itemStore.TableName + "\nSET " +
 		strings.Join(setOptions, ", ") + `
         WHERE item_id = ? AND user_id = ?
@@ -289,14 +448,14 @@ func (idb *ItemDB) ChangeItem(data item_schemas.ChangeItem) (item_schemas.ItemDB
 	args = append(args, data.ItemID, data.UserID)
 	// logger.Info.Println(query)
 
-	stmt, err := idb.itemStore.DB.Prepare(query)
+	stmt, err := idb.itemStore.DB.PrepareContext(ctx, query)
 	if err != nil {
 		return item_schemas.ItemDB{}, E.ErrInternalServer
 	}
 	defer stmt.Close()
 
 	personIDNull := sql.NullInt64{}
-	err = stmt.QueryRow(
+	err = stmt.QueryRowContext(ctx,
 		args...,
 	).Scan(
 		&itemDB.ItemID,
@@ -307,6 +466,10 @@ func (idb *ItemDB) ChangeItem(data item_schemas.ChangeItem) (item_schemas.ItemDB
 		&itemDB.ItemAmount,
 		&itemDB.ItemType,
 		&personIDNull,
+		&itemDB.RevisionID,
+		&itemDB.ItemUnit,
+		&itemDB.PriceMode,
+		&itemDB.Currency,
 	)
 	if personIDNull.Valid {
 		itemDB.PersonID = uint(personIDNull.Int64)
@@ -325,16 +488,19 @@ func (idb *ItemDB) ChangeItem(data item_schemas.ChangeItem) (item_schemas.ItemDB
 	return itemDB, nil
 }
 
-func (idb *ItemDB) DeleteItem(data item_schemas.DeleteItem) error {
+func (idb *ItemDB) DeleteItem(ctx context.Context, data item_schemas.DeleteItem) error {
+	ctx, cancel := idb.itemStore.Context(ctx)
+	defer cancel()
+
 	query := `DELETE FROM ` + idb.itemStore.TableName + ` 
         WHERE item_id = ? AND user_id = ?`
 
-	stmt, err := idb.itemStore.DB.Prepare(query)
+	stmt, err := idb.itemStore.DB.PrepareContext(ctx, query)
 	defer stmt.Close()
 	if err != nil {
 		return E.ErrInternalServer
 	}
-	_, err = stmt.Exec(data.ItemID, data.UserID)
+	_, err = stmt.ExecContext(ctx, data.ItemID, data.UserID)
 	if err != nil {
 		if util.IsErrorSQL(err, sqlite3.ErrNotFound) {
 			return E.ErrNotFound
@@ -345,7 +511,10 @@ func (idb *ItemDB) DeleteItem(data item_schemas.DeleteItem) error {
 	return nil
 }
 
-func (idb *ItemDB) GetItemsRange(data item_schemas.GetItemsRange) ([]item_schemas.ItemParsed, error) {
+func (idb *ItemDB) GetItemsRange(ctx context.Context, data item_schemas.GetItemsRange) ([]item_schemas.ItemParsed, error) {
+	ctx, cancel := idb.itemStore.Context(ctx)
+	defer cancel()
+
 	var itemParsed item_schemas.ItemParsed = item_schemas.ItemParsed{}
 	query := fmt.Sprintf(`
         SELECT
@@ -357,24 +526,31 @@ func (idb *ItemDB) GetItemsRange(data item_schemas.GetItemsRange) ([]item_schema
             %[1]s.item_amount,
             %[1]s.item_type,
             %[1]s.person_id,
+            %[1]s.revision_id,
+            %[1]s.item_unit,
+            %[1]s.price_mode,
+            %[1]s.currency,
             %[2]s.product_title,
             %[2]s.product_calories,
             %[2]s.product_fats,
             %[2]s.product_carbs,
             %[2]s.product_proteins,
-            %[3]s.person_name
+            %[3]s.person_name,
+            %[2]s.net_quantity,
+            %[2]s.net_unit,
+            %[2]s.density
         FROM ((%[1]s
-            INNER JOIN %[2]s ON %[1]s.product_id = %[2]s.product_id) 
-            INNER JOIN %[3]s ON %[1]s.user_id = %[3]s.user_id)
+            INNER JOIN %[2]s ON %[1]s.revision_id = %[2]s.revision_id) 
+            LEFT JOIN %[3]s ON %[1]s.person_id = %[3]s.person_id)
         WHERE 
             (%[1]s.user_id = ? AND (%[1]s.item_date >= ? AND %[1]s.item_date <= ?))
         GROUP BY %[1]s.item_id`,
 		idb.itemStore.TableName,
-		idb.productStore.TableName,
+		idb.revisionStore.TableName,
 		idb.personStore.TableName,
 	)
 
-	rows, err := idb.itemStore.DB.Query(query,
+	rows, err := idb.itemStore.DB.QueryContext(ctx, query,
 		data.UserID,
 		data.ItemDateFrom.Format("2006-01-02"),
 		data.ItemDateTo.Format("2006-01-02"),
@@ -400,12 +576,19 @@ func (idb *ItemDB) GetItemsRange(data item_schemas.GetItemsRange) ([]item_schema
 			&itemParsed.ItemAmount,
 			&itemParsed.ItemType,
 			&personIDNull,
+			&itemParsed.RevisionID,
+			&itemParsed.ItemUnit,
+			&itemParsed.PriceMode,
+			&itemParsed.Currency,
 			&itemParsed.ProductTitle,
 			&itemParsed.ProductCalories,
 			&itemParsed.ProductFats,
 			&itemParsed.ProductCarbs,
 			&itemParsed.ProductProteins,
 			&personNameNull,
+			&itemParsed.ProductMeasure.NetQuantity,
+			&itemParsed.ProductMeasure.NetUnit,
+			&itemParsed.ProductMeasure.Density,
 		)
 		if personIDNull.Valid {
 			itemParsed.PersonID = uint(personIDNull.Int64)
@@ -419,6 +602,246 @@ func (idb *ItemDB) GetItemsRange(data item_schemas.GetItemsRange) ([]item_schema
 		}
 		items = append(items, itemParsed)
 	}
+	rows.Close()
 
+	err = idb.withSplits(ctx, items)
+	if err != nil {
+		return []item_schemas.ItemParsed{}, err
+	}
 	return items, nil
 }
+
+// GetPersonItems returns the items by date, the oldest first.
+func (idb *ItemDB) GetPersonItems(ctx context.Context, data item_schemas.GetPersonItems) ([]item_schemas.ItemParsed, error) {
+	ctx, cancel := idb.itemStore.Context(ctx)
+	defer cancel()
+
+	var itemParsed item_schemas.ItemParsed = item_schemas.ItemParsed{}
+	query := fmt.Sprintf(`
+        SELECT
+            %[1]s.item_id,
+            %[1]s.user_id,
+            %[1]s.product_id,
+            %[1]s.item_date,
+            %[1]s.item_cost,
+            %[1]s.item_amount,
+            %[1]s.item_type,
+            %[1]s.person_id,
+            %[1]s.revision_id,
+            %[1]s.item_unit,
+            %[1]s.price_mode,
+            %[1]s.currency,
+            %[2]s.product_title,
+            %[2]s.product_calories,
+            %[2]s.product_fats,
+            %[2]s.product_carbs,
+            %[2]s.product_proteins,
+            %[3]s.person_name,
+            %[2]s.net_quantity,
+            %[2]s.net_unit,
+            %[2]s.density
+        FROM ((%[1]s
+            INNER JOIN %[2]s ON %[1]s.revision_id = %[2]s.revision_id) 
+            LEFT JOIN %[3]s ON %[1]s.person_id = %[3]s.person_id)
+        WHERE
+            (%[1]s.user_id = ? AND (
+                (%[1]s.person_id IS NOT NULL AND (? = 0 OR %[1]s.person_id = ?)) OR
+                %[1]s.item_id IN (SELECT item_id FROM %[4]s
+                    WHERE person_id IS NOT NULL AND (? = 0 OR person_id = ?))))
+        GROUP BY %[1]s.item_id
+        ORDER BY %[1]s.item_date, %[1]s.item_id`,
+		idb.itemStore.TableName,
+		idb.revisionStore.TableName,
+		idb.personStore.TableName,
+		idb.splitStore.TableName,
+	)
+
+	rows, err := idb.itemStore.DB.QueryContext(ctx, query,
+		data.UserID,
+		data.PersonID,
+		data.PersonID,
+		data.PersonID,
+		data.PersonID,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return []item_schemas.ItemParsed{}, nil
+		}
+		return []item_schemas.ItemParsed{}, E.ErrInternalServer
+	}
+	defer rows.Close()
+
+	personIDNull := sql.NullInt64{}
+	personNameNull := sql.NullString{}
+	items := []item_schemas.ItemParsed{}
+	for rows.Next() {
+		err = rows.Scan(
+			&itemParsed.ItemID,
+			&itemParsed.UserID,
+			&itemParsed.ProductID,
+			&itemParsed.ItemDate,
+			&itemParsed.ItemCost,
+			&itemParsed.ItemAmount,
+			&itemParsed.ItemType,
+			&personIDNull,
+			&itemParsed.RevisionID,
+			&itemParsed.ItemUnit,
+			&itemParsed.PriceMode,
+			&itemParsed.Currency,
+			&itemParsed.ProductTitle,
+			&itemParsed.ProductCalories,
+			&itemParsed.ProductFats,
+			&itemParsed.ProductCarbs,
+			&itemParsed.ProductProteins,
+			&personNameNull,
+			&itemParsed.ProductMeasure.NetQuantity,
+			&itemParsed.ProductMeasure.NetUnit,
+			&itemParsed.ProductMeasure.Density,
+		)
+		if personIDNull.Valid {
+			itemParsed.PersonID = uint(personIDNull.Int64)
+			itemParsed.PersonName = personNameNull.String
+		} else {
+			itemParsed.PersonID = 0
+			itemParsed.PersonName = ""
+		}
+		if err != nil {
+			return []item_schemas.ItemParsed{}, E.ErrInternalServer
+		}
+		items = append(items, itemParsed)
+	}
+	rows.Close()
+
+	err = idb.withSplits(ctx, items)
+	if err != nil {
+		return []item_schemas.ItemParsed{}, err
+	}
+	return items, nil
+}
+
+// splitsBatchSize is how many item ids withSplits binds to one query, far
+// below the limit of SQLite on bound variables.
+const splitsBatchSize = 500
+
+// withSplits fills the splits of the items, the user first and then the
+// persons in the order they were added.
+func (idb *ItemDB) withSplits(ctx context.Context, items []item_schemas.ItemParsed) error {
+	inds := map[uint]int{}
+	for ind := range items {
+		items[ind].Splits = []item_schemas.SplitDB{}
+		inds[items[ind].ItemID] = ind
+	}
+	for from := 0; from < len(items); from += splitsBatchSize {
+		to := min(from+splitsBatchSize, len(items))
+		err := idb.loadSplits(ctx, items, inds, items[from:to])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSplits adds the splits of batch to items, inds maps item ids to their
+// index in items.
+func (idb *ItemDB) loadSplits(ctx context.Context, items []item_schemas.ItemParsed, inds map[uint]int, batch []item_schemas.ItemParsed) error {
+	placeholders := []string{}
+	args := []any{}
+	for _, itemParsed := range batch {
+		placeholders = append(placeholders, "?")
+		args = append(args, itemParsed.ItemID)
+	}
+	query := fmt.Sprintf(`
+        SELECT
+            %[1]s.split_id,
+            %[1]s.item_id,
+            %[1]s.person_id,
+            %[1]s.share_type,
+            %[1]s.share_value,
+            %[2]s.person_name
+        FROM %[1]s
+            LEFT JOIN %[2]s ON %[1]s.person_id = %[2]s.person_id
+        WHERE %[1]s.item_id IN (`+strings.Join(placeholders, ", ")+`)
+        ORDER BY %[1]s.person_id IS NOT NULL, %[1]s.split_id`,
+		idb.splitStore.TableName,
+		idb.personStore.TableName,
+	)
+
+	rows, err := idb.splitStore.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	defer rows.Close()
+
+	personIDNull := sql.NullInt64{}
+	personNameNull := sql.NullString{}
+	for rows.Next() {
+		splitDB := item_schemas.SplitDB{}
+		err = rows.Scan(
+			&splitDB.SplitID,
+			&splitDB.ItemID,
+			&personIDNull,
+			&splitDB.ShareType,
+			&splitDB.ShareValue,
+			&personNameNull,
+		)
+		if err != nil {
+			return E.ErrInternalServer
+		}
+		if personIDNull.Valid {
+			splitDB.PersonID = uint(personIDNull.Int64)
+			splitDB.PersonName = personNameNull.String
+		}
+		ind := inds[splitDB.ItemID]
+		items[ind].Splits = append(items[ind].Splits, splitDB)
+	}
+	if rows.Err() != nil {
+		return E.ErrInternalServer
+	}
+	return nil
+}
+
+// SetItemSplits replaces the splits of an item of the user. It returns
+// E.ErrNotFound when the user has no such item or a person is not one of
+// theirs, E.ErrUnprocessableEntity when a person is given twice. Run it in a
+// transaction, see tx_db.
+func (idb *ItemDB) SetItemSplits(ctx context.Context, data item_schemas.SetItemSplits) error {
+	ctx, cancel := idb.splitStore.Context(ctx)
+	defer cancel()
+
+	query := `DELETE FROM ` + idb.splitStore.TableName + `
+        WHERE item_id IN (SELECT item_id FROM ` + idb.itemStore.TableName + `
+            WHERE item_id = ? AND user_id = ?)`
+	_, err := idb.splitStore.DB.ExecContext(ctx, query, data.ItemID, data.UserID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+
+	// The item and the person have to be of the user
+	query = fmt.Sprintf(`INSERT INTO %[1]s (item_id, person_id, share_type, share_value)
+        SELECT %[2]s.item_id, %[3]s.person_id, ?, ?
+        FROM %[2]s LEFT JOIN %[3]s ON %[2]s.user_id = %[3]s.user_id AND %[3]s.person_id = ?
+        WHERE %[2]s.item_id = ? AND %[2]s.user_id = ? AND (? = 0 OR %[3]s.person_id IS NOT NULL)`,
+		idb.splitStore.TableName,
+		idb.itemStore.TableName,
+		idb.personStore.TableName,
+	)
+	for _, split := range data.Splits {
+		args := []any{split.ShareType, split.ShareValue, split.PersonID, data.ItemID, data.UserID, split.PersonID}
+		res, err := idb.splitStore.DB.ExecContext(ctx, query, args...)
+		if err != nil {
+			if util.IsErrorSQL(err, sqlite3.ErrConstraint) {
+				return E.ErrUnprocessableEntity
+			}
+			return E.ErrInternalServer
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return E.ErrInternalServer
+		}
+		if affected == 0 {
+			return E.ErrNotFound
+		}
+	}
+
+	return nil
+}
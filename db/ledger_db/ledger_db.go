@@ -0,0 +1,150 @@
+package ledger_db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/bmg-c/product-diary/db"
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/schemas/ledger_schemas"
+	"github.com/bmg-c/product-diary/util"
+	"github.com/mattn/go-sqlite3"
+)
+
+type LedgerDB struct {
+	paymentStore *db.Store
+	personStore  *db.Store
+}
+
+func NewLedgerDB(paymentStore *db.Store, personStore *db.Store) (*LedgerDB, error) {
+	if paymentStore == nil || personStore == nil {
+		return nil, fmt.Errorf("Error creating LedgerDB instance, one of the stores is nil")
+	}
+	return &LedgerDB{
+		paymentStore: paymentStore,
+		personStore:  personStore,
+	}, nil
+}
+
+// WithTx returns a copy of the LedgerDB that runs every query inside tx.
+func (ldb *LedgerDB) WithTx(tx *sql.Tx) *LedgerDB {
+	return &LedgerDB{
+		paymentStore: ldb.paymentStore.WithTx(tx),
+		personStore:  ldb.personStore.WithTx(tx),
+	}
+}
+
+// AddPayment returns E.ErrNotFound when the person is not one of the user.
+func (ldb *LedgerDB) AddPayment(ctx context.Context, data ledger_schemas.AddPayment) (ledger_schemas.PaymentDB, error) {
+	ctx, cancel := ldb.paymentStore.Context(ctx)
+	defer cancel()
+
+	query := `INSERT INTO ` + ldb.paymentStore.TableName + `
+        (user_id, person_id, payment_date, payment_type, amount, currency, note)
+        SELECT user_id, person_id, ?, ?, ?, ?, ?
+        FROM ` + ldb.personStore.TableName + `
+        WHERE person_id = ? AND user_id = ?
+        RETURNING payment_id, user_id, person_id, payment_date, payment_type, amount, currency, note`
+
+	stmt, err := ldb.paymentStore.DB.PrepareContext(ctx, query)
+	if err != nil {
+		return ledger_schemas.PaymentDB{}, E.ErrInternalServer
+	}
+	defer stmt.Close()
+
+	paymentDB := ledger_schemas.PaymentDB{}
+	err = stmt.QueryRowContext(ctx,
+		data.PaymentDate.Format("2006-01-02"),
+		data.PaymentType,
+		data.Amount,
+		data.Currency,
+		data.Note,
+		data.PersonID,
+		data.UserID,
+	).Scan(
+		&paymentDB.PaymentID,
+		&paymentDB.UserID,
+		&paymentDB.PersonID,
+		&paymentDB.PaymentDate,
+		&paymentDB.PaymentType,
+		&paymentDB.Amount,
+		&paymentDB.Currency,
+		&paymentDB.Note,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ledger_schemas.PaymentDB{}, E.ErrNotFound
+		}
+		if util.IsErrorSQL(err, sqlite3.ErrConstraint) {
+			return ledger_schemas.PaymentDB{}, E.ErrUnprocessableEntity
+		}
+		return ledger_schemas.PaymentDB{}, E.ErrInternalServer
+	}
+
+	return paymentDB, nil
+}
+
+// DeletePayment returns E.ErrNotFound when the user has no such payment.
+func (ldb *LedgerDB) DeletePayment(ctx context.Context, data ledger_schemas.DeletePayment) error {
+	ctx, cancel := ldb.paymentStore.Context(ctx)
+	defer cancel()
+
+	query := `DELETE FROM ` + ldb.paymentStore.TableName + `
+        WHERE payment_id = ? AND user_id = ?`
+
+	res, err := ldb.paymentStore.DB.ExecContext(ctx, query, data.PaymentID, data.UserID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	if affected == 0 {
+		return E.ErrNotFound
+	}
+	return nil
+}
+
+// GetPayments returns the payments by date, the oldest first.
+func (ldb *LedgerDB) GetPayments(ctx context.Context, data ledger_schemas.GetPayments) ([]ledger_schemas.PaymentDB, error) {
+	ctx, cancel := ldb.paymentStore.Context(ctx)
+	defer cancel()
+
+	query := `SELECT payment_id, user_id, person_id, payment_date, payment_type, amount, currency, note
+        FROM ` + ldb.paymentStore.TableName + `
+        WHERE user_id = ? AND (? = 0 OR person_id = ?)
+        ORDER BY payment_date, payment_id`
+
+	rows, err := ldb.paymentStore.DB.QueryContext(ctx, query, data.UserID, data.PersonID, data.PersonID)
+	if err != nil {
+		return []ledger_schemas.PaymentDB{}, E.ErrInternalServer
+	}
+	defer rows.Close()
+
+	payments := []ledger_schemas.PaymentDB{}
+	for rows.Next() {
+		paymentDB := ledger_schemas.PaymentDB{}
+		err = rows.Scan(
+			&paymentDB.PaymentID,
+			&paymentDB.UserID,
+			&paymentDB.PersonID,
+			&paymentDB.PaymentDate,
+			&paymentDB.PaymentType,
+			&paymentDB.Amount,
+			&paymentDB.Currency,
+			&paymentDB.Note,
+		)
+		if err != nil {
+			return []ledger_schemas.PaymentDB{}, E.ErrInternalServer
+		}
+		payments = append(payments, paymentDB)
+	}
+	if rows.Err() != nil {
+		return []ledger_schemas.PaymentDB{}, E.ErrInternalServer
+	}
+
+	return payments, nil
+}
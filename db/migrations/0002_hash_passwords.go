@@ -0,0 +1,95 @@
+package migrations
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func init() {
+	register(Migration{
+		Version: 2,
+		Name:    "hash_passwords",
+		Up:      hashPasswordsUp,
+		// Plaintext passwords can not be restored
+		Down: nil,
+	})
+}
+
+// The password hash as it was when passwords started to be hashed. The
+// migration keeps its own copy, so changing util.HashPassword does not change
+// what it writes. Hashes with older parameters are recomputed on login, see
+// util.VerifyPassword.
+const (
+	hashPasswordsAlgorithm  = "pbkdf2_sha256"
+	hashPasswordsIterations = 600000
+	hashPasswordsSaltLength = 16
+	hashPasswordsKeyLength  = 32
+)
+
+// hashPasswordsUp replaces every plaintext password left from the time
+// passwords were stored as is.
+func hashPasswordsUp(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT user_id, password FROM users`)
+	if err != nil {
+		return err
+	}
+	plaintext := map[uint]string{}
+	for rows.Next() {
+		var userID uint
+		var password string
+		err = rows.Scan(&userID, &password)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if !isHashedPassword(password) {
+			plaintext[userID] = password
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for userID, password := range plaintext {
+		passwordHash, err := hashPassword(password)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE users SET password = ? WHERE user_id = ?`, passwordHash, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isHashedPassword(password string) bool {
+	parts := strings.Split(password, "$")
+	return len(parts) == 4 && parts[0] == hashPasswordsAlgorithm
+}
+
+// hashPassword encodes like util.HashPassword did at the time of the
+// migration, "algorithm$iterations$salt$key".
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, hashPasswordsSaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := pbkdf2.Key([]byte(password), salt, hashPasswordsIterations, hashPasswordsKeyLength, sha256.New)
+
+	return fmt.Sprintf("%s$%d$%s$%s",
+		hashPasswordsAlgorithm,
+		hashPasswordsIterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
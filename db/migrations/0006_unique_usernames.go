@@ -0,0 +1,85 @@
+package migrations
+
+import (
+	"database/sql"
+	"strconv"
+)
+
+func init() {
+	register(Migration{
+		Version: 6,
+		Name:    "unique_usernames",
+		Up:      uniqueUsernamesUp,
+		// Down is sql/0006_unique_usernames.down.sql, renamed users keep
+		// their new names
+	})
+}
+
+// uniqueUsernamesMaxLength is the username length limit when usernames were
+// made unique. The migration keeps its own copy, so changing the limit of
+// schemas.DefRV does not change the names it picks.
+const uniqueUsernamesMaxLength = 12
+
+// uniqueUsernamesUp renames users that share a username with an older user
+// and then makes usernames unique. The oldest user keeps the name, the others
+// get their user ID appended, shortened to fit the username length limit.
+func uniqueUsernamesUp(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT user_id, username FROM users ORDER BY user_id`)
+	if err != nil {
+		return err
+	}
+	taken := map[string]bool{}
+	renames := map[uint]string{}
+	var duplicates []uint
+	var names []string
+	for rows.Next() {
+		var userID uint
+		var username string
+		err = rows.Scan(&userID, &username)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if taken[username] {
+			duplicates = append(duplicates, userID)
+			names = append(names, username)
+		}
+		taken[username] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// Renames are picked after reading every name, so that they can not take
+	// the name of a later user
+	maxLength := uniqueUsernamesMaxLength
+	for i, userID := range duplicates {
+		for n := 0; ; n++ {
+			suffix := strconv.FormatUint(uint64(userID), 10)
+			if n > 0 {
+				suffix += "_" + strconv.Itoa(n)
+			}
+			base := []rune(names[i])
+			if len(base)+len(suffix) > maxLength {
+				base = base[:max(0, maxLength-len(suffix))]
+			}
+			username := string(base) + suffix
+			if !taken[username] {
+				taken[username] = true
+				renames[userID] = username
+				break
+			}
+		}
+	}
+
+	for userID, username := range renames {
+		_, err = tx.Exec(`UPDATE users SET username = ? WHERE user_id = ?`, username, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`CREATE UNIQUE INDEX users_username ON users (username)`)
+	return err
+}
@@ -0,0 +1,95 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+func init() {
+	register(Migration{
+		Version: 12,
+		Name:    "product_fingerprints",
+		Up:      productFingerprintsUp,
+		// Down is sql/0012_product_fingerprints.down.sql
+	})
+}
+
+// productFingerprintsUp stores the fingerprint of every product that is not
+// deleted, in the format of product_schemas.Fingerprint at the time, and then
+// makes fingerprints unique. Identical products that already exist are kept,
+// only the oldest one gets the fingerprint.
+func productFingerprintsUp(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE products ADD COLUMN fingerprint TEXT`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT product_id, product_title, manufacturer, product_type,
+        product_calories, product_fats, product_carbs, product_proteins
+        FROM products WHERE is_deleted = FALSE ORDER BY product_id`)
+	if err != nil {
+		return err
+	}
+	taken := map[string]bool{}
+	fingerprints := map[uint]string{}
+	for rows.Next() {
+		var productID uint
+		var title, manufacturer string
+		var productType uint8
+		var calories, fats, carbs, proteins float32
+		err = rows.Scan(&productID, &title, &manufacturer, &productType,
+			&calories, &fats, &carbs, &proteins)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		fingerprint := fmt.Sprintf("%s|%s|%d|%g|%g|%g|%g",
+			fingerprintNormalize(title),
+			fingerprintNormalize(manufacturer),
+			productType,
+			calories,
+			fats,
+			carbs,
+			proteins,
+		)
+		if !taken[fingerprint] {
+			taken[fingerprint] = true
+			fingerprints[productID] = fingerprint
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for productID, fingerprint := range fingerprints {
+		_, err = tx.Exec(`UPDATE products SET fingerprint = ? WHERE product_id = ?`, fingerprint, productID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`CREATE UNIQUE INDEX products_fingerprint ON products (fingerprint)
+        WHERE is_deleted = FALSE`)
+	return err
+}
+
+// fingerprintNormalize is product_schemas.NormalizeTitle as it was when
+// fingerprints were added. The migration keeps its own copy, so changing the
+// normalization later does not change what it writes.
+func fingerprintNormalize(title string) string {
+	runes := []rune(strings.ToLower(title))
+	var b strings.Builder
+	for i, r := range runes {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		case (r == '.' || r == ',') && i > 0 && i+1 < len(runes) &&
+			unicode.IsDigit(runes[i-1]) && unicode.IsDigit(runes[i+1]):
+			b.WriteRune('.')
+		}
+	}
+	return b.String()
+}
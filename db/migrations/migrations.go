@@ -0,0 +1,301 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bmg-c/product-diary/logger"
+)
+
+//go:embed sql/*.sql
+var sqlFiles embed.FS
+
+var ErrDatabaseNewer = errors.New("Database schema is newer than the application")
+
+const migrationsTableName = "schema_migrations"
+
+type MigrateFunc func(tx *sql.Tx) error
+
+type Migration struct {
+	Version uint
+	Name    string
+	Up      MigrateFunc
+	Down    MigrateFunc
+}
+
+// goMigrations hold steps that can not be expressed in SQL alone.
+var goMigrations []Migration
+
+func register(m Migration) {
+	goMigrations = append(goMigrations, m)
+}
+
+// All returns every known migration ordered by version. Migrations are read
+// from sql/<version>_<name>.up.sql and sql/<version>_<name>.down.sql files,
+// or registered from Go code.
+func All() ([]Migration, error) {
+	entries, err := sqlFiles.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[uint]*Migration{}
+	for _, m := range goMigrations {
+		if _, exists := byVersion[m.Version]; exists {
+			return nil, fmt.Errorf("Migration %d is registered twice", m.Version)
+		}
+		byVersion[m.Version] = &m
+	}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		version, name, direction, err := parseFileName(fileName)
+		if err != nil {
+			return nil, err
+		}
+		content, err := sqlFiles.ReadFile(path.Join("sql", fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if exists && (m.Name != name || (direction == "up" && m.Up != nil) || (direction == "down" && m.Down != nil)) {
+			return nil, fmt.Errorf("Migration %d (%s) is defined twice", version, name)
+		}
+		if !exists {
+			m = &Migration{
+				Version: version,
+				Name:    name,
+			}
+			byVersion[version] = m
+		}
+		switch direction {
+		case "up":
+			m.Up = execFunc(string(content))
+		case "down":
+			m.Down = execFunc(string(content))
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		if m.Up == nil {
+			return nil, fmt.Errorf("Migration %d (%s) has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != uint(i+1) {
+			return nil, fmt.Errorf("Migration versions are not sequential, expected %d got %d", i+1, m.Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Latest returns the version the application expects the database to be at.
+func Latest() (uint, error) {
+	migrations, err := All()
+	if err != nil {
+		return 0, err
+	}
+	return uint(len(migrations)), nil
+}
+
+// Migrate applies every pending migration.
+func Migrate(db *sql.DB) error {
+	latest, err := Latest()
+	if err != nil {
+		return err
+	}
+	return MigrateTo(db, latest)
+}
+
+// MigrateTo moves the database schema up or down to the target version. All
+// steps run inside a single transaction, so a failed step leaves the schema
+// untouched. Foreign keys are switched off while migrating so that tables can
+// be rebuilt, and checked once before commit.
+func MigrateTo(db *sql.DB, target uint) error {
+	ctx := context.Background()
+	migrations, err := All()
+	if err != nil {
+		return err
+	}
+	latest := uint(len(migrations))
+	if target > latest {
+		return fmt.Errorf("Unknown target version %d, latest is %d", target, latest)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = createMigrationsTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+	current, err := currentVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if current > latest {
+		return fmt.Errorf("%w (database version %d, application version %d)", ErrDatabaseNewer, current, latest)
+	}
+	if current == target {
+		return nil
+	}
+
+	// The pragma is a no-op inside a transaction, so it is set on the
+	// connection beforehand.
+	_, err = conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if current < target {
+		for _, m := range migrations[current:target] {
+			logger.Info.Printf("Applying migration %d (%s)", m.Version, m.Name)
+			err = m.Up(tx)
+			if err != nil {
+				return fmt.Errorf("Failed to apply migration %d (%s): %w", m.Version, m.Name, err)
+			}
+			_, err = tx.Exec(`INSERT INTO `+migrationsTableName+` (version, name) VALUES (?, ?)`, m.Version, m.Name)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		for i := current; i > target; i-- {
+			m := migrations[i-1]
+			if m.Down == nil {
+				return fmt.Errorf("Migration %d (%s) can not be reverted", m.Version, m.Name)
+			}
+			logger.Info.Printf("Reverting migration %d (%s)", m.Version, m.Name)
+			err = m.Down(tx)
+			if err != nil {
+				return fmt.Errorf("Failed to revert migration %d (%s): %w", m.Version, m.Name, err)
+			}
+			_, err = tx.Exec(`DELETE FROM `+migrationsTableName+` WHERE version = ?`, m.Version)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = checkForeignKeys(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Current returns the latest applied migration version, 0 for a fresh database.
+func Current(db *sql.DB) (uint, error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	err = createMigrationsTable(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	return currentVersion(ctx, conn)
+}
+
+func currentVersion(ctx context.Context, conn *sql.Conn) (uint, error) {
+	var version sql.NullInt64
+	err := conn.QueryRowContext(ctx, `SELECT MAX(version) FROM `+migrationsTableName).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	if !version.Valid {
+		return 0, nil
+	}
+	return uint(version.Int64), nil
+}
+
+func createMigrationsTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+migrationsTableName+` (
+        version INTEGER PRIMARY KEY,
+        name VARCHAR(128) NOT NULL,
+        applied_at DATETIME default (datetime('now'))
+    );`)
+	return err
+}
+
+func checkForeignKeys(tx *sql.Tx) error {
+	rows, err := tx.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var table string
+		var rowID sql.NullInt64
+		var parent string
+		var fkID int64
+		err = rows.Scan(&table, &rowID, &parent, &fkID)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("Foreign key violation after migrating, table %s row %d references %s",
+			table, rowID.Int64, parent)
+	}
+	return rows.Err()
+}
+
+func execFunc(query string) MigrateFunc {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// parseFileName splits "0001_init.up.sql" into 1, "init" and "up".
+func parseFileName(fileName string) (uint, string, string, error) {
+	base, found := strings.CutSuffix(fileName, ".sql")
+	if !found {
+		return 0, "", "", fmt.Errorf("Unexpected migration file %s", fileName)
+	}
+	dot := strings.LastIndex(base, ".")
+	if dot == -1 {
+		return 0, "", "", fmt.Errorf("Migration file %s has no direction", fileName)
+	}
+	direction := base[dot+1:]
+	if direction != "up" && direction != "down" {
+		return 0, "", "", fmt.Errorf("Migration file %s has unknown direction %s", fileName, direction)
+	}
+	versionStr, name, found := strings.Cut(base[:dot], "_")
+	if !found {
+		return 0, "", "", fmt.Errorf("Migration file %s has no name", fileName)
+	}
+	version, err := strconv.ParseUint(versionStr, 10, 0)
+	if err != nil || version == 0 {
+		return 0, "", "", fmt.Errorf("Migration file %s has invalid version", fileName)
+	}
+
+	return uint(version), name, direction, nil
+}
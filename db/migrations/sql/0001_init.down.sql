@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS items;
+DROP TABLE IF EXISTS products;
+DROP TABLE IF EXISTS persons;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS codes;
+DROP TABLE IF EXISTS users;
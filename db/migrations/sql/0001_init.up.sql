@@ -0,0 +1,60 @@
+CREATE TABLE IF NOT EXISTS users (
+    user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username VARCHAR(64) NOT NULL,
+    email VARCHAR(255) NOT NULL UNIQUE,
+    password VARCHAR(255) NOT NULL,
+    created_at DATETIME default (datetime('now'))
+);
+
+CREATE TABLE IF NOT EXISTS codes (
+    code_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email VARCHAR(255) NOT NULL UNIQUE,
+    code VARCHAR(6),
+    created_at DATETIME default (datetime('now'))
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    session_uuid VARCHAR(32) NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE RESTRICT
+);
+
+CREATE TABLE IF NOT EXISTS persons (
+    person_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    person_name VARCHAR(64) NOT NULL,
+    is_hidden INTEGER NOT NULL DEFAULT FALSE,
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE RESTRICT,
+    UNIQUE(user_id, person_name)
+);
+
+CREATE TABLE IF NOT EXISTS products (
+    product_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    product_title VARCHAR(128) NOT NULL,
+    product_calories REAL DEFAULT 0,
+    product_fats REAL DEFAULT 0,
+    product_carbs REAL DEFAULT 0,
+    product_proteins REAL DEFAULT 0,
+    user_id INTEGER NOT NULL,
+    is_deleted INTEGER NOT NULL DEFAULT FALSE,
+    CHECK (product_fats + product_carbs + product_proteins <= 100),
+    CHECK (length(product_title) >= 4 AND length(product_title) <= 128),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE RESTRICT
+);
+
+CREATE TABLE IF NOT EXISTS items (
+    item_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    item_date DATE NOT NULL,
+    item_cost REAL DEFAULT 0,
+    item_amount REAL DEFAULT 0,
+    item_type INTEGER NOT NULL DEFAULT 1,
+    person_id INTEGER DEFAULT NULL,
+    CHECK (item_type >= 1 AND item_type <= 3),
+    CHECK (item_cost >= 0),
+    CHECK (item_amount >= 0),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE RESTRICT,
+    FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE RESTRICT,
+    FOREIGN KEY (person_id) REFERENCES persons (person_id) ON DELETE RESTRICT
+);
@@ -0,0 +1,11 @@
+CREATE TABLE sessions_old (
+    session_uuid VARCHAR(32) NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE RESTRICT
+);
+
+INSERT INTO sessions_old (session_uuid, user_id)
+    SELECT session_uuid, user_id FROM sessions WHERE expires_at > datetime('now');
+
+DROP TABLE sessions;
+ALTER TABLE sessions_old RENAME TO sessions;
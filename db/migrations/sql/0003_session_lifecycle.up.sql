@@ -0,0 +1,22 @@
+CREATE TABLE sessions_new (
+    session_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_uuid VARCHAR(36) NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    last_seen_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    expires_at DATETIME NOT NULL,
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE RESTRICT
+);
+
+-- Sessions created before expiry existed get the default lifetime from now
+INSERT INTO sessions_new (session_uuid, user_id, created_at, last_seen_at, expires_at)
+    SELECT session_uuid, user_id, datetime('now'), datetime('now'), datetime('now', '+30 days')
+    FROM sessions;
+
+DROP TABLE sessions;
+ALTER TABLE sessions_new RENAME TO sessions;
+
+CREATE INDEX sessions_user_id ON sessions (user_id);
+CREATE INDEX sessions_expires_at ON sessions (expires_at);
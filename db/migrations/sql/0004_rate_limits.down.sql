@@ -0,0 +1,4 @@
+ALTER TABLE codes DROP COLUMN attempts;
+
+DROP TABLE lockouts;
+DROP TABLE rate_limits;
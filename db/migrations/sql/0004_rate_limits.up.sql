@@ -0,0 +1,16 @@
+-- Times are unix nanoseconds, so that buckets can be refilled in SQL
+CREATE TABLE rate_limits (
+    limit_key VARCHAR(255) PRIMARY KEY,
+    tokens REAL NOT NULL,
+    updated_at INTEGER NOT NULL,
+    allowed INTEGER NOT NULL DEFAULT TRUE
+);
+
+CREATE TABLE lockouts (
+    limit_key VARCHAR(255) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure INTEGER NOT NULL DEFAULT 0,
+    locked_until INTEGER NOT NULL DEFAULT 0
+);
+
+ALTER TABLE codes ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
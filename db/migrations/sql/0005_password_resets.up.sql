@@ -0,0 +1,11 @@
+-- Only the SHA-256 of a reset token is stored, the token itself is only in
+-- the mail
+CREATE TABLE password_resets (
+    reset_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX password_resets_user_id ON password_resets (user_id);
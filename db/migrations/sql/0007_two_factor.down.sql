@@ -0,0 +1,5 @@
+DELETE FROM sessions WHERE pending_2fa;
+ALTER TABLE sessions DROP COLUMN pending_2fa;
+
+DROP TABLE recovery_codes;
+DROP TABLE totp_secrets;
@@ -0,0 +1,21 @@
+-- A secret without confirmed_at is being enrolled and is not asked for at
+-- login yet. last_used_step keeps a code from being used twice.
+CREATE TABLE totp_secrets (
+    user_id INTEGER PRIMARY KEY,
+    secret VARCHAR(64) NOT NULL,
+    confirmed_at DATETIME,
+    last_used_step INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+-- Recovery codes are single use and stored as SHA-256 like reset tokens
+CREATE TABLE recovery_codes (
+    recovery_code_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash VARCHAR(64) NOT NULL,
+    UNIQUE (user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+-- Pending sessions only allow entering the second factor
+ALTER TABLE sessions ADD COLUMN pending_2fa INTEGER NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+ALTER TABLE products DROP COLUMN is_hidden;
+ALTER TABLE users DROP COLUMN is_disabled;
+ALTER TABLE users DROP COLUMN role;
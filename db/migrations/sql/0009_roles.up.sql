@@ -0,0 +1,9 @@
+-- Roles grant permissions, see user_schemas.Can. Disabled users can not log
+-- in and their sessions and API tokens stop working.
+ALTER TABLE users ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'user'
+    CHECK (role IN ('user', 'moderator', 'admin'));
+ALTER TABLE users ADD COLUMN is_disabled INTEGER NOT NULL DEFAULT FALSE;
+
+-- Hidden products are left out of the catalog by moderators, items keep
+-- pointing to them
+ALTER TABLE products ADD COLUMN is_hidden INTEGER NOT NULL DEFAULT FALSE;
@@ -0,0 +1,27 @@
+-- Columns used by a foreign key can not be dropped, so items is rebuilt
+CREATE TABLE items_old (
+    item_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    item_date DATE NOT NULL,
+    item_cost REAL DEFAULT 0,
+    item_amount REAL DEFAULT 0,
+    item_type INTEGER NOT NULL DEFAULT 1,
+    person_id INTEGER DEFAULT NULL,
+    CHECK (item_type >= 1 AND item_type <= 3),
+    CHECK (item_cost >= 0),
+    CHECK (item_amount >= 0),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE RESTRICT,
+    FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE RESTRICT,
+    FOREIGN KEY (person_id) REFERENCES persons (person_id) ON DELETE RESTRICT
+);
+INSERT INTO items_old (item_id, user_id, product_id, item_date, item_cost, item_amount, item_type, person_id)
+SELECT item_id, user_id, product_id, item_date, item_cost, item_amount, item_type, person_id FROM items;
+DROP TABLE items;
+ALTER TABLE items_old RENAME TO items;
+
+DROP TRIGGER products_revision_update;
+DROP TRIGGER products_revision_insert;
+DROP TRIGGER product_revisions_immutable_delete;
+DROP TRIGGER product_revisions_immutable_update;
+DROP TABLE product_revisions;
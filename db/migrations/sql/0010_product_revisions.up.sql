@@ -0,0 +1,71 @@
+-- Every version of a product is kept as an immutable revision, written by
+-- the triggers below whenever a product is added or edited. Items point at
+-- the revision they were logged with, so edits do not change past entries.
+CREATE TABLE product_revisions (
+    revision_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    product_id INTEGER NOT NULL,
+    revision_number INTEGER NOT NULL,
+    product_title VARCHAR(128) NOT NULL,
+    product_calories REAL DEFAULT 0,
+    product_fats REAL DEFAULT 0,
+    product_carbs REAL DEFAULT 0,
+    product_proteins REAL DEFAULT 0,
+    user_id INTEGER NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    UNIQUE (product_id, revision_number),
+    FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE RESTRICT,
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE RESTRICT
+);
+
+CREATE TRIGGER product_revisions_immutable_update
+BEFORE UPDATE ON product_revisions
+BEGIN
+    SELECT RAISE(ABORT, 'product revisions are immutable');
+END;
+
+CREATE TRIGGER product_revisions_immutable_delete
+BEFORE DELETE ON product_revisions
+BEGIN
+    SELECT RAISE(ABORT, 'product revisions are immutable');
+END;
+
+CREATE TRIGGER products_revision_insert
+AFTER INSERT ON products
+BEGIN
+    INSERT INTO product_revisions (product_id, revision_number, product_title,
+        product_calories, product_fats, product_carbs, product_proteins, user_id)
+    VALUES (NEW.product_id, 1, NEW.product_title,
+        NEW.product_calories, NEW.product_fats, NEW.product_carbs, NEW.product_proteins, NEW.user_id);
+END;
+
+CREATE TRIGGER products_revision_update
+AFTER UPDATE OF product_title, product_calories, product_fats, product_carbs, product_proteins ON products
+WHEN OLD.product_title IS NOT NEW.product_title
+    OR OLD.product_calories IS NOT NEW.product_calories
+    OR OLD.product_fats IS NOT NEW.product_fats
+    OR OLD.product_carbs IS NOT NEW.product_carbs
+    OR OLD.product_proteins IS NOT NEW.product_proteins
+BEGIN
+    INSERT INTO product_revisions (product_id, revision_number, product_title,
+        product_calories, product_fats, product_carbs, product_proteins, user_id)
+    VALUES (NEW.product_id,
+        (SELECT MAX(revision_number) + 1 FROM product_revisions WHERE product_id = NEW.product_id),
+        NEW.product_title,
+        NEW.product_calories, NEW.product_fats, NEW.product_carbs, NEW.product_proteins, NEW.user_id);
+END;
+
+INSERT INTO product_revisions (product_id, revision_number, product_title,
+    product_calories, product_fats, product_carbs, product_proteins, user_id)
+SELECT product_id, 1, product_title,
+    product_calories, product_fats, product_carbs, product_proteins, user_id
+FROM products;
+
+ALTER TABLE items ADD COLUMN revision_id INTEGER
+    REFERENCES product_revisions (revision_id) ON DELETE RESTRICT;
+
+UPDATE items SET revision_id = (
+    SELECT revision_id FROM product_revisions
+    WHERE product_revisions.product_id = items.product_id
+);
+
+CREATE INDEX product_revisions_product_id ON product_revisions (product_id);
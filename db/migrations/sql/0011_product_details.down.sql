@@ -0,0 +1,32 @@
+DROP TRIGGER products_revision_insert;
+DROP TRIGGER products_revision_update;
+
+CREATE TRIGGER products_revision_insert
+AFTER INSERT ON products
+BEGIN
+    INSERT INTO product_revisions (product_id, revision_number, product_title,
+        product_calories, product_fats, product_carbs, product_proteins, user_id)
+    VALUES (NEW.product_id, 1, NEW.product_title,
+        NEW.product_calories, NEW.product_fats, NEW.product_carbs, NEW.product_proteins, NEW.user_id);
+END;
+
+CREATE TRIGGER products_revision_update
+AFTER UPDATE OF product_title, product_calories, product_fats, product_carbs, product_proteins ON products
+WHEN OLD.product_title IS NOT NEW.product_title
+    OR OLD.product_calories IS NOT NEW.product_calories
+    OR OLD.product_fats IS NOT NEW.product_fats
+    OR OLD.product_carbs IS NOT NEW.product_carbs
+    OR OLD.product_proteins IS NOT NEW.product_proteins
+BEGIN
+    INSERT INTO product_revisions (product_id, revision_number, product_title,
+        product_calories, product_fats, product_carbs, product_proteins, user_id)
+    VALUES (NEW.product_id,
+        (SELECT MAX(revision_number) + 1 FROM product_revisions WHERE product_id = NEW.product_id),
+        NEW.product_title,
+        NEW.product_calories, NEW.product_fats, NEW.product_carbs, NEW.product_proteins, NEW.user_id);
+END;
+
+ALTER TABLE product_revisions DROP COLUMN product_type;
+ALTER TABLE product_revisions DROP COLUMN manufacturer;
+ALTER TABLE products DROP COLUMN product_type;
+ALTER TABLE products DROP COLUMN manufacturer;
@@ -0,0 +1,40 @@
+-- Products get a manufacturer and a type: 1 food, 2 electronics, 3 other.
+-- Existing products are food. Revisions keep both fields as well.
+ALTER TABLE products ADD COLUMN manufacturer VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE products ADD COLUMN product_type INTEGER NOT NULL DEFAULT 1
+    CHECK (product_type >= 1 AND product_type <= 3);
+
+ALTER TABLE product_revisions ADD COLUMN manufacturer VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE product_revisions ADD COLUMN product_type INTEGER NOT NULL DEFAULT 1
+    CHECK (product_type >= 1 AND product_type <= 3);
+
+DROP TRIGGER products_revision_insert;
+DROP TRIGGER products_revision_update;
+
+CREATE TRIGGER products_revision_insert
+AFTER INSERT ON products
+BEGIN
+    INSERT INTO product_revisions (product_id, revision_number, product_title, manufacturer, product_type,
+        product_calories, product_fats, product_carbs, product_proteins, user_id)
+    VALUES (NEW.product_id, 1, NEW.product_title, NEW.manufacturer, NEW.product_type,
+        NEW.product_calories, NEW.product_fats, NEW.product_carbs, NEW.product_proteins, NEW.user_id);
+END;
+
+CREATE TRIGGER products_revision_update
+AFTER UPDATE OF product_title, manufacturer, product_type,
+    product_calories, product_fats, product_carbs, product_proteins ON products
+WHEN OLD.product_title IS NOT NEW.product_title
+    OR OLD.manufacturer IS NOT NEW.manufacturer
+    OR OLD.product_type IS NOT NEW.product_type
+    OR OLD.product_calories IS NOT NEW.product_calories
+    OR OLD.product_fats IS NOT NEW.product_fats
+    OR OLD.product_carbs IS NOT NEW.product_carbs
+    OR OLD.product_proteins IS NOT NEW.product_proteins
+BEGIN
+    INSERT INTO product_revisions (product_id, revision_number, product_title, manufacturer, product_type,
+        product_calories, product_fats, product_carbs, product_proteins, user_id)
+    VALUES (NEW.product_id,
+        (SELECT MAX(revision_number) + 1 FROM product_revisions WHERE product_id = NEW.product_id),
+        NEW.product_title, NEW.manufacturer, NEW.product_type,
+        NEW.product_calories, NEW.product_fats, NEW.product_carbs, NEW.product_proteins, NEW.user_id);
+END;
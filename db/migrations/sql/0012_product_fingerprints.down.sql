@@ -0,0 +1,2 @@
+DROP INDEX products_fingerprint;
+ALTER TABLE products DROP COLUMN fingerprint;
@@ -0,0 +1,3 @@
+DROP TRIGGER users_search_username;
+DROP TRIGGER product_revisions_search_insert;
+DROP TABLE product_search;
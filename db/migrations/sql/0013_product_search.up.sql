@@ -0,0 +1,45 @@
+-- Full text search over the revisions of products. The product registry
+-- matches the latest revision of every product, the item views the revision
+-- an item was logged with. The unicode61 tokenizer folds the case and the
+-- diacritics of every script, the prefix indexes serve prefix queries.
+-- Type names are indexed in every language of the interface.
+CREATE VIRTUAL TABLE product_search USING fts5(
+    product_title,
+    manufacturer,
+    product_type,
+    creator_name,
+    tokenize = 'unicode61 remove_diacritics 2',
+    prefix = '1 2 3'
+);
+
+CREATE TRIGGER product_revisions_search_insert
+AFTER INSERT ON product_revisions
+BEGIN
+    INSERT INTO product_search (rowid, product_title, manufacturer, product_type, creator_name)
+    VALUES (NEW.revision_id, NEW.product_title, NEW.manufacturer,
+        CASE NEW.product_type
+            WHEN 1 THEN 'Food Еда'
+            WHEN 2 THEN 'Electronics Электроника'
+            ELSE 'Other Прочее'
+        END,
+        (SELECT username FROM users WHERE user_id = NEW.user_id));
+END;
+
+CREATE TRIGGER users_search_username
+AFTER UPDATE OF username ON users
+WHEN OLD.username IS NOT NEW.username
+BEGIN
+    UPDATE product_search SET creator_name = NEW.username
+    WHERE rowid IN (SELECT revision_id FROM product_revisions WHERE user_id = NEW.user_id);
+END;
+
+INSERT INTO product_search (rowid, product_title, manufacturer, product_type, creator_name)
+SELECT product_revisions.revision_id, product_revisions.product_title, product_revisions.manufacturer,
+    CASE product_revisions.product_type
+        WHEN 1 THEN 'Food Еда'
+        WHEN 2 THEN 'Electronics Электроника'
+        ELSE 'Other Прочее'
+    END,
+    users.username
+FROM product_revisions
+    INNER JOIN users ON users.user_id = product_revisions.user_id;
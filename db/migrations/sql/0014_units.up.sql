@@ -0,0 +1,57 @@
+-- Item amounts get a unit: 1 g, 2 ml, 3 kg, 4 l, 5 piece. Products get the
+-- net quantity of a piece, in g or ml, and a density in g/ml to convert
+-- volumes. Revisions keep the measure as well, items are counted with the
+-- product as it was when they were logged.
+-- Existing items count in pieces of 100 g, which is how the nutrition of an
+-- amount was computed before.
+ALTER TABLE products ADD COLUMN net_quantity REAL NOT NULL DEFAULT 100
+    CHECK (net_quantity > 0);
+ALTER TABLE products ADD COLUMN net_unit INTEGER NOT NULL DEFAULT 1
+    CHECK (net_unit >= 1 AND net_unit <= 2);
+ALTER TABLE products ADD COLUMN density REAL NOT NULL DEFAULT 1
+    CHECK (density > 0);
+
+ALTER TABLE product_revisions ADD COLUMN net_quantity REAL NOT NULL DEFAULT 100
+    CHECK (net_quantity > 0);
+ALTER TABLE product_revisions ADD COLUMN net_unit INTEGER NOT NULL DEFAULT 1
+    CHECK (net_unit >= 1 AND net_unit <= 2);
+ALTER TABLE product_revisions ADD COLUMN density REAL NOT NULL DEFAULT 1
+    CHECK (density > 0);
+
+ALTER TABLE items ADD COLUMN item_unit INTEGER NOT NULL DEFAULT 5
+    CHECK (item_unit >= 1 AND item_unit <= 5);
+
+DROP TRIGGER products_revision_insert;
+DROP TRIGGER products_revision_update;
+
+CREATE TRIGGER products_revision_insert
+AFTER INSERT ON products
+BEGIN
+    INSERT INTO product_revisions (product_id, revision_number, product_title, manufacturer, product_type,
+        product_calories, product_fats, product_carbs, product_proteins,
+        net_quantity, net_unit, density, user_id)
+    VALUES (NEW.product_id, 1, NEW.product_title, NEW.manufacturer, NEW.product_type,
+        NEW.product_calories, NEW.product_fats, NEW.product_carbs, NEW.product_proteins,
+        NEW.net_quantity, NEW.net_unit, NEW.density, NEW.user_id);
+END;
+
+CREATE TRIGGER products_revision_update
+AFTER UPDATE OF product_title, manufacturer, product_type,
+    product_calories, product_fats, product_carbs, product_proteins ON products
+WHEN OLD.product_title IS NOT NEW.product_title
+    OR OLD.manufacturer IS NOT NEW.manufacturer
+    OR OLD.product_type IS NOT NEW.product_type
+    OR OLD.product_calories IS NOT NEW.product_calories
+    OR OLD.product_fats IS NOT NEW.product_fats
+    OR OLD.product_carbs IS NOT NEW.product_carbs
+    OR OLD.product_proteins IS NOT NEW.product_proteins
+BEGIN
+    INSERT INTO product_revisions (product_id, revision_number, product_title, manufacturer, product_type,
+        product_calories, product_fats, product_carbs, product_proteins,
+        net_quantity, net_unit, density, user_id)
+    VALUES (NEW.product_id,
+        (SELECT MAX(revision_number) + 1 FROM product_revisions WHERE product_id = NEW.product_id),
+        NEW.product_title, NEW.manufacturer, NEW.product_type,
+        NEW.product_calories, NEW.product_fats, NEW.product_carbs, NEW.product_proteins,
+        NEW.net_quantity, NEW.net_unit, NEW.density, NEW.user_id);
+END;
@@ -0,0 +1,41 @@
+-- Totals and prices of a kilogram are turned back into prices of a unit. A
+-- kilogram price is converted with the measure of the revision the item was
+-- logged with, the way services.UnitService.ToGrams weighs a unit.
+CREATE TABLE items_old (
+    item_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    item_date DATE NOT NULL,
+    item_cost REAL DEFAULT 0,
+    item_amount REAL DEFAULT 0,
+    item_type INTEGER NOT NULL DEFAULT 1,
+    person_id INTEGER DEFAULT NULL,
+    revision_id INTEGER REFERENCES product_revisions (revision_id) ON DELETE RESTRICT,
+    item_unit INTEGER NOT NULL DEFAULT 5,
+    CHECK (item_type >= 1 AND item_type <= 3),
+    CHECK (item_cost >= 0),
+    CHECK (item_amount >= 0),
+    CHECK (item_unit >= 1 AND item_unit <= 5),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE RESTRICT,
+    FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE RESTRICT,
+    FOREIGN KEY (person_id) REFERENCES persons (person_id) ON DELETE RESTRICT
+);
+INSERT INTO items_old (item_id, user_id, product_id, item_date, item_cost, item_amount, item_type,
+    person_id, revision_id, item_unit)
+SELECT i.item_id, i.user_id, i.product_id, i.item_date,
+    CASE
+        WHEN i.price_mode = 3 AND i.item_amount > 0 THEN i.item_cost / 100.0 / i.item_amount
+        WHEN i.price_mode = 2 THEN i.item_cost / 100.0 * (CASE i.item_unit
+            WHEN 1 THEN 1
+            WHEN 2 THEN r.density
+            WHEN 3 THEN 1000
+            WHEN 4 THEN 1000 * r.density
+            ELSE r.net_quantity * (CASE r.net_unit WHEN 2 THEN r.density ELSE 1 END)
+        END) / 1000
+        ELSE i.item_cost / 100.0
+    END,
+    i.item_amount, i.item_type, i.person_id, i.revision_id, i.item_unit
+FROM items AS i
+    LEFT JOIN product_revisions AS r ON r.revision_id = i.revision_id;
+DROP TABLE items;
+ALTER TABLE items_old RENAME TO items;
@@ -0,0 +1,33 @@
+-- Costs are kept as integers in minor units (kopecks, cents), so that sums do
+-- not drift, and items tell what their cost is: 1 the price of a unit of the
+-- amount, 2 the price of a kilogram, 3 the total paid. Existing costs were
+-- prices of a unit.
+-- The type of a column can not be changed, so items is rebuilt
+CREATE TABLE items_new (
+    item_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    item_date DATE NOT NULL,
+    item_cost INTEGER NOT NULL DEFAULT 0,
+    item_amount REAL DEFAULT 0,
+    item_type INTEGER NOT NULL DEFAULT 1,
+    person_id INTEGER DEFAULT NULL,
+    revision_id INTEGER REFERENCES product_revisions (revision_id) ON DELETE RESTRICT,
+    item_unit INTEGER NOT NULL DEFAULT 5,
+    price_mode INTEGER NOT NULL DEFAULT 1,
+    CHECK (item_type >= 1 AND item_type <= 3),
+    CHECK (item_cost >= 0),
+    CHECK (item_amount >= 0),
+    CHECK (item_unit >= 1 AND item_unit <= 5),
+    CHECK (price_mode >= 1 AND price_mode <= 3),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE RESTRICT,
+    FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE RESTRICT,
+    FOREIGN KEY (person_id) REFERENCES persons (person_id) ON DELETE RESTRICT
+);
+INSERT INTO items_new (item_id, user_id, product_id, item_date, item_cost, item_amount, item_type,
+    person_id, revision_id, item_unit, price_mode)
+SELECT item_id, user_id, product_id, item_date, CAST(ROUND(COALESCE(item_cost, 0) * 100) AS INTEGER),
+    item_amount, item_type, person_id, revision_id, item_unit, 1
+FROM items;
+DROP TABLE items;
+ALTER TABLE items_new RENAME TO items;
@@ -0,0 +1,3 @@
+DROP TABLE currency_rates;
+ALTER TABLE items DROP COLUMN currency;
+ALTER TABLE users DROP COLUMN base_currency;
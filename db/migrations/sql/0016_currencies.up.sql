@@ -0,0 +1,20 @@
+-- Items are bought in a currency and the analytics of a user are summed up in
+-- their base currency. Everything so far was in roubles.
+ALTER TABLE users ADD COLUMN base_currency CHAR(3) NOT NULL DEFAULT 'RUB';
+ALTER TABLE items ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'RUB';
+
+-- Exchange rates are entered by the user, by hand or from CSV, there is no
+-- live source. A rate tells that one currency_from costs rate currency_to
+-- from rate_date on, until the next rate of the pair.
+CREATE TABLE currency_rates (
+    rate_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    rate_date DATE NOT NULL,
+    currency_from CHAR(3) NOT NULL,
+    currency_to CHAR(3) NOT NULL,
+    rate REAL NOT NULL,
+    CHECK (rate > 0),
+    CHECK (currency_from <> currency_to),
+    UNIQUE (user_id, currency_from, currency_to, rate_date),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
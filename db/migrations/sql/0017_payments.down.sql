@@ -0,0 +1,2 @@
+DROP INDEX payments_user_person;
+DROP TABLE payments;
@@ -0,0 +1,18 @@
+-- Payments settle debts between a user and a person outside of purchases,
+-- like a friend paying the user back. payment_type is 1 when the user paid
+-- the person and 2 when the person paid the user.
+CREATE TABLE payments (
+    payment_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    person_id INTEGER NOT NULL,
+    payment_date DATE NOT NULL,
+    payment_type INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'RUB',
+    note TEXT NOT NULL DEFAULT '',
+    CHECK (payment_type >= 1 AND payment_type <= 2),
+    CHECK (amount > 0),
+    FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+    FOREIGN KEY (person_id) REFERENCES persons (person_id) ON DELETE RESTRICT
+);
+CREATE INDEX payments_user_person ON payments (user_id, person_id);
@@ -0,0 +1,3 @@
+DROP INDEX item_splits_person;
+DROP INDEX item_splits_item_person;
+DROP TABLE item_splits;
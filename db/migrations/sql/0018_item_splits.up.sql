@@ -0,0 +1,18 @@
+-- Splits share an item bought by the user between the user and their persons.
+-- A NULL person_id is the share of the user. share_type is 1 for a
+-- percentage of the total in hundredths of a percent, 2 for an amount in minor
+-- units of the item currency and 3 for an equal part of what is left, with a
+-- share_value of 0.
+CREATE TABLE item_splits (
+    split_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    item_id INTEGER NOT NULL,
+    person_id INTEGER DEFAULT NULL,
+    share_type INTEGER NOT NULL,
+    share_value INTEGER NOT NULL DEFAULT 0,
+    CHECK (share_type >= 1 AND share_type <= 3),
+    CHECK (share_value >= 0),
+    FOREIGN KEY (item_id) REFERENCES items (item_id) ON DELETE CASCADE,
+    FOREIGN KEY (person_id) REFERENCES persons (person_id) ON DELETE RESTRICT
+);
+CREATE UNIQUE INDEX item_splits_item_person ON item_splits (item_id, COALESCE(person_id, 0));
+CREATE INDEX item_splits_person ON item_splits (person_id);
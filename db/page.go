@@ -0,0 +1,91 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+)
+
+// cursor is the sort value and id of the last row of a page. The next page
+// starts right after it, so pages do not skip or repeat rows when rows are
+// added in between, and no rows have to be counted to get to a page.
+type cursor struct {
+	Value any  `json:"v"`
+	ID    uint `json:"id"`
+}
+
+func EncodeCursor(value any, id uint) string {
+	if b, ok := value.([]byte); ok {
+		value = string(b)
+	}
+	out, _ := json.Marshal(cursor{Value: value, ID: id})
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+// DecodeCursor returns E.ErrUnprocessableEntity for cursors not made by
+// EncodeCursor.
+func DecodeCursor(s string) (any, uint, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, 0, E.ErrUnprocessableEntity
+	}
+	c := cursor{}
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, 0, E.ErrUnprocessableEntity
+	}
+	switch c.Value.(type) {
+	case string, float64:
+	default:
+		return nil, 0, E.ErrUnprocessableEntity
+	}
+	return c.Value, c.ID, nil
+}
+
+// Paginate returns the page after cursor of a query sorted by sortColumn and,
+// for ties, by idColumn. The query has to end with its WHERE clause, to which
+// where is added, and be followed by order, which sorts and limits the page.
+// The query selects sortColumn and idColumn last, see PageRow, and args
+// follow its own arguments. An empty cursor returns the first page and a
+// limit of 0 every row.
+func Paginate(sortColumn string, idColumn string, desc bool, cursor string, limit uint) (where string, order string, args []any, err error) {
+	direction, compare := "ASC", ">"
+	if desc {
+		direction, compare = "DESC", "<"
+	}
+
+	if cursor != "" {
+		value, id, err := DecodeCursor(cursor)
+		if err != nil {
+			return "", "", nil, err
+		}
+		where = fmt.Sprintf(" AND (%s, %s) %s (?, ?)", sortColumn, idColumn, compare)
+		args = append(args, value, id)
+	}
+	order = fmt.Sprintf(" ORDER BY %[1]s %[3]s, %[2]s %[3]s", sortColumn, idColumn, direction)
+	if limit > 0 {
+		order += " LIMIT ?"
+		args = append(args, limit)
+	}
+	return where, order, args, nil
+}
+
+// PageRow scans the sort and id columns of Paginate that a query selects after
+// the columns scanned by the caller.
+type PageRow struct {
+	Rows  *sql.Rows
+	Value any
+	ID    uint
+}
+
+func (pr *PageRow) Scan(dest ...any) error {
+	return pr.Rows.Scan(append(dest, &pr.Value, &pr.ID)...)
+}
+
+// Cursor of the page after the scanned row.
+func (pr *PageRow) Cursor() string {
+	return EncodeCursor(pr.Value, pr.ID)
+}
@@ -1,6 +1,7 @@
 package product_db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -13,37 +14,71 @@ import (
 )
 
 type ProductDB struct {
-	productStore *db.Store
+	productStore  *db.Store
+	revisionStore *db.Store
+	userStore     *db.Store
+	searchStore   *db.Store
 }
 
-func NewProductDB(productStore *db.Store) (*ProductDB, error) {
-	if productStore == nil {
+func NewProductDB(productStore *db.Store, revisionStore *db.Store, userStore *db.Store, searchStore *db.Store) (*ProductDB, error) {
+	if productStore == nil || revisionStore == nil || userStore == nil || searchStore == nil {
 		return nil, fmt.Errorf("Error creating ProductDB instance, one of the stores is nil")
 	}
 	return &ProductDB{
-		productStore: productStore,
+		productStore:  productStore,
+		revisionStore: revisionStore,
+		userStore:     userStore,
+		searchStore:   searchStore,
 	}, nil
 }
 
-func (pdb *ProductDB) AddProduct(data product_schemas.AddProduct) (product_schemas.ProductDB, error) {
+// WithTx returns a copy of the ProductDB that runs every query inside tx.
+func (pdb *ProductDB) WithTx(tx *sql.Tx) *ProductDB {
+	return &ProductDB{
+		productStore:  pdb.productStore.WithTx(tx),
+		revisionStore: pdb.revisionStore.WithTx(tx),
+		userStore:     pdb.userStore.WithTx(tx),
+		searchStore:   pdb.searchStore.WithTx(tx),
+	}
+}
+
+// AddProduct returns E.ErrConflict when an identical product exists, see
+// product_schemas.Fingerprint. Products added without a measure get
+// product_schemas.DefaultMeasure.
+func (pdb *ProductDB) AddProduct(ctx context.Context, data product_schemas.AddProduct) (product_schemas.ProductDB, error) {
+	ctx, cancel := pdb.productStore.Context(ctx)
+	defer cancel()
+
 	query := `INSERT INTO ` + pdb.productStore.TableName + `
-        (product_id, product_title, product_calories, product_fats, product_carbs, product_proteins, user_id, is_deleted)
-        VALUES (NULL, ?, ?, ?, ?, ?, ?, FALSE)`
+        (product_id, product_title, manufacturer, product_type,
+        product_calories, product_fats, product_carbs, product_proteins,
+        net_quantity, net_unit, density, user_id, is_deleted, fingerprint)
+        VALUES (NULL, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, FALSE, ?)`
 
-	stmt, err := pdb.productStore.DB.Prepare(query)
+	stmt, err := pdb.productStore.DB.PrepareContext(ctx, query)
 	defer stmt.Close()
 	if err != nil {
 		return product_schemas.ProductDB{}, E.ErrInternalServer
 	}
-	res, err := stmt.Exec(
+	measure := data.Measure()
+	res, err := stmt.ExecContext(ctx,
 		data.ProductTitle,
+		data.Manufacturer,
+		data.ProductType,
 		data.ProductCalories,
 		data.ProductFats,
 		data.ProductCarbs,
 		data.ProductProteins,
+		measure.NetQuantity,
+		measure.NetUnit,
+		measure.Density,
 		data.UserID,
+		data.Fingerprint,
 	)
 	if err != nil {
+		if util.IsErrorSQLUnique(err) {
+			return product_schemas.ProductDB{}, E.ErrConflict
+		}
 		if util.IsErrorSQL(err, sqlite3.ErrConstraint) {
 			return product_schemas.ProductDB{}, E.ErrUnprocessableEntity
 		}
@@ -56,28 +91,118 @@ func (pdb *ProductDB) AddProduct(data product_schemas.AddProduct) (product_schem
 	productDB := product_schemas.ProductDB{
 		ProductID:       uint(createdID),
 		ProductTitle:    data.ProductTitle,
+		Manufacturer:    data.Manufacturer,
+		ProductType:     data.ProductType,
 		ProductCalories: data.ProductCalories,
 		ProductFats:     data.ProductFats,
 		ProductCarbs:    data.ProductCarbs,
 		ProductProteins: data.ProductProteins,
 		UserID:          data.UserID,
 		IsDeleted:       false,
+		NetQuantity:     measure.NetQuantity,
+		NetUnit:         measure.NetUnit,
+		Density:         measure.Density,
 	}
 
 	return productDB, nil
 }
 
-func (pdb *ProductDB) GetProducts(data product_schemas.GetProducts) ([]product_schemas.ProductDB, error) {
-	var productDB product_schemas.ProductDB = product_schemas.ProductDB{}
-	query := `SELECT product_id, product_title, product_calories, product_fats, product_carbs, product_proteins, user_id, is_deleted
-        FROM ` + pdb.productStore.TableName + `
-        WHERE length(trim(replace(lower(?), ' ', ''), replace(lower(product_title || product_calories 
-    || product_fats || product_carbs || product_proteins), ' ', ''))) < 1 AND
-            is_deleted = FALSE`
+// productColumns selects a product together with the username of its
+// creator, see productFrom.
+const productColumns = `p.product_id, p.product_title, p.manufacturer, p.product_type,
+        p.product_calories, p.product_fats, p.product_carbs, p.product_proteins,
+        p.user_id, p.is_deleted, p.is_hidden, u.username,
+        p.net_quantity, p.net_unit, p.density`
+
+func (pdb *ProductDB) productFrom() string {
+	return pdb.productStore.TableName + ` AS p
+        INNER JOIN ` + pdb.userStore.TableName + ` AS u ON u.user_id = p.user_id`
+}
+
+func scanProduct(row interface{ Scan(dest ...any) error }) (product_schemas.ProductDB, error) {
+	productDB := product_schemas.ProductDB{}
+	err := row.Scan(
+		&productDB.ProductID,
+		&productDB.ProductTitle,
+		&productDB.Manufacturer,
+		&productDB.ProductType,
+		&productDB.ProductCalories,
+		&productDB.ProductFats,
+		&productDB.ProductCarbs,
+		&productDB.ProductProteins,
+		&productDB.UserID,
+		&productDB.IsDeleted,
+		&productDB.IsHidden,
+		&productDB.CreatorName,
+		&productDB.NetQuantity,
+		&productDB.NetUnit,
+		&productDB.Density,
+	)
+	return productDB, err
+}
+
+// productSort maps the sort fields of product_schemas.GetProducts to the
+// columns they sort by.
+var productSort = map[string]string{
+	"product_title":    "p.product_title",
+	"manufacturer":     "p.manufacturer",
+	"product_type":     "p.product_type",
+	"product_calories": "p.product_calories",
+	"product_fats":     "p.product_fats",
+	"product_carbs":    "p.product_carbs",
+	"product_proteins": "p.product_proteins",
+	"creator_name":     "u.username",
+}
+
+// GetProducts searches the title, manufacturer, type (in every language) and
+// creator of the latest revision of every product, best matches first. Words
+// match by their start, see db.MatchQuery. The page is cut by db.Paginate.
+func (pdb *ProductDB) GetProducts(ctx context.Context, data product_schemas.GetProducts) ([]product_schemas.ProductDB, error) {
+	ctx, cancel := pdb.productStore.Context(ctx)
+	defer cancel()
+
+	sortColumn, ok := productSort[data.SortField]
+	if data.SortField != "" && !ok {
+		return []product_schemas.ProductDB{}, E.ErrUnprocessableEntity
+	}
+
+	search := pdb.searchStore.TableName
+	match := db.MatchQuery(data.SearchQuery)
+	if sortColumn == "" {
+		sortColumn = "p.product_id"
+		if match != "" {
+			sortColumn = "bm25(" + search + ", 10.0, 5.0, 2.0, 1.0)"
+		}
+	}
+	pageWhere, pageOrder, pageArgs, err := db.Paginate(sortColumn, "p.product_id", data.SortDirection == "desc", data.Cursor, data.Limit)
+	if err != nil {
+		return []product_schemas.ProductDB{}, err
+	}
+
+	var query string
+	var args []any
+	if match == "" {
+		query = `SELECT ` + productColumns + `, ` + sortColumn + `, p.product_id
+        FROM ` + pdb.productFrom() + `
+        WHERE p.is_deleted = FALSE AND (p.is_hidden = FALSE OR ?)`
+		args = []any{data.IncludeHidden}
+	} else {
+		query = `SELECT ` + productColumns + `, ` + sortColumn + `, p.product_id
+        FROM ` + search + `
+            INNER JOIN ` + pdb.revisionStore.TableName + ` AS r ON r.revision_id = ` + search + `.rowid
+            INNER JOIN ` + pdb.productStore.TableName + ` AS p ON p.product_id = r.product_id
+            INNER JOIN ` + pdb.userStore.TableName + ` AS u ON u.user_id = p.user_id
+        WHERE ` + search + ` MATCH ? AND
+            r.revision_number = (SELECT MAX(revision_number) FROM ` + pdb.revisionStore.TableName + `
+                WHERE product_id = r.product_id) AND
+            p.is_deleted = FALSE AND (p.is_hidden = FALSE OR ?)`
+		args = []any{match, data.IncludeHidden}
+	}
+	query += pageWhere + pageOrder
+	args = append(args, pageArgs...)
 
-	rows, err := pdb.productStore.DB.Query(query, data.SearchQuery)
+	rows, err := pdb.productStore.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		fmt.Printf("%v\n", err)
 		if errors.Is(err, sql.ErrNoRows) {
 			return []product_schemas.ProductDB{}, nil
 		}
@@ -86,50 +211,50 @@ func (pdb *ProductDB) GetProducts(data product_schemas.GetProducts) ([]product_s
 	defer rows.Close()
 
 	products := []product_schemas.ProductDB{}
+	row := &db.PageRow{Rows: rows}
 	for rows.Next() {
-		err = rows.Scan(
-			&productDB.ProductID,
-			&productDB.ProductTitle,
-			&productDB.ProductCalories,
-			&productDB.ProductFats,
-			&productDB.ProductCarbs,
-			&productDB.ProductProteins,
-			&productDB.UserID,
-			&productDB.IsDeleted,
-		)
+		productDB, err := scanProduct(row)
 		if err != nil {
 			return []product_schemas.ProductDB{}, E.ErrInternalServer
 		}
+		productDB.Cursor = row.Cursor()
 		products = append(products, productDB)
 	}
 
 	return products, nil
 }
 
-func (pdb *ProductDB) GetProduct(data product_schemas.GetProduct) (product_schemas.ProductDB, error) {
-	var productDB product_schemas.ProductDB = product_schemas.ProductDB{}
-	query := `SELECT product_id, product_title, product_calories, product_fats,
-        product_carbs, product_proteins, user_id, is_deleted FROM ` + pdb.productStore.TableName + `
-		WHERE product_id = ? AND is_deleted = FALSE`
+func (pdb *ProductDB) GetProduct(ctx context.Context, data product_schemas.GetProduct) (product_schemas.ProductDB, error) {
+	ctx, cancel := pdb.productStore.Context(ctx)
+	defer cancel()
 
-	stmt, err := pdb.productStore.DB.Prepare(query)
+	query := `SELECT ` + productColumns + `
+        FROM ` + pdb.productFrom() + `
+		WHERE p.product_id = ? AND p.is_deleted = FALSE AND (p.is_hidden = FALSE OR p.user_id = ? OR ?)`
+
+	productDB, err := scanProduct(pdb.productStore.DB.QueryRowContext(ctx, query, data.ProductID, data.UserID, data.IncludeHidden))
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return product_schemas.ProductDB{}, E.ErrNotFound
+		}
 		return product_schemas.ProductDB{}, E.ErrInternalServer
 	}
-	defer stmt.Close()
 
-	err = stmt.QueryRow(
-		data.ProductID,
-	).Scan(
-		&productDB.ProductID,
-		&productDB.ProductTitle,
-		&productDB.ProductCalories,
-		&productDB.ProductFats,
-		&productDB.ProductCarbs,
-		&productDB.ProductProteins,
-		&productDB.UserID,
-		&productDB.IsDeleted,
-	)
+	return productDB, nil
+}
+
+// GetProductByFingerprint returns the product identical to one with the
+// fingerprint, see product_schemas.Fingerprint. Returns E.ErrNotFound when
+// there is none.
+func (pdb *ProductDB) GetProductByFingerprint(ctx context.Context, fingerprint string) (product_schemas.ProductDB, error) {
+	ctx, cancel := pdb.productStore.Context(ctx)
+	defer cancel()
+
+	query := `SELECT ` + productColumns + `
+        FROM ` + pdb.productFrom() + `
+        WHERE p.fingerprint = ? AND p.is_deleted = FALSE`
+
+	productDB, err := scanProduct(pdb.productStore.DB.QueryRowContext(ctx, query, fingerprint))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return product_schemas.ProductDB{}, E.ErrNotFound
@@ -140,17 +265,61 @@ func (pdb *ProductDB) GetProduct(data product_schemas.GetProduct) (product_schem
 	return productDB, nil
 }
 
-func (pdb *ProductDB) DeleteProduct(data product_schemas.DeleteProduct) error {
+// GetSimilarProducts returns up to limit listed products whose latest title
+// shares the start of a word with title, best matches first.
+func (pdb *ProductDB) GetSimilarProducts(ctx context.Context, title string, limit uint) ([]product_schemas.ProductDB, error) {
+	ctx, cancel := pdb.productStore.Context(ctx)
+	defer cancel()
+
+	match := db.MatchAnyQuery(title)
+	if match == "" {
+		return []product_schemas.ProductDB{}, nil
+	}
+	search := pdb.searchStore.TableName
+	query := `SELECT ` + productColumns + `
+        FROM ` + search + `
+            INNER JOIN ` + pdb.revisionStore.TableName + ` AS r ON r.revision_id = ` + search + `.rowid
+            INNER JOIN ` + pdb.productStore.TableName + ` AS p ON p.product_id = r.product_id
+            INNER JOIN ` + pdb.userStore.TableName + ` AS u ON u.user_id = p.user_id
+        WHERE ` + search + ` MATCH ? AND
+            r.revision_number = (SELECT MAX(revision_number) FROM ` + pdb.revisionStore.TableName + `
+                WHERE product_id = r.product_id) AND
+            p.is_deleted = FALSE AND p.is_hidden = FALSE
+        ORDER BY bm25(` + search + `)
+        LIMIT ?`
+
+	rows, err := pdb.productStore.DB.QueryContext(ctx, query, "product_title : ("+match+")", limit)
+	if err != nil {
+		return []product_schemas.ProductDB{}, E.ErrInternalServer
+	}
+	defer rows.Close()
+
+	products := []product_schemas.ProductDB{}
+	for rows.Next() {
+		productDB, err := scanProduct(rows)
+		if err != nil {
+			return []product_schemas.ProductDB{}, E.ErrInternalServer
+		}
+		products = append(products, productDB)
+	}
+
+	return products, nil
+}
+
+func (pdb *ProductDB) DeleteProduct(ctx context.Context, data product_schemas.DeleteProduct) error {
+	ctx, cancel := pdb.productStore.Context(ctx)
+	defer cancel()
+
 	query := `UPDATE ` + pdb.productStore.TableName + ` 
         SET is_deleted = TRUE
         WHERE product_id = ? AND user_id = ?`
 
-	stmt, err := pdb.productStore.DB.Prepare(query)
+	stmt, err := pdb.productStore.DB.PrepareContext(ctx, query)
 	defer stmt.Close()
 	if err != nil {
 		return E.ErrInternalServer
 	}
-	_, err = stmt.Exec(data.ProductID, data.UserID)
+	_, err = stmt.ExecContext(ctx, data.ProductID, data.UserID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return E.ErrNotFound
@@ -160,3 +329,138 @@ func (pdb *ProductDB) DeleteProduct(data product_schemas.DeleteProduct) error {
 
 	return nil
 }
+
+// SetProductHidden hides or restores a product in the catalog, whoever owns
+// it. Returns E.ErrNotFound for unknown or deleted products.
+func (pdb *ProductDB) SetProductHidden(ctx context.Context, data product_schemas.SetProductHidden) error {
+	ctx, cancel := pdb.productStore.Context(ctx)
+	defer cancel()
+
+	query := `UPDATE ` + pdb.productStore.TableName + `
+        SET is_hidden = ?
+        WHERE product_id = ? AND is_deleted = FALSE`
+
+	res, err := pdb.productStore.DB.ExecContext(ctx, query, data.IsHidden, data.ProductID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	if affected == 0 {
+		return E.ErrNotFound
+	}
+	return nil
+}
+
+// ChangeProduct updates a product of data.UserID. The database keeps the
+// previous version as a revision. Returns E.ErrNotFound when the product does
+// not exist or belongs to someone else, E.ErrConflict when the change makes
+// it identical to another product.
+func (pdb *ProductDB) ChangeProduct(ctx context.Context, data product_schemas.ChangeProduct) error {
+	ctx, cancel := pdb.productStore.Context(ctx)
+	defer cancel()
+
+	query := `UPDATE ` + pdb.productStore.TableName + `
+        SET product_title = ?, manufacturer = ?, product_type = ?, fingerprint = ?
+        WHERE product_id = ? AND user_id = ? AND is_deleted = FALSE`
+
+	res, err := pdb.productStore.DB.ExecContext(ctx, query,
+		data.ProductTitle, data.Manufacturer, data.ProductType, data.Fingerprint, data.ProductID, data.UserID)
+	if err != nil {
+		if util.IsErrorSQLUnique(err) {
+			return E.ErrConflict
+		}
+		if util.IsErrorSQL(err, sqlite3.ErrConstraint) {
+			return E.ErrUnprocessableEntity
+		}
+		return E.ErrInternalServer
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	if affected == 0 {
+		return E.ErrNotFound
+	}
+	return nil
+}
+
+const revisionColumns = `r.revision_id, r.product_id, r.revision_number, r.product_title, r.manufacturer, r.product_type,
+        r.product_calories, r.product_fats, r.product_carbs, r.product_proteins, r.user_id, r.created_at`
+
+// revisionFrom joins the product of revisions, which hides them the way
+// GetProduct does.
+func (pdb *ProductDB) revisionFrom() string {
+	return pdb.revisionStore.TableName + ` AS r
+        INNER JOIN ` + pdb.productStore.TableName + ` AS p ON p.product_id = r.product_id`
+}
+
+func scanRevision(row interface{ Scan(dest ...any) error }) (product_schemas.ProductRevision, error) {
+	revision := product_schemas.ProductRevision{}
+	err := row.Scan(
+		&revision.RevisionID,
+		&revision.ProductID,
+		&revision.RevisionNumber,
+		&revision.ProductTitle,
+		&revision.Manufacturer,
+		&revision.ProductType,
+		&revision.ProductCalories,
+		&revision.ProductFats,
+		&revision.ProductCarbs,
+		&revision.ProductProteins,
+		&revision.UserID,
+		&revision.CreatedAt,
+	)
+	return revision, err
+}
+
+// GetProductRevisions returns the revisions of a product, newest first.
+func (pdb *ProductDB) GetProductRevisions(ctx context.Context, data product_schemas.GetProduct) ([]product_schemas.ProductRevision, error) {
+	ctx, cancel := pdb.revisionStore.Context(ctx)
+	defer cancel()
+
+	query := `SELECT ` + revisionColumns + `
+        FROM ` + pdb.revisionFrom() + `
+        WHERE r.product_id = ? AND (p.is_hidden = FALSE OR p.user_id = ? OR ?)
+        ORDER BY r.revision_number DESC`
+
+	rows, err := pdb.revisionStore.DB.QueryContext(ctx, query, data.ProductID, data.UserID, data.IncludeHidden)
+	if err != nil {
+		return []product_schemas.ProductRevision{}, E.ErrInternalServer
+	}
+	defer rows.Close()
+
+	revisions := []product_schemas.ProductRevision{}
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return []product_schemas.ProductRevision{}, E.ErrInternalServer
+		}
+		revisions = append(revisions, revision)
+	}
+	if rows.Err() != nil {
+		return []product_schemas.ProductRevision{}, E.ErrInternalServer
+	}
+	return revisions, nil
+}
+
+func (pdb *ProductDB) GetProductRevision(ctx context.Context, data product_schemas.GetProduct, revisionNumber uint) (product_schemas.ProductRevision, error) {
+	ctx, cancel := pdb.revisionStore.Context(ctx)
+	defer cancel()
+
+	query := `SELECT ` + revisionColumns + `
+        FROM ` + pdb.revisionFrom() + `
+        WHERE r.product_id = ? AND r.revision_number = ? AND (p.is_hidden = FALSE OR p.user_id = ? OR ?)`
+
+	revision, err := scanRevision(pdb.revisionStore.DB.QueryRowContext(ctx, query,
+		data.ProductID, revisionNumber, data.UserID, data.IncludeHidden))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return product_schemas.ProductRevision{}, E.ErrNotFound
+		}
+		return product_schemas.ProductRevision{}, E.ErrInternalServer
+	}
+	return revision, nil
+}
@@ -0,0 +1,152 @@
+package ratelimit_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/bmg-c/product-diary/db"
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/ratelimit"
+)
+
+// RateLimitDB keeps rate limits in SQLite, so that they survive restarts and
+// are shared by every instance using the database.
+type RateLimitDB struct {
+	rateLimitStore *db.Store
+	lockoutStore   *db.Store
+}
+
+// Compile time check
+var _ ratelimit.Store = new(RateLimitDB)
+
+func NewRateLimitDB(rateLimitStore *db.Store, lockoutStore *db.Store) (*RateLimitDB, error) {
+	if rateLimitStore == nil || lockoutStore == nil {
+		return nil, fmt.Errorf("Error creating RateLimitDB instance, one of the stores is nil")
+	}
+	return &RateLimitDB{
+		rateLimitStore: rateLimitStore,
+		lockoutStore:   lockoutStore,
+	}, nil
+}
+
+// Take refills and takes from the bucket in a single UPDATE, so concurrent
+// requests can not both take the last token.
+func (rdb *RateLimitDB) Take(ctx context.Context, key string, limit ratelimit.Limit, now time.Time) (ratelimit.Result, error) {
+	ctx, cancel := rdb.rateLimitStore.Context(ctx)
+	defer cancel()
+
+	query := `INSERT INTO ` + rdb.rateLimitStore.TableName + ` (limit_key, tokens, updated_at)
+        VALUES (?, ?, ?)
+        ON CONFLICT (limit_key) DO NOTHING`
+	_, err := rdb.rateLimitStore.DB.ExecContext(ctx, query, key, limit.Burst, now.UnixNano())
+	if err != nil {
+		return ratelimit.Result{}, E.ErrInternalServer
+	}
+
+	every := float64(limit.Every)
+	if every <= 0 {
+		every = 1
+	}
+	// Every expression of SET sees the values from before the update
+	refilled := `MIN(?1, tokens + MAX(?2 - updated_at, 0) / ?3)`
+	query = `UPDATE ` + rdb.rateLimitStore.TableName + `
+        SET allowed = ` + refilled + ` >= 1,
+            tokens = CASE WHEN ` + refilled + ` >= 1 THEN ` + refilled + ` - 1 ELSE ` + refilled + ` END,
+            updated_at = ?2
+        WHERE limit_key = ?4
+        RETURNING allowed, tokens`
+
+	var allowed bool
+	var tokens float64
+	err = rdb.rateLimitStore.DB.QueryRowContext(ctx, query,
+		limit.Burst,
+		now.UnixNano(),
+		every,
+		key,
+	).Scan(&allowed, &tokens)
+	if err != nil {
+		return ratelimit.Result{}, E.ErrInternalServer
+	}
+
+	res := ratelimit.Result{Allowed: allowed}
+	if !allowed {
+		res.RetryAfter = time.Duration((1 - tokens) * float64(limit.Every))
+	}
+	return res, nil
+}
+
+func (rdb *RateLimitDB) GetLockout(ctx context.Context, key string) (ratelimit.Lockout, error) {
+	ctx, cancel := rdb.lockoutStore.Context(ctx)
+	defer cancel()
+
+	query := `SELECT failures, last_failure, locked_until FROM ` + rdb.lockoutStore.TableName + `
+        WHERE limit_key = ?`
+
+	var failures uint
+	var lastFailure, lockedUntil int64
+	err := rdb.lockoutStore.DB.QueryRowContext(ctx, query, key).Scan(&failures, &lastFailure, &lockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ratelimit.Lockout{}, nil
+		}
+		return ratelimit.Lockout{}, E.ErrInternalServer
+	}
+
+	return ratelimit.Lockout{
+		Failures:    failures,
+		LastFailure: unixNano(lastFailure),
+		LockedUntil: unixNano(lockedUntil),
+	}, nil
+}
+
+func (rdb *RateLimitDB) SetLockout(ctx context.Context, key string, lockout ratelimit.Lockout) error {
+	ctx, cancel := rdb.lockoutStore.Context(ctx)
+	defer cancel()
+
+	query := `INSERT INTO ` + rdb.lockoutStore.TableName + ` (limit_key, failures, last_failure, locked_until)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT (limit_key) DO UPDATE SET
+            failures = excluded.failures,
+            last_failure = excluded.last_failure,
+            locked_until = excluded.locked_until`
+
+	_, err := rdb.lockoutStore.DB.ExecContext(ctx, query,
+		key,
+		lockout.Failures,
+		toUnixNano(lockout.LastFailure),
+		toUnixNano(lockout.LockedUntil),
+	)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	return nil
+}
+
+func (rdb *RateLimitDB) DeleteLockout(ctx context.Context, key string) error {
+	ctx, cancel := rdb.lockoutStore.Context(ctx)
+	defer cancel()
+
+	query := `DELETE FROM ` + rdb.lockoutStore.TableName + ` WHERE limit_key = ?`
+
+	_, err := rdb.lockoutStore.DB.ExecContext(ctx, query, key)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	return nil
+}
+
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func unixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
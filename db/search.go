@@ -0,0 +1,38 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"unicode"
+)
+
+// MatchQuery turns what a user typed into an FTS5 query: every word has to
+// match the start of a word, in any column. Words are split the way the
+// unicode61 tokenizer splits them and quoted, so the FTS5 query syntax can not
+// be used. Returns "" when there is nothing to search for.
+func MatchQuery(query string) string {
+	return strings.Join(matchWords(query), " ")
+}
+
+// MatchAnyQuery is MatchQuery for which one of the words is enough.
+func MatchAnyQuery(query string) string {
+	return strings.Join(matchWords(query), " OR ")
+}
+
+func matchWords(query string) []string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = `"` + word + `"*`
+	}
+	return words
+}
+
+// hasFTS5 tells whether SQLite was built with FTS5, which go-sqlite3 only
+// does with the sqlite_fts5 build tag.
+func hasFTS5(db *sql.DB) (bool, error) {
+	var used bool
+	err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&used)
+	return used, err
+}
@@ -0,0 +1,88 @@
+package tx_db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/bmg-c/product-diary/db"
+	"github.com/bmg-c/product-diary/db/currency_db"
+	"github.com/bmg-c/product-diary/db/item_db"
+	"github.com/bmg-c/product-diary/db/ledger_db"
+	"github.com/bmg-c/product-diary/db/product_db"
+	"github.com/bmg-c/product-diary/db/user_db"
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/services"
+)
+
+type TxDB struct {
+	database   *db.Database
+	userDB     *user_db.UserDB
+	productDB  *product_db.ProductDB
+	itemDB     *item_db.ItemDB
+	currencyDB *currency_db.CurrencyDB
+	ledgerDB   *ledger_db.LedgerDB
+}
+
+func NewTxDB(database *db.Database, userDB *user_db.UserDB, productDB *product_db.ProductDB, itemDB *item_db.ItemDB,
+	currencyDB *currency_db.CurrencyDB, ledgerDB *ledger_db.LedgerDB) (*TxDB, error) {
+	if database == nil || userDB == nil || productDB == nil || itemDB == nil || currencyDB == nil || ledgerDB == nil {
+		return nil, fmt.Errorf("Error creating TxDB instance, one of the layers is nil")
+	}
+	return &TxDB{
+		database:   database,
+		userDB:     userDB,
+		productDB:  productDB,
+		itemDB:     itemDB,
+		currencyDB: currencyDB,
+		ledgerDB:   ledgerDB,
+	}, nil
+}
+
+func (tdb *TxDB) WithTx(ctx context.Context, fn func(tx services.Tx) error) error {
+	sqlTx, err := tdb.database.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	// No-op after a successful commit, rolls back on errors and panics.
+	defer sqlTx.Rollback()
+
+	err = fn(&tx{
+		sqlTx:  sqlTx,
+		parent: tdb,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = sqlTx.Commit()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	return nil
+}
+
+type tx struct {
+	sqlTx  *sql.Tx
+	parent *TxDB
+}
+
+func (t *tx) UserDB() services.UserDB {
+	return t.parent.userDB.WithTx(t.sqlTx)
+}
+
+func (t *tx) ProductDB() services.ProductDB {
+	return t.parent.productDB.WithTx(t.sqlTx)
+}
+
+func (t *tx) ItemDB() services.ItemDB {
+	return t.parent.itemDB.WithTx(t.sqlTx)
+}
+
+func (t *tx) CurrencyDB() services.CurrencyDB {
+	return t.parent.currencyDB.WithTx(t.sqlTx)
+}
+
+func (t *tx) LedgerDB() services.LedgerDB {
+	return t.parent.ledgerDB.WithTx(t.sqlTx)
+}
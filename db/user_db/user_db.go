@@ -1,8 +1,11 @@
 package user_db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/bmg-c/product-diary/db"
 	E "github.com/bmg-c/product-diary/errorhandler"
@@ -14,27 +17,59 @@ import (
 	"github.com/mattn/go-sqlite3"
 )
 
+// passwordResetLifetime is how long a password reset token can be used, as an
+// SQLite datetime modifier. The reset mail tells the same.
+const passwordResetLifetime = "-30 minutes"
+
 type UserDB struct {
-	userStore    *db.Store
-	codeStore    *db.Store
-	sessionStore *db.Store
-	personStore  *db.Store
+	userStore     *db.Store
+	codeStore     *db.Store
+	sessionStore  *db.Store
+	personStore   *db.Store
+	resetStore    *db.Store
+	totpStore     *db.Store
+	recoveryStore *db.Store
+	apiTokenStore *db.Store
 }
 
-func NewUserDB(userStore *db.Store, codeStore *db.Store, sessionStore *db.Store, personStore *db.Store) (*UserDB, error) {
-	if userStore == nil || codeStore == nil || sessionStore == nil || personStore == nil {
+func NewUserDB(userStore *db.Store, codeStore *db.Store, sessionStore *db.Store, personStore *db.Store,
+	resetStore *db.Store, totpStore *db.Store, recoveryStore *db.Store, apiTokenStore *db.Store,
+) (*UserDB, error) {
+	if userStore == nil || codeStore == nil || sessionStore == nil || personStore == nil ||
+		resetStore == nil || totpStore == nil || recoveryStore == nil || apiTokenStore == nil {
 		return nil, fmt.Errorf("Error creating UserDB instance, one of the stores is nil")
 	}
 	return &UserDB{
-		userStore:    userStore,
-		codeStore:    codeStore,
-		sessionStore: sessionStore,
-		personStore:  personStore,
+		userStore:     userStore,
+		codeStore:     codeStore,
+		sessionStore:  sessionStore,
+		personStore:   personStore,
+		resetStore:    resetStore,
+		totpStore:     totpStore,
+		recoveryStore: recoveryStore,
+		apiTokenStore: apiTokenStore,
 	}, nil
 }
 
-func (udb *UserDB) AddCode(email string) error {
-	err := udb.deleteExpiredCodes()
+// WithTx returns a copy of the UserDB that runs every query inside tx.
+func (udb *UserDB) WithTx(tx *sql.Tx) *UserDB {
+	return &UserDB{
+		userStore:     udb.userStore.WithTx(tx),
+		codeStore:     udb.codeStore.WithTx(tx),
+		sessionStore:  udb.sessionStore.WithTx(tx),
+		personStore:   udb.personStore.WithTx(tx),
+		resetStore:    udb.resetStore.WithTx(tx),
+		totpStore:     udb.totpStore.WithTx(tx),
+		recoveryStore: udb.recoveryStore.WithTx(tx),
+		apiTokenStore: udb.apiTokenStore.WithTx(tx),
+	}
+}
+
+func (udb *UserDB) AddCode(ctx context.Context, email string, code string) error {
+	ctx, cancel := udb.codeStore.Context(ctx)
+	defer cancel()
+
+	err := udb.deleteExpiredCodes(ctx)
 	if err != nil {
 		return E.ErrInternalServer
 	}
@@ -42,12 +77,12 @@ func (udb *UserDB) AddCode(email string) error {
 	query := `INSERT INTO ` + udb.codeStore.TableName + `(code_id, email, code, created_at)
         VALUES (NULL, ?, ?, datetime('now'))`
 
-	stmt, err := udb.codeStore.DB.Prepare(query)
+	stmt, err := udb.codeStore.DB.PrepareContext(ctx, query)
 	defer stmt.Close()
 	if err != nil {
 		return E.ErrInternalServer
 	}
-	_, err = stmt.Exec(email, "000000")
+	_, err = stmt.ExecContext(ctx, email, code)
 	if err != nil {
 		return E.ErrInternalServer
 	}
@@ -55,8 +90,11 @@ func (udb *UserDB) AddCode(email string) error {
 	return nil
 }
 
-func (udb *UserDB) GetCode(email string) (string, error) {
-	err := udb.deleteExpiredCodes()
+func (udb *UserDB) GetCode(ctx context.Context, email string) (string, error) {
+	ctx, cancel := udb.codeStore.Context(ctx)
+	defer cancel()
+
+	err := udb.deleteExpiredCodes(ctx)
 	if err != nil {
 		return "", E.ErrInternalServer
 	}
@@ -64,7 +102,7 @@ func (udb *UserDB) GetCode(email string) (string, error) {
 	var code string = ""
 	query := `SELECT code FROM ` + udb.codeStore.TableName + ` WHERE email = ?`
 
-	err = udb.codeStore.DB.QueryRow(query, email).Scan(&code)
+	err = udb.codeStore.DB.QueryRowContext(ctx, query, email).Scan(&code)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return "", E.ErrNotFound
@@ -74,60 +112,267 @@ func (udb *UserDB) GetCode(email string) (string, error) {
 	return code, nil
 }
 
-func (udb *UserDB) AddUser(email string) error {
-	username := "master"
-	password := "awooga"
+// AddCodeAttempt counts a wrong guess of the code sent to email and returns
+// the number of wrong guesses so far.
+func (udb *UserDB) AddCodeAttempt(ctx context.Context, email string) (uint, error) {
+	ctx, cancel := udb.codeStore.Context(ctx)
+	defer cancel()
+
+	query := `UPDATE ` + udb.codeStore.TableName + ` SET attempts = attempts + 1
+        WHERE email = ?
+        RETURNING attempts`
+
+	var attempts uint
+	err := udb.codeStore.DB.QueryRowContext(ctx, query, email).Scan(&attempts)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, E.ErrNotFound
+		}
+		return 0, E.ErrInternalServer
+	}
+	return attempts, nil
+}
+
+func (udb *UserDB) DeleteCode(ctx context.Context, email string) error {
+	ctx, cancel := udb.codeStore.Context(ctx)
+	defer cancel()
+
+	query := `DELETE FROM ` + udb.codeStore.TableName + ` WHERE email = ?`
+
+	_, err := udb.codeStore.DB.ExecContext(ctx, query, email)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	return nil
+}
+
+// AddPasswordReset stores the hash of a reset token for the user. Earlier
+// tokens of the user stop working, only the latest mail can be used.
+func (udb *UserDB) AddPasswordReset(ctx context.Context, userID uint, tokenHash string) error {
+	ctx, cancel := udb.resetStore.Context(ctx)
+	defer cancel()
+
+	err := udb.deleteExpiredPasswordResets(ctx)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+
+	query := `DELETE FROM ` + udb.resetStore.TableName + ` WHERE user_id = ?`
+	_, err = udb.resetStore.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+
+	query = `INSERT INTO ` + udb.resetStore.TableName + `(reset_id, token_hash, user_id, created_at)
+        VALUES (NULL, ?, ?, datetime('now'))`
+	_, err = udb.resetStore.DB.ExecContext(ctx, query, tokenHash, userID)
+	if err != nil {
+		if util.IsErrorSQL(err, sqlite3.ErrConstraint) {
+			return E.ErrUnprocessableEntity
+		}
+		return E.ErrInternalServer
+	}
+
+	return nil
+}
+
+// GetPasswordReset returns the user of an unexpired reset token.
+func (udb *UserDB) GetPasswordReset(ctx context.Context, tokenHash string) (uint, error) {
+	ctx, cancel := udb.resetStore.Context(ctx)
+	defer cancel()
+
+	err := udb.deleteExpiredPasswordResets(ctx)
+	if err != nil {
+		return 0, E.ErrInternalServer
+	}
+
+	var userID uint
+	query := `SELECT user_id FROM ` + udb.resetStore.TableName + ` WHERE token_hash = ?`
+
+	err = udb.resetStore.DB.QueryRowContext(ctx, query, tokenHash).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, E.ErrNotFound
+		}
+		return 0, E.ErrInternalServer
+	}
+	return userID, nil
+}
+
+// UsePasswordReset deletes an unexpired reset token and returns its user.
+// Deleting and reading in one statement makes the token single use even
+// with concurrent requests.
+func (udb *UserDB) UsePasswordReset(ctx context.Context, tokenHash string) (uint, error) {
+	ctx, cancel := udb.resetStore.Context(ctx)
+	defer cancel()
+
+	query := `DELETE FROM ` + udb.resetStore.TableName + `
+        WHERE token_hash = ? AND created_at > datetime('now', ?)
+        RETURNING user_id`
+
+	var userID uint
+	err := udb.resetStore.DB.QueryRowContext(ctx, query, tokenHash, passwordResetLifetime).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, E.ErrNotFound
+		}
+		return 0, E.ErrInternalServer
+	}
+	return userID, nil
+}
+
+func (udb *UserDB) AddUser(ctx context.Context, data user_schemas.AddUser) error {
+	ctx, cancel := udb.userStore.Context(ctx)
+	defer cancel()
 
 	query := `INSERT INTO ` + udb.userStore.TableName + `(user_id, username, email, password, created_at)
         VALUES (NULL, ?, ?, ?, datetime('now'))`
 
-	stmt, err := udb.userStore.DB.Prepare(query)
+	stmt, err := udb.userStore.DB.PrepareContext(ctx, query)
+	if err != nil {
+		return E.ErrInternalServer
+	}
 	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, data.Username, data.Email, data.PasswordHash)
 	if err != nil {
+		if util.IsErrorSQL(err, sqlite3.ErrConstraint) {
+			return E.ErrConflict
+		}
 		return E.ErrInternalServer
 	}
-	_, err = stmt.Exec(username, email, password)
+
+	return nil
+}
+
+// UpdateUsername returns E.ErrConflict when another user has the username.
+func (udb *UserDB) UpdateUsername(ctx context.Context, userID uint, username string) error {
+	return udb.updateUserColumn(ctx, "username", userID, username)
+}
+
+// UpdateEmail returns E.ErrConflict when another user has the email.
+func (udb *UserDB) UpdateEmail(ctx context.Context, userID uint, email string) error {
+	return udb.updateUserColumn(ctx, "email", userID, email)
+}
+
+func (udb *UserDB) updateUserColumn(ctx context.Context, column string, userID uint, value string) error {
+	ctx, cancel := udb.userStore.Context(ctx)
+	defer cancel()
+
+	query := `UPDATE ` + udb.userStore.TableName + `
+        SET ` + column + ` = ?
+        WHERE user_id = ?`
+
+	res, err := udb.userStore.DB.ExecContext(ctx, query, value, userID)
 	if err != nil {
 		if util.IsErrorSQL(err, sqlite3.ErrConstraint) {
-			return E.ErrUnprocessableEntity
+			return E.ErrConflict
 		}
 		return E.ErrInternalServer
 	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	if affected == 0 {
+		return E.ErrNotFound
+	}
 
 	return nil
 }
 
-func (udb *UserDB) GetUser(userInfo user_schemas.GetUser) (user_schemas.UserDB, error) {
+func (udb *UserDB) SetUserRole(ctx context.Context, data user_schemas.SetUserRole) error {
+	return udb.updateUserColumn(ctx, "role", data.UserID, data.Role)
+}
+
+func (udb *UserDB) SetBaseCurrency(ctx context.Context, data user_schemas.SetBaseCurrency) error {
+	return udb.updateUserColumn(ctx, "base_currency", data.UserID, data.BaseCurrency)
+}
+
+func (udb *UserDB) SetUserDisabled(ctx context.Context, data user_schemas.SetUserDisabled) error {
+	ctx, cancel := udb.userStore.Context(ctx)
+	defer cancel()
+
+	query := `UPDATE ` + udb.userStore.TableName + ` SET is_disabled = ? WHERE user_id = ?`
+
+	res, err := udb.userStore.DB.ExecContext(ctx, query, data.IsDisabled, data.UserID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	if affected == 0 {
+		return E.ErrNotFound
+	}
+	return nil
+}
+
+func (udb *UserDB) UpdatePassword(ctx context.Context, userID uint, passwordHash string) error {
+	ctx, cancel := udb.userStore.Context(ctx)
+	defer cancel()
+
+	query := `UPDATE ` + udb.userStore.TableName + `
+        SET password = ?
+        WHERE user_id = ?`
+
+	stmt, err := udb.userStore.DB.PrepareContext(ctx, query)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, passwordHash, userID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	if affected == 0 {
+		return E.ErrNotFound
+	}
+
+	return nil
+}
+
+func (udb *UserDB) GetUser(ctx context.Context, userInfo user_schemas.GetUser) (user_schemas.UserDB, error) {
+	ctx, cancel := udb.userStore.Context(ctx)
+	defer cancel()
+
 	var userDB user_schemas.UserDB = user_schemas.UserDB{}
 
 	var query string = ""
 	var arg any
 	if !schemas.IsZero(userInfo.UserID) {
-		query = `SELECT user_id, username, email, password, created_at FROM ` + udb.userStore.TableName + ` 
+		query = `SELECT user_id, username, email, password, role, is_disabled, created_at, base_currency FROM ` + udb.userStore.TableName + ` 
 		    WHERE user_id = ?`
 		arg = userInfo.UserID
 	} else if !schemas.IsZero(userInfo.Email) {
-		query = `SELECT user_id, username, email, password, created_at FROM ` + udb.userStore.TableName + ` 
+		query = `SELECT user_id, username, email, password, role, is_disabled, created_at, base_currency FROM ` + udb.userStore.TableName + ` 
 		    WHERE email = ?`
 		arg = userInfo.Email
 	} else {
 		return user_schemas.UserDB{}, E.ErrUnprocessableEntity
 	}
 
-	stmt, err := udb.userStore.DB.Prepare(query)
+	stmt, err := udb.userStore.DB.PrepareContext(ctx, query)
 	if err != nil {
 		return user_schemas.UserDB{}, E.ErrInternalServer
 	}
 	defer stmt.Close()
 
-	err = stmt.QueryRow(
+	err = stmt.QueryRowContext(ctx,
 		arg,
 	).Scan(
 		&userDB.UserID,
 		&userDB.Username,
 		&userDB.Email,
-		&userDB.Password,
+		&userDB.PasswordHash,
+		&userDB.Role,
+		&userDB.IsDisabled,
 		&userDB.CreatedAt,
+		&userDB.BaseCurrency,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -145,12 +390,15 @@ func (udb *UserDB) GetUser(userInfo user_schemas.GetUser) (user_schemas.UserDB,
 	return userDB, nil
 }
 
-func (udb *UserDB) GetUsersAll() ([]user_schemas.UserDB, error) {
+func (udb *UserDB) GetUsersAll(ctx context.Context) ([]user_schemas.UserDB, error) {
+	ctx, cancel := udb.userStore.Context(ctx)
+	defer cancel()
+
 	var userDB user_schemas.UserDB = user_schemas.UserDB{}
-	query := `SELECT user_id, username, email, password, created_at FROM ` + udb.userStore.TableName +
+	query := `SELECT user_id, username, email, password, role, is_disabled, created_at, base_currency FROM ` + udb.userStore.TableName +
 		` ORDER BY created_at DESC`
 
-	rows, err := udb.userStore.DB.Query(query)
+	rows, err := udb.userStore.DB.QueryContext(ctx, query)
 	if err != nil {
 		return []user_schemas.UserDB{}, E.ErrInternalServer
 	}
@@ -162,8 +410,11 @@ func (udb *UserDB) GetUsersAll() ([]user_schemas.UserDB, error) {
 			&userDB.UserID,
 			&userDB.Username,
 			&userDB.Email,
-			&userDB.Password,
+			&userDB.PasswordHash,
+			&userDB.Role,
+			&userDB.IsDisabled,
 			&userDB.CreatedAt,
+			&userDB.BaseCurrency,
 		)
 		if err != nil {
 			return []user_schemas.UserDB{}, E.ErrInternalServer
@@ -180,30 +431,41 @@ func (udb *UserDB) GetUsersAll() ([]user_schemas.UserDB, error) {
 	return users, nil
 }
 
-func (udb *UserDB) GetSession(sessionUUID uuid.UUID) (user_schemas.SessionDB, error) {
+func (udb *UserDB) GetSession(ctx context.Context, sessionUUID uuid.UUID) (user_schemas.SessionDB, error) {
+	ctx, cancel := udb.sessionStore.Context(ctx)
+	defer cancel()
+
 	var sessionDB user_schemas.SessionDB = user_schemas.SessionDB{}
 
 	var query string = ""
 	var arg any
 	if !schemas.IsZero(sessionUUID) {
-		query = `SELECT session_uuid, user_id FROM ` + udb.sessionStore.TableName + ` 
+		query = `SELECT session_id, session_uuid, user_id, created_at, last_seen_at, expires_at, user_agent, ip, pending_2fa
+            FROM ` + udb.sessionStore.TableName + ` 
 		    WHERE session_uuid = ?`
 		arg = sessionUUID
 	} else {
 		return user_schemas.SessionDB{}, E.ErrUnprocessableEntity
 	}
 
-	stmt, err := udb.sessionStore.DB.Prepare(query)
+	stmt, err := udb.sessionStore.DB.PrepareContext(ctx, query)
 	if err != nil {
 		return user_schemas.SessionDB{}, E.ErrInternalServer
 	}
 	defer stmt.Close()
 
-	err = stmt.QueryRow(
+	err = stmt.QueryRowContext(ctx,
 		arg,
 	).Scan(
+		&sessionDB.SessionID,
 		&sessionDB.SessionUUID,
 		&sessionDB.UserID,
+		&sessionDB.CreatedAt,
+		&sessionDB.LastSeenAt,
+		&sessionDB.ExpiresAt,
+		&sessionDB.UserAgent,
+		&sessionDB.IP,
+		&sessionDB.Pending2FA,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -221,21 +483,79 @@ func (udb *UserDB) GetSession(sessionUUID uuid.UUID) (user_schemas.SessionDB, er
 	return sessionDB, nil
 }
 
-func (udb *UserDB) AddSession(userID uint) (uuid.UUID, error) {
-	query := `INSERT INTO ` + udb.sessionStore.TableName + `(session_uuid, user_id)
-        VALUES (?, ?)`
+// GetUserSessions returns the sessions of the user that have not reached
+// their absolute expiry, most recently used first. Sessions waiting for the
+// second factor are left out.
+func (udb *UserDB) GetUserSessions(ctx context.Context, userID uint) ([]user_schemas.SessionDB, error) {
+	ctx, cancel := udb.sessionStore.Context(ctx)
+	defer cancel()
+
+	query := `SELECT session_id, session_uuid, user_id, created_at, last_seen_at, expires_at, user_agent, ip
+        FROM ` + udb.sessionStore.TableName + `
+        WHERE user_id = ? AND expires_at > datetime('now') AND NOT pending_2fa
+        ORDER BY last_seen_at DESC`
+
+	rows, err := udb.sessionStore.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, E.ErrInternalServer
+	}
+	defer rows.Close()
+
+	sessions := []user_schemas.SessionDB{}
+	for rows.Next() {
+		var sessionDB user_schemas.SessionDB
+		err = rows.Scan(
+			&sessionDB.SessionID,
+			&sessionDB.SessionUUID,
+			&sessionDB.UserID,
+			&sessionDB.CreatedAt,
+			&sessionDB.LastSeenAt,
+			&sessionDB.ExpiresAt,
+			&sessionDB.UserAgent,
+			&sessionDB.IP,
+		)
+		if err != nil {
+			return nil, E.ErrInternalServer
+		}
+		sessions = append(sessions, sessionDB)
+	}
+	if rows.Err() != nil {
+		return nil, E.ErrInternalServer
+	}
+
+	return sessions, nil
+}
+
+func (udb *UserDB) AddSession(ctx context.Context, data user_schemas.AddSession) (uuid.UUID, error) {
+	ctx, cancel := udb.sessionStore.Context(ctx)
+	defer cancel()
+
+	err := udb.deleteExpiredSessions(ctx)
+	if err != nil {
+		return uuid.UUID{}, E.ErrInternalServer
+	}
+
+	query := `INSERT INTO ` + udb.sessionStore.TableName + `(session_uuid, user_id, created_at, last_seen_at, expires_at, user_agent, ip, pending_2fa)
+        VALUES (?, ?, datetime('now'), datetime('now'), ?, ?, ?, ?)`
 	sessionUUID := uuid.New()
 	sessionUUIDStr := sessionUUID.String()
 	if schemas.IsZero(sessionUUID) {
 		return uuid.UUID{}, E.ErrInternalServer
 	}
 
-	stmt, err := udb.sessionStore.DB.Prepare(query)
-	defer stmt.Close()
+	stmt, err := udb.sessionStore.DB.PrepareContext(ctx, query)
 	if err != nil {
 		return uuid.UUID{}, E.ErrInternalServer
 	}
-	_, err = stmt.Exec(sessionUUIDStr, userID)
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx,
+		sessionUUIDStr,
+		data.UserID,
+		db.FormatTime(data.ExpiresAt),
+		data.UserAgent,
+		data.IP,
+		data.Pending2FA,
+	)
 	if err != nil {
 		return uuid.UUID{}, E.ErrInternalServer
 	}
@@ -243,16 +563,404 @@ func (udb *UserDB) AddSession(userID uint) (uuid.UUID, error) {
 	return sessionUUID, nil
 }
 
-func (udb *UserDB) AddPerson(personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error) {
+// TouchSession records that the session was used at lastSeenAt.
+func (udb *UserDB) TouchSession(ctx context.Context, sessionUUID uuid.UUID, lastSeenAt time.Time) error {
+	ctx, cancel := udb.sessionStore.Context(ctx)
+	defer cancel()
+
+	query := `UPDATE ` + udb.sessionStore.TableName + ` SET last_seen_at = ? WHERE session_uuid = ?`
+
+	_, err := udb.sessionStore.DB.ExecContext(ctx, query, db.FormatTime(lastSeenAt), sessionUUID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	return nil
+}
+
+func (udb *UserDB) DeleteSession(ctx context.Context, sessionUUID uuid.UUID) error {
+	ctx, cancel := udb.sessionStore.Context(ctx)
+	defer cancel()
+
+	query := `DELETE FROM ` + udb.sessionStore.TableName + ` WHERE session_uuid = ?`
+
+	res, err := udb.sessionStore.DB.ExecContext(ctx, query, sessionUUID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	if affected == 0 {
+		return E.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteUserSession deletes a session by its ID, only if it belongs to the
+// given user.
+func (udb *UserDB) DeleteUserSession(ctx context.Context, sessionInfo user_schemas.GetUserSession) error {
+	ctx, cancel := udb.sessionStore.Context(ctx)
+	defer cancel()
+
+	query := `DELETE FROM ` + udb.sessionStore.TableName + ` WHERE session_id = ? AND user_id = ?`
+
+	res, err := udb.sessionStore.DB.ExecContext(ctx, query, sessionInfo.SessionID, sessionInfo.UserID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	if affected == 0 {
+		return E.ErrNotFound
+	}
+	return nil
+}
+
+func (udb *UserDB) DeleteUserSessions(ctx context.Context, userID uint) error {
+	ctx, cancel := udb.sessionStore.Context(ctx)
+	defer cancel()
+
+	query := `DELETE FROM ` + udb.sessionStore.TableName + ` WHERE user_id = ?`
+
+	_, err := udb.sessionStore.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	return nil
+}
+
+// GetTOTP returns the TOTP secret of the user, E.ErrNotFound if the user
+// never started enrolling.
+func (udb *UserDB) GetTOTP(ctx context.Context, userID uint) (user_schemas.TOTPDB, error) {
+	ctx, cancel := udb.totpStore.Context(ctx)
+	defer cancel()
+
+	query := `SELECT user_id, secret, confirmed_at IS NOT NULL, last_used_step FROM ` + udb.totpStore.TableName + `
+        WHERE user_id = ?`
+
+	var totpDB user_schemas.TOTPDB
+	err := udb.totpStore.DB.QueryRowContext(ctx, query, userID).Scan(
+		&totpDB.UserID,
+		&totpDB.Secret,
+		&totpDB.Enabled,
+		&totpDB.LastUsedStep,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return user_schemas.TOTPDB{}, E.ErrNotFound
+		}
+		return user_schemas.TOTPDB{}, E.ErrInternalServer
+	}
+	return totpDB, nil
+}
+
+// SetTOTPSecret starts enrolling with a new secret. An enabled secret is
+// not replaced, E.ErrConflict is returned instead.
+func (udb *UserDB) SetTOTPSecret(ctx context.Context, userID uint, secret string) error {
+	ctx, cancel := udb.totpStore.Context(ctx)
+	defer cancel()
+
+	query := `INSERT INTO ` + udb.totpStore.TableName + ` (user_id, secret, confirmed_at, last_used_step)
+        VALUES (?, ?, NULL, 0)
+        ON CONFLICT (user_id) DO UPDATE SET
+            secret = excluded.secret,
+            last_used_step = 0
+        WHERE confirmed_at IS NULL`
+
+	res, err := udb.totpStore.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	if affected == 0 {
+		return E.ErrConflict
+	}
+	return nil
+}
+
+// EnableTOTP confirms the secret being enrolled. step is the step of the code
+// that confirmed it, which can not be used again.
+func (udb *UserDB) EnableTOTP(ctx context.Context, userID uint, step int64) error {
+	ctx, cancel := udb.totpStore.Context(ctx)
+	defer cancel()
+
+	query := `UPDATE ` + udb.totpStore.TableName + `
+        SET confirmed_at = datetime('now'), last_used_step = ?
+        WHERE user_id = ? AND confirmed_at IS NULL`
+
+	res, err := udb.totpStore.DB.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	if affected == 0 {
+		return E.ErrNotFound
+	}
+	return nil
+}
+
+// UseTOTPStep records that the code of step was used. It returns
+// E.ErrUnprocessableEntity when that or a later step was already used, so
+// that concurrent requests can not both use a code.
+func (udb *UserDB) UseTOTPStep(ctx context.Context, userID uint, step int64) error {
+	ctx, cancel := udb.totpStore.Context(ctx)
+	defer cancel()
+
+	query := `UPDATE ` + udb.totpStore.TableName + `
+        SET last_used_step = ?
+        WHERE user_id = ? AND last_used_step < ?`
+
+	res, err := udb.totpStore.DB.ExecContext(ctx, query, step, userID, step)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	if affected == 0 {
+		return E.ErrUnprocessableEntity
+	}
+	return nil
+}
+
+// DeleteTOTP turns two-factor authentication off and forgets the recovery
+// codes of the user.
+func (udb *UserDB) DeleteTOTP(ctx context.Context, userID uint) error {
+	ctx, cancel := udb.totpStore.Context(ctx)
+	defer cancel()
+
+	query := `DELETE FROM ` + udb.totpStore.TableName + ` WHERE user_id = ?`
+	_, err := udb.totpStore.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	query = `DELETE FROM ` + udb.recoveryStore.TableName + ` WHERE user_id = ?`
+	_, err = udb.recoveryStore.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	return nil
+}
+
+// SetRecoveryCodes replaces the recovery codes of the user.
+func (udb *UserDB) SetRecoveryCodes(ctx context.Context, userID uint, codeHashes []string) error {
+	ctx, cancel := udb.recoveryStore.Context(ctx)
+	defer cancel()
+
+	query := `DELETE FROM ` + udb.recoveryStore.TableName + ` WHERE user_id = ?`
+	_, err := udb.recoveryStore.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+
+	query = `INSERT INTO ` + udb.recoveryStore.TableName + ` (recovery_code_id, user_id, code_hash)
+        VALUES (NULL, ?, ?)`
+	stmt, err := udb.recoveryStore.DB.PrepareContext(ctx, query)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	defer stmt.Close()
+	for _, codeHash := range codeHashes {
+		_, err = stmt.ExecContext(ctx, userID, codeHash)
+		if err != nil {
+			return E.ErrInternalServer
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode deletes a recovery code of the user. It returns
+// E.ErrUnprocessableEntity when the user has no such code.
+func (udb *UserDB) UseRecoveryCode(ctx context.Context, userID uint, codeHash string) error {
+	ctx, cancel := udb.recoveryStore.Context(ctx)
+	defer cancel()
+
+	query := `DELETE FROM ` + udb.recoveryStore.TableName + ` WHERE user_id = ? AND code_hash = ?`
+
+	res, err := udb.recoveryStore.DB.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	if affected == 0 {
+		return E.ErrUnprocessableEntity
+	}
+	return nil
+}
+
+func (udb *UserDB) CountRecoveryCodes(ctx context.Context, userID uint) (uint, error) {
+	ctx, cancel := udb.recoveryStore.Context(ctx)
+	defer cancel()
+
+	query := `SELECT COUNT(*) FROM ` + udb.recoveryStore.TableName + ` WHERE user_id = ?`
+
+	var count uint
+	err := udb.recoveryStore.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	if err != nil {
+		return 0, E.ErrInternalServer
+	}
+	return count, nil
+}
+
+func (udb *UserDB) AddAPIToken(ctx context.Context, data user_schemas.AddAPITokenDB) (uint, error) {
+	ctx, cancel := udb.apiTokenStore.Context(ctx)
+	defer cancel()
+
+	var expiresAt any
+	if !data.ExpiresAt.IsZero() {
+		expiresAt = db.FormatTime(data.ExpiresAt)
+	}
+
+	query := `INSERT INTO ` + udb.apiTokenStore.TableName + ` (user_id, name, token_hash, scopes, created_at, expires_at)
+        VALUES (?, ?, ?, ?, datetime('now'), ?)
+        RETURNING token_id`
+
+	var tokenID uint
+	err := udb.apiTokenStore.DB.QueryRowContext(ctx, query,
+		data.UserID,
+		data.Name,
+		data.TokenHash,
+		strings.Join(data.Scopes, " "),
+		expiresAt,
+	).Scan(&tokenID)
+	if err != nil {
+		return 0, E.ErrInternalServer
+	}
+	return tokenID, nil
+}
+
+// GetAPIToken finds a token by its hash, expired or not.
+func (udb *UserDB) GetAPIToken(ctx context.Context, tokenHash string) (user_schemas.APITokenDB, error) {
+	ctx, cancel := udb.apiTokenStore.Context(ctx)
+	defer cancel()
+
+	query := `SELECT token_id, user_id, name, scopes, created_at, expires_at, last_used_at
+        FROM ` + udb.apiTokenStore.TableName + `
+        WHERE token_hash = ?`
+
+	tokenDB, err := scanAPIToken(udb.apiTokenStore.DB.QueryRowContext(ctx, query, tokenHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return user_schemas.APITokenDB{}, E.ErrNotFound
+		}
+		return user_schemas.APITokenDB{}, E.ErrInternalServer
+	}
+	return tokenDB, nil
+}
+
+// GetUserAPITokens returns the tokens of the user, newest first.
+func (udb *UserDB) GetUserAPITokens(ctx context.Context, userID uint) ([]user_schemas.APITokenDB, error) {
+	ctx, cancel := udb.apiTokenStore.Context(ctx)
+	defer cancel()
+
+	query := `SELECT token_id, user_id, name, scopes, created_at, expires_at, last_used_at
+        FROM ` + udb.apiTokenStore.TableName + `
+        WHERE user_id = ?
+        ORDER BY token_id DESC`
+
+	rows, err := udb.apiTokenStore.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, E.ErrInternalServer
+	}
+	defer rows.Close()
+
+	tokens := []user_schemas.APITokenDB{}
+	for rows.Next() {
+		tokenDB, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, E.ErrInternalServer
+		}
+		tokens = append(tokens, tokenDB)
+	}
+	if rows.Err() != nil {
+		return nil, E.ErrInternalServer
+	}
+	return tokens, nil
+}
+
+// TouchAPIToken records that the token was used at lastUsedAt.
+func (udb *UserDB) TouchAPIToken(ctx context.Context, tokenID uint, lastUsedAt time.Time) error {
+	ctx, cancel := udb.apiTokenStore.Context(ctx)
+	defer cancel()
+
+	query := `UPDATE ` + udb.apiTokenStore.TableName + ` SET last_used_at = ? WHERE token_id = ?`
+
+	_, err := udb.apiTokenStore.DB.ExecContext(ctx, query, db.FormatTime(lastUsedAt), tokenID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	return nil
+}
+
+// DeleteAPIToken deletes a token, only if it belongs to the given user.
+func (udb *UserDB) DeleteAPIToken(ctx context.Context, tokenInfo user_schemas.GetAPIToken) error {
+	ctx, cancel := udb.apiTokenStore.Context(ctx)
+	defer cancel()
+
+	query := `DELETE FROM ` + udb.apiTokenStore.TableName + ` WHERE token_id = ? AND user_id = ?`
+
+	res, err := udb.apiTokenStore.DB.ExecContext(ctx, query, tokenInfo.TokenID, tokenInfo.UserID)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	if affected == 0 {
+		return E.ErrNotFound
+	}
+	return nil
+}
+
+func scanAPIToken(row interface{ Scan(dest ...any) error }) (user_schemas.APITokenDB, error) {
+	var tokenDB user_schemas.APITokenDB
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(
+		&tokenDB.TokenID,
+		&tokenDB.UserID,
+		&tokenDB.Name,
+		&scopes,
+		&tokenDB.CreatedAt,
+		&expiresAt,
+		&lastUsedAt,
+	)
+	if err != nil {
+		return user_schemas.APITokenDB{}, err
+	}
+	tokenDB.Scopes = strings.Fields(scopes)
+	tokenDB.ExpiresAt = expiresAt.Time
+	tokenDB.LastUsedAt = lastUsedAt.Time
+	return tokenDB, nil
+}
+
+func (udb *UserDB) AddPerson(ctx context.Context, personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error) {
+	ctx, cancel := udb.personStore.Context(ctx)
+	defer cancel()
+
 	query := `INSERT INTO ` + udb.personStore.TableName + `(person_id, user_id, person_name, is_hidden)
         VALUES (NULL, ?, ?, FALSE)`
 
-	stmt, err := udb.personStore.DB.Prepare(query)
+	stmt, err := udb.personStore.DB.PrepareContext(ctx, query)
 	defer stmt.Close()
 	if err != nil {
 		return user_schemas.PersonDB{}, E.ErrInternalServer
 	}
-	res, err := stmt.Exec(personInfo.UserID, personInfo.PersonName)
+	res, err := stmt.ExecContext(ctx, personInfo.UserID, personInfo.PersonName)
 	if err != nil {
 		if util.IsErrorSQL(err, sqlite3.ErrConstraint) {
 			return user_schemas.PersonDB{}, E.ErrUnprocessableEntity
@@ -273,12 +981,15 @@ func (udb *UserDB) AddPerson(personInfo user_schemas.GetPerson) (user_schemas.Pe
 	return personDB, nil
 }
 
-func (udb *UserDB) GetUserPersons(userInfo user_schemas.GetUser) ([]user_schemas.PersonDB, error) {
+func (udb *UserDB) GetUserPersons(ctx context.Context, userInfo user_schemas.GetUser) ([]user_schemas.PersonDB, error) {
+	ctx, cancel := udb.personStore.Context(ctx)
+	defer cancel()
+
 	var personDB user_schemas.PersonDB = user_schemas.PersonDB{}
 	query := `SELECT person_id, user_id, person_name, is_hidden FROM ` + udb.personStore.TableName + `
         WHERE user_id=?`
 
-	rows, err := udb.userStore.DB.Query(query, userInfo.UserID)
+	rows, err := udb.userStore.DB.QueryContext(ctx, query, userInfo.UserID)
 	if err != nil {
 		return []user_schemas.PersonDB{}, E.ErrInternalServer
 	}
@@ -301,20 +1012,23 @@ func (udb *UserDB) GetUserPersons(userInfo user_schemas.GetUser) ([]user_schemas
 	return persons, nil
 }
 
-func (udb *UserDB) ToggleHiddenPerson(personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error) {
+func (udb *UserDB) ToggleHiddenPerson(ctx context.Context, personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error) {
+	ctx, cancel := udb.personStore.Context(ctx)
+	defer cancel()
+
 	query := `UPDATE ` + udb.personStore.TableName + ` 
         SET is_hidden = 1 - is_hidden` + `
         WHERE user_id = ? AND person_name = ?
         RETURNING *`
 
-	stmt, err := udb.personStore.DB.Prepare(query)
+	stmt, err := udb.personStore.DB.PrepareContext(ctx, query)
 	defer stmt.Close()
 	if err != nil {
 		return user_schemas.PersonDB{}, E.ErrInternalServer
 	}
 
 	personDB := user_schemas.PersonDB{}
-	err = stmt.QueryRow(personInfo.UserID, personInfo.PersonName).Scan(
+	err = stmt.QueryRowContext(ctx, personInfo.UserID, personInfo.PersonName).Scan(
 		&personDB.PersonID,
 		&personDB.UserID,
 		&personDB.PersonName,
@@ -330,16 +1044,47 @@ func (udb *UserDB) ToggleHiddenPerson(personInfo user_schemas.GetPerson) (user_s
 	return personDB, nil
 }
 
-func (udb *UserDB) deleteExpiredCodes() error {
+func (udb *UserDB) deleteExpiredSessions(ctx context.Context) error {
+	ctx, cancel := udb.sessionStore.Context(ctx)
+	defer cancel()
+
+	query := `DELETE FROM ` + udb.sessionStore.TableName + ` WHERE expires_at <= datetime('now')`
+
+	_, err := udb.sessionStore.DB.ExecContext(ctx, query)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	return nil
+}
+
+func (udb *UserDB) deleteExpiredCodes(ctx context.Context) error {
+	ctx, cancel := udb.codeStore.Context(ctx)
+	defer cancel()
+
 	query := `DELETE FROM ` + udb.codeStore.TableName + ` 
         WHERE created_at <= datetime('now', '-5 minutes')`
 
-	stmt, err := udb.codeStore.DB.Prepare(query)
+	stmt, err := udb.codeStore.DB.PrepareContext(ctx, query)
 	defer stmt.Close()
 	if err != nil {
 		return E.ErrInternalServer
 	}
-	_, err = stmt.Exec()
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+
+	return nil
+}
+
+func (udb *UserDB) deleteExpiredPasswordResets(ctx context.Context) error {
+	ctx, cancel := udb.resetStore.Context(ctx)
+	defer cancel()
+
+	query := `DELETE FROM ` + udb.resetStore.TableName + ` 
+        WHERE created_at <= datetime('now', ?)`
+
+	_, err := udb.resetStore.DB.ExecContext(ctx, query, passwordResetLifetime)
 	if err != nil {
 		return E.ErrInternalServer
 	}
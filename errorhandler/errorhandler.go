@@ -8,4 +8,8 @@ var (
 	ErrInternalServer      = errors.New("Internal server error")
 	ErrNotFound            = errors.New("Not found")
 	ErrUnprocessableEntity = errors.New("Unprocessable Entity")
+	// ErrConflict is returned when a value that has to be unique is taken
+	ErrConflict = errors.New("Conflict")
+	// ErrForbidden is returned when the user is known but not allowed to act
+	ErrForbidden = errors.New("Forbidden")
 )
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	L "github.com/bmg-c/product-diary/localization"
+	"github.com/bmg-c/product-diary/logger"
+	"github.com/bmg-c/product-diary/middleware"
+	"github.com/bmg-c/product-diary/schemas"
+	"github.com/bmg-c/product-diary/schemas/currency_schemas"
+	"github.com/bmg-c/product-diary/util"
+	"github.com/bmg-c/product-diary/views/analytics_views"
+)
+
+func NewCurrencyHandler(currencyService CurrencyService) *CurrencyHandler {
+	return &CurrencyHandler{
+		currencyService: currencyService,
+	}
+}
+
+type CurrencyHandler struct {
+	currencyService CurrencyService
+}
+
+// renderRates redraws the rates block with the current rates of the user.
+func (ch *CurrencyHandler) renderRates(ctx context.Context, l *L.Localizer, r *http.Request, userID uint,
+	code *int, out *[]byte, data analytics_views.RatesBlockData) {
+	rates, err := ch.currencyService.GetRates(ctx, currency_schemas.GetRates{UserID: userID})
+	if err != nil {
+		*code = http.StatusInternalServerError
+		logger.Error.Printf("Server error %v\n", err)
+		return
+	}
+	data.Rates = rates
+	util.RenderComponent(out, analytics_views.RatesBlock(l, data), r)
+}
+
+func (ch *CurrencyHandler) HandleGetRates(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	ch.renderRates(r.Context(), l, r, userDB.UserID, &code, &out, analytics_views.RatesBlockData{})
+}
+
+func (ch *CurrencyHandler) HandleAddRate(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	var input currency_schemas.AddRate = currency_schemas.AddRate{}
+
+	err := r.ParseForm()
+	if err == nil {
+		input.RateDate, err = time.Parse("2006-01-02", r.Form.Get("rate_date"))
+	}
+	if err == nil {
+		input.Rate, err = strconv.ParseFloat(strings.Replace(r.Form.Get("rate"), ",", ".", 1), 64)
+	}
+	input.CurrencyFrom = r.Form.Get("currency_from")
+	input.CurrencyTo = r.Form.Get("currency_to")
+	input.UserID = userDB.UserID
+
+	ve := schemas.ValidateStruct(input)
+	if err != nil || ve != nil {
+		code = http.StatusUnprocessableEntity
+		ch.renderRates(r.Context(), l, r, userDB.UserID, &code, &out,
+			analytics_views.RatesBlockData{Err: L.GetError(L.MsgErrorRate)})
+		return
+	}
+
+	_, err = ch.currencyService.AddRate(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrUnprocessableEntity:
+			code = http.StatusUnprocessableEntity
+			ch.renderRates(r.Context(), l, r, userDB.UserID, &code, &out,
+				analytics_views.RatesBlockData{Err: L.GetError(L.MsgErrorRate)})
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Printf("Server error %v\n", err)
+			return
+		}
+	}
+
+	ch.renderRates(r.Context(), l, r, userDB.UserID, &code, &out, analytics_views.RatesBlockData{})
+}
+
+func (ch *CurrencyHandler) HandleDeleteRate(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	var input currency_schemas.DeleteRate = currency_schemas.DeleteRate{}
+
+	err := r.ParseForm()
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Error???? %v\n", err)
+		return
+	}
+	input.RateID, err = util.GetUintFromString(r.Form.Get("rate_id"))
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+	input.UserID = userDB.UserID
+
+	ve := schemas.ValidateStruct(input)
+	if ve != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+
+	err = ch.currencyService.DeleteRate(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrUnprocessableEntity:
+			code = http.StatusUnprocessableEntity
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Printf("Server error %v\n", err)
+			return
+		}
+	}
+
+	ch.renderRates(r.Context(), l, r, userDB.UserID, &code, &out, analytics_views.RatesBlockData{})
+}
+
+func (ch *CurrencyHandler) HandleImportRates(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	err := r.ParseForm()
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Error???? %v\n", err)
+		return
+	}
+	input := currency_schemas.ImportRates{
+		UserID: userDB.UserID,
+		CSV:    r.Form.Get("csv"),
+	}
+
+	ve := schemas.ValidateStruct(input)
+	if ve != nil {
+		code = http.StatusUnprocessableEntity
+		ch.renderRates(r.Context(), l, r, userDB.UserID, &code, &out,
+			analytics_views.RatesBlockData{Err: L.GetError(L.MsgErrorRatesCSVLine, 1)})
+		return
+	}
+
+	result, err := ch.currencyService.ImportRates(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrUnprocessableEntity:
+			code = http.StatusUnprocessableEntity
+			ch.renderRates(r.Context(), l, r, userDB.UserID, &code, &out,
+				analytics_views.RatesBlockData{Err: L.GetError(L.MsgErrorRatesCSVLine, result.BadLine)})
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Printf("Server error %v\n", err)
+			return
+		}
+	}
+
+	ch.renderRates(r.Context(), l, r, userDB.UserID, &code, &out,
+		analytics_views.RatesBlockData{Imported: result.Imported})
+}
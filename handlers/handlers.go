@@ -1,37 +1,100 @@
 package handlers
 
 import (
+	"context"
+	"time"
+
+	L "github.com/bmg-c/product-diary/localization"
+	"github.com/bmg-c/product-diary/schemas/currency_schemas"
 	"github.com/bmg-c/product-diary/schemas/item_schemas"
+	"github.com/bmg-c/product-diary/schemas/ledger_schemas"
 	"github.com/bmg-c/product-diary/schemas/product_schemas"
 	"github.com/bmg-c/product-diary/schemas/user_schemas"
 	"github.com/google/uuid"
 )
 
+// PageSize is how many rows the product and item tables load at once.
+const PageSize = 50
+
+// RateLimiter is implemented by ratelimit.Guard. Durations are how long the
+// client has to wait, zero when it may proceed.
+type RateLimiter interface {
+	Allow(ctx context.Context, keys ...string) (time.Duration, error)
+	Failure(ctx context.Context, keys ...string) (time.Duration, error)
+	Success(ctx context.Context, keys ...string) error
+}
+
 type UserService interface {
-	GetUser(userInfo user_schemas.GetUser) (user_schemas.UserPublic, error)
-	GetUsersAll() ([]user_schemas.UserPublic, error)
-	SigninUser(ur user_schemas.UserSignin) error
-	ConfirmSignin(ucr user_schemas.UserConfirmSignin) error
-	LoginUser(ul user_schemas.UserLogin) (uuid.UUID, error)
-	GetUserBySession(sessionUUID uuid.UUID) (user_schemas.UserDB, error)
-	AddPerson(personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error)
-	GetUserPersons(userInfo user_schemas.GetUser) ([]user_schemas.PersonDB, error)
-	ToggleHiddenPerson(personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error)
+	GetUser(ctx context.Context, userInfo user_schemas.GetUser) (user_schemas.UserPublic, error)
+	GetUsersAll(ctx context.Context) ([]user_schemas.UserPublic, error)
+	SigninUser(ctx context.Context, ur user_schemas.UserSignin, l *L.Localizer) error
+	ConfirmSignin(ctx context.Context, ucr user_schemas.UserConfirmSignin) error
+	RequestPasswordReset(ctx context.Context, rr user_schemas.PasswordResetRequest, l *L.Localizer) error
+	CheckPasswordReset(ctx context.Context, token string) error
+	ResetPassword(ctx context.Context, pr user_schemas.PasswordReset) error
+	UpdateUsername(ctx context.Context, data user_schemas.UpdateUsername) error
+	SetBaseCurrency(ctx context.Context, data user_schemas.SetBaseCurrency) error
+	ChangePassword(ctx context.Context, data user_schemas.ChangePassword) error
+	ChangeEmail(ctx context.Context, data user_schemas.ChangeEmail, l *L.Localizer) error
+	ConfirmChangeEmail(ctx context.Context, data user_schemas.ConfirmChangeEmail) error
+	LoginUser(ctx context.Context, ul user_schemas.UserLogin, client user_schemas.SessionClient) (user_schemas.LoginResult, error)
+	Login2FA(ctx context.Context, data user_schemas.Login2FA, client user_schemas.SessionClient) (uuid.UUID, error)
+	GetPendingUser(ctx context.Context, sessionUUID uuid.UUID) (user_schemas.UserDB, error)
+	GetTOTPStatus(ctx context.Context, userID uint) (user_schemas.TOTPStatus, error)
+	BeginTOTP(ctx context.Context, userID uint) (user_schemas.TOTPEnrollment, error)
+	GetTOTPEnrollment(ctx context.Context, userID uint) (user_schemas.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, data user_schemas.VerifyTOTP) ([]string, error)
+	RegenerateRecoveryCodes(ctx context.Context, data user_schemas.VerifyTOTP) ([]string, error)
+	DisableTOTP(ctx context.Context, data user_schemas.VerifyTOTP) error
+	CreateAPIToken(ctx context.Context, data user_schemas.AddAPIToken) (user_schemas.CreatedAPIToken, error)
+	GetAPITokens(ctx context.Context, userID uint) ([]user_schemas.APITokenDB, error)
+	RevokeAPIToken(ctx context.Context, tokenInfo user_schemas.GetAPIToken) error
+	GetUserBySession(ctx context.Context, sessionUUID uuid.UUID) (user_schemas.UserDB, error)
+	GetUserSessions(ctx context.Context, userID uint, currentUUID uuid.UUID) ([]user_schemas.SessionPublic, error)
+	LogoutUser(ctx context.Context, sessionUUID uuid.UUID) error
+	RevokeSession(ctx context.Context, sessionInfo user_schemas.GetUserSession) error
+	LogoutEverywhere(ctx context.Context, userID uint) error
+	SetUserRole(ctx context.Context, actorID uint, data user_schemas.SetUserRole) error
+	SetUserDisabled(ctx context.Context, actorID uint, data user_schemas.SetUserDisabled) error
+	ForceLogout(ctx context.Context, userID uint) error
+	AddPerson(ctx context.Context, personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error)
+	GetUserPersons(ctx context.Context, userInfo user_schemas.GetUser) ([]user_schemas.PersonDB, error)
+	ToggleHiddenPerson(ctx context.Context, personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error)
 }
 
 type ProductService interface {
-	AddProduct(data product_schemas.AddProduct) (product_schemas.ProductDB, error)
-	GetProducts(data product_schemas.GetProducts) ([]product_schemas.ProductDB, error)
-	GetProduct(data product_schemas.GetProduct) (product_schemas.ProductDB, error)
-	DeleteProduct(data product_schemas.DeleteProduct) error
+	AddProduct(ctx context.Context, data product_schemas.AddProduct) (product_schemas.ProductDB, error)
+	GetProducts(ctx context.Context, data product_schemas.GetProducts) ([]product_schemas.ProductDB, error)
+	GetProduct(ctx context.Context, data product_schemas.GetProduct) (product_schemas.ProductDB, error)
+	SimilarProducts(ctx context.Context, data product_schemas.AddProduct) ([]product_schemas.ProductDB, error)
+	DeleteProduct(ctx context.Context, data product_schemas.DeleteProduct) error
+	SetProductHidden(ctx context.Context, data product_schemas.SetProductHidden) (product_schemas.ProductDB, error)
+	ChangeProduct(ctx context.Context, data product_schemas.ChangeProduct) (product_schemas.ProductDB, error)
+	GetProductRevisions(ctx context.Context, data product_schemas.GetProduct) ([]product_schemas.ProductRevision, error)
+	GetRevisionDiff(ctx context.Context, data product_schemas.GetRevisionDiff) (product_schemas.RevisionDiff, error)
 }
 
 type ItemService interface {
-	AddItem(data item_schemas.AddItem) (item_schemas.ItemParsed, error)
-	DeleteItem(data item_schemas.DeleteItem) error
-	// GetItem(data item_schemas.GetItem) (item_schemas.ItemParsed, error)
-	GetItems(data item_schemas.GetItems) ([]item_schemas.ItemParsed, error)
-	ChangeItem(data item_schemas.ChangeItem) (item_schemas.ItemParsed, error)
-	GetAnalyticsRange(data item_schemas.GetItemsRange) (item_schemas.Analytics, error)
-	GetAnalytics(data []item_schemas.ItemParsed) (item_schemas.Analytics, error)
+	AddItem(ctx context.Context, data item_schemas.AddItem) (item_schemas.ItemParsed, error)
+	DeleteItem(ctx context.Context, data item_schemas.DeleteItem) error
+	// GetItem(ctx context.Context, data item_schemas.GetItem) (item_schemas.ItemParsed, error)
+	GetItems(ctx context.Context, data item_schemas.GetItems) ([]item_schemas.ItemParsed, error)
+	ChangeItem(ctx context.Context, data item_schemas.ChangeItem) (item_schemas.ItemParsed, error)
+	SetItemSplits(ctx context.Context, data item_schemas.SetItemSplits) (item_schemas.ItemParsed, error)
+	GetAnalyticsRange(ctx context.Context, data item_schemas.GetItemsRange) (item_schemas.Analytics, error)
+	GetAnalytics(ctx context.Context, userID uint, baseCurrency string, data []item_schemas.ItemParsed) (item_schemas.Analytics, error)
+}
+
+type CurrencyService interface {
+	AddRate(ctx context.Context, data currency_schemas.AddRate) (currency_schemas.RateDB, error)
+	DeleteRate(ctx context.Context, data currency_schemas.DeleteRate) error
+	GetRates(ctx context.Context, data currency_schemas.GetRates) ([]currency_schemas.RateDB, error)
+	ImportRates(ctx context.Context, data currency_schemas.ImportRates) (currency_schemas.ImportRatesResult, error)
+}
+
+type LedgerService interface {
+	AddPayment(ctx context.Context, data ledger_schemas.AddPayment) (ledger_schemas.PaymentDB, error)
+	DeletePayment(ctx context.Context, data ledger_schemas.DeletePayment) error
+	GetStatement(ctx context.Context, data ledger_schemas.GetStatement) (ledger_schemas.Statement, error)
+	GetBalances(ctx context.Context, data ledger_schemas.GetBalances) (ledger_schemas.Balances, error)
 }
@@ -1,13 +1,16 @@
 package handlers
 
 import (
-	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
 	"time"
 
 	E "github.com/bmg-c/product-diary/errorhandler"
+	L "github.com/bmg-c/product-diary/localization"
 	"github.com/bmg-c/product-diary/logger"
+	"github.com/bmg-c/product-diary/middleware"
 	"github.com/bmg-c/product-diary/schemas"
 	"github.com/bmg-c/product-diary/schemas/item_schemas"
 	"github.com/bmg-c/product-diary/schemas/user_schemas"
@@ -28,32 +31,29 @@ type ItemHandler struct {
 	userService UserService
 }
 
+// priceModeFromString reads one of item_schemas.PriceModes, an empty one is 0.
+func priceModeFromString(str string) (uint8, error) {
+	if str == "" {
+		return 0, nil
+	}
+	priceMode, err := util.GetUintFromString(str)
+	if err != nil || priceMode > 255 || !slices.Contains(item_schemas.PriceModes, uint8(priceMode)) {
+		return 0, E.ErrUnprocessableEntity
+	}
+	return uint8(priceMode), nil
+}
+
 func (ih *ItemHandler) HandleGetItems(w http.ResponseWriter, r *http.Request) {
 	l := util.InitHTMLHandler(w, r)
 	var code int = http.StatusOK
 	var out []byte
 	defer util.RespondHTTP(w, &code, &out)
 
-	var userDB user_schemas.UserDB
-	sessionUUID, err := util.GetUserSessionCookieValue(w, r)
-	if err != nil {
-		if errors.Is(err, E.ErrInternalServer) {
-			logger.Error.Printf("Failure getting session cookie.\n")
-		}
-	} else {
-		userDB, err = ih.userService.GetUserBySession(sessionUUID)
-		if err != nil {
-			if errors.Is(err, E.ErrInternalServer) {
-				logger.Error.Printf("Failure getting session cookie.\n")
-			}
-			code = http.StatusUnprocessableEntity
-			return
-		}
-	}
+	userDB, _ := middleware.UserFromContext(r.Context())
 
 	var input item_schemas.GetItems = item_schemas.GetItems{}
 
-	err = r.ParseForm()
+	err := r.ParseForm()
 	if err != nil {
 		code = http.StatusInternalServerError
 		logger.Error.Printf("Error???? %v\n", err)
@@ -64,6 +64,11 @@ func (ih *ItemHandler) HandleGetItems(w http.ResponseWriter, r *http.Request) {
 		code = http.StatusUnprocessableEntity
 		return
 	}
+	input.SearchQuery = r.Form.Get("search_query")
+	input.SortField = r.Form.Get("sort_field")
+	input.SortDirection = r.Form.Get("sort_direction")
+	input.Cursor = r.Form.Get("cursor")
+	input.Limit = PageSize + 1
 	input.UserID = userDB.UserID
 
 	ve := schemas.ValidateStruct(input)
@@ -72,7 +77,7 @@ func (ih *ItemHandler) HandleGetItems(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	items, err := ih.itemService.GetItems(input)
+	items, err := ih.itemService.GetItems(r.Context(), input)
 	if err != nil {
 		switch err {
 		case E.ErrUnprocessableEntity:
@@ -88,7 +93,7 @@ func (ih *ItemHandler) HandleGetItems(w http.ResponseWriter, r *http.Request) {
 	inputUser := user_schemas.GetUser{
 		UserID: userDB.UserID,
 	}
-	persons, err := ih.userService.GetUserPersons(inputUser)
+	persons, err := ih.userService.GetUserPersons(r.Context(), inputUser)
 	if err != nil {
 		switch err {
 		case E.ErrUnprocessableEntity:
@@ -101,9 +106,29 @@ func (ih *ItemHandler) HandleGetItems(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	util.RenderComponent(&out, product_views.ItemList(l, items, persons), r)
+	page := product_views.ListPage{
+		SortField:     input.SortField,
+		SortDirection: input.SortDirection,
+		First:         input.Cursor == "",
+	}
+	if len(items) > PageSize {
+		items = items[:PageSize]
+		page.NextCursor = items[PageSize-1].Cursor
+	}
+	util.RenderComponent(&out, product_views.ItemList(l, items, persons, page), r)
+	if !page.First {
+		return
+	}
 
-	a, err := ih.itemService.GetAnalytics(items)
+	// The analytics are of every item of the day, not just of the page
+	input.Limit = 0
+	items, err = ih.itemService.GetItems(r.Context(), input)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Server error %v\n", err)
+		return
+	}
+	a, err := ih.itemService.GetAnalytics(r.Context(), userDB.UserID, userDB.BaseCurrency, items)
 	if err != nil {
 		switch err {
 		case E.ErrUnprocessableEntity:
@@ -118,32 +143,26 @@ func (ih *ItemHandler) HandleGetItems(w http.ResponseWriter, r *http.Request) {
 	util.RenderComponent(&out, analytics_views.AnalyticsRangeOOB(l, a), r)
 }
 
+// costFromString reads an item cost like "12.34" in minor units, an empty one
+// is 0.
+func costFromString(str string) (int64, error) {
+	if str == "" {
+		return 0, nil
+	}
+	return util.GetMinorFromString(str)
+}
+
 func (ih *ItemHandler) HandleAddItem(w http.ResponseWriter, r *http.Request) {
 	l := util.InitHTMLHandler(w, r)
 	var code int = http.StatusOK
 	var out []byte
 	defer util.RespondHTTP(w, &code, &out)
 
-	var userDB user_schemas.UserDB
-	sessionUUID, err := util.GetUserSessionCookieValue(w, r)
-	if err != nil {
-		if errors.Is(err, E.ErrInternalServer) {
-			logger.Error.Printf("Failure getting session cookie.\n")
-		}
-	} else {
-		userDB, err = ih.userService.GetUserBySession(sessionUUID)
-		if err != nil {
-			if errors.Is(err, E.ErrInternalServer) {
-				logger.Error.Printf("Failure getting session cookie.\n")
-			}
-			code = http.StatusUnprocessableEntity
-			return
-		}
-	}
+	userDB, _ := middleware.UserFromContext(r.Context())
 
 	var input item_schemas.AddItem = item_schemas.AddItem{}
 
-	err = r.ParseForm()
+	err := r.ParseForm()
 	if err != nil {
 		code = http.StatusInternalServerError
 		logger.Error.Printf("Error???? %v\n", err)
@@ -159,12 +178,31 @@ func (ih *ItemHandler) HandleAddItem(w http.ResponseWriter, r *http.Request) {
 		code = http.StatusUnprocessableEntity
 		return
 	}
-	input.ItemCost, _ = util.GetFloatFromString(r.Form.Get("item_cost"))
+	input.ItemCost, err = costFromString(r.Form.Get("item_cost"))
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
 	itemTypeMaybe, err := util.GetUintFromString(r.Form.Get("item_type"))
 	if err == nil {
 		input.ItemType = uint8(itemTypeMaybe)
 	}
 	input.PersonID, _ = util.GetUintFromString(r.Form.Get("person_id"))
+	input.ItemUnit, err = unitFromString(r.Form.Get("item_unit"))
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+	input.PriceMode, err = priceModeFromString(r.Form.Get("price_mode"))
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+	input.Currency = r.Form.Get("currency")
+	if input.Currency == "" {
+		// Nothing bought of the product yet
+		input.Currency = userDB.BaseCurrency
+	}
 	input.UserID = userDB.UserID
 
 	ve := schemas.ValidateStruct(input)
@@ -173,7 +211,7 @@ func (ih *ItemHandler) HandleAddItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	itemParsed, err := ih.itemService.AddItem(input)
+	itemParsed, err := ih.itemService.AddItem(r.Context(), input)
 	if err != nil {
 		switch err {
 		case E.ErrUnprocessableEntity:
@@ -189,7 +227,7 @@ func (ih *ItemHandler) HandleAddItem(w http.ResponseWriter, r *http.Request) {
 	inputUser := user_schemas.GetUser{
 		UserID: userDB.UserID,
 	}
-	persons, err := ih.userService.GetUserPersons(inputUser)
+	persons, err := ih.userService.GetUserPersons(r.Context(), inputUser)
 	if err != nil {
 		switch err {
 		case E.ErrUnprocessableEntity:
@@ -211,26 +249,11 @@ func (ih *ItemHandler) HandleDeleteItem(w http.ResponseWriter, r *http.Request)
 	var out []byte
 	defer util.RespondHTTP(w, &code, &out)
 
-	var userDB user_schemas.UserDB
-	sessionUUID, err := util.GetUserSessionCookieValue(w, r)
-	if err != nil {
-		if errors.Is(err, E.ErrInternalServer) {
-			logger.Error.Printf("Failure getting session cookie.\n")
-		}
-	} else {
-		userDB, err = ih.userService.GetUserBySession(sessionUUID)
-		if err != nil {
-			if errors.Is(err, E.ErrInternalServer) {
-				logger.Error.Printf("Failure getting session cookie.\n")
-			}
-			code = http.StatusUnprocessableEntity
-			return
-		}
-	}
+	userDB, _ := middleware.UserFromContext(r.Context())
 
 	var input item_schemas.DeleteItem = item_schemas.DeleteItem{}
 
-	err = r.ParseForm()
+	err := r.ParseForm()
 	if err != nil {
 		code = http.StatusInternalServerError
 		logger.Error.Printf("Error???? %v\n", err)
@@ -249,7 +272,7 @@ func (ih *ItemHandler) HandleDeleteItem(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = ih.itemService.DeleteItem(input)
+	err = ih.itemService.DeleteItem(r.Context(), input)
 	if err != nil {
 		switch err {
 		case E.ErrUnprocessableEntity:
@@ -269,26 +292,11 @@ func (ih *ItemHandler) HandleChangeItem(w http.ResponseWriter, r *http.Request)
 	var out []byte
 	defer util.RespondHTTP(w, &code, &out)
 
-	var userDB user_schemas.UserDB
-	sessionUUID, err := util.GetUserSessionCookieValue(w, r)
-	if err != nil {
-		if errors.Is(err, E.ErrInternalServer) {
-			logger.Error.Printf("Failure getting session cookie.\n")
-		}
-	} else {
-		userDB, err = ih.userService.GetUserBySession(sessionUUID)
-		if err != nil {
-			if errors.Is(err, E.ErrInternalServer) {
-				logger.Error.Printf("Failure getting session cookie.\n")
-			}
-			code = http.StatusUnprocessableEntity
-			return
-		}
-	}
+	userDB, _ := middleware.UserFromContext(r.Context())
 
 	var input item_schemas.ChangeItem = item_schemas.ChangeItem{}
 
-	err = r.ParseForm()
+	err := r.ParseForm()
 	if err != nil {
 		code = http.StatusInternalServerError
 		logger.Error.Printf("Error???? %v\n", err)
@@ -300,11 +308,26 @@ func (ih *ItemHandler) HandleChangeItem(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	input.ProductID, _ = util.GetUintFromString(r.Form.Get("product_id"))
-	input.ItemCost, _ = util.GetFloatFromString(r.Form.Get("item_cost"))
+	input.ItemCost, err = costFromString(r.Form.Get("item_cost"))
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
 	input.ItemAmount, _ = util.GetFloatFromString(r.Form.Get("item_amount"))
 	typ, _ := util.GetUintFromString(r.Form.Get("item_type"))
 	input.ItemType = uint8(typ)
 	input.PersonID, _ = util.GetUintFromString(r.Form.Get("person_id"))
+	input.ItemUnit, err = unitFromString(r.Form.Get("item_unit"))
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+	input.PriceMode, err = priceModeFromString(r.Form.Get("price_mode"))
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+	input.Currency = r.Form.Get("currency")
 	input.UserID = userDB.UserID
 
 	ve := schemas.ValidateStruct(input)
@@ -313,7 +336,7 @@ func (ih *ItemHandler) HandleChangeItem(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	itemParsed, err := ih.itemService.ChangeItem(input)
+	itemParsed, err := ih.itemService.ChangeItem(r.Context(), input)
 	if err != nil {
 		switch err {
 		case E.ErrUnprocessableEntity:
@@ -329,7 +352,7 @@ func (ih *ItemHandler) HandleChangeItem(w http.ResponseWriter, r *http.Request)
 	inputUser := user_schemas.GetUser{
 		UserID: userDB.UserID,
 	}
-	persons, err := ih.userService.GetUserPersons(inputUser)
+	persons, err := ih.userService.GetUserPersons(r.Context(), inputUser)
 	if err != nil {
 		switch err {
 		case E.ErrUnprocessableEntity:
@@ -342,9 +365,11 @@ func (ih *ItemHandler) HandleChangeItem(w http.ResponseWriter, r *http.Request)
 		}
 	}
 	w.Header().Add("HX-Trigger", fmt.Sprintf(
-		`{"setTempValues":{"product_id":%d, "item_cost":%f, "item_type":%d, "person_id":%d}}`,
+		`{"setTempValues":{"product_id":%d, "item_cost":"%s", "price_mode":%d, "currency":"%s", "item_type":%d, "person_id":%d}}`,
 		itemParsed.ProductID,
-		itemParsed.ItemCost,
+		util.FormatMinor(itemParsed.ItemCost),
+		itemParsed.PriceMode,
+		itemParsed.Currency,
 		itemParsed.ItemType,
 		itemParsed.PersonID,
 	))
@@ -352,32 +377,123 @@ func (ih *ItemHandler) HandleChangeItem(w http.ResponseWriter, r *http.Request)
 	util.RenderComponent(&out, product_views.Item(l, itemParsed, persons), r)
 }
 
-func (ih *ItemHandler) HandleGetAnalyticsRange(w http.ResponseWriter, r *http.Request) {
+// splitsFromForm reads the split rows of the form, rows without a share type
+// are left out. Every split is validated, since schemas.ValidateStruct does
+// not look into slices.
+func splitsFromForm(form url.Values) ([]item_schemas.Split, error) {
+	personIDs := form["split_person_id"]
+	shareTypes := form["split_share_type"]
+	shareValues := form["split_share_value"]
+	if len(personIDs) != len(shareTypes) || len(personIDs) != len(shareValues) {
+		return nil, E.ErrUnprocessableEntity
+	}
+	splits := []item_schemas.Split{}
+	for ind := range personIDs {
+		if shareTypes[ind] == "" {
+			continue
+		}
+		split := item_schemas.Split{}
+		var err error
+		if personIDs[ind] != "" {
+			split.PersonID, err = util.GetUintFromString(personIDs[ind])
+		}
+		if err == nil {
+			split.ShareType, err = unitFromString(shareTypes[ind])
+		}
+		if err == nil && split.ShareType != item_schemas.ShareTypeEqual {
+			// Percentages are in hundredths like costs
+			split.ShareValue, err = costFromString(shareValues[ind])
+		}
+		if err != nil || schemas.ValidateStruct(split) != nil {
+			return nil, E.ErrUnprocessableEntity
+		}
+		splits = append(splits, split)
+	}
+	return splits, nil
+}
+
+func (ih *ItemHandler) HandleSetItemSplits(w http.ResponseWriter, r *http.Request) {
 	l := util.InitHTMLHandler(w, r)
 	var code int = http.StatusOK
 	var out []byte
 	defer util.RespondHTTP(w, &code, &out)
 
-	var userDB user_schemas.UserDB
-	sessionUUID, err := util.GetUserSessionCookieValue(w, r)
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	var input item_schemas.SetItemSplits = item_schemas.SetItemSplits{}
+
+	err := r.ParseForm()
 	if err != nil {
-		if errors.Is(err, E.ErrInternalServer) {
-			logger.Error.Printf("Failure getting session cookie.\n")
-		}
-	} else {
-		userDB, err = ih.userService.GetUserBySession(sessionUUID)
-		if err != nil {
-			if errors.Is(err, E.ErrInternalServer) {
-				logger.Error.Printf("Failure getting session cookie.\n")
-			}
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Error???? %v\n", err)
+		return
+	}
+	input.ItemID, err = util.GetUintFromString(r.Form.Get("item_id"))
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+	input.UserID = userDB.UserID
+
+	inputUser := user_schemas.GetUser{
+		UserID: userDB.UserID,
+	}
+	persons, err := ih.userService.GetUserPersons(r.Context(), inputUser)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Server error %v\n", err)
+		return
+	}
+	data := product_views.ItemSplitsData{
+		ItemID:  input.ItemID,
+		Persons: persons,
+		Open:    true,
+	}
+
+	input.Splits, err = splitsFromForm(r.Form)
+	if err == nil && schemas.ValidateStruct(input) != nil {
+		err = E.ErrUnprocessableEntity
+	}
+	if err == nil {
+		var itemParsed item_schemas.ItemParsed
+		itemParsed, err = ih.itemService.SetItemSplits(r.Context(), input)
+		data.Splits = itemParsed.Splits
+	}
+	if err != nil {
+		switch err {
+		case E.ErrUnprocessableEntity:
 			code = http.StatusUnprocessableEntity
+			// Show the refused splits as they were given
+			for _, split := range input.Splits {
+				data.Splits = append(data.Splits, item_schemas.SplitDB{
+					ItemID:     input.ItemID,
+					PersonID:   split.PersonID,
+					ShareType:  split.ShareType,
+					ShareValue: split.ShareValue,
+				})
+			}
+			data.Err = L.GetError(L.MsgErrorSplit)
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Printf("Server error %v\n", err)
 			return
 		}
 	}
 
+	util.RenderComponent(&out, product_views.ItemSplits(l, data), r)
+}
+
+func (ih *ItemHandler) HandleGetAnalyticsRange(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
 	var input item_schemas.GetItemsRange = item_schemas.GetItemsRange{}
 
-	err = r.ParseForm()
+	err := r.ParseForm()
 	if err != nil {
 		code = http.StatusInternalServerError
 		logger.Error.Printf("Error???? %v\n", err)
@@ -395,6 +511,7 @@ func (ih *ItemHandler) HandleGetAnalyticsRange(w http.ResponseWriter, r *http.Re
 		return
 	}
 	input.UserID = userDB.UserID
+	input.BaseCurrency = userDB.BaseCurrency
 
 	ve := schemas.ValidateStruct(input)
 	if ve != nil {
@@ -402,7 +519,7 @@ func (ih *ItemHandler) HandleGetAnalyticsRange(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	a, err := ih.itemService.GetAnalyticsRange(input)
+	a, err := ih.itemService.GetAnalyticsRange(r.Context(), input)
 	if err != nil {
 		switch err {
 		case E.ErrUnprocessableEntity:
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	L "github.com/bmg-c/product-diary/localization"
+	"github.com/bmg-c/product-diary/logger"
+	"github.com/bmg-c/product-diary/middleware"
+	"github.com/bmg-c/product-diary/schemas"
+	"github.com/bmg-c/product-diary/schemas/ledger_schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/util"
+	"github.com/bmg-c/product-diary/views/ledger_views"
+)
+
+func NewLedgerHandler(ledgerService LedgerService) *LedgerHandler {
+	return &LedgerHandler{
+		ledgerService: ledgerService,
+	}
+}
+
+type LedgerHandler struct {
+	ledgerService LedgerService
+}
+
+func (lh *LedgerHandler) HandleLedgerPage(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	util.RenderComponent(&out, ledger_views.LedgerPage(l), r)
+}
+
+func (lh *LedgerHandler) HandleStatementPage(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	personID, err := util.GetUintFromString(r.URL.Query().Get("person_id"))
+	if err != nil {
+		code = http.StatusNotFound
+		return
+	}
+	util.RenderComponent(&out, ledger_views.StatementPage(l, personID), r)
+}
+
+func (lh *LedgerHandler) HandleGetBalances(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	input := ledger_schemas.GetBalances{
+		UserID:       userDB.UserID,
+		BaseCurrency: userDB.BaseCurrency,
+	}
+	balances, err := lh.ledgerService.GetBalances(r.Context(), input)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Server error %v\n", err)
+		return
+	}
+	util.RenderComponent(&out, ledger_views.BalancesBlock(l, balances), r)
+}
+
+// renderStatement redraws the statement of the person after a change.
+func (lh *LedgerHandler) renderStatement(ctx context.Context, l *L.Localizer, r *http.Request, userDB user_schemas.UserDB,
+	personID uint, code *int, out *[]byte, data ledger_views.StatementBlockData) {
+	statement, err := lh.ledgerService.GetStatement(ctx, ledger_schemas.GetStatement{
+		UserID:       userDB.UserID,
+		PersonID:     personID,
+		BaseCurrency: userDB.BaseCurrency,
+	})
+	if err != nil {
+		switch err {
+		case E.ErrNotFound:
+			*code = http.StatusNotFound
+			*out = []byte(l.GetLocalized(L.MsgErrorGetPersonNotFound))
+			return
+		default:
+			*code = http.StatusInternalServerError
+			logger.Error.Printf("Server error %v\n", err)
+			return
+		}
+	}
+	data.Statement = statement
+	util.RenderComponent(out, ledger_views.StatementBlock(l, data), r)
+}
+
+func (lh *LedgerHandler) HandleGetStatement(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	err := r.ParseForm()
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Error???? %v\n", err)
+		return
+	}
+	personID, err := util.GetUintFromString(r.Form.Get("person_id"))
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+
+	lh.renderStatement(r.Context(), l, r, userDB, personID, &code, &out, ledger_views.StatementBlockData{})
+}
+
+func (lh *LedgerHandler) HandleAddPayment(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	var input ledger_schemas.AddPayment = ledger_schemas.AddPayment{}
+
+	err := r.ParseForm()
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Error???? %v\n", err)
+		return
+	}
+	input.PersonID, err = util.GetUintFromString(r.Form.Get("person_id"))
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+	input.PaymentDate, err = time.Parse("2006-01-02", r.Form.Get("payment_date"))
+	if err == nil {
+		input.PaymentType, err = unitFromString(r.Form.Get("payment_type"))
+	}
+	if err == nil {
+		input.Amount, err = util.GetMinorFromString(r.Form.Get("amount"))
+	}
+	input.Currency = r.Form.Get("currency")
+	input.Note = r.Form.Get("note")
+	input.UserID = userDB.UserID
+
+	ve := schemas.ValidateStruct(input)
+	if err != nil || ve != nil {
+		code = http.StatusUnprocessableEntity
+		lh.renderStatement(r.Context(), l, r, userDB, input.PersonID, &code, &out,
+			ledger_views.StatementBlockData{Err: L.GetError(L.MsgErrorPayment)})
+		return
+	}
+
+	_, err = lh.ledgerService.AddPayment(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrUnprocessableEntity:
+			code = http.StatusUnprocessableEntity
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Printf("Server error %v\n", err)
+			return
+		}
+	}
+
+	lh.renderStatement(r.Context(), l, r, userDB, input.PersonID, &code, &out, ledger_views.StatementBlockData{})
+}
+
+func (lh *LedgerHandler) HandleDeletePayment(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	var input ledger_schemas.DeletePayment = ledger_schemas.DeletePayment{}
+
+	err := r.ParseForm()
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Error???? %v\n", err)
+		return
+	}
+	input.PaymentID, err = util.GetUintFromString(r.Form.Get("payment_id"))
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+	personID, err := util.GetUintFromString(r.Form.Get("person_id"))
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+	input.UserID = userDB.UserID
+
+	ve := schemas.ValidateStruct(input)
+	if ve != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+
+	err = lh.ledgerService.DeletePayment(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrUnprocessableEntity:
+			code = http.StatusUnprocessableEntity
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Printf("Server error %v\n", err)
+			return
+		}
+	}
+
+	lh.renderStatement(r.Context(), l, r, userDB, personID, &code, &out, ledger_views.StatementBlockData{})
+}
@@ -1,12 +1,13 @@
 package handlers
 
 import (
-	"errors"
 	"net/http"
+	"strings"
 
 	E "github.com/bmg-c/product-diary/errorhandler"
 	L "github.com/bmg-c/product-diary/localization"
 	"github.com/bmg-c/product-diary/logger"
+	"github.com/bmg-c/product-diary/middleware"
 	"github.com/bmg-c/product-diary/schemas"
 	"github.com/bmg-c/product-diary/schemas/product_schemas"
 	"github.com/bmg-c/product-diary/schemas/user_schemas"
@@ -35,58 +36,133 @@ func (ph *ProductHandler) HandleProductsPage(w http.ResponseWriter, r *http.Requ
 	util.RenderComponent(&out, product_views.ProductsPage(l), r)
 }
 
-func (ph *ProductHandler) HandleAddProduct(w http.ResponseWriter, r *http.Request) {
+// productTypeFromString reads a product type, an empty one is food.
+func productTypeFromString(str string) (uint8, error) {
+	if str == "" {
+		return product_schemas.ProductTypeFood, nil
+	}
+	productType, err := util.GetUintFromString(str)
+	if err != nil || productType > 255 {
+		return 0, E.ErrUnprocessableEntity
+	}
+	return uint8(productType), nil
+}
+
+// unitFromString reads one of product_schemas.Units, an empty one is 0.
+func unitFromString(str string) (uint8, error) {
+	if str == "" {
+		return 0, nil
+	}
+	unit, err := util.GetUintFromString(str)
+	if err != nil || unit > 255 {
+		return 0, E.ErrUnprocessableEntity
+	}
+	return uint8(unit), nil
+}
+
+// HandleProductAddRow redraws the add row, so that the nutrients are only
+// asked for food.
+func (ph *ProductHandler) HandleProductAddRow(w http.ResponseWriter, r *http.Request) {
 	l := util.InitHTMLHandler(w, r)
 	var code int = http.StatusOK
 	var out []byte
 	defer util.RespondHTTP(w, &code, &out)
 
-	var userDB user_schemas.UserDB
-	sessionUUID, err := util.GetUserSessionCookieValue(w, r)
+	var input product_schemas.AddProduct = product_schemas.AddProduct{}
+
+	err := r.ParseForm()
 	if err != nil {
-		if errors.Is(err, E.ErrInternalServer) {
-			logger.Error.Printf("Failure getting session cookie.\n")
-		}
-	} else {
-		userDB, err = ph.userService.GetUserBySession(sessionUUID)
-		if err != nil {
-			if errors.Is(err, E.ErrInternalServer) {
-				logger.Error.Printf("Failure getting session cookie.\n")
-			}
-			code = http.StatusUnprocessableEntity
-			return
+		code = http.StatusUnprocessableEntity
+		return
+	}
+	input.ProductTitle = r.Form.Get("product_title")
+	input.Manufacturer = r.Form.Get("manufacturer")
+	input.ProductType, _ = productTypeFromString(r.Form.Get("product_type"))
+	input.ProductCalories, _ = util.GetFloatFromString(r.Form.Get("product_calories"))
+	input.ProductFats, _ = util.GetFloatFromString(r.Form.Get("product_fats"))
+	input.ProductCarbs, _ = util.GetFloatFromString(r.Form.Get("product_carbs"))
+	input.ProductProteins, _ = util.GetFloatFromString(r.Form.Get("product_proteins"))
+	input.NetQuantity, _ = util.GetFloatFromString(r.Form.Get("net_quantity"))
+	input.NetUnit, _ = unitFromString(r.Form.Get("net_unit"))
+	input.Density, _ = util.GetFloatFromString(r.Form.Get("density"))
+
+	util.RenderComponent(&out, product_views.ProductAddRow(l, input, product_views.ProductAddRowErrors{}), r)
+}
+
+// similarTitles lists products for MsgErrorProductSimilar.
+func similarTitles(products []product_schemas.ProductDB) string {
+	titles := make([]string, 0, len(products))
+	for _, productDB := range products {
+		title := productDB.ProductTitle
+		if productDB.Manufacturer != "" {
+			title += " (" + productDB.Manufacturer + ")"
 		}
+		titles = append(titles, strings.ReplaceAll(title, L.Divider, "/"))
 	}
+	return strings.Join(titles, "; ")
+}
+
+func (ph *ProductHandler) HandleAddProduct(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
 
 	var input product_schemas.AddProduct = product_schemas.AddProduct{}
 	var inputErrs product_views.ProductAddRowErrors = product_views.ProductAddRowErrors{}
 
-	err = r.ParseForm()
+	err := r.ParseForm()
 	if err != nil {
 		code = http.StatusInternalServerError
 		logger.Error.Printf("Error???? %v\n", err)
 		return
 	}
 	input.ProductTitle = r.Form.Get("product_title")
-	input.ProductCalories, err = util.GetFloatFromString(r.Form.Get("product_calories"))
-	if err != nil {
-		code = http.StatusUnprocessableEntity
-		inputErrs.CaloriesErr = L.GetError(L.MsgErrorProductCalories)
-	}
-	input.ProductFats, err = util.GetFloatFromString(r.Form.Get("product_fats"))
+	input.Manufacturer = r.Form.Get("manufacturer")
+	input.ProductType, err = productTypeFromString(r.Form.Get("product_type"))
 	if err != nil {
 		code = http.StatusUnprocessableEntity
-		inputErrs.FatsErr = L.GetError(L.MsgErrorProductNutrient)
+		inputErrs.TypeErr = L.GetError(L.MsgErrorProductType)
 	}
-	input.ProductCarbs, err = util.GetFloatFromString(r.Form.Get("product_carbs"))
-	if err != nil {
-		code = http.StatusUnprocessableEntity
-		inputErrs.CarbsErr = L.GetError(L.MsgErrorProductNutrient)
-	}
-	input.ProductProteins, err = util.GetFloatFromString(r.Form.Get("product_proteins"))
-	if err != nil {
-		code = http.StatusUnprocessableEntity
-		inputErrs.ProteinsErr = L.GetError(L.MsgErrorProductNutrient)
+	// Nutrients are only required for food
+	if input.ProductType == product_schemas.ProductTypeFood {
+		input.ProductCalories, err = util.GetFloatFromString(r.Form.Get("product_calories"))
+		if err != nil {
+			code = http.StatusUnprocessableEntity
+			inputErrs.CaloriesErr = L.GetError(L.MsgErrorProductCalories)
+		}
+		input.ProductFats, err = util.GetFloatFromString(r.Form.Get("product_fats"))
+		if err != nil {
+			code = http.StatusUnprocessableEntity
+			inputErrs.FatsErr = L.GetError(L.MsgErrorProductNutrient)
+		}
+		input.ProductCarbs, err = util.GetFloatFromString(r.Form.Get("product_carbs"))
+		if err != nil {
+			code = http.StatusUnprocessableEntity
+			inputErrs.CarbsErr = L.GetError(L.MsgErrorProductNutrient)
+		}
+		input.ProductProteins, err = util.GetFloatFromString(r.Form.Get("product_proteins"))
+		if err != nil {
+			code = http.StatusUnprocessableEntity
+			inputErrs.ProteinsErr = L.GetError(L.MsgErrorProductNutrient)
+		}
+		input.NetQuantity, err = util.GetFloatFromString(r.Form.Get("net_quantity"))
+		if err != nil {
+			code = http.StatusUnprocessableEntity
+			inputErrs.NetQuantityErr = L.GetError(L.MsgErrorProductNetQuantity)
+		}
+		input.NetUnit, err = unitFromString(r.Form.Get("net_unit"))
+		if err != nil {
+			code = http.StatusUnprocessableEntity
+			inputErrs.NetQuantityErr = L.GetError(L.MsgErrorProductNetQuantity)
+		}
+		input.Density, err = util.GetFloatFromString(r.Form.Get("density"))
+		if err != nil {
+			code = http.StatusUnprocessableEntity
+			inputErrs.DensityErr = L.GetError(L.MsgErrorProductDensity)
+		}
 	}
 	input.UserID = userDB.UserID
 	ve := schemas.ValidateStruct(input)
@@ -97,6 +173,10 @@ func (ph *ProductHandler) HandleAddProduct(w http.ResponseWriter, r *http.Reques
 			switch fe.Name() {
 			case "ProductTitle":
 				inputErrs.TitleErr = L.GetError(L.MsgErrorProductTitle)
+			case "Manufacturer":
+				inputErrs.ManufacturerErr = L.GetError(L.MsgErrorProductManufacturer)
+			case "ProductType":
+				inputErrs.TypeErr = L.GetError(L.MsgErrorProductType)
 			case "ProductCalories":
 				inputErrs.CaloriesErr = L.GetError(L.MsgErrorProductCalories)
 			case "ProductFats":
@@ -105,13 +185,48 @@ func (ph *ProductHandler) HandleAddProduct(w http.ResponseWriter, r *http.Reques
 				inputErrs.CarbsErr = L.GetError(L.MsgErrorProductNutrient)
 			case "ProductProteins":
 				inputErrs.ProteinsErr = L.GetError(L.MsgErrorProductNutrient)
+			case "NetQuantity", "NetUnit":
+				inputErrs.NetQuantityErr = L.GetError(L.MsgErrorProductNetQuantity)
+			case "Density":
+				inputErrs.DensityErr = L.GetError(L.MsgErrorProductDensity)
 			}
 		}
 	}
 
-	productDB, err := ph.productService.AddProduct(input)
+	if code == http.StatusUnprocessableEntity {
+		util.RenderComponent(&out, product_views.ProductAddRow(l, input, inputErrs), r)
+		return
+	}
+
+	// Similar products are suggested once, the user can then add anyway
+	if r.Form.Get("confirmed") != "true" {
+		similar, err := ph.productService.SimilarProducts(r.Context(), input)
+		if err != nil {
+			switch err {
+			case E.ErrConflict:
+				code = http.StatusUnprocessableEntity
+				inputErrs.TitleErr = L.GetError(L.MsgErrorProductExists)
+				util.RenderComponent(&out, product_views.ProductAddRow(l, input, inputErrs), r)
+			default:
+				code = http.StatusInternalServerError
+				logger.Error.Printf("Server error %v\n", err)
+			}
+			return
+		}
+		if len(similar) > 0 {
+			code = http.StatusUnprocessableEntity
+			inputErrs.SuggestionErr = L.GetError(L.MsgErrorProductSimilar, similarTitles(similar))
+			util.RenderComponent(&out, product_views.ProductAddRow(l, input, inputErrs), r)
+			return
+		}
+	}
+
+	productDB, err := ph.productService.AddProduct(r.Context(), input)
 	if err != nil {
 		switch err {
+		case E.ErrConflict:
+			code = http.StatusUnprocessableEntity
+			inputErrs.TitleErr = L.GetError(L.MsgErrorProductExists)
 		case E.ErrUnprocessableEntity:
 			code = http.StatusUnprocessableEntity
 		default:
@@ -121,9 +236,9 @@ func (ph *ProductHandler) HandleAddProduct(w http.ResponseWriter, r *http.Reques
 		}
 		util.RenderComponent(&out, product_views.ProductAddRow(l, input, inputErrs), r)
 	} else {
-		input = product_schemas.AddProduct{}
+		input = product_schemas.AddProduct{ProductType: product_schemas.ProductTypeFood}
 		util.RenderComponent(&out, product_views.ProductAddRow(l, input, inputErrs), r)
-		util.RenderComponent(&out, product_views.Product(l, productDB, userDB.UserID), r)
+		util.RenderComponent(&out, product_views.Product(l, productDB, userDB.UserID, userDB.Can(user_schemas.PermModerateProducts)), r)
 	}
 }
 
@@ -133,39 +248,52 @@ func (ph *ProductHandler) HandleGetProducts(w http.ResponseWriter, r *http.Reque
 	var out []byte
 	defer util.RespondHTTP(w, &code, &out)
 
-	var userDB user_schemas.UserDB
-	sessionUUID, err := util.GetUserSessionCookieValue(w, r)
-	if err != nil {
-		if errors.Is(err, E.ErrInternalServer) {
-			logger.Error.Printf("Failure getting session cookie.\n")
-		}
-	} else {
-		userDB, err = ph.userService.GetUserBySession(sessionUUID)
-		if err != nil {
-			if errors.Is(err, E.ErrInternalServer) {
-				logger.Error.Printf("Failure getting session cookie.\n")
-			}
-			code = http.StatusUnprocessableEntity
-			return
-		}
-	}
+	userDB, _ := middleware.UserFromContext(r.Context())
 
 	var input product_schemas.GetProducts = product_schemas.GetProducts{}
 
-	err = r.ParseForm()
+	err := r.ParseForm()
 	input.SearchQuery = r.Form.Get("search_query")
+	input.IncludeHidden = userDB.Can(user_schemas.PermModerateProducts)
 	if err != nil {
 		code = http.StatusInternalServerError
 		logger.Error.Printf("Error???? %v\n", err)
 	}
+	input.SortField = r.Form.Get("sort_field")
+	input.SortDirection = r.Form.Get("sort_direction")
+	input.Cursor = r.Form.Get("cursor")
+	// One more than a page tells whether there is a next one
+	input.Limit = PageSize + 1
+
+	ve := schemas.ValidateStruct(input)
+	if ve != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
 
-	products, err := ph.productService.GetProducts(input)
+	products, err := ph.productService.GetProducts(r.Context(), input)
 	if err != nil {
-		code = http.StatusInternalServerError
-		logger.Error.Printf("Server error %v\n", err)
+		switch err {
+		case E.ErrUnprocessableEntity:
+			code = http.StatusUnprocessableEntity
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Printf("Server error %v\n", err)
+			return
+		}
 	}
 
-	util.RenderComponent(&out, product_views.ProductList(l, products, userDB.UserID), r)
+	page := product_views.ListPage{
+		SortField:     input.SortField,
+		SortDirection: input.SortDirection,
+		First:         input.Cursor == "",
+	}
+	if len(products) > PageSize {
+		products = products[:PageSize]
+		page.NextCursor = products[PageSize-1].Cursor
+	}
+	util.RenderComponent(&out, product_views.ProductList(l, products, userDB.UserID, input.IncludeHidden, page), r)
 }
 
 func (ph *ProductHandler) HandleCopyProduct(w http.ResponseWriter, r *http.Request) {
@@ -174,20 +302,12 @@ func (ph *ProductHandler) HandleCopyProduct(w http.ResponseWriter, r *http.Reque
 	var out []byte
 	defer util.RespondHTTP(w, &code, &out)
 
-	var input product_schemas.GetProduct = product_schemas.GetProduct{}
-
-	err := r.ParseForm()
-	if err != nil {
-		code = http.StatusUnprocessableEntity
-		return
-	}
-	input.ProductID, err = util.GetUintFromString(r.Form.Get("product_id"))
-	if err != nil {
-		code = http.StatusUnprocessableEntity
+	input, ok := productInput(r, &code)
+	if !ok {
 		return
 	}
 
-	productDB, err := ph.productService.GetProduct(input)
+	productDB, err := ph.productService.GetProduct(r.Context(), input)
 	if err != nil {
 		switch err {
 		case E.ErrNotFound:
@@ -202,10 +322,15 @@ func (ph *ProductHandler) HandleCopyProduct(w http.ResponseWriter, r *http.Reque
 
 	addProduct := product_schemas.AddProduct{
 		ProductTitle:    productDB.ProductTitle,
+		Manufacturer:    productDB.Manufacturer,
+		ProductType:     productDB.ProductType,
 		ProductCalories: productDB.ProductCalories,
 		ProductFats:     productDB.ProductFats,
 		ProductCarbs:    productDB.ProductCarbs,
 		ProductProteins: productDB.ProductProteins,
+		NetQuantity:     productDB.NetQuantity,
+		NetUnit:         productDB.NetUnit,
+		Density:         productDB.Density,
 		UserID:          productDB.UserID,
 	}
 
@@ -218,26 +343,49 @@ func (ph *ProductHandler) HandleDeleteProduct(w http.ResponseWriter, r *http.Req
 	var out []byte
 	defer util.RespondHTTP(w, &code, &out)
 
-	var userDB user_schemas.UserDB
-	sessionUUID, err := util.GetUserSessionCookieValue(w, r)
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	var input product_schemas.DeleteProduct = product_schemas.DeleteProduct{}
+
+	err := r.ParseForm()
 	if err != nil {
-		if errors.Is(err, E.ErrInternalServer) {
-			logger.Error.Printf("Failure getting session cookie.\n")
-		}
-	} else {
-		userDB, err = ph.userService.GetUserBySession(sessionUUID)
-		if err != nil {
-			if errors.Is(err, E.ErrInternalServer) {
-				logger.Error.Printf("Failure getting session cookie.\n")
-			}
+		code = http.StatusUnprocessableEntity
+		return
+	}
+	input.ProductID, err = util.GetUintFromString(r.Form.Get("product_id"))
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+	input.UserID = userDB.UserID
+
+	err = ph.productService.DeleteProduct(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrNotFound:
 			code = http.StatusUnprocessableEntity
 			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Println("Error", err)
+			return
 		}
 	}
+}
 
-	var input product_schemas.DeleteProduct = product_schemas.DeleteProduct{}
+// HandleSetProductHidden lets moderators hide products of others from the
+// catalog, or restore them.
+func (ph *ProductHandler) HandleSetProductHidden(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	var input product_schemas.SetProductHidden = product_schemas.SetProductHidden{}
 
-	err = r.ParseForm()
+	err := r.ParseForm()
 	if err != nil {
 		code = http.StatusUnprocessableEntity
 		return
@@ -247,18 +395,251 @@ func (ph *ProductHandler) HandleDeleteProduct(w http.ResponseWriter, r *http.Req
 		code = http.StatusUnprocessableEntity
 		return
 	}
+	input.IsHidden = r.Form.Get("is_hidden") == "true"
+
+	productDB, err := ph.productService.SetProductHidden(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrNotFound:
+			code = http.StatusUnprocessableEntity
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Println("Error", err)
+			return
+		}
+	}
+
+	util.RenderComponent(&out, product_views.Product(l, productDB, userDB.UserID, true), r)
+}
+
+// productInput reads the product_id of a form, hidden products are only found
+// for their creator and moderators. On failure it sets code and returns
+// false.
+func productInput(r *http.Request, code *int) (product_schemas.GetProduct, bool) {
+	userDB, _ := middleware.UserFromContext(r.Context())
+	var input product_schemas.GetProduct = product_schemas.GetProduct{
+		UserID:        userDB.UserID,
+		IncludeHidden: userDB.Can(user_schemas.PermModerateProducts),
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		*code = http.StatusUnprocessableEntity
+		return input, false
+	}
+	input.ProductID, err = util.GetUintFromString(r.Form.Get("product_id"))
+	if err != nil {
+		*code = http.StatusUnprocessableEntity
+		return input, false
+	}
+	return input, true
+}
+
+func (ph *ProductHandler) HandleGetProduct(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	input, ok := productInput(r, &code)
+	if !ok {
+		return
+	}
+
+	productDB, err := ph.productService.GetProduct(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrNotFound:
+			code = http.StatusNotFound
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Println("Error", err)
+			return
+		}
+	}
+
+	util.RenderComponent(&out, product_views.Product(l, productDB, userDB.UserID, userDB.Can(user_schemas.PermModerateProducts)), r)
+}
+
+// HandleEditProduct turns the row of a product into a form for its creator.
+func (ph *ProductHandler) HandleEditProduct(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	input, ok := productInput(r, &code)
+	if !ok {
+		return
+	}
+
+	productDB, err := ph.productService.GetProduct(r.Context(), input)
+	if err == nil && productDB.UserID != userDB.UserID {
+		err = E.ErrNotFound
+	}
+	if err != nil {
+		switch err {
+		case E.ErrNotFound:
+			code = http.StatusUnprocessableEntity
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Println("Error", err)
+			return
+		}
+	}
+
+	util.RenderComponent(&out, product_views.ProductEditRow(l, productDB, product_views.ProductAddRowErrors{}), r)
+}
+
+func (ph *ProductHandler) HandleChangeProduct(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	var input product_schemas.ChangeProduct = product_schemas.ChangeProduct{}
+
+	productInfo, ok := productInput(r, &code)
+	if !ok {
+		return
+	}
+	input.ProductID = productInfo.ProductID
 	input.UserID = userDB.UserID
+	input.ProductTitle = r.Form.Get("product_title")
+	input.Manufacturer = r.Form.Get("manufacturer")
+	var inputErrs product_views.ProductAddRowErrors = product_views.ProductAddRowErrors{}
+	var typeErr error
+	input.ProductType, typeErr = productTypeFromString(r.Form.Get("product_type"))
+	if typeErr != nil {
+		inputErrs.TypeErr = L.GetError(L.MsgErrorProductType)
+	}
 
-	err = ph.productService.DeleteProduct(input)
+	productDB, err := ph.productService.GetProduct(r.Context(), productInfo)
+	if err == nil && productDB.UserID != userDB.UserID {
+		err = E.ErrNotFound
+	}
+	if err == nil {
+		ve := schemas.ValidateStruct(input)
+		for _, fe := range ve {
+			switch fe.Name() {
+			case "ProductTitle":
+				inputErrs.TitleErr = L.GetError(L.MsgErrorProductTitle)
+			case "Manufacturer":
+				inputErrs.ManufacturerErr = L.GetError(L.MsgErrorProductManufacturer)
+			case "ProductType":
+				inputErrs.TypeErr = L.GetError(L.MsgErrorProductType)
+			}
+		}
+		if inputErrs.TypeErr == nil && productDB.IsFood() != (input.ProductType == product_schemas.ProductTypeFood) {
+			inputErrs.TypeErr = L.GetError(L.MsgErrorProductTypeFood)
+		}
+		if ve != nil || inputErrs.TypeErr != nil {
+			err = E.ErrUnprocessableEntity
+		}
+	}
+	var changed product_schemas.ProductDB
+	if err == nil {
+		changed, err = ph.productService.ChangeProduct(r.Context(), input)
+	}
 	if err != nil {
 		switch err {
 		case E.ErrNotFound:
 			code = http.StatusUnprocessableEntity
 			return
+		case E.ErrUnprocessableEntity, E.ErrConflict:
+			code = http.StatusUnprocessableEntity
+			if err == E.ErrConflict {
+				inputErrs.TitleErr = L.GetError(L.MsgErrorProductExists)
+			} else if inputErrs == (product_views.ProductAddRowErrors{}) {
+				inputErrs.TitleErr = L.GetError(L.MsgErrorProductTitle)
+			}
+			productDB.ProductTitle = input.ProductTitle
+			productDB.Manufacturer = input.Manufacturer
+			util.RenderComponent(&out, product_views.ProductEditRow(l, productDB, inputErrs), r)
+			return
 		default:
 			code = http.StatusInternalServerError
 			logger.Error.Println("Error", err)
 			return
 		}
 	}
+
+	util.RenderComponent(&out, product_views.Product(l, changed, userDB.UserID, userDB.Can(user_schemas.PermModerateProducts)), r)
+}
+
+func (ph *ProductHandler) HandleProductHistory(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	input, ok := productInput(r, &code)
+	if !ok {
+		return
+	}
+
+	revisions, err := ph.productService.GetProductRevisions(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrNotFound:
+			code = http.StatusNotFound
+			util.RenderComponent(&out, product_views.ProductError(l, L.GetError(L.MsgErrorGetProductNotFound)), r)
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Println("Error", err)
+			return
+		}
+	}
+
+	util.RenderComponent(&out, product_views.ProductHistory(l, revisions), r)
+}
+
+func (ph *ProductHandler) HandleRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	var input product_schemas.GetRevisionDiff = product_schemas.GetRevisionDiff{}
+
+	productInfo, ok := productInput(r, &code)
+	if !ok {
+		return
+	}
+	input.ProductID = productInfo.ProductID
+	input.UserID = productInfo.UserID
+	input.IncludeHidden = productInfo.IncludeHidden
+	var fromErr, toErr error
+	input.From, fromErr = util.GetUintFromString(r.Form.Get("from"))
+	input.To, toErr = util.GetUintFromString(r.Form.Get("to"))
+	if fromErr != nil || toErr != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+
+	diff, err := ph.productService.GetRevisionDiff(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrNotFound:
+			code = http.StatusNotFound
+			util.RenderComponent(&out, product_views.ProductError(l, L.GetError(L.MsgErrorGetRevisionNotFound)), r)
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Println("Error", err)
+			return
+		}
+	}
+
+	util.RenderComponent(&out, product_views.RevisionDiff(l, diff), r)
 }
@@ -4,24 +4,49 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	E "github.com/bmg-c/product-diary/errorhandler"
 	L "github.com/bmg-c/product-diary/localization"
 	"github.com/bmg-c/product-diary/logger"
+	"github.com/bmg-c/product-diary/middleware"
 	"github.com/bmg-c/product-diary/schemas"
 	"github.com/bmg-c/product-diary/schemas/user_schemas"
 	"github.com/bmg-c/product-diary/util"
 	"github.com/bmg-c/product-diary/views/user_views"
+	"github.com/google/uuid"
 )
 
-func NewUserHandler(us UserService) *UserHandler {
+func NewUserHandler(us UserService, loginLimiter RateLimiter, signinLimiter RateLimiter) *UserHandler {
 	return &UserHandler{
-		UserService: us,
+		UserService:   us,
+		loginLimiter:  loginLimiter,
+		signinLimiter: signinLimiter,
 	}
 }
 
 type UserHandler struct {
-	UserService UserService
+	UserService   UserService
+	loginLimiter  RateLimiter
+	signinLimiter RateLimiter
+}
+
+// rateLimitKeys limits a client both by address and by the account it tries,
+// so neither many accounts from one address nor one account from many
+// addresses can be guessed quickly.
+func rateLimitKeys(prefix string, r *http.Request, email string) (string, string) {
+	client := util.GetSessionClient(r)
+	return prefix + ":ip:" + client.IP, prefix + ":email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// tooManyRequests sets the status and Retry-After header of a rate limited
+// request and returns the localized error to show.
+func tooManyRequests(w http.ResponseWriter, code *int, wait time.Duration) error {
+	seconds := int(wait / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	*code = http.StatusTooManyRequests
+	return L.GetError(L.MsgErrorTooManyRequests, seconds)
 }
 
 func (uh *UserHandler) HandleUsersPage(w http.ResponseWriter, r *http.Request) {
@@ -39,24 +64,9 @@ func (uh *UserHandler) HandleControlsIndex(w http.ResponseWriter, r *http.Reques
 	var out []byte
 	defer util.RespondHTTP(w, &code, &out)
 
-	var authorized bool = false
-	// var userDB user_schemas.UserDB
-	sessionUUID, err := util.GetUserSessionCookieValue(w, r)
-	if err != nil {
-		if errors.Is(err, E.ErrInternalServer) {
-			logger.Error.Printf("Failure getting session cookie.\n")
-		}
-	} else {
-		// userDB, err = uh.UserService.GetUserBySession(sessionUUID)
-		_, err = uh.UserService.GetUserBySession(sessionUUID)
-		if err != nil {
-			logger.Error.Printf("Failure getting user from valid session cookie.\n")
-		} else {
-			authorized = true
-		}
-	}
+	userDB, authorized := middleware.UserFromContext(r.Context())
 
-	util.RenderComponent(&out, user_views.UserControls(l, authorized), r)
+	util.RenderComponent(&out, user_views.UserControls(l, authorized, userDB.Can(user_schemas.PermManageUsers)), r)
 }
 
 func (uh *UserHandler) HandleSigninIndex(w http.ResponseWriter, r *http.Request) {
@@ -82,6 +92,7 @@ func (uh *UserHandler) HandleSigninSignin(w http.ResponseWriter, r *http.Request
 	var inputConfirm user_schemas.UserConfirmSignin = user_schemas.UserConfirmSignin{}
 
 	var hasCode bool = false
+	var inputErr error = nil
 	err := r.ParseForm()
 	if err != nil {
 		err = E.ErrUnprocessableEntity
@@ -97,9 +108,20 @@ func (uh *UserHandler) HandleSigninSignin(w http.ResponseWriter, r *http.Request
 	if hasCode {
 		inputConfirm.Email = r.Form.Get("email")
 		inputConfirm.Code = r.Form.Get("code")
+		inputConfirm.Username = r.Form.Get("username")
+		inputConfirm.Password = r.Form.Get("password")
 		ve := schemas.ValidateStruct(inputConfirm)
 		if ve != nil {
 			err = E.ErrUnprocessableEntity
+			inputErr = L.GetError(L.MsgErrorCodeWrong)
+			for _, fe := range ve {
+				switch fe.Name() {
+				case "Username":
+					inputErr = L.GetError(L.MsgErrorUsername)
+				case "Password":
+					inputErr = L.GetError(L.MsgErrorPassword)
+				}
+			}
 		}
 	} else {
 		input.Email = r.Form.Get("email")
@@ -112,18 +134,15 @@ func (uh *UserHandler) HandleSigninSignin(w http.ResponseWriter, r *http.Request
 		switch err {
 		case E.ErrUnprocessableEntity:
 			code = http.StatusUnprocessableEntity
-			var email string
-			if hasCode {
-				err = L.GetError(L.MsgErrorCodeWrong)
-				email = inputConfirm.Email
-			} else {
-				err = L.GetError(L.MsgErrorEmailWrong)
-				email = input.Email
-			}
 			data := user_views.SigninData{
 				CodeSent: hasCode,
-				Email:    email,
-				Err:      err,
+				Email:    input.Email,
+				Err:      L.GetError(L.MsgErrorEmailWrong),
+			}
+			if hasCode {
+				data.Email = inputConfirm.Email
+				data.Username = inputConfirm.Username
+				data.Err = inputErr
 			}
 			util.RenderComponent(&out, user_views.SigninIndex(l, data), r)
 			return
@@ -133,8 +152,30 @@ func (uh *UserHandler) HandleSigninSignin(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	email := input.Email
+	if hasCode {
+		email = inputConfirm.Email
+	}
+	ipKey, emailKey := rateLimitKeys("signin", r, email)
+	wait, err := uh.signinLimiter.Allow(r.Context(), ipKey, emailKey)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Failure checking rate limit: %v\n", err)
+		return
+	}
+	if wait > 0 {
+		data := user_views.SigninData{
+			CodeSent: hasCode,
+			Email:    email,
+			Username: inputConfirm.Username,
+			Err:      tooManyRequests(w, &code, wait),
+		}
+		util.RenderComponent(&out, user_views.SigninIndex(l, data), r)
+		return
+	}
+
 	if hasCode {
-		err = uh.UserService.ConfirmSignin(inputConfirm)
+		err = uh.UserService.ConfirmSignin(r.Context(), inputConfirm)
 		if err != nil {
 			switch err {
 			case E.ErrUnprocessableEntity:
@@ -142,8 +183,25 @@ func (uh *UserHandler) HandleSigninSignin(w http.ResponseWriter, r *http.Request
 				data := user_views.SigninData{
 					CodeSent: hasCode,
 					Email:    inputConfirm.Email,
+					Username: inputConfirm.Username,
 					Err:      L.GetError(L.MsgErrorCodeWrong),
 				}
+				wait, err = uh.signinLimiter.Failure(r.Context(), ipKey, emailKey)
+				if err != nil {
+					logger.Error.Printf("Failure recording failed attempt: %v\n", err)
+				} else if wait > 0 {
+					data.Err = tooManyRequests(w, &code, wait)
+				}
+				util.RenderComponent(&out, user_views.SigninIndex(l, data), r)
+				return
+			case E.ErrConflict:
+				code = http.StatusUnprocessableEntity
+				data := user_views.SigninData{
+					CodeSent: hasCode,
+					Email:    inputConfirm.Email,
+					Username: inputConfirm.Username,
+					Err:      L.GetError(L.MsgErrorUsernameAlreadyExists),
+				}
 				util.RenderComponent(&out, user_views.SigninIndex(l, data), r)
 				return
 			default:
@@ -153,9 +211,13 @@ func (uh *UserHandler) HandleSigninSignin(w http.ResponseWriter, r *http.Request
 			}
 		}
 
+		err = uh.signinLimiter.Success(r.Context(), emailKey)
+		if err != nil {
+			logger.Error.Printf("Failure resetting failed attempts: %v\n", err)
+		}
 		util.RenderComponent(&out, user_views.EndSignin(l, inputConfirm.Email), r)
 	} else {
-		err = uh.UserService.SigninUser(input)
+		err = uh.UserService.SigninUser(r.Context(), input, l)
 		if err != nil {
 			switch err {
 			case E.ErrUnprocessableEntity:
@@ -185,19 +247,21 @@ func (uh *UserHandler) HandleSigninSignin(w http.ResponseWriter, r *http.Request
 }
 
 func (uh *UserHandler) HandleGetUsersAll(w http.ResponseWriter, r *http.Request) {
-	_ = util.InitHTMLHandler(w, r)
+	l := util.InitHTMLHandler(w, r)
 	var code int = http.StatusOK
 	var out []byte
 	defer util.RespondHTTP(w, &code, &out)
 
-	users, err := uh.UserService.GetUsersAll()
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	users, err := uh.UserService.GetUsersAll(r.Context())
 	if err != nil {
 		code = http.StatusInternalServerError
 		logger.Error.Println("Failure getting users from the database.")
 		return
 	}
 
-	util.RenderComponent(&out, user_views.UserlistIndex(users), r)
+	util.RenderComponent(&out, user_views.UserlistIndex(l, users, userDB.UserID), r)
 }
 
 func (uh *UserHandler) HandleGetUser(w http.ResponseWriter, r *http.Request) {
@@ -219,7 +283,7 @@ func (uh *UserHandler) HandleGetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := uh.UserService.GetUser(input)
+	user, err := uh.UserService.GetUser(r.Context(), input)
 	if err != nil {
 		code = http.StatusNotFound
 		util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorGetUserNotFound)), r)
@@ -275,167 +339,1227 @@ func (uh *UserHandler) HandleLoginLogin(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	sessionUUID, err := uh.UserService.LoginUser(input)
+	ipKey, emailKey := rateLimitKeys("login", r, input.Email)
+	wait, err := uh.loginLimiter.Allow(r.Context(), ipKey, emailKey)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Failure checking rate limit: %v\n", err)
+		return
+	}
+	if wait > 0 {
+		util.RenderComponent(&out, user_views.LoginIndex(l, data), r)
+		util.RenderComponent(&out, user_views.ErrorMsg(l, tooManyRequests(w, &code, wait)), r)
+		return
+	}
+
+	result, err := uh.UserService.LoginUser(r.Context(), input, util.GetSessionClient(r))
+	if errors.Is(err, E.ErrForbidden) {
+		code = http.StatusUnprocessableEntity
+		util.RenderComponent(&out, user_views.LoginIndex(l, data), r)
+		util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorUserDisabled)), r)
+		return
+	}
 	if err != nil {
+		if !errors.Is(err, E.ErrNotFound) && !errors.Is(err, E.ErrUnprocessableEntity) {
+			code = http.StatusInternalServerError
+			logger.Error.Printf("Failure logging in: %v\n", err)
+			return
+		}
 		code = http.StatusNotFound
+		loginErr := L.GetError(L.MsgErrorGetUserNotFound)
+		wait, err = uh.loginLimiter.Failure(r.Context(), ipKey, emailKey)
+		if err != nil {
+			logger.Error.Printf("Failure recording failed attempt: %v\n", err)
+		} else if wait > 0 {
+			loginErr = tooManyRequests(w, &code, wait)
+		}
 		util.RenderComponent(&out, user_views.LoginIndex(l, data), r)
-		util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorGetUserNotFound)), r)
+		util.RenderComponent(&out, user_views.ErrorMsg(l, loginErr), r)
 		return
 	}
-	util.SetUserSessionCookie(w, sessionUUID)
+	// The failures are forgotten once the second factor is given as well
+	if result.Pending2FA {
+		util.RenderComponent(&out, user_views.LoginTOTPIndex(l, result.SessionUUID.String()), r)
+		return
+	}
+	err = uh.loginLimiter.Success(r.Context(), emailKey)
+	if err != nil {
+		logger.Error.Printf("Failure resetting failed attempts: %v\n", err)
+	}
+	util.SetUserSessionCookie(w, result.SessionUUID)
 
 	w.Header().Set("HX-Redirect", r.Header.Get("Referer"))
 }
 
-func (uh *UserHandler) HandleProfileIndex(w http.ResponseWriter, r *http.Request) {
+func (uh *UserHandler) HandleLogin2FA(w http.ResponseWriter, r *http.Request) {
 	l := util.InitHTMLHandler(w, r)
 	var code int = http.StatusOK
 	var out []byte
 	defer util.RespondHTTP(w, &code, &out)
 
-	var userDB user_schemas.UserDB
-	sessionUUID, err := util.GetUserSessionCookieValue(w, r)
+	err := r.ParseForm()
+	sessionUUID, uuidErr := uuid.Parse(r.Form.Get("pending_session"))
+	if err != nil || uuidErr != nil {
+		code = http.StatusNotFound
+		util.RenderComponent(&out, user_views.LoginIndex(l, user_views.LoginData{}), r)
+		util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorLoginExpired)), r)
+		return
+	}
+	input := user_schemas.Login2FA{
+		SessionUUID: sessionUUID,
+		Code:        r.Form.Get("code"),
+	}
+	ve := schemas.ValidateStruct(input)
+	if ve != nil {
+		code = http.StatusUnprocessableEntity
+		util.RenderComponent(&out, user_views.LoginTOTPIndex(l, sessionUUID.String()), r)
+		util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorTwoFactorCodeWrong)), r)
+		return
+	}
+
+	// Wrong codes are counted per user, a new login does not start over
+	userDB, err := uh.UserService.GetPendingUser(r.Context(), sessionUUID)
 	if err != nil {
-		if errors.Is(err, E.ErrInternalServer) {
-			logger.Error.Printf("Failure getting session cookie.\n")
+		if !errors.Is(err, E.ErrNotFound) {
+			code = http.StatusInternalServerError
+			logger.Error.Printf("Failure getting pending session: %v\n", err)
+			return
 		}
-	} else {
-		userDB, err = uh.UserService.GetUserBySession(sessionUUID)
-		if err != nil {
-			if errors.Is(err, E.ErrInternalServer) {
-				logger.Error.Printf("Failure getting session cookie.\n")
-			}
+		code = http.StatusNotFound
+		util.RenderComponent(&out, user_views.LoginIndex(l, user_views.LoginData{}), r)
+		util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorLoginExpired)), r)
+		return
+	}
+	ipKey, _ := rateLimitKeys("2fa", r, "")
+	userKey := "2fa:user:" + strconv.FormatUint(uint64(userDB.UserID), 10)
+	wait, err := uh.loginLimiter.Allow(r.Context(), ipKey, userKey)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Failure checking rate limit: %v\n", err)
+		return
+	}
+	if wait > 0 {
+		util.RenderComponent(&out, user_views.LoginTOTPIndex(l, sessionUUID.String()), r)
+		util.RenderComponent(&out, user_views.ErrorMsg(l, tooManyRequests(w, &code, wait)), r)
+		return
+	}
+
+	newUUID, err := uh.UserService.Login2FA(r.Context(), input, util.GetSessionClient(r))
+	if err != nil {
+		switch err {
+		case E.ErrUnprocessableEntity:
 			code = http.StatusUnprocessableEntity
+			loginErr := L.GetError(L.MsgErrorTwoFactorCodeWrong)
+			wait, err = uh.loginLimiter.Failure(r.Context(), ipKey, userKey)
+			if err != nil {
+				logger.Error.Printf("Failure recording failed attempt: %v\n", err)
+			} else if wait > 0 {
+				loginErr = tooManyRequests(w, &code, wait)
+			}
+			util.RenderComponent(&out, user_views.LoginTOTPIndex(l, sessionUUID.String()), r)
+			util.RenderComponent(&out, user_views.ErrorMsg(l, loginErr), r)
+			return
+		case E.ErrNotFound:
+			code = http.StatusNotFound
+			util.RenderComponent(&out, user_views.LoginIndex(l, user_views.LoginData{}), r)
+			util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorLoginExpired)), r)
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Printf("Failure checking second factor: %v\n", err)
 			return
 		}
 	}
-	up := user_schemas.UserPublic{
-		UserID:    userDB.UserID,
-		Email:     userDB.Email,
-		Username:  userDB.Username,
-		CreatedAt: userDB.CreatedAt,
+	_, emailKey := rateLimitKeys("login", r, userDB.Email)
+	err = uh.loginLimiter.Success(r.Context(), userKey, emailKey)
+	if err != nil {
+		logger.Error.Printf("Failure resetting failed attempts: %v\n", err)
 	}
+	util.SetUserSessionCookie(w, newUUID)
 
-	userInfo := user_schemas.GetUser{
-		UserID: userDB.UserID,
+	w.Header().Set("HX-Redirect", r.Header.Get("Referer"))
+}
+
+func (uh *UserHandler) HandlePasswordResetIndex(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	util.RenderComponent(&out, user_views.PasswordResetRequestIndex(l, user_views.PasswordResetRequestData{}), r)
+}
+
+func (uh *UserHandler) HandlePasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	var input user_schemas.PasswordResetRequest = user_schemas.PasswordResetRequest{}
+
+	err := r.ParseForm()
+	input.Email = r.Form.Get("email")
+	data := user_views.PasswordResetRequestData{
+		Email: input.Email,
+	}
+	ve := schemas.ValidateStruct(input)
+	if ve != nil || err != nil {
+		code = http.StatusUnprocessableEntity
+		data.Err = L.GetError(L.MsgErrorEmailWrong)
+		util.RenderComponent(&out, user_views.PasswordResetRequestIndex(l, data), r)
+		return
 	}
 
-	persons, err := uh.UserService.GetUserPersons(userInfo)
+	// Every request sends a mail, so it is limited like sign up
+	ipKey, emailKey := rateLimitKeys("reset", r, input.Email)
+	wait, err := uh.signinLimiter.Allow(r.Context(), ipKey, emailKey)
 	if err != nil {
-		logger.Error.Printf("Erorr: %v\n", err)
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Failure checking rate limit: %v\n", err)
+		return
+	}
+	if wait > 0 {
+		data.Err = tooManyRequests(w, &code, wait)
+		util.RenderComponent(&out, user_views.PasswordResetRequestIndex(l, data), r)
+		return
+	}
+
+	err = uh.UserService.RequestPasswordReset(r.Context(), input, l)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Println("Error requesting password reset")
+		return
 	}
 
-	util.RenderComponent(&out, user_views.ProfileBlock(l, up, persons), r)
+	data.Sent = true
+	util.RenderComponent(&out, user_views.PasswordResetRequestIndex(l, data), r)
 }
 
-func (uh *UserHandler) HandleTogglePerson(w http.ResponseWriter, r *http.Request) {
+func (uh *UserHandler) HandlePasswordResetPage(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	// Keep the token out of the Referer of requests made by the page
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	data := user_views.PasswordResetData{
+		Token: r.URL.Query().Get("token"),
+	}
+	err := uh.UserService.CheckPasswordReset(r.Context(), data.Token)
+	switch err {
+	case nil:
+		data.Valid = true
+	case E.ErrNotFound:
+		code = http.StatusNotFound
+	default:
+		code = http.StatusInternalServerError
+		logger.Error.Println("Error checking password reset token")
+		return
+	}
+
+	util.RenderComponent(&out, user_views.PasswordResetPage(l, data), r)
+}
+
+func (uh *UserHandler) HandlePasswordResetReset(w http.ResponseWriter, r *http.Request) {
 	l := util.InitHTMLHandler(w, r)
 	var code int = http.StatusOK
 	var out []byte
 	defer util.RespondHTTP(w, &code, &out)
 
-	var userDB user_schemas.UserDB
-	sessionUUID, err := util.GetUserSessionCookieValue(w, r)
+	var input user_schemas.PasswordReset = user_schemas.PasswordReset{}
+
+	err := r.ParseForm()
+	input.Token = r.Form.Get("token")
+	input.Password = r.Form.Get("password")
+	data := user_views.PasswordResetData{
+		Token: input.Token,
+		Valid: true,
+	}
 	if err != nil {
-		if errors.Is(err, E.ErrInternalServer) {
-			logger.Error.Printf("Failure getting session cookie.\n")
-		}
-	} else {
-		userDB, err = uh.UserService.GetUserBySession(sessionUUID)
-		if err != nil {
-			if errors.Is(err, E.ErrInternalServer) {
-				logger.Error.Printf("Failure getting session cookie.\n")
+		code = http.StatusUnprocessableEntity
+		data.Valid = false
+		util.RenderComponent(&out, user_views.PasswordResetIndex(l, data), r)
+		return
+	}
+	ve := schemas.ValidateStruct(input)
+	if ve != nil {
+		code = http.StatusUnprocessableEntity
+		for _, fe := range ve {
+			switch fe.Name() {
+			case "Token":
+				data.Valid = false
+			case "Password":
+				data.Err = L.GetError(L.MsgErrorPassword)
 			}
-			code = http.StatusUnprocessableEntity
-			return
 		}
+		util.RenderComponent(&out, user_views.PasswordResetIndex(l, data), r)
+		return
 	}
 
-	var input user_schemas.GetPerson = user_schemas.GetPerson{}
-	err = r.ParseForm()
-	input.UserID = userDB.UserID
-	input.PersonName = r.Form.Get("person_name")
-	ve := schemas.ValidateStruct(input)
-	if ve != nil || schemas.IsZero(input) || err != nil {
-		code = http.StatusUnprocessableEntity
-		util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorUsernameEmpty)), r)
+	ipKey, _ := rateLimitKeys("reset", r, "")
+	wait, err := uh.signinLimiter.Allow(r.Context(), ipKey)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Failure checking rate limit: %v\n", err)
+		return
+	}
+	if wait > 0 {
+		data.Err = tooManyRequests(w, &code, wait)
+		util.RenderComponent(&out, user_views.PasswordResetIndex(l, data), r)
 		return
 	}
 
-	personDB, err := uh.UserService.ToggleHiddenPerson(input)
+	err = uh.UserService.ResetPassword(r.Context(), input)
 	if err != nil {
 		switch err {
-		case E.ErrUnprocessableEntity:
-			code = http.StatusUnprocessableEntity
-			util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorGetUserNotFound)), r)
+		case E.ErrNotFound:
+			code = http.StatusNotFound
+			data.Valid = false
+			util.RenderComponent(&out, user_views.PasswordResetIndex(l, data), r)
 			return
 		default:
 			code = http.StatusInternalServerError
-			logger.Error.Println("Failure getting users from the database.")
+			logger.Error.Println("Error resetting password")
 			return
 		}
 	}
 
-	util.RenderComponent(&out, user_views.Person(l, personDB), r)
+	// The sessions of the user are gone, including this one
+	util.DeleteUserSessionCookie(w)
+	data.Done = true
+	util.RenderComponent(&out, user_views.PasswordResetIndex(l, data), r)
 }
 
-func (uh *UserHandler) HandleAddPerson(w http.ResponseWriter, r *http.Request) {
+func (uh *UserHandler) HandleProfileIndex(w http.ResponseWriter, r *http.Request) {
 	l := util.InitHTMLHandler(w, r)
 	var code int = http.StatusOK
 	var out []byte
 	defer util.RespondHTTP(w, &code, &out)
 
-	var userDB user_schemas.UserDB
-	sessionUUID, err := util.GetUserSessionCookieValue(w, r)
+	userDB, _ := middleware.UserFromContext(r.Context())
+	sessionUUID, _ := middleware.SessionFromContext(r.Context())
+	up := user_schemas.UserPublic{
+		UserID:    userDB.UserID,
+		Email:     userDB.Email,
+		Username:  userDB.Username,
+		CreatedAt: userDB.CreatedAt,
+	}
+
+	userInfo := user_schemas.GetUser{
+		UserID: userDB.UserID,
+	}
+
+	persons, err := uh.UserService.GetUserPersons(r.Context(), userInfo)
 	if err != nil {
-		if errors.Is(err, E.ErrInternalServer) {
-			logger.Error.Printf("Failure getting session cookie.\n")
-		}
-	} else {
-		userDB, err = uh.UserService.GetUserBySession(sessionUUID)
-		if err != nil {
-			if errors.Is(err, E.ErrInternalServer) {
-				logger.Error.Printf("Failure getting session cookie.\n")
-			}
-			code = http.StatusUnprocessableEntity
-			return
-		}
+		logger.Error.Printf("Erorr: %v\n", err)
+	}
+	sessions, err := uh.UserService.GetUserSessions(r.Context(), userDB.UserID, sessionUUID)
+	if err != nil {
+		logger.Error.Printf("Erorr: %v\n", err)
 	}
 
-	var input user_schemas.GetPerson = user_schemas.GetPerson{}
-	err = r.ParseForm()
-	input.UserID = userDB.UserID
-	input.PersonName = r.Form.Get("person_name")
+	totp, err := uh.UserService.GetTOTPStatus(r.Context(), userDB.UserID)
+	if err != nil {
+		logger.Error.Printf("Erorr: %v\n", err)
+	}
+
+	tokens, err := uh.UserService.GetAPITokens(r.Context(), userDB.UserID)
+	if err != nil {
+		logger.Error.Printf("Erorr: %v\n", err)
+	}
+
+	util.RenderComponent(&out, user_views.ProfileBlock(l, up, totp, persons, sessions, tokens), r)
+}
+
+func (uh *UserHandler) HandleUpdateUsername(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	err := r.ParseForm()
+	input := user_schemas.UpdateUsername{
+		UserID:   userDB.UserID,
+		Username: r.Form.Get("username"),
+	}
+	data := user_views.UsernameFormData{
+		Username: input.Username,
+	}
 	ve := schemas.ValidateStruct(input)
-	if ve != nil || schemas.IsZero(input) || err != nil {
+	if ve != nil || err != nil {
 		code = http.StatusUnprocessableEntity
-		util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorUsernameEmpty)), r)
+		data.Err = L.GetError(L.MsgErrorUsername)
+		util.RenderComponent(&out, user_views.UsernameForm(l, data), r)
 		return
 	}
 
-	personDB, err := uh.UserService.AddPerson(input)
+	err = uh.UserService.UpdateUsername(r.Context(), input)
 	if err != nil {
 		switch err {
-		case E.ErrUnprocessableEntity:
+		case E.ErrConflict:
 			code = http.StatusUnprocessableEntity
-			util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorUsernameAlreadyExists)), r)
+			data.Err = L.GetError(L.MsgErrorUsernameAlreadyExists)
+			util.RenderComponent(&out, user_views.UsernameForm(l, data), r)
 			return
 		default:
 			code = http.StatusInternalServerError
-			logger.Error.Println("Failure getting users from the database.")
+			logger.Error.Println("Error updating username")
 			return
 		}
 	}
 
-	util.RenderComponent(&out, user_views.Person(l, personDB), r)
+	data.Saved = true
+	util.RenderComponent(&out, user_views.UsernameForm(l, data), r)
 }
 
-func (uh *UserHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
-	_ = util.InitHTMLHandler(w, r)
+func (uh *UserHandler) HandleSetBaseCurrency(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
 	var code int = http.StatusOK
 	var out []byte
 	defer util.RespondHTTP(w, &code, &out)
 
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	err := r.ParseForm()
+	input := user_schemas.SetBaseCurrency{
+		UserID:       userDB.UserID,
+		BaseCurrency: r.Form.Get("base_currency"),
+	}
+	data := user_views.CurrencyFormData{
+		BaseCurrency: input.BaseCurrency,
+	}
+	ve := schemas.ValidateStruct(input)
+	if ve != nil || err != nil {
+		code = http.StatusUnprocessableEntity
+		data.BaseCurrency = userDB.BaseCurrency
+		data.Err = L.GetError(L.MsgErrorCurrency)
+		util.RenderComponent(&out, user_views.CurrencyForm(l, data), r)
+		return
+	}
+
+	err = uh.UserService.SetBaseCurrency(r.Context(), input)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Println("Error setting base currency")
+		return
+	}
+
+	data.Saved = true
+	util.RenderComponent(&out, user_views.CurrencyForm(l, data), r)
+}
+
+func (uh *UserHandler) HandleChangeEmail(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	err := r.ParseForm()
+	hasCode := r.Form.Has("code")
+	data := user_views.EmailFormData{
+		Email:    r.Form.Get("email"),
+		CodeSent: hasCode,
+	}
+	input := user_schemas.ChangeEmail{
+		UserID: userDB.UserID,
+		Email:  data.Email,
+	}
+	inputConfirm := user_schemas.ConfirmChangeEmail{
+		UserID: userDB.UserID,
+		Email:  data.Email,
+		Code:   r.Form.Get("code"),
+	}
+	var ve schemas.ValidationErrors
+	if hasCode {
+		ve = schemas.ValidateStruct(inputConfirm)
+	} else {
+		ve = schemas.ValidateStruct(input)
+	}
+	if ve != nil || err != nil {
+		code = http.StatusUnprocessableEntity
+		data.Err = L.GetError(L.MsgErrorEmailWrong)
+		for _, fe := range ve {
+			if fe.Name() == "Code" {
+				data.Err = L.GetError(L.MsgErrorCodeWrong)
+			}
+		}
+		util.RenderComponent(&out, user_views.EmailForm(l, data), r)
+		return
+	}
+
+	// Sending codes and guessing them is limited like sign up
+	ipKey, emailKey := rateLimitKeys("email", r, data.Email)
+	wait, err := uh.signinLimiter.Allow(r.Context(), ipKey, emailKey)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Failure checking rate limit: %v\n", err)
+		return
+	}
+	if wait > 0 {
+		data.Err = tooManyRequests(w, &code, wait)
+		util.RenderComponent(&out, user_views.EmailForm(l, data), r)
+		return
+	}
+
+	if !hasCode {
+		err = uh.UserService.ChangeEmail(r.Context(), input, l)
+		if err != nil {
+			switch err {
+			case E.ErrConflict:
+				code = http.StatusUnprocessableEntity
+				data.Err = L.GetError(L.MsgEmailExists)
+				util.RenderComponent(&out, user_views.EmailForm(l, data), r)
+				return
+			default:
+				code = http.StatusInternalServerError
+				logger.Error.Println("Error sending email change code")
+				return
+			}
+		}
+		data.CodeSent = true
+		util.RenderComponent(&out, user_views.EmailForm(l, data), r)
+		return
+	}
+
+	err = uh.UserService.ConfirmChangeEmail(r.Context(), inputConfirm)
+	if err != nil {
+		switch err {
+		case E.ErrUnprocessableEntity:
+			code = http.StatusUnprocessableEntity
+			data.Err = L.GetError(L.MsgErrorCodeWrong)
+			wait, err = uh.signinLimiter.Failure(r.Context(), ipKey, emailKey)
+			if err != nil {
+				logger.Error.Printf("Failure recording failed attempt: %v\n", err)
+			} else if wait > 0 {
+				data.Err = tooManyRequests(w, &code, wait)
+			}
+			util.RenderComponent(&out, user_views.EmailForm(l, data), r)
+			return
+		case E.ErrConflict:
+			code = http.StatusUnprocessableEntity
+			data.CodeSent = false
+			data.Err = L.GetError(L.MsgEmailExists)
+			util.RenderComponent(&out, user_views.EmailForm(l, data), r)
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Println("Error confirming email change")
+			return
+		}
+	}
+
+	err = uh.signinLimiter.Success(r.Context(), emailKey)
+	if err != nil {
+		logger.Error.Printf("Failure resetting failed attempts: %v\n", err)
+	}
+	data.CodeSent = false
+	data.Saved = true
+	util.RenderComponent(&out, user_views.EmailForm(l, data), r)
+}
+
+func (uh *UserHandler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+	data := user_views.PasswordFormData{}
+
+	err := r.ParseForm()
+	input := user_schemas.ChangePassword{
+		UserID:          userDB.UserID,
+		CurrentPassword: r.Form.Get("current_password"),
+		NewPassword:     r.Form.Get("new_password"),
+	}
+	ve := schemas.ValidateStruct(input)
+	if ve != nil || err != nil {
+		code = http.StatusUnprocessableEntity
+		data.Err = L.GetError(L.MsgErrorPassword)
+		for _, fe := range ve {
+			if fe.Name() == "CurrentPassword" {
+				data.Err = L.GetError(L.MsgErrorPasswordWrong)
+			}
+		}
+		util.RenderComponent(&out, user_views.PasswordForm(l, data), r)
+		return
+	}
+
+	// A stolen session should not allow guessing the password
+	ipKey, _ := rateLimitKeys("password", r, "")
+	userKey := "password:user:" + strconv.FormatUint(uint64(userDB.UserID), 10)
+	wait, err := uh.loginLimiter.Allow(r.Context(), ipKey, userKey)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Failure checking rate limit: %v\n", err)
+		return
+	}
+	if wait > 0 {
+		data.Err = tooManyRequests(w, &code, wait)
+		util.RenderComponent(&out, user_views.PasswordForm(l, data), r)
+		return
+	}
+
+	err = uh.UserService.ChangePassword(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrUnprocessableEntity:
+			code = http.StatusUnprocessableEntity
+			data.Err = L.GetError(L.MsgErrorPasswordWrong)
+			wait, err = uh.loginLimiter.Failure(r.Context(), ipKey, userKey)
+			if err != nil {
+				logger.Error.Printf("Failure recording failed attempt: %v\n", err)
+			} else if wait > 0 {
+				data.Err = tooManyRequests(w, &code, wait)
+			}
+			util.RenderComponent(&out, user_views.PasswordForm(l, data), r)
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Println("Error changing password")
+			return
+		}
+	}
+
+	err = uh.loginLimiter.Success(r.Context(), userKey)
+	if err != nil {
+		logger.Error.Printf("Failure resetting failed attempts: %v\n", err)
+	}
+	data.Saved = true
+	util.RenderComponent(&out, user_views.PasswordForm(l, data), r)
+}
+
+// totpFormData draws the QR code of an enrollment.
+func totpFormData(enrollment user_schemas.TOTPEnrollment) (user_views.TOTPFormData, error) {
+	qrPath, qrSize, err := util.QRCodeSVGPath(enrollment.URI)
+	if err != nil {
+		return user_views.TOTPFormData{}, err
+	}
+	return user_views.TOTPFormData{
+		Enrollment: enrollment,
+		QRPath:     qrPath,
+		QRSize:     qrSize,
+	}, nil
+}
+
+func (uh *UserHandler) HandleBeginTOTP(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	enrollment, err := uh.UserService.BeginTOTP(r.Context(), userDB.UserID)
+	if err != nil {
+		switch err {
+		case E.ErrConflict:
+			// Already enabled in another tab
+			status, err := uh.UserService.GetTOTPStatus(r.Context(), userDB.UserID)
+			if err != nil {
+				code = http.StatusInternalServerError
+				logger.Error.Println("Error getting two-factor status")
+				return
+			}
+			util.RenderComponent(&out, user_views.TOTPForm(l, user_views.TOTPFormData{Status: status}), r)
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Println("Error beginning two-factor setup")
+			return
+		}
+	}
+	data, err := totpFormData(enrollment)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Failure drawing QR code: %v\n", err)
+		return
+	}
+
+	util.RenderComponent(&out, user_views.TOTPForm(l, data), r)
+}
+
+// verifyTOTPInput reads the code of the two-factor forms and checks the rate
+// limit of the user. It renders the form itself and returns false when the
+// request should stop.
+func (uh *UserHandler) verifyTOTPInput(w http.ResponseWriter, r *http.Request, code *int, out *[]byte, l *L.Localizer, data user_views.TOTPFormData) (user_schemas.VerifyTOTP, string, bool) {
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	err := r.ParseForm()
+	input := user_schemas.VerifyTOTP{
+		UserID: userDB.UserID,
+		Code:   r.Form.Get("code"),
+	}
+	ve := schemas.ValidateStruct(input)
+	if ve != nil || err != nil {
+		*code = http.StatusUnprocessableEntity
+		data.Err = L.GetError(L.MsgErrorTwoFactorCodeWrong)
+		util.RenderComponent(out, user_views.TOTPForm(l, data), r)
+		return input, "", false
+	}
+
+	userKey := "2fa:user:" + strconv.FormatUint(uint64(userDB.UserID), 10)
+	wait, err := uh.loginLimiter.Allow(r.Context(), userKey)
+	if err != nil {
+		*code = http.StatusInternalServerError
+		logger.Error.Printf("Failure checking rate limit: %v\n", err)
+		return input, "", false
+	}
+	if wait > 0 {
+		data.Err = tooManyRequests(w, code, wait)
+		util.RenderComponent(out, user_views.TOTPForm(l, data), r)
+		return input, "", false
+	}
+	return input, userKey, true
+}
+
+// totpFailure records a wrong code and returns the error to show.
+func (uh *UserHandler) totpFailure(w http.ResponseWriter, r *http.Request, code *int, userKey string) error {
+	*code = http.StatusUnprocessableEntity
+	totpErr := L.GetError(L.MsgErrorTwoFactorCodeWrong)
+	wait, err := uh.loginLimiter.Failure(r.Context(), userKey)
+	if err != nil {
+		logger.Error.Printf("Failure recording failed attempt: %v\n", err)
+	} else if wait > 0 {
+		totpErr = tooManyRequests(w, code, wait)
+	}
+	return totpErr
+}
+
+func (uh *UserHandler) HandleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	enrollment, err := uh.UserService.GetTOTPEnrollment(r.Context(), userDB.UserID)
+	if err != nil {
+		switch err {
+		case E.ErrNotFound:
+			// Nothing to confirm, show the current state
+			status, err := uh.UserService.GetTOTPStatus(r.Context(), userDB.UserID)
+			if err != nil {
+				code = http.StatusInternalServerError
+				logger.Error.Println("Error getting two-factor status")
+				return
+			}
+			code = http.StatusNotFound
+			util.RenderComponent(&out, user_views.TOTPForm(l, user_views.TOTPFormData{Status: status}), r)
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Println("Error getting two-factor setup")
+			return
+		}
+	}
+	data, err := totpFormData(enrollment)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Failure drawing QR code: %v\n", err)
+		return
+	}
+
+	input, userKey, ok := uh.verifyTOTPInput(w, r, &code, &out, l, data)
+	if !ok {
+		return
+	}
+
+	recoveryCodes, err := uh.UserService.ConfirmTOTP(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrUnprocessableEntity:
+			data.Err = uh.totpFailure(w, r, &code, userKey)
+			util.RenderComponent(&out, user_views.TOTPForm(l, data), r)
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Println("Error confirming two-factor setup")
+			return
+		}
+	}
+
+	err = uh.loginLimiter.Success(r.Context(), userKey)
+	if err != nil {
+		logger.Error.Printf("Failure resetting failed attempts: %v\n", err)
+	}
+	data = user_views.TOTPFormData{
+		Status: user_schemas.TOTPStatus{
+			Enabled:           true,
+			RecoveryCodesLeft: uint(len(recoveryCodes)),
+		},
+		RecoveryCodes: recoveryCodes,
+	}
+	util.RenderComponent(&out, user_views.TOTPForm(l, data), r)
+}
+
+func (uh *UserHandler) HandleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	status, err := uh.UserService.GetTOTPStatus(r.Context(), userDB.UserID)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Println("Error getting two-factor status")
+		return
+	}
+	data := user_views.TOTPFormData{Status: status}
+
+	input, userKey, ok := uh.verifyTOTPInput(w, r, &code, &out, l, data)
+	if !ok {
+		return
+	}
+
+	recoveryCodes, err := uh.UserService.RegenerateRecoveryCodes(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrUnprocessableEntity:
+			data.Err = uh.totpFailure(w, r, &code, userKey)
+			util.RenderComponent(&out, user_views.TOTPForm(l, data), r)
+			return
+		case E.ErrNotFound:
+			code = http.StatusNotFound
+			util.RenderComponent(&out, user_views.TOTPForm(l, data), r)
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Println("Error creating recovery codes")
+			return
+		}
+	}
+
+	err = uh.loginLimiter.Success(r.Context(), userKey)
+	if err != nil {
+		logger.Error.Printf("Failure resetting failed attempts: %v\n", err)
+	}
+	data.Status.RecoveryCodesLeft = uint(len(recoveryCodes))
+	data.RecoveryCodes = recoveryCodes
+	util.RenderComponent(&out, user_views.TOTPForm(l, data), r)
+}
+
+func (uh *UserHandler) HandleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	status, err := uh.UserService.GetTOTPStatus(r.Context(), userDB.UserID)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Println("Error getting two-factor status")
+		return
+	}
+	data := user_views.TOTPFormData{Status: status}
+
+	input, userKey, ok := uh.verifyTOTPInput(w, r, &code, &out, l, data)
+	if !ok {
+		return
+	}
+
+	err = uh.UserService.DisableTOTP(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrUnprocessableEntity:
+			data.Err = uh.totpFailure(w, r, &code, userKey)
+			util.RenderComponent(&out, user_views.TOTPForm(l, data), r)
+			return
+		case E.ErrNotFound:
+			code = http.StatusNotFound
+			util.RenderComponent(&out, user_views.TOTPForm(l, data), r)
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Println("Error disabling two-factor authentication")
+			return
+		}
+	}
+
+	err = uh.loginLimiter.Success(r.Context(), userKey)
+	if err != nil {
+		logger.Error.Printf("Failure resetting failed attempts: %v\n", err)
+	}
+	util.RenderComponent(&out, user_views.TOTPForm(l, user_views.TOTPFormData{}), r)
+}
+
+func (uh *UserHandler) HandleCreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	err := r.ParseForm()
+	input := user_schemas.AddAPIToken{
+		UserID: userDB.UserID,
+		Name:   strings.TrimSpace(r.Form.Get("name")),
+		Scopes: r.Form["scopes"],
+	}
+	if err == nil && r.Form.Get("expires_in_days") != "" {
+		input.ExpiresInDays, err = util.GetUintFromString(r.Form.Get("expires_in_days"))
+	}
+	data := user_views.APITokenFormData{
+		Name:          input.Name,
+		ExpiresInDays: input.ExpiresInDays,
+		Scopes:        input.Scopes,
+	}
+	ve := schemas.ValidateStruct(input)
+	if ve != nil || err != nil || len(input.Scopes) == 0 {
+		code = http.StatusUnprocessableEntity
+		data.Err = L.GetError(L.MsgErrorAPITokenScopes)
+		for _, fe := range ve {
+			if fe.Name() == "Name" {
+				data.Err = L.GetError(L.MsgErrorAPITokenName)
+			}
+		}
+	} else {
+		data.Created, err = uh.UserService.CreateAPIToken(r.Context(), input)
+		if err != nil {
+			switch err {
+			case E.ErrUnprocessableEntity:
+				code = http.StatusUnprocessableEntity
+				data.Err = L.GetError(L.MsgErrorAPITokenScopes)
+			default:
+				code = http.StatusInternalServerError
+				logger.Error.Printf("Failure creating API token: %v\n", err)
+				return
+			}
+		} else {
+			data = user_views.APITokenFormData{Created: data.Created}
+		}
+	}
+
+	tokens, err := uh.UserService.GetAPITokens(r.Context(), userDB.UserID)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Failure getting API tokens: %v\n", err)
+		return
+	}
+	util.RenderComponent(&out, user_views.APITokenBlock(l, tokens, data), r)
+}
+
+func (uh *UserHandler) HandleRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	var input user_schemas.GetAPIToken = user_schemas.GetAPIToken{}
+	err := r.ParseForm()
+	input.UserID = userDB.UserID
+	if err == nil {
+		input.TokenID, err = util.GetUintFromString(r.Form.Get("token_id"))
+	}
+	ve := schemas.ValidateStruct(input)
+	if ve != nil || err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+
+	data := user_views.APITokenFormData{}
+	err = uh.UserService.RevokeAPIToken(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrNotFound:
+			code = http.StatusNotFound
+			data.Err = L.GetError(L.MsgErrorGetAPITokenNotFound)
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Printf("Failure revoking API token: %v\n", err)
+			return
+		}
+	}
+
+	tokens, err := uh.UserService.GetAPITokens(r.Context(), userDB.UserID)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Failure getting API tokens: %v\n", err)
+		return
+	}
+	util.RenderComponent(&out, user_views.APITokenBlock(l, tokens, data), r)
+}
+
+// adminUserInput reads the user_id of the admin forms. On failure it sets
+// code and returns false.
+func adminUserInput(r *http.Request, code *int) (uint, bool) {
+	err := r.ParseForm()
+	if err != nil {
+		*code = http.StatusUnprocessableEntity
+		return 0, false
+	}
+	userID, err := util.GetUintFromString(r.Form.Get("user_id"))
+	if err != nil || userID == 0 {
+		*code = http.StatusUnprocessableEntity
+		return 0, false
+	}
+	return userID, true
+}
+
+// renderAdminUser re-renders the row of a user in the admin list after an
+// admin action, with the error of the action if there was one.
+func (uh *UserHandler) renderAdminUser(r *http.Request, code *int, out *[]byte, l *L.Localizer, userID uint, actorID uint, actionErr error) {
+	if actionErr != nil {
+		switch actionErr {
+		case E.ErrNotFound:
+			*code = http.StatusNotFound
+			util.RenderComponent(out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorGetUserNotFound)), r)
+			return
+		case E.ErrForbidden:
+			*code = http.StatusUnprocessableEntity
+			util.RenderComponent(out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorAdminSelf)), r)
+		default:
+			*code = http.StatusInternalServerError
+			logger.Error.Printf("Failure managing user %d: %v\n", userID, actionErr)
+			return
+		}
+	}
+
+	user, err := uh.UserService.GetUser(r.Context(), user_schemas.GetUser{UserID: userID})
+	if err != nil {
+		*code = http.StatusInternalServerError
+		logger.Error.Printf("Failure getting user %d: %v\n", userID, err)
+		return
+	}
+	util.RenderComponent(out, user_views.AdminUser(l, user, actorID), r)
+}
+
+func (uh *UserHandler) HandleSetUserRole(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	userID, ok := adminUserInput(r, &code)
+	if !ok {
+		return
+	}
+	input := user_schemas.SetUserRole{
+		UserID: userID,
+		Role:   r.Form.Get("role"),
+	}
+	ve := schemas.ValidateStruct(input)
+	if ve != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+
+	err := uh.UserService.SetUserRole(r.Context(), userDB.UserID, input)
+	uh.renderAdminUser(r, &code, &out, l, userID, userDB.UserID, err)
+}
+
+func (uh *UserHandler) HandleSetUserDisabled(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	userID, ok := adminUserInput(r, &code)
+	if !ok {
+		return
+	}
+	input := user_schemas.SetUserDisabled{
+		UserID:     userID,
+		IsDisabled: r.Form.Get("is_disabled") == "true",
+	}
+
+	err := uh.UserService.SetUserDisabled(r.Context(), userDB.UserID, input)
+	uh.renderAdminUser(r, &code, &out, l, userID, userDB.UserID, err)
+}
+
+func (uh *UserHandler) HandleForceLogout(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	userID, ok := adminUserInput(r, &code)
+	if !ok {
+		return
+	}
+
+	err := uh.UserService.ForceLogout(r.Context(), userID)
+	uh.renderAdminUser(r, &code, &out, l, userID, userDB.UserID, err)
+}
+
+func (uh *UserHandler) HandleTogglePerson(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	var input user_schemas.GetPerson = user_schemas.GetPerson{}
+	err := r.ParseForm()
+	input.UserID = userDB.UserID
+	input.PersonName = r.Form.Get("person_name")
+	ve := schemas.ValidateStruct(input)
+	if ve != nil || schemas.IsZero(input) || err != nil {
+		code = http.StatusUnprocessableEntity
+		util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorUsernameEmpty)), r)
+		return
+	}
+
+	personDB, err := uh.UserService.ToggleHiddenPerson(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrUnprocessableEntity:
+			code = http.StatusUnprocessableEntity
+			util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorGetUserNotFound)), r)
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Println("Failure getting users from the database.")
+			return
+		}
+	}
+
+	util.RenderComponent(&out, user_views.Person(l, personDB), r)
+}
+
+func (uh *UserHandler) HandleAddPerson(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	var input user_schemas.GetPerson = user_schemas.GetPerson{}
+	err := r.ParseForm()
+	input.UserID = userDB.UserID
+	input.PersonName = r.Form.Get("person_name")
+	ve := schemas.ValidateStruct(input)
+	if ve != nil || schemas.IsZero(input) || err != nil {
+		code = http.StatusUnprocessableEntity
+		util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorUsernameEmpty)), r)
+		return
+	}
+
+	personDB, err := uh.UserService.AddPerson(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrUnprocessableEntity:
+			code = http.StatusUnprocessableEntity
+			util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorUsernameAlreadyExists)), r)
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Println("Failure getting users from the database.")
+			return
+		}
+	}
+
+	util.RenderComponent(&out, user_views.Person(l, personDB), r)
+}
+
+func (uh *UserHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	_ = util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	sessionUUID, ok := middleware.SessionFromContext(r.Context())
+	if ok {
+		err := uh.UserService.LogoutUser(r.Context(), sessionUUID)
+		if err != nil {
+			code = http.StatusInternalServerError
+			logger.Error.Printf("Failure deleting session: %v\n", err)
+			return
+		}
+	}
+	util.DeleteUserSessionCookie(w)
+
+	w.Header().Set("HX-Redirect", r.Header.Get("Referer"))
+}
+
+func (uh *UserHandler) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	l := util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+	sessionUUID, _ := middleware.SessionFromContext(r.Context())
+
+	var input user_schemas.GetUserSession = user_schemas.GetUserSession{}
+	err := r.ParseForm()
+	input.UserID = userDB.UserID
+	if err == nil {
+		input.SessionID, err = util.GetUintFromString(r.Form.Get("session_id"))
+	}
+	ve := schemas.ValidateStruct(input)
+	if ve != nil || err != nil {
+		code = http.StatusUnprocessableEntity
+		return
+	}
+
+	err = uh.UserService.RevokeSession(r.Context(), input)
+	if err != nil {
+		switch err {
+		case E.ErrUnprocessableEntity:
+			code = http.StatusUnprocessableEntity
+			util.RenderComponent(&out, user_views.ErrorMsg(l, L.GetError(L.MsgErrorGetSessionNotFound)), r)
+			return
+		default:
+			code = http.StatusInternalServerError
+			logger.Error.Printf("Failure revoking session: %v\n", err)
+			return
+		}
+	}
+
+	sessions, err := uh.UserService.GetUserSessions(r.Context(), userDB.UserID, sessionUUID)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Failure getting sessions: %v\n", err)
+		return
+	}
+	// Revoking the current session is a logout
+	for _, session := range sessions {
+		if session.IsCurrent {
+			util.RenderComponent(&out, user_views.SessionList(l, sessions), r)
+			return
+		}
+	}
+	util.DeleteUserSessionCookie(w)
+	w.Header().Set("HX-Redirect", r.Header.Get("Referer"))
+}
+
+func (uh *UserHandler) HandleLogoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	_ = util.InitHTMLHandler(w, r)
+	var code int = http.StatusOK
+	var out []byte
+	defer util.RespondHTTP(w, &code, &out)
+
+	userDB, _ := middleware.UserFromContext(r.Context())
+
+	err := uh.UserService.LogoutEverywhere(r.Context(), userDB.UserID)
+	if err != nil {
+		code = http.StatusInternalServerError
+		logger.Error.Printf("Failure deleting sessions: %v\n", err)
+		return
+	}
 	util.DeleteUserSessionCookie(w)
 
 	w.Header().Set("HX-Redirect", r.Header.Get("Referer"))
@@ -1,6 +1,7 @@
 package localization
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -48,6 +49,141 @@ const (
 	MsgErrorProductTitle
 	MsgErrorProductCalories
 	MsgErrorProductNutrient
+	MsgErrorUsername
+	MsgErrorPassword
+	MsgPasswordPlaceholder
+	MsgMailCodeSubject
+	MsgMailCodeBody
+	MsgSessions
+	MsgRevoke
+	MsgLogOutEverywhere
+	MsgCurrentSession
+	MsgSessionLastSeen
+	MsgUnknownDevice
+	MsgErrorTooManyRequests
+	MsgForgotPassword
+	MsgSendResetLink
+	MsgResetLinkSent
+	MsgMailResetSubject
+	MsgMailResetBody
+	MsgResetPassword
+	MsgErrorResetTokenInvalid
+	MsgPasswordChanged
+	MsgChange
+	MsgApply
+	MsgSaved
+	MsgCurrentPasswordPlaceholder
+	MsgNewPasswordPlaceholder
+	MsgSendCode
+	MsgConfirm
+	MsgChangeEmailCodeSent
+	MsgMailChangeEmailSubject
+	MsgMailChangeEmailBody
+	MsgTwoFactor
+	MsgEnable
+	MsgDisable
+	MsgTwoFactorScan
+	MsgTwoFactorCodePlaceholder
+	MsgTwoFactorLoginPlaceholder
+	MsgTwoFactorEnabled
+	MsgRecoveryCodesInfo
+	MsgNewRecoveryCodes
+	MsgTwoFactorLoginInfo
+	MsgErrorTwoFactorCodeWrong
+	MsgErrorLoginExpired
+	MsgAPITokens
+	MsgAPITokenNamePlaceholder
+	MsgNeverExpires
+	MsgExpiresInDays
+	MsgCreate
+	MsgAPITokenCreated
+	MsgAPITokenInfo
+	MsgNever
+	MsgAPITokenExpires
+	MsgAPITokenExpired
+	MsgErrorAPITokenName
+	MsgErrorAPITokenScopes
+	MsgErrorGetAPITokenNotFound
+	MsgRole
+	MsgRoleUser
+	MsgRoleModerator
+	MsgRoleAdmin
+	MsgSetRole
+	MsgAccountDisabled
+	MsgForceLogout
+	MsgErrorUserDisabled
+	MsgErrorAdminSelf
+	MsgEdit
+	MsgCancel
+	MsgHistory
+	MsgRevision
+	MsgCompare
+	MsgNoChanges
+	MsgErrorGetProductNotFound
+	MsgErrorGetRevisionNotFound
+	MsgManufacturer
+	MsgProductType
+	MsgProductTypeFood
+	MsgProductTypeElectronics
+	MsgProductTypeOther
+	MsgErrorProductManufacturer
+	MsgErrorProductType
+	MsgErrorProductTypeFood
+	MsgErrorProductExists
+	MsgErrorProductSimilar
+	MsgAddAnyway
+	MsgLoadMore
+	MsgPiece
+	MsgDensity
+	MsgUnitGram
+	MsgUnitKilogram
+	MsgUnitMilliliter
+	MsgUnitLiter
+	MsgUnitPiece
+	MsgErrorProductNetQuantity
+	MsgErrorProductDensity
+	MsgPriceModeUnit
+	MsgPriceModeKilogram
+	MsgPriceModeTotal
+	MsgCurrency
+	MsgBaseCurrency
+	MsgCurrencyRates
+	MsgRateDate
+	MsgCurrencyFrom
+	MsgCurrencyTo
+	MsgRate
+	MsgImportRates
+	MsgRatesCSVPlaceholder
+	MsgErrorRate
+	MsgDelete
+	MsgRatesImported
+	MsgErrorRatesCSVLine
+	MsgErrorCurrency
+	MsgLedger
+	MsgStatement
+	MsgBalance
+	MsgDate
+	MsgAmount
+	MsgNote
+	MsgEntryFromPerson
+	MsgEntryToPerson
+	MsgPaymentToPerson
+	MsgPaymentFromPerson
+	MsgYouOwe
+	MsgOwesYou
+	MsgSettled
+	MsgNoRate
+	MsgErrorPayment
+	MsgErrorGetPersonNotFound
+	MsgSplit
+	MsgMe
+	MsgSave
+	MsgShareTypeNone
+	MsgShareTypePercent
+	MsgShareTypeAmount
+	MsgShareTypeEqual
+	MsgEntrySplit
+	MsgErrorSplit
 )
 
 const (
@@ -325,6 +461,1099 @@ var translations map[Msg]translateFunction = map[Msg]translateFunction{
 			return fmt.Sprintf("Value can't be more than 100g")
 		}
 	},
+	MsgErrorUsername: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Никнейм должен содержать от 2 до 12 символов")
+		default:
+			return fmt.Sprintf("Username should contain 2 to 12 characters")
+		}
+	},
+	MsgErrorPassword: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Пароль должен содержать от 6 до 30 символов")
+		default:
+			return fmt.Sprintf("Password should contain 6 to 30 characters")
+		}
+	},
+	MsgPasswordPlaceholder: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Пароль")
+		default:
+			return fmt.Sprintf("Password")
+		}
+	},
+	MsgMailCodeSubject: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Код подтверждения Product Diary")
+		default:
+			return fmt.Sprintf("Product Diary confirmation code")
+		}
+	},
+	MsgMailCodeBody: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Ваш код подтверждения: %s\n\nКод действителен 5 минут. Если вы не регистрировались, просто проигнорируйте это письмо.\n", args[0])
+		default:
+			return fmt.Sprintf("Your confirmation code is: %s\n\nThe code is valid for 5 minutes. If you did not sign up, you can ignore this mail.\n", args[0])
+		}
+	},
+	MsgSessions: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Активные сеансы")
+		default:
+			return fmt.Sprintf("Active sessions")
+		}
+	},
+	MsgRevoke: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Завершить")
+		default:
+			return fmt.Sprintf("Revoke")
+		}
+	},
+	MsgLogOutEverywhere: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Выйти на всех устройствах")
+		default:
+			return fmt.Sprintf("Log out everywhere")
+		}
+	},
+	MsgCurrentSession: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Это устройство")
+		default:
+			return fmt.Sprintf("This device")
+		}
+	},
+	MsgSessionLastSeen: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Последняя активность %s с адреса %s", args[0], args[1])
+		default:
+			return fmt.Sprintf("Last active %s from %s", args[0], args[1])
+		}
+	},
+	MsgUnknownDevice: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Неизвестное устройство")
+		default:
+			return fmt.Sprintf("Unknown device")
+		}
+	},
+	MsgErrorTooManyRequests: func(locale Locale, args []string) string {
+		seconds, err := strconv.Atoi(args[0])
+		if err != nil {
+			seconds = 0
+		}
+		// Waits of a minute and more are shown in whole minutes
+		minutes := (seconds + 59) / 60
+
+		switch locale {
+		case LocaleRuRU:
+			if seconds < 60 {
+				return fmt.Sprintf("Слишком много попыток, повторите через %d сек.", seconds)
+			}
+			return fmt.Sprintf("Слишком много попыток, повторите через %d мин.", minutes)
+		default:
+			if seconds < 60 {
+				return fmt.Sprintf("Too many attempts, try again in %d s", seconds)
+			}
+			return fmt.Sprintf("Too many attempts, try again in %d min", minutes)
+		}
+	},
+	MsgForgotPassword: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Забыли пароль?")
+		default:
+			return fmt.Sprintf("Forgot password?")
+		}
+	},
+	MsgSendResetLink: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Отправить ссылку")
+		default:
+			return fmt.Sprintf("Send link")
+		}
+	},
+	MsgResetLinkSent: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Если для %s есть учётная запись, на неё отправлена ссылка для сброса пароля", args[0])
+		default:
+			return fmt.Sprintf("If there is an account for %s, a password reset link has been sent to it", args[0])
+		}
+	},
+	MsgMailResetSubject: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Сброс пароля Product Diary")
+		default:
+			return fmt.Sprintf("Product Diary password reset")
+		}
+	},
+	MsgMailResetBody: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Чтобы задать новый пароль, откройте ссылку:\n\n%s\n\nСсылка действительна 30 минут. После сброса пароля будет выполнен выход на всех устройствах. Если вы не запрашивали сброс, просто проигнорируйте это письмо.\n", args[0])
+		default:
+			return fmt.Sprintf("To set a new password, open this link:\n\n%s\n\nThe link is valid for 30 minutes. Resetting the password logs you out on every device. If you did not ask for a reset, you can ignore this mail.\n", args[0])
+		}
+	},
+	MsgResetPassword: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Сменить пароль")
+		default:
+			return fmt.Sprintf("Change password")
+		}
+	},
+	MsgErrorResetTokenInvalid: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Ссылка для сброса пароля недействительна или устарела")
+		default:
+			return fmt.Sprintf("The password reset link is invalid or has expired")
+		}
+	},
+	MsgPasswordChanged: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Пароль изменён, войдите с новым паролем")
+		default:
+			return fmt.Sprintf("Your password has been changed, log in with the new password")
+		}
+	},
+	MsgChange: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Изменить")
+		default:
+			return fmt.Sprintf("Change")
+		}
+	},
+	MsgApply: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Применить")
+		default:
+			return fmt.Sprintf("Apply")
+		}
+	},
+	MsgSaved: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Сохранено")
+		default:
+			return fmt.Sprintf("Saved")
+		}
+	},
+	MsgCurrentPasswordPlaceholder: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Текущий пароль")
+		default:
+			return fmt.Sprintf("Current password")
+		}
+	},
+	MsgNewPasswordPlaceholder: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Новый пароль")
+		default:
+			return fmt.Sprintf("New password")
+		}
+	},
+	MsgSendCode: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Отправить код")
+		default:
+			return fmt.Sprintf("Send code")
+		}
+	},
+	MsgConfirm: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Подтвердить")
+		default:
+			return fmt.Sprintf("Confirm")
+		}
+	},
+	MsgChangeEmailCodeSent: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Код подтверждения отправлен на %s", args[0])
+		default:
+			return fmt.Sprintf("A confirmation code has been sent to %s", args[0])
+		}
+	},
+	MsgMailChangeEmailSubject: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Подтверждение почты Product Diary")
+		default:
+			return fmt.Sprintf("Product Diary email confirmation")
+		}
+	},
+	MsgMailChangeEmailBody: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Код для смены почты: %s\n\nКод действителен 5 минут. Если вы не меняли почту, просто проигнорируйте это письмо.\n", args[0])
+		default:
+			return fmt.Sprintf("Your code to change the email is: %s\n\nThe code is valid for 5 minutes. If you did not change your email, you can ignore this mail.\n", args[0])
+		}
+	},
+	MsgTwoFactor: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Двухфакторная аутентификация")
+		default:
+			return fmt.Sprintf("Two-factor authentication")
+		}
+	},
+	MsgEnable: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Включить")
+		default:
+			return fmt.Sprintf("Enable")
+		}
+	},
+	MsgDisable: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Отключить")
+		default:
+			return fmt.Sprintf("Disable")
+		}
+	},
+	MsgTwoFactorScan: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Отсканируйте QR-код приложением-аутентификатором или введите ключ вручную, затем введите код из приложения")
+		default:
+			return fmt.Sprintf("Scan the QR code with an authenticator app or enter the key manually, then enter the code the app shows")
+		}
+	},
+	MsgTwoFactorCodePlaceholder: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Код из приложения")
+		default:
+			return fmt.Sprintf("Code from the app")
+		}
+	},
+	MsgTwoFactorLoginPlaceholder: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Код из приложения или код восстановления")
+		default:
+			return fmt.Sprintf("App code or recovery code")
+		}
+	},
+	MsgTwoFactorEnabled: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Двухфакторная аутентификация включена. Осталось кодов восстановления: %s", args[0])
+		default:
+			return fmt.Sprintf("Two-factor authentication is on. Recovery codes left: %s", args[0])
+		}
+	},
+	MsgRecoveryCodesInfo: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Сохраните коды восстановления. Каждый можно использовать один раз вместо кода из приложения. Они показываются только сейчас.")
+		default:
+			return fmt.Sprintf("Save these recovery codes. Each can be used once instead of a code from the app. They are shown only now.")
+		}
+	},
+	MsgNewRecoveryCodes: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Новые коды восстановления")
+		default:
+			return fmt.Sprintf("New recovery codes")
+		}
+	},
+	MsgTwoFactorLoginInfo: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Введите код из приложения-аутентификатора или код восстановления")
+		default:
+			return fmt.Sprintf("Enter the code from your authenticator app or a recovery code")
+		}
+	},
+	MsgErrorTwoFactorCodeWrong: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Неверный код")
+		default:
+			return fmt.Sprintf("Wrong code")
+		}
+	},
+	MsgErrorLoginExpired: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Время входа истекло, войдите снова")
+		default:
+			return fmt.Sprintf("The login has expired, log in again")
+		}
+	},
+	MsgAPITokens: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("API-токены")
+		default:
+			return fmt.Sprintf("API tokens")
+		}
+	},
+	MsgAPITokenNamePlaceholder: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Название токена")
+		default:
+			return fmt.Sprintf("Token name")
+		}
+	},
+	MsgNeverExpires: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Бессрочно")
+		default:
+			return fmt.Sprintf("Never expires")
+		}
+	},
+	MsgExpiresInDays: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("На %s дней", args[0])
+		default:
+			return fmt.Sprintf("For %s days", args[0])
+		}
+	},
+	MsgCreate: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Создать")
+		default:
+			return fmt.Sprintf("Create")
+		}
+	},
+	MsgAPITokenCreated: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Скопируйте токен сейчас, больше он показан не будет:")
+		default:
+			return fmt.Sprintf("Copy the token now, it is not shown again:")
+		}
+	},
+	MsgAPITokenInfo: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Создан %s, использован %s", args[0], args[1])
+		default:
+			return fmt.Sprintf("Created %s, last used %s", args[0], args[1])
+		}
+	},
+	MsgNever: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("никогда")
+		default:
+			return fmt.Sprintf("never")
+		}
+	},
+	MsgAPITokenExpires: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Действует до %s", args[0])
+		default:
+			return fmt.Sprintf("Expires %s", args[0])
+		}
+	},
+	MsgAPITokenExpired: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Истёк")
+		default:
+			return fmt.Sprintf("Expired")
+		}
+	},
+	MsgErrorAPITokenName: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Название токена должно быть от 1 до 32 символов")
+		default:
+			return fmt.Sprintf("Token name must be 1 to 32 characters long")
+		}
+	},
+	MsgErrorAPITokenScopes: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Выберите хотя бы одно право")
+		default:
+			return fmt.Sprintf("Choose at least one scope")
+		}
+	},
+	MsgErrorGetAPITokenNotFound: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Токен не найден")
+		default:
+			return fmt.Sprintf("Token not found")
+		}
+	},
+	MsgRole: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Роль")
+		default:
+			return fmt.Sprintf("Role")
+		}
+	},
+	MsgRoleUser: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Пользователь")
+		default:
+			return fmt.Sprintf("User")
+		}
+	},
+	MsgRoleModerator: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Модератор")
+		default:
+			return fmt.Sprintf("Moderator")
+		}
+	},
+	MsgRoleAdmin: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Администратор")
+		default:
+			return fmt.Sprintf("Administrator")
+		}
+	},
+	MsgSetRole: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Изменить роль")
+		default:
+			return fmt.Sprintf("Change role")
+		}
+	},
+	MsgAccountDisabled: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Аккаунт отключён")
+		default:
+			return fmt.Sprintf("Account disabled")
+		}
+	},
+	MsgForceLogout: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Завершить сеансы")
+		default:
+			return fmt.Sprintf("End sessions")
+		}
+	},
+	MsgErrorUserDisabled: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Этот аккаунт отключён")
+		default:
+			return fmt.Sprintf("This account is disabled")
+		}
+	},
+	MsgErrorAdminSelf: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Здесь нельзя изменить собственный аккаунт")
+		default:
+			return fmt.Sprintf("You can not change your own account here")
+		}
+	},
+	MsgEdit: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Изменить")
+		default:
+			return fmt.Sprintf("Edit")
+		}
+	},
+	MsgCancel: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Отмена")
+		default:
+			return fmt.Sprintf("Cancel")
+		}
+	},
+	MsgHistory: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("История")
+		default:
+			return fmt.Sprintf("History")
+		}
+	},
+	MsgRevision: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Версия")
+		default:
+			return fmt.Sprintf("Revision")
+		}
+	},
+	MsgCompare: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Сравнить")
+		default:
+			return fmt.Sprintf("Compare")
+		}
+	},
+	MsgNoChanges: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Без изменений")
+		default:
+			return fmt.Sprintf("No changes")
+		}
+	},
+	MsgErrorGetProductNotFound: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Продукт не найден")
+		default:
+			return fmt.Sprintf("Product not found")
+		}
+	},
+	MsgErrorGetRevisionNotFound: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Версия не найдена")
+		default:
+			return fmt.Sprintf("Revision not found")
+		}
+	},
+	MsgManufacturer: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Производитель")
+		default:
+			return fmt.Sprintf("Manufacturer")
+		}
+	},
+	MsgProductType: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Тип")
+		default:
+			return fmt.Sprintf("Type")
+		}
+	},
+	MsgProductTypeFood: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Еда")
+		default:
+			return fmt.Sprintf("Food")
+		}
+	},
+	MsgProductTypeElectronics: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Электроника")
+		default:
+			return fmt.Sprintf("Electronics")
+		}
+	},
+	MsgProductTypeOther: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Прочее")
+		default:
+			return fmt.Sprintf("Other")
+		}
+	},
+	MsgErrorProductManufacturer: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Производитель может быть не длиннее 64 символов")
+		default:
+			return fmt.Sprintf("Manufacturer can be at most 64 characters long")
+		}
+	},
+	MsgErrorProductType: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Выберите тип продукта")
+		default:
+			return fmt.Sprintf("Choose a product type")
+		}
+	},
+	MsgErrorProductTypeFood: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Еду нельзя сменить на другой тип и обратно, её пищевая ценность неизменна")
+		default:
+			return fmt.Sprintf("Food can not change to another type and back, its nutrients are fixed")
+		}
+	},
+	MsgErrorProductExists: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Такой продукт уже существует")
+		default:
+			return fmt.Sprintf("Such a product already exists")
+		}
+	},
+	MsgErrorProductSimilar: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Возможно, вы имели в виду: %s?", args[0])
+		default:
+			return fmt.Sprintf("Did you mean: %s?", args[0])
+		}
+	},
+	MsgAddAnyway: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Всё равно добавить")
+		default:
+			return fmt.Sprintf("Add anyway")
+		}
+	},
+	MsgLoadMore: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Загрузить ещё")
+		default:
+			return fmt.Sprintf("Load more")
+		}
+	},
+	MsgPiece: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Штука")
+		default:
+			return fmt.Sprintf("Piece")
+		}
+	},
+	MsgDensity: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Плотность, г/мл")
+		default:
+			return fmt.Sprintf("Density, g/ml")
+		}
+	},
+	MsgUnitGram: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("г")
+		default:
+			return fmt.Sprintf("g")
+		}
+	},
+	MsgUnitKilogram: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("кг")
+		default:
+			return fmt.Sprintf("kg")
+		}
+	},
+	MsgUnitMilliliter: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("мл")
+		default:
+			return fmt.Sprintf("ml")
+		}
+	},
+	MsgUnitLiter: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("л")
+		default:
+			return fmt.Sprintf("l")
+		}
+	},
+	MsgUnitPiece: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("шт")
+		default:
+			return fmt.Sprintf("pc")
+		}
+	},
+	MsgErrorProductNetQuantity: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Штука должна быть от 0,1 до 100000 г или мл")
+		default:
+			return fmt.Sprintf("A piece has to be from 0.1 to 100000 g or ml")
+		}
+	},
+	MsgErrorProductDensity: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Плотность должна быть от 0,1 до 25 г/мл")
+		default:
+			return fmt.Sprintf("Density has to be from 0.1 to 25 g/ml")
+		}
+	},
+	MsgPriceModeUnit: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("за единицу")
+		default:
+			return fmt.Sprintf("per unit")
+		}
+	},
+	MsgPriceModeKilogram: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("за кг")
+		default:
+			return fmt.Sprintf("per kg")
+		}
+	},
+	MsgPriceModeTotal: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("всего")
+		default:
+			return fmt.Sprintf("total")
+		}
+	},
+	MsgCurrency: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Валюта")
+		default:
+			return fmt.Sprintf("Currency")
+		}
+	},
+	MsgBaseCurrency: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Основная валюта")
+		default:
+			return fmt.Sprintf("Base currency")
+		}
+	},
+	MsgCurrencyRates: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Курсы валют")
+		default:
+			return fmt.Sprintf("Exchange rates")
+		}
+	},
+	MsgRateDate: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Дата")
+		default:
+			return fmt.Sprintf("Date")
+		}
+	},
+	MsgCurrencyFrom: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Из")
+		default:
+			return fmt.Sprintf("From")
+		}
+	},
+	MsgCurrencyTo: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("В")
+		default:
+			return fmt.Sprintf("To")
+		}
+	},
+	MsgRate: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Курс")
+		default:
+			return fmt.Sprintf("Rate")
+		}
+	},
+	MsgImportRates: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Импорт CSV")
+		default:
+			return fmt.Sprintf("Import CSV")
+		}
+	},
+	MsgRatesCSVPlaceholder: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("дата,из,в,курс на строку, например 2024-05-01,EUR,RUB,98.5")
+		default:
+			return fmt.Sprintf("date,from,to,rate per line, e.g. 2024-05-01,EUR,RUB,98.5")
+		}
+	},
+	MsgErrorRate: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Введите дату, две разные валюты и курс больше 0")
+		default:
+			return fmt.Sprintf("Enter a date, two different currencies and a rate above 0")
+		}
+	},
+	MsgDelete: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Удалить")
+		default:
+			return fmt.Sprintf("Delete")
+		}
+	},
+	MsgRatesImported: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Импортировано курсов: %s", args[0])
+		default:
+			return fmt.Sprintf("Imported rates: %s", args[0])
+		}
+	},
+	MsgErrorRatesCSVLine: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Строка %s не в виде дата,из,в,курс", args[0])
+		default:
+			return fmt.Sprintf("Line %s is not date,from,to,rate", args[0])
+		}
+	},
+	MsgErrorCurrency: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Выберите валюту из списка")
+		default:
+			return fmt.Sprintf("Choose a currency from the list")
+		}
+	},
+	MsgLedger: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Долги")
+		default:
+			return fmt.Sprintf("Debts")
+		}
+	},
+	MsgStatement: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Выписка")
+		default:
+			return fmt.Sprintf("Statement")
+		}
+	},
+	MsgBalance: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Баланс")
+		default:
+			return fmt.Sprintf("Balance")
+		}
+	},
+	MsgDate: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Дата")
+		default:
+			return fmt.Sprintf("Date")
+		}
+	},
+	MsgAmount: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Сумма")
+		default:
+			return fmt.Sprintf("Amount")
+		}
+	},
+	MsgNote: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Примечание")
+		default:
+			return fmt.Sprintf("Note")
+		}
+	},
+	MsgEntryFromPerson: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Куплено мне")
+		default:
+			return fmt.Sprintf("Bought for me")
+		}
+	},
+	MsgEntryToPerson: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Куплено мной")
+		default:
+			return fmt.Sprintf("Bought by me")
+		}
+	},
+	MsgPaymentToPerson: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Я заплатил")
+		default:
+			return fmt.Sprintf("I paid")
+		}
+	},
+	MsgPaymentFromPerson: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Заплатили мне")
+		default:
+			return fmt.Sprintf("Paid me")
+		}
+	},
+	MsgYouOwe: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Вы должны %s", args[0])
+		default:
+			return fmt.Sprintf("You owe %s", args[0])
+		}
+	},
+	MsgOwesYou: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Должны вам %s", args[0])
+		default:
+			return fmt.Sprintf("Owes you %s", args[0])
+		}
+	},
+	MsgSettled: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Долгов нет")
+		default:
+			return fmt.Sprintf("Settled")
+		}
+	},
+	MsgNoRate: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Нет курса к %s", args[0])
+		default:
+			return fmt.Sprintf("No rate to %s", args[0])
+		}
+	},
+	MsgErrorPayment: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Введите дату, вид и сумму больше 0")
+		default:
+			return fmt.Sprintf("Enter a date, a type and an amount above 0")
+		}
+	},
+	MsgErrorGetPersonNotFound: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Такого человека нет")
+		default:
+			return fmt.Sprintf("No such person")
+		}
+	},
+	MsgSplit: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Разделить")
+		default:
+			return fmt.Sprintf("Split")
+		}
+	},
+	MsgMe: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Я")
+		default:
+			return fmt.Sprintf("Me")
+		}
+	},
+	MsgSave: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Сохранить")
+		default:
+			return fmt.Sprintf("Save")
+		}
+	},
+	MsgShareTypeNone: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Без доли")
+		default:
+			return fmt.Sprintf("No share")
+		}
+	},
+	MsgShareTypePercent: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Процент")
+		default:
+			return fmt.Sprintf("Percent")
+		}
+	},
+	MsgShareTypeAmount: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Сумма")
+		default:
+			return fmt.Sprintf("Fixed amount")
+		}
+	},
+	MsgShareTypeEqual: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Поровну")
+		default:
+			return fmt.Sprintf("Equal part")
+		}
+	},
+	MsgEntrySplit: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Доля в покупке")
+		default:
+			return fmt.Sprintf("Their share")
+		}
+	},
+	MsgErrorSplit: func(locale Locale, args []string) string {
+		switch locale {
+		case LocaleRuRU:
+			return fmt.Sprintf("Доли не сходятся с суммой или человек указан дважды")
+		default:
+			return fmt.Sprintf("Shares do not add up to the total or a person is given twice")
+		}
+	},
 }
 
 func Localize(msg string, locale Locale) string {
@@ -354,11 +1583,11 @@ func GetMessage(msgID Msg, args ...any) string {
 }
 
 func GetError(msgID Msg, args ...any) error {
-	return fmt.Errorf(GetMessage(msgID, args))
+	return errors.New(GetMessage(msgID, args...))
 }
 
 func GetLocalized(locale Locale, msgID Msg, args ...any) string {
-	return Localize(GetMessage(msgID, args), locale)
+	return Localize(GetMessage(msgID, args...), locale)
 }
 
 type Localizer struct {
@@ -0,0 +1,56 @@
+package localization
+
+import (
+	"strconv"
+	"strings"
+)
+
+// currencySymbols of every locale. Currencies without a symbol are written
+// with their code.
+var currencySymbols = map[Locale]map[string]string{
+	LocaleEnUS: {"USD": "$", "EUR": "€", "GBP": "£", "CNY": "CN¥"},
+	LocaleRuRU: {"RUB": "₽", "USD": "$", "EUR": "€", "GBP": "£", "CNY": "CN¥"},
+}
+
+// FormatMoney writes minor units of a currency the way the locale does,
+// "$1,234.50" in en-US and "1 234,50 $" in ru-RU. Every currency is taken to
+// have 100 minor units.
+func FormatMoney(locale Locale, minor int64, currency string) string {
+	sign := ""
+	u := uint64(minor)
+	if minor < 0 {
+		sign = "-"
+		u = uint64(-minor)
+	}
+	symbol, found := currencySymbols[locale][currency]
+	if !found {
+		symbol = currency
+	}
+
+	separator, point := ",", "."
+	if locale == LocaleRuRU {
+		separator, point = " ", ","
+	}
+	whole := strconv.FormatUint(u/100, 10)
+	groups := []string{}
+	for len(whole) > 3 {
+		groups = append([]string{whole[len(whole)-3:]}, groups...)
+		whole = whole[:len(whole)-3]
+	}
+	groups = append([]string{whole}, groups...)
+	fraction := strconv.FormatUint(u%100+100, 10)[1:]
+	number := strings.Join(groups, separator) + point + fraction
+
+	switch {
+	case locale == LocaleRuRU:
+		return sign + number + " " + symbol
+	case found:
+		return sign + symbol + number
+	default:
+		return sign + symbol + " " + number
+	}
+}
+
+func (l *Localizer) FormatMoney(minor int64, currency string) string {
+	return FormatMoney(l.locale, minor, currency)
+}
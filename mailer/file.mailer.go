@@ -0,0 +1,65 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bmg-c/product-diary/services"
+)
+
+// FileMailer writes every mail as an .eml file into a directory, so that
+// tests and developers can read them.
+type FileMailer struct {
+	dir  string
+	from string
+	mu   sync.Mutex
+	seq  uint
+}
+
+func NewFileMailer(dir string, from string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create mail directory %s (%s)", dir, err.Error())
+	}
+	return &FileMailer{
+		dir:  dir,
+		from: from,
+	}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, mail services.Mail) error {
+	msg, err := buildMessage(m.from, mail)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.seq++
+	fileName := fmt.Sprintf("%s-%04d-%s.eml",
+		time.Now().Format("20060102T150405"), m.seq, sanitizeFileName(mail.To))
+	m.mu.Unlock()
+
+	return os.WriteFile(filepath.Join(m.dir, fileName), msg, 0o644)
+}
+
+// Files returns the paths of the written mails addressed to "to", oldest first.
+func (m *FileMailer) Files(to string) ([]string, error) {
+	return filepath.Glob(filepath.Join(m.dir, "*-"+sanitizeFileName(to)+".eml"))
+}
+
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '@', r == '.', r == '_', r == '+':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
@@ -0,0 +1,20 @@
+package mailer
+
+import (
+	"context"
+
+	"github.com/bmg-c/product-diary/logger"
+	"github.com/bmg-c/product-diary/services"
+)
+
+// LogMailer prints mails to the log instead of sending them, for development.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(ctx context.Context, mail services.Mail) error {
+	logger.Info.Printf("Mail to %s\nSubject: %s\n\n%s", mail.To, mail.Subject, mail.Body)
+	return nil
+}
@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+
+	"github.com/bmg-c/product-diary/services"
+)
+
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer sends mails through the server at addr ("host:port"). Plain
+// authentication is used when username is not empty.
+func NewSMTPMailer(addr string, from string, username string, password string) (*SMTPMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid SMTP address %s (%s)", addr, err.Error())
+	}
+	if from == "" {
+		return nil, fmt.Errorf("SMTP sender address is empty")
+	}
+
+	var auth smtp.Auth = nil
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{
+		addr: addr,
+		from: from,
+		auth: auth,
+	}, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, mail services.Mail) error {
+	msg, err := buildMessage(m.from, mail)
+	if err != nil {
+		return err
+	}
+
+	// net/smtp has no context support, the request is only checked before
+	// connecting.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{mail.To}, msg)
+}
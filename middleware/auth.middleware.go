@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/logger"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/util"
+	"github.com/google/uuid"
+)
+
+// LoginPath is the page unauthenticated HTMX requests are redirected to.
+const LoginPath = "/users"
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	sessionContextKey
+	csrfContextKey
+	scopesContextKey
+)
+
+type SessionResolver interface {
+	GetUserBySession(ctx context.Context, sessionUUID uuid.UUID) (user_schemas.UserDB, error)
+	GetUserByAPIToken(ctx context.Context, token string) (user_schemas.UserDB, []string, error)
+}
+
+// Authenticate resolves the session cookie once per request and stores the
+// user in the request context. Requests without a valid session continue
+// anonymously, routes decide with RequireUser or OptionalUser.
+//
+// Requests with an "Authorization: Bearer" header are authenticated by the
+// personal API token instead, and the cookie is ignored. An invalid token
+// gets 401 right away, as scripts should not silently run anonymously.
+func Authenticate(resolver SessionResolver) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if authorization := r.Header.Get("Authorization"); authorization != "" {
+				token, found := strings.CutPrefix(authorization, "Bearer ")
+				if !found {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				userDB, scopes, err := resolver.GetUserByAPIToken(r.Context(), strings.TrimSpace(token))
+				if err != nil {
+					if errors.Is(err, E.ErrInternalServer) {
+						logger.Error.Printf("Failure getting user of API token: %v\n", err)
+						w.WriteHeader(http.StatusInternalServerError)
+						return
+					}
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				ctx := context.WithValue(r.Context(), userContextKey, userDB)
+				ctx = context.WithValue(ctx, scopesContextKey, scopes)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			sessionUUID, err := util.GetUserSessionCookieValue(w, r)
+			if err != nil {
+				if errors.Is(err, E.ErrInternalServer) {
+					logger.Error.Printf("Failure getting session cookie.\n")
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userDB, err := resolver.GetUserBySession(r.Context(), sessionUUID)
+			if err != nil {
+				if errors.Is(err, E.ErrInternalServer) {
+					logger.Error.Printf("Failure getting user of session: %v\n", err)
+				} else {
+					// Expired or revoked
+					util.DeleteUserSessionCookie(w)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userContextKey, userDB)
+			ctx = context.WithValue(ctx, sessionContextKey, sessionUUID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// UserFromContext returns the user stored by Authenticate.
+func UserFromContext(ctx context.Context) (user_schemas.UserDB, bool) {
+	userDB, ok := ctx.Value(userContextKey).(user_schemas.UserDB)
+	return userDB, ok
+}
+
+// SessionFromContext returns the session the user was authenticated with.
+func SessionFromContext(ctx context.Context) (uuid.UUID, bool) {
+	sessionUUID, ok := ctx.Value(sessionContextKey).(uuid.UUID)
+	return sessionUUID, ok
+}
+
+// ScopesFromContext returns the scopes of the API token the request was
+// authenticated with. It returns false for sessions, which are not limited.
+func ScopesFromContext(ctx context.Context) ([]string, bool) {
+	scopes, ok := ctx.Value(scopesContextKey).([]string)
+	return scopes, ok
+}
+
+// RequireUser rejects anonymous requests with 401. HTMX requests are also
+// told to redirect to the login page. API tokens get 403, they only reach
+// routes marked with RequireScope.
+func RequireUser(next http.HandlerFunc) http.HandlerFunc {
+	return RequireScope("", next)
+}
+
+// RequireScope is RequireUser for routes that API tokens with scope may use.
+func RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := UserFromContext(r.Context()); !ok {
+			if r.Header.Get("HX-Request") == "true" {
+				w.Header().Set("HX-Redirect", LoginPath)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if scopes, ok := ScopesFromContext(r.Context()); ok {
+			if scope == "" || !slices.Contains(scopes, scope) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// RequirePermission is RequireUser for routes that need the role of the user
+// to grant permission. Other users get 403.
+func RequirePermission(permission user_schemas.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		userDB, _ := UserFromContext(r.Context())
+		if !userDB.Can(permission) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// OptionalUser marks routes that serve anonymous requests as well. The
+// handler checks UserFromContext itself.
+func OptionalUser(next http.HandlerFunc) http.HandlerFunc {
+	return next
+}
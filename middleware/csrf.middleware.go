@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/bmg-c/product-diary/logger"
+)
+
+const (
+	// CSRFHeader is sent by HTMX, see views.Layout
+	CSRFHeader = "X-CSRF-Token"
+	// CSRFFormField is accepted from plain forms
+	CSRFFormField = "csrf_token"
+	// csrfCookieName holds the random ID of visitors without a session
+	csrfCookieName = "csrf"
+)
+
+// CSRF protects every request that is not GET, HEAD, OPTIONS or TRACE. The
+// expected token is an HMAC of the session set by Authenticate, or of a random
+// cookie for anonymous visitors, so it changes on login and logout. Requests
+// with a missing or mismatched token get 403.
+//
+// Requests authenticated by an API token are not checked, browsers do not
+// add the Authorization header to cross-site requests by themselves.
+func CSRF(secret []byte) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := ScopesFromContext(r.Context()); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var token string
+			if sessionUUID, ok := SessionFromContext(r.Context()); ok {
+				token = csrfToken(secret, "session:"+sessionUUID.String())
+			} else {
+				anonID, err := getCSRFCookieValue(r)
+				if err != nil {
+					anonID, err = newCSRFCookieValue()
+					if err != nil {
+						logger.Error.Printf("Failure generating CSRF cookie: %v\n", err)
+						w.WriteHeader(http.StatusInternalServerError)
+						return
+					}
+					setCSRFCookie(w, anonID)
+				}
+				token = csrfToken(secret, "anonymous:"+anonID)
+			}
+
+			if !isSafeMethod(r.Method) {
+				sent := r.Header.Get(CSRFHeader)
+				if sent == "" {
+					sent = r.PostFormValue(CSRFFormField)
+				}
+				if sent == "" || !hmac.Equal([]byte(sent), []byte(token)) {
+					logger.Info.Printf("Rejected request with invalid CSRF token %s %s", r.Method, r.URL.Path)
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), csrfContextKey, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// CSRFTokenFromContext returns the token requests from this page must send.
+func CSRFTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(csrfContextKey).(string)
+	return token
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+func csrfToken(secret []byte, subject string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(subject))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func getCSRFCookieValue(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil {
+		return "", err
+	}
+	value, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(value) != 32 {
+		return "", http.ErrNoCookie
+	}
+	return cookie.Value, nil
+}
+
+func newCSRFCookieValue() (string, error) {
+	value := make([]byte, 32)
+	_, err := rand.Read(value)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(value), nil
+}
+
+func setCSRFCookie(w http.ResponseWriter, value string) {
+	cookie := http.Cookie{
+		Name:     csrfCookieName,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, &cookie)
+}
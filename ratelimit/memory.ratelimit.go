@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Entries untouched for this long are dropped by the sweep.
+const memoryStoreIdle = 24 * time.Hour
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryStore keeps state in the process. It is lost on restart and not
+// shared between instances.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]bucket
+	lockouts  map[string]Lockout
+	lastSweep time.Time
+}
+
+// Compile time check
+var _ Store = new(MemoryStore)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:   map[string]bucket{},
+		lockouts:  map[string]Lockout{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (ms *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.sweep(now)
+
+	b, exists := ms.buckets[key]
+	if !exists {
+		b = bucket{tokens: limit.Burst, updated: now}
+	}
+	tokens := refill(b.tokens, now.Sub(b.updated), limit)
+
+	res := Result{Allowed: tokens >= 1}
+	if res.Allowed {
+		tokens--
+	} else {
+		res.RetryAfter = time.Duration((1 - tokens) * float64(limit.Every))
+	}
+	ms.buckets[key] = bucket{tokens: tokens, updated: now}
+	return res, nil
+}
+
+func (ms *MemoryStore) GetLockout(ctx context.Context, key string) (Lockout, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.lockouts[key], nil
+}
+
+func (ms *MemoryStore) SetLockout(ctx context.Context, key string, lockout Lockout) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.lockouts[key] = lockout
+	return nil
+}
+
+func (ms *MemoryStore) DeleteLockout(ctx context.Context, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.lockouts, key)
+	return nil
+}
+
+// sweep drops idle entries at most once per idle period.
+func (ms *MemoryStore) sweep(now time.Time) {
+	if now.Sub(ms.lastSweep) < memoryStoreIdle {
+		return
+	}
+	ms.lastSweep = now
+	for key, b := range ms.buckets {
+		if now.Sub(b.updated) > memoryStoreIdle {
+			delete(ms.buckets, key)
+		}
+	}
+	for key, lockout := range ms.lockouts {
+		if now.Sub(lockout.LastFailure) > memoryStoreIdle && now.After(lockout.LockedUntil) {
+			delete(ms.lockouts, key)
+		}
+	}
+}
+
+func refill(tokens float64, elapsed time.Duration, limit Limit) float64 {
+	if elapsed > 0 && limit.Every > 0 {
+		tokens += float64(elapsed) / float64(limit.Every)
+	}
+	return min(tokens, limit.Burst)
+}
@@ -0,0 +1,154 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit describes a token bucket that holds up to Burst tokens and gains one
+// token every Every.
+type Limit struct {
+	Burst float64
+	Every time.Duration
+}
+
+type Result struct {
+	Allowed    bool
+	RetryAfter time.Duration
+}
+
+// Lockout counts consecutive failures of a key.
+type Lockout struct {
+	Failures    uint
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// Store keeps token buckets and lockouts. GetLockout returns a zero Lockout for
+// unknown keys.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+	GetLockout(ctx context.Context, key string) (Lockout, error)
+	SetLockout(ctx context.Context, key string, lockout Lockout) error
+	DeleteLockout(ctx context.Context, key string) error
+}
+
+// LockoutPolicy locks a key after Threshold failures for Base, doubling with
+// every further failure up to Max. Failures are forgotten after Reset without
+// new ones.
+type LockoutPolicy struct {
+	Threshold uint
+	Base      time.Duration
+	Max       time.Duration
+	Reset     time.Duration
+}
+
+func (lp LockoutPolicy) duration(failures uint) time.Duration {
+	if lp.Threshold == 0 || failures < lp.Threshold {
+		return 0
+	}
+	d := lp.Base
+	for i := lp.Threshold; i < failures && d < lp.Max; i++ {
+		d *= 2
+	}
+	if d > lp.Max {
+		d = lp.Max
+	}
+	return d
+}
+
+// Guard combines a token bucket per key with progressive lockouts. Keys are
+// free form, for example "login:ip:127.0.0.1" or "login:email:a@b.c".
+type Guard struct {
+	store   Store
+	limit   Limit
+	lockout LockoutPolicy
+}
+
+func NewGuard(store Store, limit Limit, lockout LockoutPolicy) *Guard {
+	return &Guard{
+		store:   store,
+		limit:   limit,
+		lockout: lockout,
+	}
+}
+
+// Allow takes a token for every key. It returns how long the caller has to
+// wait, zero when the request may proceed.
+func (g *Guard) Allow(ctx context.Context, keys ...string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		lockout, err := g.store.GetLockout(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if lockout.LockedUntil.After(now) {
+			wait = max(wait, lockout.LockedUntil.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return roundUp(wait), nil
+	}
+
+	for _, key := range keys {
+		res, err := g.store.Take(ctx, key, g.limit, now)
+		if err != nil {
+			return 0, err
+		}
+		if !res.Allowed {
+			wait = max(wait, res.RetryAfter)
+		}
+	}
+	return roundUp(wait), nil
+}
+
+// Failure records a failed attempt for every key and returns the longest
+// lockout it caused.
+func (g *Guard) Failure(ctx context.Context, keys ...string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		lockout, err := g.store.GetLockout(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if g.lockout.Reset > 0 && now.Sub(lockout.LastFailure) > g.lockout.Reset {
+			lockout = Lockout{}
+		}
+		lockout.Failures++
+		lockout.LastFailure = now
+		if d := g.lockout.duration(lockout.Failures); d > 0 {
+			lockout.LockedUntil = now.Add(d)
+			wait = max(wait, d)
+		}
+		err = g.store.SetLockout(ctx, key, lockout)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return roundUp(wait), nil
+}
+
+// Success forgets the failures of keys.
+func (g *Guard) Success(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		err := g.store.DeleteLockout(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// roundUp makes waits whole seconds, as sent in Retry-After.
+func roundUp(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	rounded := d.Truncate(time.Second)
+	if rounded < d {
+		rounded += time.Second
+	}
+	return rounded
+}
@@ -0,0 +1,62 @@
+package currency_schemas
+
+import "time"
+
+// Currencies are ISO 4217 codes. Everything logged before currencies were
+// added is in DefaultCurrency.
+const (
+	CurrencyRUB = "RUB"
+	CurrencyUSD = "USD"
+	CurrencyEUR = "EUR"
+	CurrencyGBP = "GBP"
+	CurrencyCNY = "CNY"
+)
+
+var Currencies = []string{CurrencyRUB, CurrencyUSD, CurrencyEUR, CurrencyGBP, CurrencyCNY}
+
+const DefaultCurrency = CurrencyRUB
+
+// RateDB tells that one CurrencyFrom costs Rate CurrencyTo from RateDate on,
+// until the next rate of the pair. Rates are entered by every user for
+// themselves.
+type RateDB struct {
+	RateID       uint      `json:"rate_id" format:"id"`
+	UserID       uint      `json:"user_id" format:"id"`
+	RateDate     time.Time `json:"rate_date"`
+	CurrencyFrom string    `json:"currency_from" format:"currency"`
+	CurrencyTo   string    `json:"currency_to" format:"currency"`
+	Rate         float64   `json:"rate" format:"currency_rate"`
+}
+
+// AddRate replaces the rate of the pair on the same date.
+type AddRate struct {
+	UserID       uint      `json:"user_id" format:"id"`
+	RateDate     time.Time `json:"rate_date"`
+	CurrencyFrom string    `json:"currency_from" format:"currency"`
+	CurrencyTo   string    `json:"currency_to" format:"currency"`
+	Rate         float64   `json:"rate" format:"currency_rate"`
+}
+
+type DeleteRate struct {
+	RateID uint `json:"rate_id" format:"id"`
+	UserID uint `json:"user_id" format:"id"`
+}
+
+type GetRates struct {
+	UserID uint `json:"user_id" format:"id"`
+}
+
+// ImportRates adds a rate for every line of CSV, given as
+// date,currency_from,currency_to,rate like "2024-05-01,EUR,RUB,98.5". A
+// header line is skipped.
+type ImportRates struct {
+	UserID uint   `json:"user_id" format:"id"`
+	CSV    string `json:"csv" format:"rates_csv"`
+}
+
+type ImportRatesResult struct {
+	Imported uint `json:"imported"`
+	// BadLine is the number of the first line that is not a rate, 0 when
+	// every line is
+	BadLine uint `json:"bad_line"`
+}
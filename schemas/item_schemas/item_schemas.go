@@ -3,6 +3,7 @@ package item_schemas
 import (
 	"time"
 
+	"github.com/bmg-c/product-diary/schemas/product_schemas"
 	"github.com/bmg-c/product-diary/schemas/user_schemas"
 )
 
@@ -15,25 +16,64 @@ const (
 	ItemTypeToPersonPurchase
 )
 
+// Price modes tell what the cost of an item is, see ENTITIES.md
+const (
+	// Price of one unit of the amount, in the unit of the item
+	PriceModeUnit uint8 = iota + 1
+	// Price of a kilogram of the product
+	PriceModeKilogram
+	// Total paid for the item
+	PriceModeTotal
+)
+
+var PriceModes = []uint8{PriceModeUnit, PriceModeKilogram, PriceModeTotal}
+
+// Share types tell what the share value of a split is, see ENTITIES.md
+const (
+	// Percentage of the total in hundredths of a percent, 3333 is 33.33%
+	ShareTypePercent uint8 = iota + 1
+	// Amount in minor units of the currency of the item
+	ShareTypeAmount
+	// Equal part of what is left after percentages and amounts
+	ShareTypeEqual
+)
+
+var ShareTypes = []uint8{ShareTypePercent, ShareTypeAmount, ShareTypeEqual}
+
 type ItemDB struct {
 	ItemID     uint      `json:"item_id" format:"id"`
 	UserID     uint      `json:"user_id" format:"id"`
 	ProductID  uint      `json:"product_id" format:"id"`
 	ItemDate   time.Time `json:"item_date"`
-	ItemCost   float32   `json:"item_cost" format:"item_cost"`
+	ItemCost   int64     `json:"item_cost" format:"item_cost"`
 	ItemAmount float32   `json:"item_amount" format:"item_amount"`
 	ItemType   uint8     `json:"item_type" format:"item_type"`
 	PersonID   uint      `json:"person_id" format:"id" validate:"omitzero"`
+	// RevisionID is the version of the product the item was logged with
+	RevisionID uint `json:"revision_id" format:"id"`
+	// ItemUnit is the unit of ItemAmount, see product_schemas.Units
+	ItemUnit uint8 `json:"item_unit" format:"item_unit"`
+	// PriceMode tells what ItemCost is, see PriceModes. Costs are in minor
+	// units of the currency, kopecks or cents
+	PriceMode uint8 `json:"price_mode" format:"price_mode"`
+	// Currency ItemCost is in, see currency_schemas.Currencies
+	Currency string `json:"currency" format:"currency"`
 }
 
 type AddItem struct {
 	UserID     uint      `json:"user_id" format:"id"`
 	ProductID  uint      `json:"product_id" format:"id"`
 	ItemDate   time.Time `json:"item_date"`
-	ItemCost   float32   `json:"item_cost" format:"item_cost" validate:"omitzero"`
+	ItemCost   int64     `json:"item_cost" format:"item_cost" validate:"omitzero"`
 	ItemAmount float32   `json:"item_amount" format:"item_amount" validate:"omitzero"`
 	ItemType   uint8     `json:"item_type" format:"item_type" validate:"omitzero"`
 	PersonID   uint      `json:"person_id" format:"id" validate:"omitzero"`
+	// Pieces unless given
+	ItemUnit uint8 `json:"item_unit" format:"item_unit" validate:"omitzero"`
+	// The price of a unit unless given
+	PriceMode uint8 `json:"price_mode" format:"price_mode" validate:"omitzero"`
+	// currency_schemas.DefaultCurrency unless given
+	Currency string `json:"currency" format:"currency" validate:"omitzero"`
 }
 
 type DeleteItem struct {
@@ -45,16 +85,24 @@ type ChangeItem struct {
 	ItemID     uint    `json:"item_id" format:"id"`
 	UserID     uint    `json:"user_id" format:"id"`
 	ProductID  uint    `json:"product_id" format:"id" validate:"omitzero"`
-	ItemCost   float32 `json:"item_cost" format:"item_cost" validate:"omitzero"`
+	ItemCost   int64   `json:"item_cost" format:"item_cost" validate:"omitzero"`
 	ItemAmount float32 `json:"item_amount" format:"item_amount" validate:"omitzero"`
 	ItemType   uint8   `json:"item_type" format:"item_type" validate:"omitzero"`
 	PersonID   uint    `json:"person_id" format:"id" validate:"omitzero"`
+	ItemUnit   uint8   `json:"item_unit" format:"item_unit" validate:"omitzero"`
+	PriceMode  uint8   `json:"price_mode" format:"price_mode" validate:"omitzero"`
+	Currency   string  `json:"currency" format:"currency" validate:"omitzero"`
 }
 
+// GetItems is sorted and paged like product_schemas.GetProducts.
 type GetItems struct {
-	UserID      uint      `json:"user_id" format:"id"`
-	ItemDate    time.Time `json:"item_date"`
-	SearchQuery string    `json:"search_query"`
+	UserID        uint      `json:"user_id" format:"id"`
+	ItemDate      time.Time `json:"item_date"`
+	SearchQuery   string    `json:"search_query"`
+	SortField     string    `json:"sort_field" format:"item_sort" validate:"omitzero"`
+	SortDirection string    `json:"sort_direction" format:"sort_direction" validate:"omitzero"`
+	Cursor        string    `json:"cursor" format:"cursor" validate:"omitzero"`
+	Limit         uint      `json:"limit"`
 }
 
 type GetItem struct {
@@ -67,11 +115,15 @@ type ItemParsed struct {
 	UserID     uint      `json:"user_id" format:"id"`
 	ProductID  uint      `json:"product_id" format:"id"`
 	ItemDate   time.Time `json:"item_date"`
-	ItemCost   float32   `json:"item_cost" format:"item_cost"`
+	ItemCost   int64     `json:"item_cost" format:"item_cost"`
 	ItemAmount float32   `json:"item_amount" format:"item_amount"`
 	ItemType   uint8     `json:"item_type" format:"item_type"`
 	PersonID   uint      `json:"person_id" format:"id" validate:"omitzero"`
-	// Parsed info
+	RevisionID uint      `json:"revision_id" format:"id"`
+	ItemUnit   uint8     `json:"item_unit" format:"item_unit"`
+	PriceMode  uint8     `json:"price_mode" format:"price_mode"`
+	Currency   string    `json:"currency" format:"currency"`
+	// Parsed info, the product as it was when the item was logged
 	ProductTitle    string  `json:"product_title" format:"product_title"`
 	ProductCalories float32 `json:"product_calories" format:"product_calories"`
 	ProductFats     float32 `json:"product_fats" format:"product_nutrient"`
@@ -79,24 +131,77 @@ type ItemParsed struct {
 	ProductProteins float32 `json:"product_proteins" format:"product_nutrient"`
 	PersonName      string  `json:"person_name" format:"username" validate:"omitzero"`
 	PersonIsHidden  bool    `json:"person_is_hidden"`
+	// ProductMeasure converts ItemAmount to grams, see services.UnitService
+	ProductMeasure product_schemas.Measure `json:"product_measure"`
+	// Splits of an item bought by the user, the whole item is theirs when
+	// there are none
+	Splits []SplitDB `json:"splits"`
+	// Cursor of the page after this item, set by GetItems
+	Cursor string `json:"-"`
+}
+
+// SplitDB is the share of a person in an item bought by the user, PersonID
+// is 0 for the share of the user.
+type SplitDB struct {
+	SplitID    uint   `json:"split_id" format:"id"`
+	ItemID     uint   `json:"item_id" format:"id"`
+	PersonID   uint   `json:"person_id" format:"id" validate:"omitzero"`
+	ShareType  uint8  `json:"share_type" format:"share_type"`
+	ShareValue int64  `json:"share_value" format:"share_value"`
+	PersonName string `json:"person_name" format:"username" validate:"omitzero"`
+}
+
+type Split struct {
+	PersonID   uint  `json:"person_id" format:"id" validate:"omitzero"`
+	ShareType  uint8 `json:"share_type" format:"share_type"`
+	ShareValue int64 `json:"share_value" format:"share_value"`
+}
+
+// SetItemSplits replaces the splits of an item, without splits the whole item
+// is the user's again. Every split is validated on its own.
+type SetItemSplits struct {
+	ItemID uint    `json:"item_id" format:"id"`
+	UserID uint    `json:"user_id" format:"id"`
+	Splits []Split `json:"splits"`
+}
+
+// GetPersonItems returns the items bought by or for a person, or for every
+// person when PersonID is 0. Items split with the person are returned too.
+type GetPersonItems struct {
+	UserID   uint `json:"user_id" format:"id"`
+	PersonID uint `json:"person_id" format:"id" validate:"omitzero"`
 }
 
 type GetItemsRange struct {
 	UserID       uint      `json:"user_id" format:"id"`
 	ItemDateFrom time.Time `json:"item_date_from"`
 	ItemDateTo   time.Time `json:"item_date_to"`
+	// BaseCurrency the analytics are summed up in
+	BaseCurrency string `json:"base_currency" format:"currency"`
 }
 
 type PersonAnalytics struct {
 	PersonDB  user_schemas.PersonDB `json:"person_db"`
-	TotalDebt float32               `json:"total_debt"`
+	TotalDebt int64                 `json:"total_debt"`
+}
+
+// CurrencyAnalytics is what was spent in a currency, before converting.
+type CurrencyAnalytics struct {
+	Currency   string `json:"currency" format:"currency"`
+	TotalSpent int64  `json:"total_spent"`
 }
 
+// Money of Analytics is in minor units of BaseCurrency, like ItemDB.ItemCost.
+// Items in currencies without a rate to BaseCurrency on their date are left
+// out of the totals and debts, their currencies are listed in MissingRates.
 type Analytics struct {
-	TotalSpent    float32           `json:"total_spent"`
-	Persons       []PersonAnalytics `json:"persons"`
-	TotalCalories float32           `json:"total_calories"`
-	TotalFats     float32           `json:"total_fats"`
-	TotalCarbs    float32           `json:"total_carbs"`
-	TotalProteins float32           `json:"total_preteins"`
+	BaseCurrency  string              `json:"base_currency" format:"currency"`
+	TotalSpent    int64               `json:"total_spent"`
+	Currencies    []CurrencyAnalytics `json:"currencies"`
+	MissingRates  []string            `json:"missing_rates"`
+	Persons       []PersonAnalytics   `json:"persons"`
+	TotalCalories float32             `json:"total_calories"`
+	TotalFats     float32             `json:"total_fats"`
+	TotalCarbs    float32             `json:"total_carbs"`
+	TotalProteins float32             `json:"total_preteins"`
 }
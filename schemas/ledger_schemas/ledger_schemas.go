@@ -0,0 +1,109 @@
+package ledger_schemas
+
+import (
+	"time"
+
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+)
+
+const (
+	// The user paid the person, what the user owes them goes down
+	PaymentTypeToPerson uint8 = iota + 1
+	// The person paid the user, what the person owes the user goes down
+	PaymentTypeFromPerson
+)
+
+var PaymentTypes = []uint8{PaymentTypeToPerson, PaymentTypeFromPerson}
+
+// PaymentDB settles debts between the user and a person, see ENTITIES.md.
+// Amount is in minor units of Currency.
+type PaymentDB struct {
+	PaymentID   uint      `json:"payment_id" format:"id"`
+	UserID      uint      `json:"user_id" format:"id"`
+	PersonID    uint      `json:"person_id" format:"id"`
+	PaymentDate time.Time `json:"payment_date"`
+	PaymentType uint8     `json:"payment_type" format:"payment_type"`
+	Amount      int64     `json:"amount" format:"payment_amount"`
+	Currency    string    `json:"currency" format:"currency"`
+	Note        string    `json:"note" format:"payment_note"`
+}
+
+type AddPayment struct {
+	UserID      uint      `json:"user_id" format:"id"`
+	PersonID    uint      `json:"person_id" format:"id"`
+	PaymentDate time.Time `json:"payment_date"`
+	PaymentType uint8     `json:"payment_type" format:"payment_type"`
+	Amount      int64     `json:"amount" format:"payment_amount"`
+	Currency    string    `json:"currency" format:"currency"`
+	Note        string    `json:"note" format:"payment_note" validate:"omitzero"`
+}
+
+type DeletePayment struct {
+	PaymentID uint `json:"payment_id" format:"id"`
+	UserID    uint `json:"user_id" format:"id"`
+}
+
+// GetPayments returns the payments of every person when PersonID is 0.
+type GetPayments struct {
+	UserID   uint `json:"user_id" format:"id"`
+	PersonID uint `json:"person_id" format:"id" validate:"omitzero"`
+}
+
+type GetStatement struct {
+	UserID   uint `json:"user_id" format:"id"`
+	PersonID uint `json:"person_id" format:"id"`
+	// BaseCurrency the balance is kept in
+	BaseCurrency string `json:"base_currency" format:"currency"`
+}
+
+type GetBalances struct {
+	UserID       uint   `json:"user_id" format:"id"`
+	BaseCurrency string `json:"base_currency" format:"currency"`
+}
+
+// LedgerEntry is an item bought by or for the person, the share of the person
+// in an item bought by the user, or a payment. Balances are positive when the
+// user owes the person and negative when the person owes the user.
+type LedgerEntry struct {
+	EntryDate time.Time `json:"entry_date"`
+	PersonID  uint      `json:"person_id" format:"id"`
+	// Either ItemID and ItemType or PaymentID and PaymentType are set
+	ItemID      uint  `json:"item_id"`
+	ItemType    uint8 `json:"item_type"`
+	PaymentID   uint  `json:"payment_id"`
+	PaymentType uint8 `json:"payment_type"`
+	// Title is the product of an item or the note of a payment
+	Title string `json:"title"`
+	// Paid in minor units of Currency, only the share for a split item
+	Paid     int64  `json:"paid"`
+	Currency string `json:"currency" format:"currency"`
+	// Change is what the entry adds to the balance in the base currency
+	Change int64 `json:"change"`
+	// Converted is false when there is no rate from Currency, the entry is
+	// then left out of the balance
+	Converted bool `json:"converted"`
+	// Balance after the entry
+	Balance int64 `json:"balance"`
+}
+
+// Statement is every entry of a person in the order they happened.
+type Statement struct {
+	PersonDB     user_schemas.PersonDB `json:"person_db"`
+	BaseCurrency string                `json:"base_currency" format:"currency"`
+	Entries      []LedgerEntry         `json:"entries"`
+	Balance      int64                 `json:"balance"`
+	// MissingRates are the currencies of entries left out
+	MissingRates []string `json:"missing_rates"`
+}
+
+type PersonBalance struct {
+	PersonDB user_schemas.PersonDB `json:"person_db"`
+	Balance  int64                 `json:"balance"`
+}
+
+// Balances are the all time balances of every person of the user.
+type Balances struct {
+	BaseCurrency string          `json:"base_currency" format:"currency"`
+	Persons      []PersonBalance `json:"persons"`
+	MissingRates []string        `json:"missing_rates"`
+}
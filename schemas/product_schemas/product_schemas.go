@@ -1,27 +1,151 @@
 package product_schemas
 
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Product types from ENTITIES.md. Nutrition is only kept for food.
+const (
+	ProductTypeFood uint8 = iota + 1
+	ProductTypeElectronics
+	ProductTypeOther
+)
+
+var ProductTypes = []uint8{ProductTypeFood, ProductTypeElectronics, ProductTypeOther}
+
+// Units of item amounts. The net quantity of a piece of a product is given in
+// one of the first two.
+const (
+	UnitGram uint8 = iota + 1
+	UnitMilliliter
+	UnitKilogram
+	UnitLiter
+	UnitPiece
+)
+
+var Units = []uint8{UnitGram, UnitKilogram, UnitMilliliter, UnitLiter, UnitPiece}
+
+var NetUnits = []uint8{UnitGram, UnitMilliliter}
+
+// Measure tells how much of a product there is in a piece and how much a
+// milliliter of it weighs, see services.UnitService.
+type Measure struct {
+	// NetQuantity of a piece in NetUnit
+	NetQuantity float32
+	NetUnit     uint8
+	// Density in grams per milliliter
+	Density float32
+}
+
+// DefaultMeasure is used for products added without one: a piece of 100 g
+// with the density of water. Items logged before units existed count in
+// such pieces, which keeps their nutrition as it was.
+var DefaultMeasure = Measure{NetQuantity: 100, NetUnit: UnitGram, Density: 1}
+
 type ProductDB struct {
 	ProductID       uint    `json:"product_id" format:"id"`
 	ProductTitle    string  `json:"product_title" format:"product_title"`
+	Manufacturer    string  `json:"manufacturer" format:"product_manufacturer"`
+	ProductType     uint8   `json:"product_type" format:"product_type"`
 	ProductCalories float32 `json:"product_calories" format:"product_calories"`
 	ProductFats     float32 `json:"product_fats" format:"product_nutrient"`
 	ProductCarbs    float32 `json:"product_carbs" format:"product_nutrient"`
 	ProductProteins float32 `json:"product_proteins" format:"product_nutrient"`
 	UserID          uint    `json:"user_id" format:"id"`
 	IsDeleted       bool    `json:"is_deleted"`
+	IsHidden        bool    `json:"is_hidden"`
+	CreatorName     string  `json:"creator_name"`
+	NetQuantity     float32 `json:"net_quantity" format:"product_net_quantity"`
+	NetUnit         uint8   `json:"net_unit" format:"product_net_unit"`
+	Density         float32 `json:"density" format:"product_density"`
+	// Cursor of the page after this product, set by GetProducts
+	Cursor string `json:"-"`
 }
 
+func (p ProductDB) IsFood() bool {
+	return p.ProductType == ProductTypeFood
+}
+
+func (p ProductDB) Measure() Measure {
+	return Measure{NetQuantity: p.NetQuantity, NetUnit: p.NetUnit, Density: p.Density}
+}
+
+// NormalizeTitle folds the spelling differences that do not make another
+// product: case, spaces, punctuation and decimal commas, so "Milk 3.2%" and
+// "milk 3,2 %" both become "milk3.2".
+func NormalizeTitle(title string) string {
+	runes := []rune(strings.ToLower(title))
+	var b strings.Builder
+	for i, r := range runes {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		case (r == '.' || r == ',') && i > 0 && i+1 < len(runes) &&
+			unicode.IsDigit(runes[i-1]) && unicode.IsDigit(runes[i+1]):
+			b.WriteRune('.')
+		}
+	}
+	return b.String()
+}
+
+// Fingerprint identifies identical products, only one product that is not
+// deleted can have it.
+func Fingerprint(p ProductDB) string {
+	return fmt.Sprintf("%s|%s|%d|%g|%g|%g|%g",
+		NormalizeTitle(p.ProductTitle),
+		NormalizeTitle(p.Manufacturer),
+		p.ProductType,
+		p.ProductCalories,
+		p.ProductFats,
+		p.ProductCarbs,
+		p.ProductProteins,
+	)
+}
+
+// AddProduct needs the nutrients only for food, other types are stored
+// without them.
 type AddProduct struct {
 	ProductTitle    string  `json:"product_title" format:"product_title"`
+	Manufacturer    string  `json:"manufacturer" format:"product_manufacturer" validate:"omitzero"`
+	ProductType     uint8   `json:"product_type" format:"product_type"`
 	ProductCalories float32 `json:"product_calories" format:"product_calories" validate:"omitzero"`
 	ProductFats     float32 `json:"product_fats" format:"product_nutrient" validate:"omitzero"`
 	ProductCarbs    float32 `json:"product_carbs" format:"product_nutrient" validate:"omitzero"`
 	ProductProteins float32 `json:"product_proteins" format:"product_nutrient" validate:"omitzero"`
-	UserID          uint    `json:"user_id" format:"id"`
+	// The measure can not be changed later either, zero values are taken from
+	// DefaultMeasure
+	NetQuantity float32 `json:"net_quantity" format:"product_net_quantity" validate:"omitzero"`
+	NetUnit     uint8   `json:"net_unit" format:"product_net_unit" validate:"omitzero"`
+	Density     float32 `json:"density" format:"product_density" validate:"omitzero"`
+	UserID      uint    `json:"user_id" format:"id"`
+	// Fingerprint is set by the service, see Fingerprint
+	Fingerprint string `json:"-"`
 }
 
+// Measure of the product, DefaultMeasure for the values not given.
+func (p AddProduct) Measure() Measure {
+	m := DefaultMeasure
+	if p.NetQuantity != 0 {
+		m.NetQuantity = p.NetQuantity
+	}
+	if p.NetUnit != 0 {
+		m.NetUnit = p.NetUnit
+	}
+	if p.Density != 0 {
+		m.Density = p.Density
+	}
+	return m
+}
+
+// GetProduct leaves out hidden products unless UserID, the caller, created
+// them or IncludeHidden is set for moderators.
 type GetProduct struct {
-	ProductID uint `json:"product_id" format:"id"`
+	ProductID     uint `json:"product_id" format:"id"`
+	UserID        uint `json:"user_id"`
+	IncludeHidden bool `json:"include_hidden"`
 }
 
 type DeleteProduct struct {
@@ -29,6 +153,101 @@ type DeleteProduct struct {
 	UserID    uint `json:"user_id" format:"id"`
 }
 
+// GetProducts leaves out hidden products unless IncludeHidden is set for
+// moderators. Products are sorted by SortField, by relevance when searching
+// or else by id, and Cursor continues after the last product of the previous
+// page. A Limit of 0 returns every product.
 type GetProducts struct {
-	SearchQuery string `json:"search_query"`
+	SearchQuery   string `json:"search_query"`
+	IncludeHidden bool   `json:"include_hidden"`
+	SortField     string `json:"sort_field" format:"product_sort" validate:"omitzero"`
+	SortDirection string `json:"sort_direction" format:"sort_direction" validate:"omitzero"`
+	Cursor        string `json:"cursor" format:"cursor" validate:"omitzero"`
+	Limit         uint   `json:"limit"`
+}
+
+type SetProductHidden struct {
+	ProductID uint `json:"product_id" format:"id"`
+	IsHidden  bool `json:"is_hidden"`
+}
+
+// ChangeProduct edits the fields of a product that ENTITIES.md allows to
+// change. Only the creator can change a product, and the type can not move
+// between food and the other types since the nutrients are fixed.
+type ChangeProduct struct {
+	ProductID    uint   `json:"product_id" format:"id"`
+	UserID       uint   `json:"user_id" format:"id"`
+	ProductTitle string `json:"product_title" format:"product_title"`
+	Manufacturer string `json:"manufacturer" format:"product_manufacturer" validate:"omitzero"`
+	ProductType  uint8  `json:"product_type" format:"product_type"`
+	// Fingerprint is set by the service, see Fingerprint
+	Fingerprint string `json:"-"`
+}
+
+// ProductRevision is a version of a product. Revisions are never changed,
+// every edit adds a new one.
+type ProductRevision struct {
+	RevisionID      uint      `json:"revision_id" format:"id"`
+	ProductID       uint      `json:"product_id" format:"id"`
+	RevisionNumber  uint      `json:"revision_number"`
+	ProductTitle    string    `json:"product_title" format:"product_title"`
+	Manufacturer    string    `json:"manufacturer" format:"product_manufacturer"`
+	ProductType     uint8     `json:"product_type" format:"product_type"`
+	ProductCalories float32   `json:"product_calories" format:"product_calories"`
+	ProductFats     float32   `json:"product_fats" format:"product_nutrient"`
+	ProductCarbs    float32   `json:"product_carbs" format:"product_nutrient"`
+	ProductProteins float32   `json:"product_proteins" format:"product_nutrient"`
+	UserID          uint      `json:"user_id" format:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// GetRevisionDiff hides products the way GetProduct does.
+type GetRevisionDiff struct {
+	ProductID     uint `json:"product_id" format:"id"`
+	UserID        uint `json:"user_id"`
+	IncludeHidden bool `json:"include_hidden"`
+	From          uint `json:"from"`
+	To            uint `json:"to"`
+}
+
+// FieldChange is a field that differs between two revisions, Field is its
+// json name.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type RevisionDiff struct {
+	From    ProductRevision `json:"from"`
+	To      ProductRevision `json:"to"`
+	Changes []FieldChange   `json:"changes"`
+}
+
+// DiffRevisions lists the product fields that differ between two revisions.
+func DiffRevisions(from ProductRevision, to ProductRevision) []FieldChange {
+	fields := []struct {
+		name          string
+		before, after any
+	}{
+		{"product_title", from.ProductTitle, to.ProductTitle},
+		{"manufacturer", from.Manufacturer, to.Manufacturer},
+		{"product_type", from.ProductType, to.ProductType},
+		{"product_calories", from.ProductCalories, to.ProductCalories},
+		{"product_fats", from.ProductFats, to.ProductFats},
+		{"product_carbs", from.ProductCarbs, to.ProductCarbs},
+		{"product_proteins", from.ProductProteins, to.ProductProteins},
+	}
+
+	changes := []FieldChange{}
+	for _, field := range fields {
+		if field.before != field.after {
+			changes = append(changes, FieldChange{
+				Field: field.name,
+				Old:   fmt.Sprint(field.before),
+				New:   fmt.Sprint(field.after),
+			})
+		}
+	}
+	return changes
 }
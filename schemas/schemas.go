@@ -31,8 +31,20 @@ type ConstRuleValues struct {
 	PasswordMaxLength       uint16
 	CodeLength              uint16
 	CodeRegex               string
+	ResetTokenLength        uint16
+	ResetTokenRegex         string
+	SecondFactorMinLength   uint16
+	SecondFactorMaxLength   uint16
+	SecondFactorRegex       string
+	APITokenNameMinLength   uint16
+	APITokenNameMaxLength   uint16
+	APITokenMaxDays         uint16
+	RoleRegex               string
 	ProductTitleMinLength   uint16
 	ProductTitleMaxLength   uint16
+	ProductManufacturerMax  uint16
+	ProductTypeMinValue     int16
+	ProductTypeMaxValue     int16
 	ProductCaloriesMinValue int16
 	ProductCaloriesMaxValue int16
 	ProductNutrientMinValue int16
@@ -41,6 +53,33 @@ type ConstRuleValues struct {
 	ItemCostMinValue        int16
 	ItemTypeMinValue        int16
 	ItemTypeMaxValue        int16
+	ItemUnitMinValue        int16
+	ItemUnitMaxValue        int16
+	PriceModeMinValue       int16
+	PriceModeMaxValue       int16
+	CurrencyRegex           string
+	RateMinValue            float64
+	RateMaxValue            float64
+	RatesCSVMaxLength       uint32
+	PaymentTypeMinValue     int16
+	PaymentTypeMaxValue     int16
+	PaymentAmountMinValue   int16
+	PaymentNoteMaxLength    uint16
+	ShareTypeMinValue       int16
+	ShareTypeMaxValue       int16
+	ShareValueMinValue      int16
+	ItemSplitsMaxCount      int
+	NetUnitMinValue         int16
+	NetUnitMaxValue         int16
+	NetQuantityMinValue     float32
+	NetQuantityMaxValue     float32
+	DensityMinValue         float32
+	DensityMaxValue         float32
+	ProductSortRegex        string
+	ItemSortRegex           string
+	SortDirectionRegex      string
+	CursorMaxLength         uint16
+	CursorRegex             string
 }
 
 var DefRV ConstRuleValues = ConstRuleValues{
@@ -51,8 +90,20 @@ var DefRV ConstRuleValues = ConstRuleValues{
 	PasswordMaxLength:       30,
 	CodeLength:              6,
 	CodeRegex:               "^[A-Z0-9]+$",
+	ResetTokenLength:        43,
+	ResetTokenRegex:         "^[A-Za-z0-9_-]+$",
+	SecondFactorMinLength:   6,
+	SecondFactorMaxLength:   16,
+	SecondFactorRegex:       "^[A-Za-z0-9 -]+$",
+	APITokenNameMinLength:   1,
+	APITokenNameMaxLength:   32,
+	APITokenMaxDays:         365,
+	RoleRegex:               "^(user|moderator|admin)$",
 	ProductTitleMinLength:   4,
 	ProductTitleMaxLength:   128,
+	ProductManufacturerMax:  64,
+	ProductTypeMinValue:     1,
+	ProductTypeMaxValue:     3,
 	ProductCaloriesMinValue: 0,
 	ProductCaloriesMaxValue: 1000,
 	ProductNutrientMinValue: 0,
@@ -61,6 +112,33 @@ var DefRV ConstRuleValues = ConstRuleValues{
 	ItemCostMinValue:        0,
 	ItemTypeMinValue:        1,
 	ItemTypeMaxValue:        3,
+	ItemUnitMinValue:        1,
+	ItemUnitMaxValue:        5,
+	PriceModeMinValue:       1,
+	PriceModeMaxValue:       3,
+	CurrencyRegex:           "^(RUB|USD|EUR|GBP|CNY)$",
+	RateMinValue:            0.000001,
+	RateMaxValue:            1000000,
+	RatesCSVMaxLength:       65536,
+	PaymentTypeMinValue:     1,
+	PaymentTypeMaxValue:     2,
+	PaymentAmountMinValue:   1,
+	PaymentNoteMaxLength:    64,
+	ShareTypeMinValue:       1,
+	ShareTypeMaxValue:       3,
+	ShareValueMinValue:      0,
+	ItemSplitsMaxCount:      16,
+	NetUnitMinValue:         1,
+	NetUnitMaxValue:         2,
+	NetQuantityMinValue:     0.1,
+	NetQuantityMaxValue:     100000,
+	DensityMinValue:         0.1,
+	DensityMaxValue:         25,
+	ProductSortRegex:        "^(product_title|manufacturer|product_type|product_calories|product_fats|product_carbs|product_proteins|creator_name)$",
+	ItemSortRegex:           "^(item_cost|item_amount|item_type|person_name|product_title|product_calories|product_fats|product_carbs|product_proteins)$",
+	SortDirectionRegex:      "^(asc|desc)$",
+	CursorMaxLength:         512,
+	CursorRegex:             "^[A-Za-z0-9_-]+$",
 }
 
 type RulesMap map[string]func(field reflect.Value, structField reflect.StructField, v string) error
@@ -83,8 +161,21 @@ var Formats map[string]string = map[string]string{
 		DefRV.PasswordMinLength, DefRV.PasswordMaxLength),
 	"code": fmt.Sprintf("min_length=%d,max_length=%d,regex=%s",
 		DefRV.CodeLength, DefRV.CodeLength, DefRV.CodeRegex),
+	"reset_token": fmt.Sprintf("min_length=%d,max_length=%d,regex=%s",
+		DefRV.ResetTokenLength, DefRV.ResetTokenLength, DefRV.ResetTokenRegex),
+	// A 6 digit TOTP code or a recovery code
+	"second_factor": fmt.Sprintf("min_length=%d,max_length=%d,regex=%s",
+		DefRV.SecondFactorMinLength, DefRV.SecondFactorMaxLength, DefRV.SecondFactorRegex),
+	"token_name": fmt.Sprintf("min_length=%d,max_length=%d",
+		DefRV.APITokenNameMinLength, DefRV.APITokenNameMaxLength),
+	"token_days": fmt.Sprintf("ge=1,le=%d", DefRV.APITokenMaxDays),
+	"role":       fmt.Sprintf("regex=%s", DefRV.RoleRegex),
 	"product_title": fmt.Sprintf("min_length=%d,max_length=%d",
 		DefRV.ProductTitleMinLength, DefRV.ProductTitleMaxLength),
+	"product_manufacturer": fmt.Sprintf("max_length=%d",
+		DefRV.ProductManufacturerMax),
+	"product_type": fmt.Sprintf("ge=%d,le=%d",
+		DefRV.ProductTypeMinValue, DefRV.ProductTypeMaxValue),
 	"product_calories": fmt.Sprintf("ge=%d,le=%d",
 		DefRV.ProductCaloriesMinValue, DefRV.ProductCaloriesMaxValue),
 	"product_nutrient": fmt.Sprintf("ge=%d,le=%d",
@@ -95,6 +186,32 @@ var Formats map[string]string = map[string]string{
 		DefRV.ItemAmountMinValue),
 	"item_type": fmt.Sprintf("ge=%d,le=%d",
 		DefRV.ItemTypeMinValue, DefRV.ItemTypeMaxValue),
+	"product_net_quantity": fmt.Sprintf("ge=%g,le=%g",
+		DefRV.NetQuantityMinValue, DefRV.NetQuantityMaxValue),
+	"product_net_unit": fmt.Sprintf("ge=%d,le=%d",
+		DefRV.NetUnitMinValue, DefRV.NetUnitMaxValue),
+	"product_density": fmt.Sprintf("ge=%g,le=%g",
+		DefRV.DensityMinValue, DefRV.DensityMaxValue),
+	"item_unit": fmt.Sprintf("ge=%d,le=%d",
+		DefRV.ItemUnitMinValue, DefRV.ItemUnitMaxValue),
+	"price_mode": fmt.Sprintf("ge=%d,le=%d",
+		DefRV.PriceModeMinValue, DefRV.PriceModeMaxValue),
+	"currency": fmt.Sprintf("regex=%s", DefRV.CurrencyRegex),
+	"currency_rate": fmt.Sprintf("ge=%g,le=%g",
+		DefRV.RateMinValue, DefRV.RateMaxValue),
+	"rates_csv":      fmt.Sprintf("min_length=1,max_length=%d", DefRV.RatesCSVMaxLength),
+	"product_sort":   fmt.Sprintf("regex=%s", DefRV.ProductSortRegex),
+	"item_sort":      fmt.Sprintf("regex=%s", DefRV.ItemSortRegex),
+	"sort_direction": fmt.Sprintf("regex=%s", DefRV.SortDirectionRegex),
+	"cursor": fmt.Sprintf("max_length=%d,regex=%s",
+		DefRV.CursorMaxLength, DefRV.CursorRegex),
+	"payment_type": fmt.Sprintf("ge=%d,le=%d",
+		DefRV.PaymentTypeMinValue, DefRV.PaymentTypeMaxValue),
+	"payment_amount": fmt.Sprintf("ge=%d", DefRV.PaymentAmountMinValue),
+	"payment_note":   fmt.Sprintf("max_length=%d", DefRV.PaymentNoteMaxLength),
+	"share_type": fmt.Sprintf("ge=%d,le=%d",
+		DefRV.ShareTypeMinValue, DefRV.ShareTypeMaxValue),
+	"share_value": fmt.Sprintf("ge=%d", DefRV.ShareValueMinValue),
 }
 
 func emailF(field reflect.Value, structField reflect.StructField, v string) error {
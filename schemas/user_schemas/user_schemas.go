@@ -1,16 +1,21 @@
 package user_schemas
 
 import (
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type UserPublic struct {
-	UserID    uint      `json:"user_id" format:"id"`
-	Username  string    `json:"username" format:"username"`
-	Email     string    `json:"email" format:"email"`
-	CreatedAt time.Time `json:"created_at,omitempty"`
+	UserID     uint      `json:"user_id" format:"id"`
+	Username   string    `json:"username" format:"username"`
+	Email      string    `json:"email" format:"email"`
+	Role       string    `json:"role" format:"role"`
+	IsDisabled bool      `json:"is_disabled"`
+	CreatedAt  time.Time `json:"created_at,omitempty"`
+	// BaseCurrency the analytics of the user are summed up in
+	BaseCurrency string `json:"base_currency" format:"currency"`
 }
 
 type UserSignin struct {
@@ -18,8 +23,46 @@ type UserSignin struct {
 }
 
 type UserConfirmSignin struct {
+	Email    string `json:"email" format:"email"`
+	Code     string `json:"code" format:"code"`
+	Username string `json:"username" format:"username"`
+	Password string `json:"password" format:"password"`
+}
+
+type PasswordResetRequest struct {
 	Email string `json:"email" format:"email"`
-	Code  string `json:"code" format:"code"`
+}
+
+type PasswordReset struct {
+	Token    string `json:"token" format:"reset_token"`
+	Password string `json:"password" format:"password"`
+}
+
+type UpdateUsername struct {
+	UserID   uint   `json:"user_id" format:"id"`
+	Username string `json:"username" format:"username"`
+}
+
+type SetBaseCurrency struct {
+	UserID       uint   `json:"user_id" format:"id"`
+	BaseCurrency string `json:"base_currency" format:"currency"`
+}
+
+type ChangePassword struct {
+	UserID          uint   `json:"user_id" format:"id"`
+	CurrentPassword string `json:"current_password" format:"password"`
+	NewPassword     string `json:"new_password" format:"password"`
+}
+
+type ChangeEmail struct {
+	UserID uint   `json:"user_id" format:"id"`
+	Email  string `json:"email" format:"email"`
+}
+
+type ConfirmChangeEmail struct {
+	UserID uint   `json:"user_id" format:"id"`
+	Email  string `json:"email" format:"email"`
+	Code   string `json:"code" format:"code"`
 }
 
 type UserGetByID struct {
@@ -32,11 +75,63 @@ type UserLogin struct {
 }
 
 type UserDB struct {
-	UserID    uint      `json:"user_id" format:"id"`
-	Username  string    `json:"username" format:"username"`
-	Email     string    `json:"email" format:"email"`
-	Password  string    `json:"password" format:"password"`
-	CreatedAt time.Time `json:"created_at,omitempty"`
+	UserID       uint      `json:"user_id" format:"id"`
+	Username     string    `json:"username" format:"username"`
+	Email        string    `json:"email" format:"email"`
+	PasswordHash string    `json:"-"`
+	Role         string    `json:"role" format:"role"`
+	IsDisabled   bool      `json:"is_disabled"`
+	CreatedAt    time.Time `json:"created_at,omitempty"`
+	BaseCurrency string    `json:"base_currency" format:"currency"`
+}
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles lists the roles from least to most privileged.
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+type Permission int
+
+const (
+	// PermManageUsers allows listing users with their emails, changing roles,
+	// disabling accounts and ending their sessions
+	PermManageUsers Permission = iota
+	// PermModerateProducts allows hiding and restoring products of others
+	PermModerateProducts
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleModerator: {PermModerateProducts},
+	RoleAdmin:     {PermManageUsers, PermModerateProducts},
+}
+
+// Can reports whether the role of the user grants permission. Disabled users
+// can do nothing.
+func (u UserDB) Can(permission Permission) bool {
+	if u.IsDisabled {
+		return false
+	}
+	return slices.Contains(rolePermissions[u.Role], permission)
+}
+
+type SetUserRole struct {
+	UserID uint   `json:"user_id" format:"id"`
+	Role   string `json:"role" format:"role"`
+}
+
+type SetUserDisabled struct {
+	UserID     uint `json:"user_id" format:"id"`
+	IsDisabled bool `json:"is_disabled"`
+}
+
+type AddUser struct {
+	Username     string `json:"username" format:"username"`
+	Email        string `json:"email" format:"email"`
+	PasswordHash string `json:"-"`
 }
 
 type GetUser struct {
@@ -45,14 +140,137 @@ type GetUser struct {
 }
 
 type SessionDB struct {
+	SessionID   uint      `json:"session_id" format:"id"`
 	SessionUUID uuid.UUID `json:"session_uuid"`
 	UserID      uint      `json:"user_id" format:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	UserAgent   string    `json:"user_agent"`
+	IP          string    `json:"ip"`
+	Pending2FA  bool      `json:"pending_2fa"`
+}
+
+type AddSession struct {
+	UserID     uint      `json:"user_id" format:"id"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Pending2FA bool      `json:"pending_2fa"`
+}
+
+// LoginResult is a full session, or a pending one when Pending2FA is set and
+// the second factor still has to be given to Login2FA.
+type LoginResult struct {
+	SessionUUID uuid.UUID `json:"session_uuid"`
+	Pending2FA  bool      `json:"pending_2fa"`
+}
+
+type Login2FA struct {
+	SessionUUID uuid.UUID `json:"session_uuid"`
+	Code        string    `json:"code" format:"second_factor"`
+}
+
+// SessionClient describes the device a session is created from.
+type SessionClient struct {
+	UserAgent string `json:"user_agent"`
+	IP        string `json:"ip"`
+}
+
+// SessionPublic leaves out the session UUID, which is the secret of the cookie.
+type SessionPublic struct {
+	SessionID  uint      `json:"session_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	IsCurrent  bool      `json:"is_current"`
+}
+
+type GetUserSession struct {
+	UserID    uint `json:"user_id" format:"id"`
+	SessionID uint `json:"session_id" format:"id"`
 }
 
 type GetSession struct {
 	SessionUUID uuid.UUID `json:"session_uuid"`
 }
 
+type TOTPDB struct {
+	UserID       uint   `json:"user_id" format:"id"`
+	Secret       string `json:"-"`
+	Enabled      bool   `json:"enabled"`
+	LastUsedStep int64  `json:"-"`
+}
+
+// TOTPEnrollment is shown once while two-factor authentication is set up.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TOTPStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft uint `json:"recovery_codes_left"`
+}
+
+// VerifyTOTP carries a TOTP code or, where accepted, a recovery code.
+type VerifyTOTP struct {
+	UserID uint   `json:"user_id" format:"id"`
+	Code   string `json:"code" format:"second_factor"`
+}
+
+// Scopes of personal API tokens. Sessions are not limited by scopes.
+const (
+	ScopeItemsRead     = "items:read"
+	ScopeItemsWrite    = "items:write"
+	ScopeProductsWrite = "products:write"
+)
+
+// APITokenScopes lists the scopes a token can be given.
+var APITokenScopes = []string{ScopeItemsRead, ScopeItemsWrite, ScopeProductsWrite}
+
+// APITokenDB leaves out the token hash. ExpiresAt is zero for tokens that do
+// not expire, LastUsedAt for tokens never used.
+type APITokenDB struct {
+	TokenID    uint      `json:"token_id" format:"id"`
+	UserID     uint      `json:"user_id" format:"id"`
+	Name       string    `json:"name" format:"token_name"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// AddAPIToken creates a token expiring after ExpiresInDays, or never when it
+// is zero.
+type AddAPIToken struct {
+	UserID        uint     `json:"user_id" format:"id"`
+	Name          string   `json:"name" format:"token_name"`
+	ExpiresInDays uint     `json:"expires_in_days" format:"token_days" validate:"omitzero"`
+	Scopes        []string `json:"scopes"`
+}
+
+type AddAPITokenDB struct {
+	UserID    uint      `json:"user_id" format:"id"`
+	Name      string    `json:"name" format:"token_name"`
+	TokenHash string    `json:"token_hash"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreatedAPIToken holds the token itself, which is only shown once.
+type CreatedAPIToken struct {
+	Token    string     `json:"token"`
+	APIToken APITokenDB `json:"api_token"`
+}
+
+type GetAPIToken struct {
+	UserID  uint `json:"user_id" format:"id"`
+	TokenID uint `json:"token_id" format:"id"`
+}
+
 type PersonDB struct {
 	PersonID   uint   `json:"person_id" format:"id"`
 	UserID     uint   `json:"user_id" format:"id"`
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/util"
+)
+
+// CreateAPIToken creates a personal access token. The token is only returned
+// here, the database keeps its hash.
+func (us *UserService) CreateAPIToken(ctx context.Context, data user_schemas.AddAPIToken) (user_schemas.CreatedAPIToken, error) {
+	if len(data.Scopes) == 0 {
+		return user_schemas.CreatedAPIToken{}, E.ErrUnprocessableEntity
+	}
+	for _, scope := range data.Scopes {
+		if !slices.Contains(user_schemas.APITokenScopes, scope) {
+			return user_schemas.CreatedAPIToken{}, E.ErrUnprocessableEntity
+		}
+	}
+	// Stored without duplicates in the order of APITokenScopes
+	scopes := []string{}
+	for _, scope := range user_schemas.APITokenScopes {
+		if slices.Contains(data.Scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	token, err := util.GenerateAPIToken()
+	if err != nil {
+		return user_schemas.CreatedAPIToken{}, E.ErrInternalServer
+	}
+	addToken := user_schemas.AddAPITokenDB{
+		UserID:    data.UserID,
+		Name:      data.Name,
+		TokenHash: util.HashToken(token),
+		Scopes:    scopes,
+	}
+	now := time.Now()
+	if data.ExpiresInDays > 0 {
+		addToken.ExpiresAt = now.AddDate(0, 0, int(data.ExpiresInDays))
+	}
+	tokenID, err := us.userDB.AddAPIToken(ctx, addToken)
+	if err != nil {
+		return user_schemas.CreatedAPIToken{}, err
+	}
+
+	return user_schemas.CreatedAPIToken{
+		Token: token,
+		APIToken: user_schemas.APITokenDB{
+			TokenID:   tokenID,
+			UserID:    data.UserID,
+			Name:      data.Name,
+			Scopes:    scopes,
+			CreatedAt: now,
+			ExpiresAt: addToken.ExpiresAt,
+		},
+	}, nil
+}
+
+func (us *UserService) GetAPITokens(ctx context.Context, userID uint) ([]user_schemas.APITokenDB, error) {
+	return us.userDB.GetUserAPITokens(ctx, userID)
+}
+
+func (us *UserService) RevokeAPIToken(ctx context.Context, tokenInfo user_schemas.GetAPIToken) error {
+	return us.userDB.DeleteAPIToken(ctx, tokenInfo)
+}
+
+// GetUserByAPIToken resolves an Authorization bearer token like
+// GetUserBySession resolves a cookie, and also returns the scopes of the
+// token. Unknown and expired tokens give E.ErrUnprocessableEntity.
+func (us *UserService) GetUserByAPIToken(ctx context.Context, token string) (user_schemas.UserDB, []string, error) {
+	if !strings.HasPrefix(token, util.APITokenPrefix) {
+		return user_schemas.UserDB{}, nil, E.ErrUnprocessableEntity
+	}
+	tokenDB, err := us.userDB.GetAPIToken(ctx, util.HashToken(token))
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			err = E.ErrUnprocessableEntity
+		}
+		return user_schemas.UserDB{}, nil, err
+	}
+
+	now := time.Now()
+	if !tokenDB.ExpiresAt.IsZero() && !now.Before(tokenDB.ExpiresAt) {
+		return user_schemas.UserDB{}, nil, E.ErrUnprocessableEntity
+	}
+	if now.Sub(tokenDB.LastUsedAt) >= sessionTouchInterval {
+		err = us.userDB.TouchAPIToken(ctx, tokenDB.TokenID, now)
+		if err != nil {
+			return user_schemas.UserDB{}, nil, err
+		}
+	}
+
+	userDB, err := us.userDB.GetUser(ctx, user_schemas.GetUser{UserID: tokenDB.UserID})
+	if err != nil {
+		return user_schemas.UserDB{}, nil, err
+	}
+	if userDB.IsDisabled {
+		return user_schemas.UserDB{}, nil, E.ErrUnprocessableEntity
+	}
+	return userDB, tokenDB.Scopes, nil
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/schemas"
+	"github.com/bmg-c/product-diary/schemas/currency_schemas"
+)
+
+func NewCurrencyService(currencyDB CurrencyDB, txDB TxDB) *CurrencyService {
+	return &CurrencyService{
+		currencyDB: currencyDB,
+		txDB:       txDB,
+	}
+}
+
+// CurrencyService keeps the exchange rates users enter themselves, there is
+// no live source of rates.
+type CurrencyService struct {
+	currencyDB CurrencyDB
+	txDB       TxDB
+}
+
+type CurrencyDB interface {
+	AddRate(ctx context.Context, data currency_schemas.AddRate) (currency_schemas.RateDB, error)
+	DeleteRate(ctx context.Context, data currency_schemas.DeleteRate) error
+	GetRates(ctx context.Context, data currency_schemas.GetRates) ([]currency_schemas.RateDB, error)
+}
+
+func (cs *CurrencyService) AddRate(ctx context.Context, data currency_schemas.AddRate) (currency_schemas.RateDB, error) {
+	if data.CurrencyFrom == data.CurrencyTo {
+		return currency_schemas.RateDB{}, E.ErrUnprocessableEntity
+	}
+	return cs.currencyDB.AddRate(ctx, data)
+}
+
+func (cs *CurrencyService) DeleteRate(ctx context.Context, data currency_schemas.DeleteRate) error {
+	err := cs.currencyDB.DeleteRate(ctx, data)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return E.ErrUnprocessableEntity
+		}
+		return err
+	}
+	return nil
+}
+
+func (cs *CurrencyService) GetRates(ctx context.Context, data currency_schemas.GetRates) ([]currency_schemas.RateDB, error) {
+	return cs.currencyDB.GetRates(ctx, data)
+}
+
+// ImportRates adds every line of the CSV or, when a line is not a rate,
+// nothing and returns E.ErrUnprocessableEntity with the line in the result.
+func (cs *CurrencyService) ImportRates(ctx context.Context, data currency_schemas.ImportRates) (currency_schemas.ImportRatesResult, error) {
+	reader := csv.NewReader(strings.NewReader(data.CSV))
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	rates := []currency_schemas.AddRate{}
+	for line := uint(1); ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		result := currency_schemas.ImportRatesResult{BadLine: line}
+		if err != nil {
+			return result, E.ErrUnprocessableEntity
+		}
+		rate, err := parseRate(record)
+		if err != nil {
+			if line == 1 {
+				// A header
+				continue
+			}
+			return result, E.ErrUnprocessableEntity
+		}
+		rate.UserID = data.UserID
+		ve := schemas.ValidateStruct(rate)
+		if ve != nil || rate.CurrencyFrom == rate.CurrencyTo {
+			return result, E.ErrUnprocessableEntity
+		}
+		rates = append(rates, rate)
+	}
+	if len(rates) == 0 {
+		return currency_schemas.ImportRatesResult{BadLine: 1}, E.ErrUnprocessableEntity
+	}
+
+	err := cs.txDB.WithTx(ctx, func(tx Tx) error {
+		for _, rate := range rates {
+			_, err := tx.CurrencyDB().AddRate(ctx, rate)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return currency_schemas.ImportRatesResult{}, err
+	}
+	return currency_schemas.ImportRatesResult{Imported: uint(len(rates))}, nil
+}
+
+func parseRate(record []string) (currency_schemas.AddRate, error) {
+	date, err := time.Parse("2006-01-02", record[0])
+	if err != nil {
+		return currency_schemas.AddRate{}, E.ErrUnprocessableEntity
+	}
+	rate, err := strconv.ParseFloat(strings.Replace(record[3], ",", ".", 1), 64)
+	if err != nil {
+		return currency_schemas.AddRate{}, E.ErrUnprocessableEntity
+	}
+	return currency_schemas.AddRate{
+		RateDate:     date,
+		CurrencyFrom: strings.ToUpper(record[1]),
+		CurrencyTo:   strings.ToUpper(record[2]),
+		Rate:         rate,
+	}, nil
+}
+
+type ratePair struct {
+	from string
+	to   string
+}
+
+// Rates converts money with the rates of a user. A rate of a pair converts
+// both ways.
+type Rates struct {
+	// Rates of every pair, the oldest first
+	pairs map[ratePair][]currency_schemas.RateDB
+}
+
+func NewRates(rates []currency_schemas.RateDB) Rates {
+	r := Rates{pairs: map[ratePair][]currency_schemas.RateDB{}}
+	for _, rateDB := range rates {
+		pair := ratePair{from: rateDB.CurrencyFrom, to: rateDB.CurrencyTo}
+		r.pairs[pair] = append(r.pairs[pair], rateDB)
+	}
+	for _, pairRates := range r.pairs {
+		sort.Slice(pairRates, func(i, j int) bool {
+			return pairRates[i].RateDate.Before(pairRates[j].RateDate)
+		})
+	}
+	return r
+}
+
+// Rate is the price of one from in to on date, which is the latest rate of
+// the pair on or before date. Returns false when there is none.
+func (r Rates) Rate(from string, to string, date time.Time) (float64, bool) {
+	if from == to {
+		return 1, true
+	}
+	direct, hasDirect := r.latest(ratePair{from: from, to: to}, date)
+	inverse, hasInverse := r.latest(ratePair{from: to, to: from}, date)
+	switch {
+	case hasDirect && (!hasInverse || !inverse.RateDate.After(direct.RateDate)):
+		return direct.Rate, true
+	case hasInverse:
+		return 1 / inverse.Rate, true
+	default:
+		return 0, false
+	}
+}
+
+func (r Rates) latest(pair ratePair, date time.Time) (currency_schemas.RateDB, bool) {
+	pairRates := r.pairs[pair]
+	i := sort.Search(len(pairRates), func(i int) bool {
+		return pairRates[i].RateDate.After(date)
+	})
+	if i == 0 {
+		return currency_schemas.RateDB{}, false
+	}
+	return pairRates[i-1], true
+}
+
+// Convert returns minor units of from as minor units of to, rounded. Every
+// currency of currency_schemas.Currencies has 100 minor units.
+func (r Rates) Convert(minor int64, from string, to string, date time.Time) (int64, bool) {
+	rate, ok := r.Rate(from, to, date)
+	if !ok {
+		return 0, false
+	}
+	return int64(math.Round(float64(minor) * rate)), true
+}
@@ -1,54 +1,74 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"math"
+	"slices"
 
 	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/schemas"
+	"github.com/bmg-c/product-diary/schemas/currency_schemas"
 	"github.com/bmg-c/product-diary/schemas/item_schemas"
 	"github.com/bmg-c/product-diary/schemas/user_schemas"
 )
 
-func NewItemService(itemDB ItemDB) *ItemService {
+func NewItemService(itemDB ItemDB, currencyDB CurrencyDB, txDB TxDB) *ItemService {
 	return &ItemService{
-		itemDB: itemDB,
+		itemDB:     itemDB,
+		currencyDB: currencyDB,
+		txDB:       txDB,
+		units:      NewUnitService(),
 	}
 }
 
 type ItemService struct {
-	itemDB ItemDB
+	itemDB     ItemDB
+	currencyDB CurrencyDB
+	txDB       TxDB
+	units      *UnitService
 }
 
 type ItemDB interface {
-	AddItem(data item_schemas.AddItem) (item_schemas.ItemDB, error)
-	DeleteItem(data item_schemas.DeleteItem) error
-	GetItem(data item_schemas.GetItem) (item_schemas.ItemParsed, error)
-	GetItems(data item_schemas.GetItems) ([]item_schemas.ItemParsed, error)
-	ChangeItem(data item_schemas.ChangeItem) (item_schemas.ItemDB, error)
-	GetItemsRange(data item_schemas.GetItemsRange) ([]item_schemas.ItemParsed, error)
+	AddItem(ctx context.Context, data item_schemas.AddItem) (item_schemas.ItemDB, error)
+	DeleteItem(ctx context.Context, data item_schemas.DeleteItem) error
+	GetItem(ctx context.Context, data item_schemas.GetItem) (item_schemas.ItemParsed, error)
+	GetItems(ctx context.Context, data item_schemas.GetItems) ([]item_schemas.ItemParsed, error)
+	ChangeItem(ctx context.Context, data item_schemas.ChangeItem) (item_schemas.ItemDB, error)
+	GetItemsRange(ctx context.Context, data item_schemas.GetItemsRange) ([]item_schemas.ItemParsed, error)
+	GetPersonItems(ctx context.Context, data item_schemas.GetPersonItems) ([]item_schemas.ItemParsed, error)
+	SetItemSplits(ctx context.Context, data item_schemas.SetItemSplits) error
 }
 
-func (is *ItemService) AddItem(data item_schemas.AddItem) (item_schemas.ItemParsed, error) {
-	itemDB, err := is.itemDB.AddItem(data)
-	if err != nil {
-		return item_schemas.ItemParsed{}, err
-	}
+func (is *ItemService) AddItem(ctx context.Context, data item_schemas.AddItem) (item_schemas.ItemParsed, error) {
+	var itemParsed item_schemas.ItemParsed
+	err := is.txDB.WithTx(ctx, func(tx Tx) error {
+		itemDB, err := tx.ItemDB().AddItem(ctx, data)
+		if err != nil {
+			return err
+		}
 
-	getItem := item_schemas.GetItem{
-		ItemID: itemDB.ItemID,
-		UserID: itemDB.UserID,
-	}
-	itemParsed, err := is.itemDB.GetItem(getItem)
-	if err != nil {
-		if errors.Is(err, E.ErrNotFound) {
-			return item_schemas.ItemParsed{}, E.ErrInternalServer
+		getItem := item_schemas.GetItem{
+			ItemID: itemDB.ItemID,
+			UserID: itemDB.UserID,
 		}
+		itemParsed, err = tx.ItemDB().GetItem(ctx, getItem)
+		if err != nil {
+			if errors.Is(err, E.ErrNotFound) {
+				return E.ErrInternalServer
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
 		return item_schemas.ItemParsed{}, err
 	}
 	return itemParsed, nil
 }
 
-func (is *ItemService) DeleteItem(data item_schemas.DeleteItem) error {
-	err := is.itemDB.DeleteItem(data)
+func (is *ItemService) DeleteItem(ctx context.Context, data item_schemas.DeleteItem) error {
+	err := is.itemDB.DeleteItem(ctx, data)
 	if err != nil {
 		if errors.Is(err, E.ErrNotFound) {
 			return E.ErrUnprocessableEntity
@@ -58,8 +78,8 @@ func (is *ItemService) DeleteItem(data item_schemas.DeleteItem) error {
 	return nil
 }
 
-func (is *ItemService) GetItems(data item_schemas.GetItems) ([]item_schemas.ItemParsed, error) {
-	items, err := is.itemDB.GetItems(data)
+func (is *ItemService) GetItems(ctx context.Context, data item_schemas.GetItems) ([]item_schemas.ItemParsed, error) {
+	items, err := is.itemDB.GetItems(ctx, data)
 	if err != nil {
 		return []item_schemas.ItemParsed{}, err
 	}
@@ -67,107 +87,203 @@ func (is *ItemService) GetItems(data item_schemas.GetItems) ([]item_schemas.Item
 	return items, nil
 }
 
-func (is *ItemService) ChangeItem(data item_schemas.ChangeItem) (item_schemas.ItemParsed, error) {
-	itemDB, err := is.itemDB.ChangeItem(data)
-	if err != nil {
-		if errors.Is(err, E.ErrNotFound) {
-			return item_schemas.ItemParsed{}, E.ErrUnprocessableEntity
+func (is *ItemService) ChangeItem(ctx context.Context, data item_schemas.ChangeItem) (item_schemas.ItemParsed, error) {
+	var itemParsed item_schemas.ItemParsed
+	err := is.txDB.WithTx(ctx, func(tx Tx) error {
+		itemDB, err := tx.ItemDB().ChangeItem(ctx, data)
+		if err != nil {
+			if errors.Is(err, E.ErrNotFound) {
+				return E.ErrUnprocessableEntity
+			}
+			return err
 		}
+
+		getItem := item_schemas.GetItem{
+			ItemID: itemDB.ItemID,
+			UserID: itemDB.UserID,
+		}
+		itemParsed, err = tx.ItemDB().GetItem(ctx, getItem)
+		if err != nil {
+			if errors.Is(err, E.ErrNotFound) {
+				return E.ErrInternalServer
+			}
+			return err
+		}
+		// A new cost or type may leave the splits not adding up
+		return is.checkSplits(itemParsed)
+	})
+	if err != nil {
 		return item_schemas.ItemParsed{}, err
 	}
+	return itemParsed, nil
+}
 
-	getItem := item_schemas.GetItem{
-		ItemID: itemDB.ItemID,
-		UserID: itemDB.UserID,
+// SetItemSplits returns E.ErrUnprocessableEntity when the user has no such
+// item, a person is not one of theirs or the shares do not add up, see
+// checkSplits.
+func (is *ItemService) SetItemSplits(ctx context.Context, data item_schemas.SetItemSplits) (item_schemas.ItemParsed, error) {
+	if len(data.Splits) > schemas.DefRV.ItemSplitsMaxCount {
+		return item_schemas.ItemParsed{}, E.ErrUnprocessableEntity
 	}
-	itemParsed, err := is.itemDB.GetItem(getItem)
-	if err != nil {
-		if errors.Is(err, E.ErrNotFound) {
-			return item_schemas.ItemParsed{}, E.ErrInternalServer
+	var itemParsed item_schemas.ItemParsed
+	err := is.txDB.WithTx(ctx, func(tx Tx) error {
+		err := tx.ItemDB().SetItemSplits(ctx, data)
+		if err != nil {
+			if errors.Is(err, E.ErrNotFound) {
+				return E.ErrUnprocessableEntity
+			}
+			return err
+		}
+
+		getItem := item_schemas.GetItem{
+			ItemID: data.ItemID,
+			UserID: data.UserID,
 		}
+		itemParsed, err = tx.ItemDB().GetItem(ctx, getItem)
+		if err != nil {
+			if errors.Is(err, E.ErrNotFound) {
+				return E.ErrUnprocessableEntity
+			}
+			return err
+		}
+		return is.checkSplits(itemParsed)
+	})
+	if err != nil {
 		return item_schemas.ItemParsed{}, err
 	}
 	return itemParsed, nil
 }
 
-func (is *ItemService) GetAnalyticsRange(data item_schemas.GetItemsRange) (item_schemas.Analytics, error) {
-	items, err := is.itemDB.GetItemsRange(data)
+// checkSplits returns E.ErrUnprocessableEntity when an item split between
+// persons is not a purchase of the user or its shares do not add up.
+func (is *ItemService) checkSplits(i item_schemas.ItemParsed) error {
+	if len(i.Splits) == 0 {
+		return nil
+	}
+	if i.ItemType != item_schemas.ItemTypeMyPurchase {
+		return E.ErrUnprocessableEntity
+	}
+	paid, err := is.ItemTotal(i)
+	if err != nil {
+		return err
+	}
+	_, err = splitShares(paid, i.Splits)
+	return err
+}
+
+func (is *ItemService) GetAnalyticsRange(ctx context.Context, data item_schemas.GetItemsRange) (item_schemas.Analytics, error) {
+	items, err := is.itemDB.GetItemsRange(ctx, data)
 	if err != nil {
 		return item_schemas.Analytics{}, err
 	}
+	return is.GetAnalytics(ctx, data.UserID, data.BaseCurrency, items)
+}
 
-	a := item_schemas.Analytics{
-		TotalSpent:    0,
-		TotalCalories: 0,
-		TotalFats:     0,
-		TotalCarbs:    0,
-		TotalProteins: 0,
-		Persons:       []item_schemas.PersonAnalytics{},
+// ItemTotal returns what was paid for an item in minor units, as told by its
+// price mode.
+func (is *ItemService) ItemTotal(i item_schemas.ItemParsed) (int64, error) {
+	return itemTotal(is.units, i)
+}
+
+func itemTotal(units *UnitService, i item_schemas.ItemParsed) (int64, error) {
+	switch i.PriceMode {
+	case item_schemas.PriceModeUnit:
+		return int64(math.Round(float64(i.ItemCost) * float64(i.ItemAmount))), nil
+	case item_schemas.PriceModeKilogram:
+		grams, err := units.ToGrams(i.ItemAmount, i.ItemUnit, i.ProductMeasure)
+		if err != nil {
+			return 0, err
+		}
+		return int64(math.Round(float64(i.ItemCost) * float64(grams) / 1000)), nil
+	case item_schemas.PriceModeTotal:
+		return i.ItemCost, nil
+	default:
+		return 0, E.ErrUnprocessableEntity
 	}
-	for _, i := range items {
-		switch i.ItemType {
-		case item_schemas.ItemTypeMyPurchase:
-			a.TotalSpent += i.ItemCost * i.ItemAmount
-			a.TotalCalories += float32(i.ProductCalories) * i.ItemAmount
-			a.TotalFats += float32(i.ProductFats) * i.ItemAmount
-			a.TotalCarbs += float32(i.ProductCarbs) * i.ItemAmount
-			a.TotalProteins += float32(i.ProductProteins) * i.ItemAmount
-		case item_schemas.ItemTypeFromPersonPurchase:
-			a.TotalSpent += i.ItemCost * i.ItemAmount
-			a.TotalCalories += float32(i.ProductCalories) * i.ItemAmount
-			a.TotalFats += float32(i.ProductFats) * i.ItemAmount
-			a.TotalCarbs += float32(i.ProductCarbs) * i.ItemAmount
-			a.TotalProteins += float32(i.ProductProteins) * i.ItemAmount
-			personInd := -1
-			for ind, personDB := range a.Persons {
-				if personDB.PersonDB.PersonID == i.PersonID {
-					personInd = ind
-					break
-				}
-			}
-			if personInd != -1 {
-				a.Persons[personInd].TotalDebt += i.ItemCost * i.ItemAmount
-			} else {
-				a.Persons = append(a.Persons, item_schemas.PersonAnalytics{
-					PersonDB: user_schemas.PersonDB{
-						PersonID:   i.PersonID,
-						UserID:     i.UserID,
-						PersonName: i.PersonName,
-					},
-					TotalDebt: i.ItemCost * i.ItemAmount,
-				})
-			}
-		case item_schemas.ItemTypeToPersonPurchase:
-			personInd := -1
-			for ind, personDB := range a.Persons {
-				if personDB.PersonDB.PersonID == i.PersonID {
-					personInd = ind
-					break
-				}
-			}
-			if personInd != -1 {
-				a.Persons[personInd].TotalDebt -= i.ItemCost * i.ItemAmount
-			} else {
-				a.Persons = append(a.Persons, item_schemas.PersonAnalytics{
-					PersonDB: user_schemas.PersonDB{
-						PersonID:   i.PersonID,
-						UserID:     i.UserID,
-						PersonName: i.PersonName,
-					},
-					TotalDebt: -(i.ItemCost * i.ItemAmount),
-				})
-			}
+}
+
+// splitShares returns the share of every split of total, in the order of
+// splits. Percentages are rounded down and what is left goes to the equal
+// splits, a minor unit more to the first ones. Without equal splits the
+// amounts and percentages have to make up the total exactly and the rounding
+// goes to the first percentages. E.ErrUnprocessableEntity when the shares do
+// not add up.
+func splitShares(total int64, splits []item_schemas.SplitDB) ([]int64, error) {
+	if len(splits) == 0 {
+		return []int64{}, nil
+	}
+	shares := make([]int64, len(splits))
+	var amounts, percents int64
+	percentInds, equalInds := []int{}, []int{}
+	for ind, splitDB := range splits {
+		switch splitDB.ShareType {
+		case item_schemas.ShareTypePercent:
+			shares[ind] = total * splitDB.ShareValue / 10000
+			percents += splitDB.ShareValue
+			percentInds = append(percentInds, ind)
+		case item_schemas.ShareTypeAmount:
+			shares[ind] = splitDB.ShareValue
+			amounts += splitDB.ShareValue
+		case item_schemas.ShareTypeEqual:
+			equalInds = append(equalInds, ind)
 		default:
-			// Error in db values?
-			return item_schemas.Analytics{}, E.ErrInternalServer
+			return nil, E.ErrUnprocessableEntity
 		}
 	}
-	return a, nil
+	// Percentages are in hundredths, so everything is compared times 10000
+	if percents > 10000 || amounts*10000+total*percents > total*10000 {
+		return nil, E.ErrUnprocessableEntity
+	}
+	rest := total
+	for _, share := range shares {
+		rest -= share
+	}
+	restInds := equalInds
+	if len(equalInds) == 0 {
+		if amounts*10000+total*percents != total*10000 {
+			return nil, E.ErrUnprocessableEntity
+		}
+		restInds = percentInds
+	}
+	if len(restInds) == 0 {
+		return shares, nil
+	}
+	count := int64(len(restInds))
+	for k, ind := range restInds {
+		shares[ind] += rest / count
+		if int64(k) < rest%count {
+			shares[ind]++
+		}
+	}
+	return shares, nil
+}
+
+// splitPart is the part of an item a share of it stands for, the splits of an
+// item that cost nothing get equal parts.
+func splitPart(total int64, share int64, count int) float32 {
+	if total == 0 {
+		return 1 / float32(count)
+	}
+	return float32(share) / float32(total)
 }
 
-func (is *ItemService) GetAnalytics(data []item_schemas.ItemParsed) (item_schemas.Analytics, error) {
+// GetAnalytics sums up the items. Nutrients are given per 100 g, so amounts
+// are converted to grams first, see UnitService. Money is summed up from the
+// totals of ItemTotal, converted to baseCurrency with the rates of the user
+// on the date of every item, see Rates. Of a split item only the share of the
+// user counts, the shares of the persons are owed to the user.
+func (is *ItemService) GetAnalytics(ctx context.Context, userID uint, baseCurrency string, data []item_schemas.ItemParsed) (item_schemas.Analytics, error) {
+	rates, err := is.currencyDB.GetRates(ctx, currency_schemas.GetRates{UserID: userID})
+	if err != nil {
+		return item_schemas.Analytics{}, err
+	}
+	r := NewRates(rates)
+
 	a := item_schemas.Analytics{
+		BaseCurrency:  baseCurrency,
 		TotalSpent:    0,
+		Currencies:    []item_schemas.CurrencyAnalytics{},
+		MissingRates:  []string{},
 		TotalCalories: 0,
 		TotalFats:     0,
 		TotalCarbs:    0,
@@ -175,58 +291,64 @@ func (is *ItemService) GetAnalytics(data []item_schemas.ItemParsed) (item_schema
 		Persons:       []item_schemas.PersonAnalytics{},
 	}
 	for _, i := range data {
+		portion, err := is.units.Portion(i)
+		if err != nil {
+			// The database only keeps units that convert
+			return item_schemas.Analytics{}, E.ErrInternalServer
+		}
+		paid, err := is.ItemTotal(i)
+		if err != nil {
+			return item_schemas.Analytics{}, E.ErrInternalServer
+		}
+		total, ok := r.Convert(paid, i.Currency, baseCurrency, i.ItemDate)
+		if !ok && !slices.Contains(a.MissingRates, i.Currency) {
+			a.MissingRates = append(a.MissingRates, i.Currency)
+		}
 		switch i.ItemType {
 		case item_schemas.ItemTypeMyPurchase:
-			a.TotalSpent += i.ItemCost * i.ItemAmount
-			a.TotalCalories += float32(i.ProductCalories) * i.ItemAmount
-			a.TotalFats += float32(i.ProductFats) * i.ItemAmount
-			a.TotalCarbs += float32(i.ProductCarbs) * i.ItemAmount
-			a.TotalProteins += float32(i.ProductProteins) * i.ItemAmount
-		case item_schemas.ItemTypeFromPersonPurchase:
-			a.TotalSpent += i.ItemCost * i.ItemAmount
-			a.TotalCalories += float32(i.ProductCalories) * i.ItemAmount
-			a.TotalFats += float32(i.ProductFats) * i.ItemAmount
-			a.TotalCarbs += float32(i.ProductCarbs) * i.ItemAmount
-			a.TotalProteins += float32(i.ProductProteins) * i.ItemAmount
-			personInd := -1
-			for ind, personDB := range a.Persons {
-				if personDB.PersonDB.PersonID == i.PersonID {
-					personInd = ind
-					break
-				}
+			if len(i.Splits) == 0 {
+				a.TotalSpent += total
+				addCurrencySpent(&a, i.Currency, paid)
+				addNutrients(&a, i, portion)
+				break
 			}
-			if personInd != -1 {
-				a.Persons[personInd].TotalDebt += i.ItemCost * i.ItemAmount
-			} else {
-				a.Persons = append(a.Persons, item_schemas.PersonAnalytics{
-					PersonDB: user_schemas.PersonDB{
-						PersonID:   i.PersonID,
-						UserID:     i.UserID,
-						PersonName: i.PersonName,
-					},
-					TotalDebt: i.ItemCost * i.ItemAmount,
-				})
+			shares, err := splitShares(paid, i.Splits)
+			if err != nil {
+				// Splits are checked when they are set
+				return item_schemas.Analytics{}, E.ErrInternalServer
 			}
-		case item_schemas.ItemTypeToPersonPurchase:
-			personInd := -1
-			for ind, personDB := range a.Persons {
-				if personDB.PersonDB.PersonID == i.PersonID {
-					personInd = ind
-					break
+			for ind, splitDB := range i.Splits {
+				share, ok := r.Convert(shares[ind], i.Currency, baseCurrency, i.ItemDate)
+				if !ok && !slices.Contains(a.MissingRates, i.Currency) {
+					a.MissingRates = append(a.MissingRates, i.Currency)
 				}
+				if splitDB.PersonID == 0 {
+					a.TotalSpent += share
+					addCurrencySpent(&a, i.Currency, shares[ind])
+					addNutrients(&a, i, portion*splitPart(paid, shares[ind], len(i.Splits)))
+					continue
+				}
+				addPersonDebt(&a, user_schemas.PersonDB{
+					PersonID:   splitDB.PersonID,
+					UserID:     i.UserID,
+					PersonName: splitDB.PersonName,
+				}, -share)
 			}
-			if personInd != -1 {
-				a.Persons[personInd].TotalDebt -= i.ItemCost * i.ItemAmount
-			} else {
-				a.Persons = append(a.Persons, item_schemas.PersonAnalytics{
-					PersonDB: user_schemas.PersonDB{
-						PersonID:   i.PersonID,
-						UserID:     i.UserID,
-						PersonName: i.PersonName,
-					},
-					TotalDebt: -(i.ItemCost * i.ItemAmount),
-				})
-			}
+		case item_schemas.ItemTypeFromPersonPurchase:
+			a.TotalSpent += total
+			addCurrencySpent(&a, i.Currency, paid)
+			addNutrients(&a, i, portion)
+			addPersonDebt(&a, user_schemas.PersonDB{
+				PersonID:   i.PersonID,
+				UserID:     i.UserID,
+				PersonName: i.PersonName,
+			}, total)
+		case item_schemas.ItemTypeToPersonPurchase:
+			addPersonDebt(&a, user_schemas.PersonDB{
+				PersonID:   i.PersonID,
+				UserID:     i.UserID,
+				PersonName: i.PersonName,
+			}, -total)
 		default:
 			// Error in db values?
 			return item_schemas.Analytics{}, E.ErrInternalServer
@@ -234,3 +356,32 @@ func (is *ItemService) GetAnalytics(data []item_schemas.ItemParsed) (item_schema
 	}
 	return a, nil
 }
+
+func addCurrencySpent(a *item_schemas.Analytics, currency string, paid int64) {
+	for ind := range a.Currencies {
+		if a.Currencies[ind].Currency == currency {
+			a.Currencies[ind].TotalSpent += paid
+			return
+		}
+	}
+	a.Currencies = append(a.Currencies, item_schemas.CurrencyAnalytics{Currency: currency, TotalSpent: paid})
+}
+
+func addNutrients(a *item_schemas.Analytics, i item_schemas.ItemParsed, portion float32) {
+	a.TotalCalories += i.ProductCalories * portion
+	a.TotalFats += i.ProductFats * portion
+	a.TotalCarbs += i.ProductCarbs * portion
+	a.TotalProteins += i.ProductProteins * portion
+}
+
+// addPersonDebt adds to what the user owes the person, a negative debt is
+// owed to the user.
+func addPersonDebt(a *item_schemas.Analytics, personDB user_schemas.PersonDB, debt int64) {
+	for ind := range a.Persons {
+		if a.Persons[ind].PersonDB.PersonID == personDB.PersonID {
+			a.Persons[ind].TotalDebt += debt
+			return
+		}
+	}
+	a.Persons = append(a.Persons, item_schemas.PersonAnalytics{PersonDB: personDB, TotalDebt: debt})
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/schemas/currency_schemas"
+	"github.com/bmg-c/product-diary/schemas/item_schemas"
+	"github.com/bmg-c/product-diary/schemas/ledger_schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+)
+
+func NewLedgerService(ledgerDB LedgerDB, itemDB ItemDB, currencyDB CurrencyDB, userDB UserDB) *LedgerService {
+	return &LedgerService{
+		ledgerDB:   ledgerDB,
+		itemDB:     itemDB,
+		currencyDB: currencyDB,
+		userDB:     userDB,
+		units:      NewUnitService(),
+	}
+}
+
+// LedgerService keeps the all time balances between the user and their
+// persons. Items bought by or for a person and shares of split items move the
+// balance and payments settle it, see ledger_schemas.LedgerEntry for the
+// sign.
+type LedgerService struct {
+	ledgerDB   LedgerDB
+	itemDB     ItemDB
+	currencyDB CurrencyDB
+	userDB     UserDB
+	units      *UnitService
+}
+
+type LedgerDB interface {
+	AddPayment(ctx context.Context, data ledger_schemas.AddPayment) (ledger_schemas.PaymentDB, error)
+	DeletePayment(ctx context.Context, data ledger_schemas.DeletePayment) error
+	GetPayments(ctx context.Context, data ledger_schemas.GetPayments) ([]ledger_schemas.PaymentDB, error)
+}
+
+// AddPayment returns E.ErrUnprocessableEntity when the person is not one of
+// the user.
+func (ls *LedgerService) AddPayment(ctx context.Context, data ledger_schemas.AddPayment) (ledger_schemas.PaymentDB, error) {
+	paymentDB, err := ls.ledgerDB.AddPayment(ctx, data)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return ledger_schemas.PaymentDB{}, E.ErrUnprocessableEntity
+		}
+		return ledger_schemas.PaymentDB{}, err
+	}
+	return paymentDB, nil
+}
+
+func (ls *LedgerService) DeletePayment(ctx context.Context, data ledger_schemas.DeletePayment) error {
+	err := ls.ledgerDB.DeletePayment(ctx, data)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return E.ErrUnprocessableEntity
+		}
+		return err
+	}
+	return nil
+}
+
+// GetStatement returns every entry of the person with the balance after it,
+// E.ErrNotFound when the person is not one of the user.
+func (ls *LedgerService) GetStatement(ctx context.Context, data ledger_schemas.GetStatement) (ledger_schemas.Statement, error) {
+	persons, err := ls.userDB.GetUserPersons(ctx, user_schemas.GetUser{UserID: data.UserID})
+	if err != nil {
+		return ledger_schemas.Statement{}, err
+	}
+	ind := slices.IndexFunc(persons, func(personDB user_schemas.PersonDB) bool {
+		return personDB.PersonID == data.PersonID
+	})
+	if ind == -1 {
+		return ledger_schemas.Statement{}, E.ErrNotFound
+	}
+
+	entries, missingRates, err := ls.entries(ctx, data.UserID, data.PersonID, data.BaseCurrency)
+	if err != nil {
+		return ledger_schemas.Statement{}, err
+	}
+	statement := ledger_schemas.Statement{
+		PersonDB:     persons[ind],
+		BaseCurrency: data.BaseCurrency,
+		Entries:      entries,
+		Balance:      0,
+		MissingRates: missingRates,
+	}
+	for i := range statement.Entries {
+		statement.Balance += statement.Entries[i].Change
+		statement.Entries[i].Balance = statement.Balance
+	}
+	return statement, nil
+}
+
+// GetBalances returns the balance of every person of the user, zero for
+// persons without entries.
+func (ls *LedgerService) GetBalances(ctx context.Context, data ledger_schemas.GetBalances) (ledger_schemas.Balances, error) {
+	persons, err := ls.userDB.GetUserPersons(ctx, user_schemas.GetUser{UserID: data.UserID})
+	if err != nil {
+		return ledger_schemas.Balances{}, err
+	}
+	entries, missingRates, err := ls.entries(ctx, data.UserID, 0, data.BaseCurrency)
+	if err != nil {
+		return ledger_schemas.Balances{}, err
+	}
+
+	balances := ledger_schemas.Balances{
+		BaseCurrency: data.BaseCurrency,
+		Persons:      []ledger_schemas.PersonBalance{},
+		MissingRates: missingRates,
+	}
+	for _, personDB := range persons {
+		personBalance := ledger_schemas.PersonBalance{PersonDB: personDB}
+		for _, entry := range entries {
+			if entry.PersonID == personDB.PersonID {
+				personBalance.Balance += entry.Change
+			}
+		}
+		balances.Persons = append(balances.Persons, personBalance)
+	}
+	return balances, nil
+}
+
+// entries merges the items and payments of a person, or of every person when
+// personID is 0, by date. On the same date items go before payments. Entries
+// are converted to baseCurrency with the rates of the user on their date.
+func (ls *LedgerService) entries(ctx context.Context, userID uint, personID uint, baseCurrency string) ([]ledger_schemas.LedgerEntry, []string, error) {
+	items, err := ls.itemDB.GetPersonItems(ctx, item_schemas.GetPersonItems{UserID: userID, PersonID: personID})
+	if err != nil {
+		return nil, nil, err
+	}
+	payments, err := ls.ledgerDB.GetPayments(ctx, ledger_schemas.GetPayments{UserID: userID, PersonID: personID})
+	if err != nil {
+		return nil, nil, err
+	}
+	rates, err := ls.currencyDB.GetRates(ctx, currency_schemas.GetRates{UserID: userID})
+	if err != nil {
+		return nil, nil, err
+	}
+	r := NewRates(rates)
+
+	entries := []ledger_schemas.LedgerEntry{}
+	missingRates := []string{}
+	add := func(entry ledger_schemas.LedgerEntry, sign int64) {
+		converted, ok := r.Convert(entry.Paid, entry.Currency, baseCurrency, entry.EntryDate)
+		if !ok && !slices.Contains(missingRates, entry.Currency) {
+			missingRates = append(missingRates, entry.Currency)
+		}
+		entry.Change = sign * converted
+		entry.Converted = ok
+		entries = append(entries, entry)
+	}
+	for _, i := range items {
+		paid, err := itemTotal(ls.units, i)
+		if err != nil {
+			return nil, nil, E.ErrInternalServer
+		}
+		var sign int64
+		switch i.ItemType {
+		case item_schemas.ItemTypeFromPersonPurchase:
+			sign = 1
+		case item_schemas.ItemTypeToPersonPurchase:
+			sign = -1
+		default:
+			// Bought by the user, the persons it is split with owe their
+			// shares
+			shares, err := splitShares(paid, i.Splits)
+			if err != nil {
+				return nil, nil, E.ErrInternalServer
+			}
+			for ind, splitDB := range i.Splits {
+				if splitDB.PersonID == 0 || (personID != 0 && splitDB.PersonID != personID) {
+					continue
+				}
+				add(ledger_schemas.LedgerEntry{
+					EntryDate: i.ItemDate,
+					PersonID:  splitDB.PersonID,
+					ItemID:    i.ItemID,
+					ItemType:  i.ItemType,
+					Title:     i.ProductTitle,
+					Paid:      shares[ind],
+					Currency:  i.Currency,
+				}, -1)
+			}
+			continue
+		}
+		add(ledger_schemas.LedgerEntry{
+			EntryDate: i.ItemDate,
+			PersonID:  i.PersonID,
+			ItemID:    i.ItemID,
+			ItemType:  i.ItemType,
+			Title:     i.ProductTitle,
+			Paid:      paid,
+			Currency:  i.Currency,
+		}, sign)
+	}
+	for _, paymentDB := range payments {
+		var sign int64 = 1
+		if paymentDB.PaymentType == ledger_schemas.PaymentTypeToPerson {
+			sign = -1
+		}
+		add(ledger_schemas.LedgerEntry{
+			EntryDate:   paymentDB.PaymentDate,
+			PersonID:    paymentDB.PersonID,
+			PaymentID:   paymentDB.PaymentID,
+			PaymentType: paymentDB.PaymentType,
+			Title:       paymentDB.Note,
+			Paid:        paymentDB.Amount,
+			Currency:    paymentDB.Currency,
+		}, sign)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].EntryDate.Before(entries[j].EntryDate)
+	})
+	return entries, missingRates, nil
+}
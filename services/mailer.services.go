@@ -0,0 +1,15 @@
+package services
+
+import "context"
+
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers plain text mails. Implementations live in the mailer
+// package.
+type Mailer interface {
+	Send(ctx context.Context, mail Mail) error
+}
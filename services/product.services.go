@@ -1,10 +1,23 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"sort"
 
 	E "github.com/bmg-c/product-diary/errorhandler"
 	"github.com/bmg-c/product-diary/schemas/product_schemas"
+	"github.com/bmg-c/product-diary/util"
+)
+
+const (
+	// SimilarTitleThreshold is the util.Similarity of normalized titles from
+	// which SimilarProducts suggests a product
+	SimilarTitleThreshold = 0.75
+	SimilarProductsLimit  = 3
+	// SimilarCandidatesLimit is how many products found by their title words
+	// SimilarProducts compares
+	SimilarCandidatesLimit = 20
 )
 
 func NewProductService(productDB ProductDB) *ProductService {
@@ -18,14 +31,44 @@ type ProductService struct {
 }
 
 type ProductDB interface {
-	AddProduct(data product_schemas.AddProduct) (product_schemas.ProductDB, error)
-	GetProducts(data product_schemas.GetProducts) ([]product_schemas.ProductDB, error)
-	GetProduct(data product_schemas.GetProduct) (product_schemas.ProductDB, error)
-	DeleteProduct(data product_schemas.DeleteProduct) error
+	AddProduct(ctx context.Context, data product_schemas.AddProduct) (product_schemas.ProductDB, error)
+	GetProducts(ctx context.Context, data product_schemas.GetProducts) ([]product_schemas.ProductDB, error)
+	GetProduct(ctx context.Context, data product_schemas.GetProduct) (product_schemas.ProductDB, error)
+	GetProductByFingerprint(ctx context.Context, fingerprint string) (product_schemas.ProductDB, error)
+	GetSimilarProducts(ctx context.Context, title string, limit uint) ([]product_schemas.ProductDB, error)
+	DeleteProduct(ctx context.Context, data product_schemas.DeleteProduct) error
+	SetProductHidden(ctx context.Context, data product_schemas.SetProductHidden) error
+	ChangeProduct(ctx context.Context, data product_schemas.ChangeProduct) error
+	GetProductRevisions(ctx context.Context, data product_schemas.GetProduct) ([]product_schemas.ProductRevision, error)
+	GetProductRevision(ctx context.Context, data product_schemas.GetProduct, revisionNumber uint) (product_schemas.ProductRevision, error)
+}
+
+// prepareAddProduct drops the nutrients of everything but food and sets the
+// fingerprint.
+func prepareAddProduct(data product_schemas.AddProduct) product_schemas.AddProduct {
+	if data.ProductType != product_schemas.ProductTypeFood {
+		data.ProductCalories = 0
+		data.ProductFats = 0
+		data.ProductCarbs = 0
+		data.ProductProteins = 0
+	}
+	data.Fingerprint = product_schemas.Fingerprint(product_schemas.ProductDB{
+		ProductTitle:    data.ProductTitle,
+		Manufacturer:    data.Manufacturer,
+		ProductType:     data.ProductType,
+		ProductCalories: data.ProductCalories,
+		ProductFats:     data.ProductFats,
+		ProductCarbs:    data.ProductCarbs,
+		ProductProteins: data.ProductProteins,
+	})
+	return data
 }
 
-func (ps *ProductService) AddProduct(data product_schemas.AddProduct) (product_schemas.ProductDB, error) {
-	productDB, err := ps.productDB.AddProduct(data)
+// AddProduct stores the nutrients of food only. Returns E.ErrConflict when an
+// identical product exists.
+func (ps *ProductService) AddProduct(ctx context.Context, data product_schemas.AddProduct) (product_schemas.ProductDB, error) {
+	data = prepareAddProduct(data)
+	productDB, err := ps.productDB.AddProduct(ctx, data)
 	if err != nil {
 		return product_schemas.ProductDB{}, err
 	}
@@ -33,8 +76,8 @@ func (ps *ProductService) AddProduct(data product_schemas.AddProduct) (product_s
 	return productDB, nil
 }
 
-func (ps *ProductService) GetProducts(data product_schemas.GetProducts) ([]product_schemas.ProductDB, error) {
-	products, err := ps.productDB.GetProducts(data)
+func (ps *ProductService) GetProducts(ctx context.Context, data product_schemas.GetProducts) ([]product_schemas.ProductDB, error) {
+	products, err := ps.productDB.GetProducts(ctx, data)
 	if err != nil {
 		return []product_schemas.ProductDB{}, err
 	}
@@ -42,8 +85,47 @@ func (ps *ProductService) GetProducts(data product_schemas.GetProducts) ([]produ
 	return products, nil
 }
 
-func (ps *ProductService) GetProduct(data product_schemas.GetProduct) (product_schemas.ProductDB, error) {
-	productDB, err := ps.productDB.GetProduct(data)
+// SimilarProducts returns up to SimilarProductsLimit listed products with a
+// title close to the one of data, the closest first, so that users can pick
+// one of them instead of adding a near copy. Only the SimilarCandidatesLimit
+// titles that share a word with data are compared. Returns E.ErrConflict when
+// a product is identical to data.
+func (ps *ProductService) SimilarProducts(ctx context.Context, data product_schemas.AddProduct) ([]product_schemas.ProductDB, error) {
+	data = prepareAddProduct(data)
+	_, err := ps.productDB.GetProductByFingerprint(ctx, data.Fingerprint)
+	if err == nil {
+		return []product_schemas.ProductDB{}, E.ErrConflict
+	}
+	if !errors.Is(err, E.ErrNotFound) {
+		return []product_schemas.ProductDB{}, err
+	}
+
+	products, err := ps.productDB.GetSimilarProducts(ctx, data.ProductTitle, SimilarCandidatesLimit)
+	if err != nil {
+		return []product_schemas.ProductDB{}, err
+	}
+
+	normalized := product_schemas.NormalizeTitle(data.ProductTitle)
+	similarity := map[uint]float64{}
+	similar := []product_schemas.ProductDB{}
+	for _, productDB := range products {
+		s := util.Similarity(normalized, product_schemas.NormalizeTitle(productDB.ProductTitle))
+		if s >= SimilarTitleThreshold {
+			similarity[productDB.ProductID] = s
+			similar = append(similar, productDB)
+		}
+	}
+	sort.SliceStable(similar, func(i, j int) bool {
+		return similarity[similar[i].ProductID] > similarity[similar[j].ProductID]
+	})
+	if len(similar) > SimilarProductsLimit {
+		similar = similar[:SimilarProductsLimit]
+	}
+	return similar, nil
+}
+
+func (ps *ProductService) GetProduct(ctx context.Context, data product_schemas.GetProduct) (product_schemas.ProductDB, error) {
+	productDB, err := ps.productDB.GetProduct(ctx, data)
 	if err != nil {
 		return product_schemas.ProductDB{}, err
 	}
@@ -51,8 +133,8 @@ func (ps *ProductService) GetProduct(data product_schemas.GetProduct) (product_s
 	return productDB, nil
 }
 
-func (ps *ProductService) DeleteProduct(data product_schemas.DeleteProduct) error {
-	err := ps.productDB.DeleteProduct(data)
+func (ps *ProductService) DeleteProduct(ctx context.Context, data product_schemas.DeleteProduct) error {
+	err := ps.productDB.DeleteProduct(ctx, data)
 	if err != nil {
 		if errors.Is(err, E.ErrNotFound) {
 			return E.ErrUnprocessableEntity
@@ -62,3 +144,72 @@ func (ps *ProductService) DeleteProduct(data product_schemas.DeleteProduct) erro
 
 	return nil
 }
+
+// SetProductHidden is for moderators, the handler checks the permission.
+func (ps *ProductService) SetProductHidden(ctx context.Context, data product_schemas.SetProductHidden) (product_schemas.ProductDB, error) {
+	err := ps.productDB.SetProductHidden(ctx, data)
+	if err != nil {
+		return product_schemas.ProductDB{}, err
+	}
+
+	return ps.productDB.GetProduct(ctx, product_schemas.GetProduct{ProductID: data.ProductID, IncludeHidden: true})
+}
+
+// ChangeProduct edits a product of data.UserID and returns it as changed.
+// Returns E.ErrUnprocessableEntity when the type would move between food and
+// the other types, E.ErrConflict when the product would become identical to
+// another one.
+func (ps *ProductService) ChangeProduct(ctx context.Context, data product_schemas.ChangeProduct) (product_schemas.ProductDB, error) {
+	productDB, err := ps.productDB.GetProduct(ctx, product_schemas.GetProduct{ProductID: data.ProductID, UserID: data.UserID})
+	if err != nil {
+		return product_schemas.ProductDB{}, err
+	}
+	if productDB.UserID != data.UserID {
+		return product_schemas.ProductDB{}, E.ErrNotFound
+	}
+	if productDB.IsFood() != (data.ProductType == product_schemas.ProductTypeFood) {
+		return product_schemas.ProductDB{}, E.ErrUnprocessableEntity
+	}
+	productDB.ProductTitle = data.ProductTitle
+	productDB.Manufacturer = data.Manufacturer
+	productDB.ProductType = data.ProductType
+	data.Fingerprint = product_schemas.Fingerprint(productDB)
+
+	err = ps.productDB.ChangeProduct(ctx, data)
+	if err != nil {
+		return product_schemas.ProductDB{}, err
+	}
+
+	return ps.productDB.GetProduct(ctx, product_schemas.GetProduct{ProductID: data.ProductID, UserID: data.UserID})
+}
+
+func (ps *ProductService) GetProductRevisions(ctx context.Context, data product_schemas.GetProduct) ([]product_schemas.ProductRevision, error) {
+	revisions, err := ps.productDB.GetProductRevisions(ctx, data)
+	if err != nil {
+		return []product_schemas.ProductRevision{}, err
+	}
+	if len(revisions) == 0 {
+		return []product_schemas.ProductRevision{}, E.ErrNotFound
+	}
+	return revisions, nil
+}
+
+// GetRevisionDiff compares two revisions of a product. Returns E.ErrNotFound
+// when one of them does not exist or the product is hidden from the caller.
+func (ps *ProductService) GetRevisionDiff(ctx context.Context, data product_schemas.GetRevisionDiff) (product_schemas.RevisionDiff, error) {
+	product := product_schemas.GetProduct{ProductID: data.ProductID, UserID: data.UserID, IncludeHidden: data.IncludeHidden}
+	from, err := ps.productDB.GetProductRevision(ctx, product, data.From)
+	if err != nil {
+		return product_schemas.RevisionDiff{}, err
+	}
+	to, err := ps.productDB.GetProductRevision(ctx, product, data.To)
+	if err != nil {
+		return product_schemas.RevisionDiff{}, err
+	}
+
+	return product_schemas.RevisionDiff{
+		From:    from,
+		To:      to,
+		Changes: product_schemas.DiffRevisions(from, to),
+	}, nil
+}
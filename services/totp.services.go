@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/logger"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/util"
+	"github.com/google/uuid"
+)
+
+const (
+	// TOTPIssuer is the name authenticator apps show for the account
+	TOTPIssuer = "Product Diary"
+	// RecoveryCodeCount is how many recovery codes a user gets at once
+	RecoveryCodeCount = 10
+)
+
+// Login2FA exchanges a pending session and a TOTP or recovery code for a
+// full session. The pending session is deleted, so a new session UUID is
+// handed out.
+func (us *UserService) Login2FA(ctx context.Context, data user_schemas.Login2FA, client user_schemas.SessionClient) (uuid.UUID, error) {
+	sessionDB, err := us.userDB.GetSession(ctx, data.SessionUUID)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	if !sessionDB.Pending2FA {
+		return uuid.UUID{}, E.ErrNotFound
+	}
+	if !time.Now().Before(sessionDB.ExpiresAt) {
+		err = us.userDB.DeleteSession(ctx, data.SessionUUID)
+		if err != nil && !errors.Is(err, E.ErrNotFound) {
+			return uuid.UUID{}, err
+		}
+		return uuid.UUID{}, E.ErrNotFound
+	}
+
+	totpDB, err := us.userDB.GetTOTP(ctx, sessionDB.UserID)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	if !totpDB.Enabled {
+		return uuid.UUID{}, E.ErrNotFound
+	}
+	err = us.verifySecondFactor(ctx, totpDB, data.Code, true)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	err = us.userDB.DeleteSession(ctx, data.SessionUUID)
+	if err != nil {
+		// Somebody else used the pending session first
+		if errors.Is(err, E.ErrNotFound) {
+			err = E.ErrUnprocessableEntity
+		}
+		return uuid.UUID{}, err
+	}
+	addSession := user_schemas.AddSession{
+		UserID:    sessionDB.UserID,
+		ExpiresAt: time.Now().Add(us.SessionLifetime),
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+	}
+	return us.userDB.AddSession(ctx, addSession)
+}
+
+// GetPendingUser returns the user a pending session was opened for, so that
+// wrong second factors can be counted per user and not per login. Returns
+// E.ErrNotFound for sessions that are not pending or have expired.
+func (us *UserService) GetPendingUser(ctx context.Context, sessionUUID uuid.UUID) (user_schemas.UserDB, error) {
+	sessionDB, err := us.userDB.GetSession(ctx, sessionUUID)
+	if err != nil {
+		return user_schemas.UserDB{}, err
+	}
+	if !sessionDB.Pending2FA || !time.Now().Before(sessionDB.ExpiresAt) {
+		return user_schemas.UserDB{}, E.ErrNotFound
+	}
+	return us.userDB.GetUser(ctx, user_schemas.GetUser{UserID: sessionDB.UserID})
+}
+
+// verifySecondFactor accepts a TOTP code, or a recovery code if
+// allowRecovery is set. Both can only be used once. It returns
+// E.ErrUnprocessableEntity for wrong codes.
+func (us *UserService) verifySecondFactor(ctx context.Context, totpDB user_schemas.TOTPDB, code string, allowRecovery bool) error {
+	code = util.NormalizeRecoveryCode(code)
+	if len(code) == util.TOTPDigits {
+		step, valid := util.VerifyTOTP(totpDB.Secret, code, time.Now(), totpDB.LastUsedStep)
+		if !valid {
+			return E.ErrUnprocessableEntity
+		}
+		return us.userDB.UseTOTPStep(ctx, totpDB.UserID, step)
+	}
+	if allowRecovery && len(code) == util.RecoveryCodeLength {
+		return us.userDB.UseRecoveryCode(ctx, totpDB.UserID, util.HashToken(code))
+	}
+	return E.ErrUnprocessableEntity
+}
+
+func (us *UserService) GetTOTPStatus(ctx context.Context, userID uint) (user_schemas.TOTPStatus, error) {
+	totpDB, err := us.userDB.GetTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return user_schemas.TOTPStatus{}, nil
+		}
+		return user_schemas.TOTPStatus{}, err
+	}
+	if !totpDB.Enabled {
+		return user_schemas.TOTPStatus{}, nil
+	}
+	left, err := us.userDB.CountRecoveryCodes(ctx, userID)
+	if err != nil {
+		return user_schemas.TOTPStatus{}, err
+	}
+	return user_schemas.TOTPStatus{Enabled: true, RecoveryCodesLeft: left}, nil
+}
+
+// BeginTOTP creates a new secret for the user to add to an authenticator
+// app. It is only asked for at login after ConfirmTOTP. Returns
+// E.ErrConflict when two-factor authentication is already on.
+func (us *UserService) BeginTOTP(ctx context.Context, userID uint) (user_schemas.TOTPEnrollment, error) {
+	userDB, err := us.userDB.GetUser(ctx, user_schemas.GetUser{UserID: userID})
+	if err != nil {
+		return user_schemas.TOTPEnrollment{}, err
+	}
+	secret, err := util.GenerateTOTPSecret()
+	if err != nil {
+		return user_schemas.TOTPEnrollment{}, E.ErrInternalServer
+	}
+	err = us.userDB.SetTOTPSecret(ctx, userID, secret)
+	if err != nil {
+		return user_schemas.TOTPEnrollment{}, err
+	}
+	return user_schemas.TOTPEnrollment{
+		Secret: secret,
+		URI:    util.TOTPURI(TOTPIssuer, userDB.Email, secret),
+	}, nil
+}
+
+// GetTOTPEnrollment returns the secret begun by BeginTOTP again, as long as it
+// is not confirmed.
+func (us *UserService) GetTOTPEnrollment(ctx context.Context, userID uint) (user_schemas.TOTPEnrollment, error) {
+	totpDB, err := us.userDB.GetTOTP(ctx, userID)
+	if err != nil {
+		return user_schemas.TOTPEnrollment{}, err
+	}
+	if totpDB.Enabled {
+		return user_schemas.TOTPEnrollment{}, E.ErrNotFound
+	}
+	userDB, err := us.userDB.GetUser(ctx, user_schemas.GetUser{UserID: userID})
+	if err != nil {
+		return user_schemas.TOTPEnrollment{}, err
+	}
+	return user_schemas.TOTPEnrollment{
+		Secret: totpDB.Secret,
+		URI:    util.TOTPURI(TOTPIssuer, userDB.Email, totpDB.Secret),
+	}, nil
+}
+
+// ConfirmTOTP turns two-factor authentication on once the user enters a code
+// from the app, and returns the recovery codes to show once.
+func (us *UserService) ConfirmTOTP(ctx context.Context, data user_schemas.VerifyTOTP) ([]string, error) {
+	totpDB, err := us.userDB.GetTOTP(ctx, data.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if totpDB.Enabled {
+		return nil, E.ErrConflict
+	}
+	step, valid := util.VerifyTOTP(totpDB.Secret, data.Code, time.Now(), totpDB.LastUsedStep)
+	if !valid {
+		return nil, E.ErrUnprocessableEntity
+	}
+
+	codes, err := us.newRecoveryCodes(ctx, data.UserID)
+	if err != nil {
+		return nil, err
+	}
+	err = us.userDB.EnableTOTP(ctx, data.UserID, step)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user after
+// checking a TOTP code.
+func (us *UserService) RegenerateRecoveryCodes(ctx context.Context, data user_schemas.VerifyTOTP) ([]string, error) {
+	totpDB, err := us.userDB.GetTOTP(ctx, data.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !totpDB.Enabled {
+		return nil, E.ErrNotFound
+	}
+	err = us.verifySecondFactor(ctx, totpDB, data.Code, false)
+	if err != nil {
+		return nil, err
+	}
+	return us.newRecoveryCodes(ctx, data.UserID)
+}
+
+// DisableTOTP turns two-factor authentication off with a TOTP or recovery
+// code, so that a lost phone does not lock the user out of the setting.
+func (us *UserService) DisableTOTP(ctx context.Context, data user_schemas.VerifyTOTP) error {
+	totpDB, err := us.userDB.GetTOTP(ctx, data.UserID)
+	if err != nil {
+		return err
+	}
+	if !totpDB.Enabled {
+		return E.ErrNotFound
+	}
+	err = us.verifySecondFactor(ctx, totpDB, data.Code, true)
+	if err != nil {
+		return err
+	}
+	return us.userDB.DeleteTOTP(ctx, data.UserID)
+}
+
+func (us *UserService) newRecoveryCodes(ctx context.Context, userID uint) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	codeHashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := util.GenerateRecoveryCode()
+		if err != nil {
+			return nil, E.ErrInternalServer
+		}
+		codes[i] = code
+		codeHashes[i] = util.HashToken(util.NormalizeRecoveryCode(code))
+	}
+	err := us.userDB.SetRecoveryCodes(ctx, userID, codeHashes)
+	if err != nil {
+		logger.Error.Printf("Failure storing recovery codes of user %d: %v", userID, err)
+		return nil, err
+	}
+	return codes, nil
+}
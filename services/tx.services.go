@@ -0,0 +1,21 @@
+package services
+
+import (
+	"context"
+)
+
+// Tx gives access to the database layers bound to a single transaction.
+// Everything done through it is committed together or not at all.
+type Tx interface {
+	UserDB() UserDB
+	ProductDB() ProductDB
+	ItemDB() ItemDB
+	CurrencyDB() CurrencyDB
+	LedgerDB() LedgerDB
+}
+
+type TxDB interface {
+	// WithTx runs fn inside a transaction. The transaction is rolled back
+	// if fn returns an error and committed otherwise.
+	WithTx(ctx context.Context, fn func(tx Tx) error) error
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/schemas/item_schemas"
+	"github.com/bmg-c/product-diary/schemas/product_schemas"
+)
+
+func NewUnitService() *UnitService {
+	return &UnitService{}
+}
+
+// UnitService converts amounts between the units of product_schemas.Units.
+// Weights and volumes convert through the density of the product, pieces
+// through the net quantity of a piece.
+type UnitService struct{}
+
+// ToGrams returns the weight of amount in unit of a product with measure m.
+// Returns E.ErrUnprocessableEntity for unknown units and measures that can
+// not convert.
+func (us *UnitService) ToGrams(amount float32, unit uint8, m product_schemas.Measure) (float32, error) {
+	switch unit {
+	case product_schemas.UnitGram:
+		return amount, nil
+	case product_schemas.UnitKilogram:
+		return amount * 1000, nil
+	case product_schemas.UnitMilliliter, product_schemas.UnitLiter:
+		if m.Density <= 0 {
+			return 0, E.ErrUnprocessableEntity
+		}
+		if unit == product_schemas.UnitLiter {
+			amount *= 1000
+		}
+		return amount * m.Density, nil
+	case product_schemas.UnitPiece:
+		if m.NetQuantity <= 0 || m.NetUnit == product_schemas.UnitPiece {
+			return 0, E.ErrUnprocessableEntity
+		}
+		return us.ToGrams(amount*m.NetQuantity, m.NetUnit, m)
+	default:
+		return 0, E.ErrUnprocessableEntity
+	}
+}
+
+// Convert returns amount in unit from as an amount in unit to.
+func (us *UnitService) Convert(amount float32, from uint8, to uint8, m product_schemas.Measure) (float32, error) {
+	grams, err := us.ToGrams(amount, from, m)
+	if err != nil {
+		return 0, err
+	}
+	gramsPerUnit, err := us.ToGrams(1, to, m)
+	if err != nil {
+		return 0, err
+	}
+	return grams / gramsPerUnit, nil
+}
+
+// Portion is the share of the per 100 g nutrients of the product that an
+// item has, see ENTITIES.md.
+func (us *UnitService) Portion(i item_schemas.ItemParsed) (float32, error) {
+	grams, err := us.ToGrams(i.ItemAmount, i.ItemUnit, i.ProductMeasure)
+	if err != nil {
+		return 0, err
+	}
+	return grams / 100, nil
+}
@@ -1,41 +1,109 @@
 package services
 
 import (
+	"context"
+	"crypto/subtle"
 	"errors"
+	"net/url"
+	"strings"
+	"time"
 
 	E "github.com/bmg-c/product-diary/errorhandler"
+	L "github.com/bmg-c/product-diary/localization"
+	"github.com/bmg-c/product-diary/logger"
+	"github.com/bmg-c/product-diary/schemas"
 	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/util"
 	"github.com/google/uuid"
 )
 
-func NewUserService(userDB UserDB) *UserService {
+const (
+	DefaultSessionLifetime    = 30 * 24 * time.Hour
+	DefaultSessionIdleTimeout = 7 * 24 * time.Hour
+	// Last use of a session is written at most this often
+	sessionTouchInterval = time.Minute
+	// A code is deleted after this many wrong guesses
+	MaxCodeAttempts = 5
+	// DefaultPublicURL is where links in mails point to
+	DefaultPublicURL = "http://localhost:1323"
+	// PasswordResetPath is the page a password reset link opens
+	PasswordResetPath = "/users/reset"
+	// PendingSessionLifetime is how long the second factor can be entered
+	// after the password
+	PendingSessionLifetime = 5 * time.Minute
+)
+
+func NewUserService(userDB UserDB, txDB TxDB, mailer Mailer) *UserService {
 	return &UserService{
-		userDB: userDB,
+		userDB:             userDB,
+		txDB:               txDB,
+		mailer:             mailer,
+		SessionLifetime:    DefaultSessionLifetime,
+		SessionIdleTimeout: DefaultSessionIdleTimeout,
+		PublicURL:          DefaultPublicURL,
 	}
 }
 
 type UserService struct {
 	userDB UserDB
+	txDB   TxDB
+	mailer Mailer
+	// SessionLifetime is the absolute lifetime of a session since login
+	SessionLifetime time.Duration
+	// SessionIdleTimeout ends sessions that were not used for this long
+	SessionIdleTimeout time.Duration
+	// PublicURL is the scheme and host of the site, links in mails are built
+	// from it rather than from the request Host header
+	PublicURL string
 }
 
 type UserDB interface {
-	AddCode(email string) error
-	GetCode(email string) (string, error)
-	AddUser(email string) error
-	GetUser(userInfo user_schemas.GetUser) (user_schemas.UserDB, error)
-	GetUsersAll() ([]user_schemas.UserDB, error)
-	GetSession(sessionUUID uuid.UUID) (user_schemas.SessionDB, error)
-	AddSession(userID uint) (uuid.UUID, error)
-	AddPerson(personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error)
-	GetUserPersons(userInfo user_schemas.GetUser) ([]user_schemas.PersonDB, error)
-	ToggleHiddenPerson(personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error)
-}
-
-func (us *UserService) SigninUser(ur user_schemas.UserSignin) error {
+	AddCode(ctx context.Context, email string, code string) error
+	AddCodeAttempt(ctx context.Context, email string) (uint, error)
+	DeleteCode(ctx context.Context, email string) error
+	GetCode(ctx context.Context, email string) (string, error)
+	AddUser(ctx context.Context, data user_schemas.AddUser) error
+	UpdatePassword(ctx context.Context, userID uint, passwordHash string) error
+	UpdateUsername(ctx context.Context, userID uint, username string) error
+	UpdateEmail(ctx context.Context, userID uint, email string) error
+	SetBaseCurrency(ctx context.Context, data user_schemas.SetBaseCurrency) error
+	SetUserRole(ctx context.Context, data user_schemas.SetUserRole) error
+	SetUserDisabled(ctx context.Context, data user_schemas.SetUserDisabled) error
+	AddPasswordReset(ctx context.Context, userID uint, tokenHash string) error
+	GetPasswordReset(ctx context.Context, tokenHash string) (uint, error)
+	UsePasswordReset(ctx context.Context, tokenHash string) (uint, error)
+	GetUser(ctx context.Context, userInfo user_schemas.GetUser) (user_schemas.UserDB, error)
+	GetUsersAll(ctx context.Context) ([]user_schemas.UserDB, error)
+	GetSession(ctx context.Context, sessionUUID uuid.UUID) (user_schemas.SessionDB, error)
+	GetUserSessions(ctx context.Context, userID uint) ([]user_schemas.SessionDB, error)
+	AddSession(ctx context.Context, data user_schemas.AddSession) (uuid.UUID, error)
+	TouchSession(ctx context.Context, sessionUUID uuid.UUID, lastSeenAt time.Time) error
+	DeleteSession(ctx context.Context, sessionUUID uuid.UUID) error
+	DeleteUserSession(ctx context.Context, sessionInfo user_schemas.GetUserSession) error
+	DeleteUserSessions(ctx context.Context, userID uint) error
+	GetTOTP(ctx context.Context, userID uint) (user_schemas.TOTPDB, error)
+	SetTOTPSecret(ctx context.Context, userID uint, secret string) error
+	EnableTOTP(ctx context.Context, userID uint, step int64) error
+	UseTOTPStep(ctx context.Context, userID uint, step int64) error
+	DeleteTOTP(ctx context.Context, userID uint) error
+	SetRecoveryCodes(ctx context.Context, userID uint, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID uint, codeHash string) error
+	CountRecoveryCodes(ctx context.Context, userID uint) (uint, error)
+	AddAPIToken(ctx context.Context, data user_schemas.AddAPITokenDB) (uint, error)
+	GetAPIToken(ctx context.Context, tokenHash string) (user_schemas.APITokenDB, error)
+	GetUserAPITokens(ctx context.Context, userID uint) ([]user_schemas.APITokenDB, error)
+	TouchAPIToken(ctx context.Context, tokenID uint, lastUsedAt time.Time) error
+	DeleteAPIToken(ctx context.Context, tokenInfo user_schemas.GetAPIToken) error
+	AddPerson(ctx context.Context, personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error)
+	GetUserPersons(ctx context.Context, userInfo user_schemas.GetUser) ([]user_schemas.PersonDB, error)
+	ToggleHiddenPerson(ctx context.Context, personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error)
+}
+
+func (us *UserService) SigninUser(ctx context.Context, ur user_schemas.UserSignin, l *L.Localizer) error {
 	userInfo := user_schemas.GetUser{
 		Email: ur.Email,
 	}
-	_, err := us.userDB.GetUser(userInfo)
+	_, err := us.userDB.GetUser(ctx, userInfo)
 	if err == nil {
 		return E.ErrUnprocessableEntity
 	}
@@ -43,7 +111,13 @@ func (us *UserService) SigninUser(ur user_schemas.UserSignin) error {
 		return err
 	}
 
-	_, err = us.userDB.GetCode(ur.Email)
+	return us.sendCode(ctx, ur.Email, l, L.MsgMailCodeSubject, L.MsgMailCodeBody)
+}
+
+// sendCode mails a new confirmation code to email. A code that is still valid
+// is not replaced, the user can keep using the one already sent.
+func (us *UserService) sendCode(ctx context.Context, email string, l *L.Localizer, subject L.Msg, body L.Msg) error {
+	_, err := us.userDB.GetCode(ctx, email)
 	if err != nil {
 		if !errors.Is(err, E.ErrNotFound) {
 			return err
@@ -52,46 +126,232 @@ func (us *UserService) SigninUser(ur user_schemas.UserSignin) error {
 		return nil
 	}
 
-	err = us.userDB.AddCode(ur.Email)
+	code, err := util.GenerateCode(schemas.DefRV.CodeLength)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	err = us.userDB.AddCode(ctx, email, code)
 	if err != nil {
 		return err
 	}
 
+	mail := Mail{
+		To:      email,
+		Subject: l.GetLocalized(subject),
+		Body:    l.GetLocalized(body, code),
+	}
+	err = us.mailer.Send(ctx, mail)
+	if err != nil {
+		logger.Error.Printf("Failure sending confirmation code to %s: %v", email, err)
+		// Let the user ask for a new code right away
+		_ = us.userDB.DeleteCode(ctx, email)
+		return E.ErrInternalServer
+	}
+
 	return nil
 }
 
-func (us *UserService) ConfirmSignin(ucr user_schemas.UserConfirmSignin) error {
-	code, err := us.userDB.GetCode(ucr.Email)
+// checkCode compares code with the one sent to email. The code is deleted
+// after MaxCodeAttempts wrong guesses.
+func (us *UserService) checkCode(ctx context.Context, email string, code string) error {
+	sentCode, err := us.userDB.GetCode(ctx, email)
 	if err != nil {
+		// Expired or used up codes are wrong codes for the user
+		if errors.Is(err, E.ErrNotFound) {
+			err = E.ErrUnprocessableEntity
+		}
 		return err
 	}
-	if code != ucr.Code {
+	if subtle.ConstantTimeCompare([]byte(sentCode), []byte(code)) != 1 {
+		attempts, err := us.userDB.AddCodeAttempt(ctx, email)
+		if err != nil && !errors.Is(err, E.ErrNotFound) {
+			return err
+		}
+		if attempts >= MaxCodeAttempts {
+			err = us.userDB.DeleteCode(ctx, email)
+			if err != nil && !errors.Is(err, E.ErrNotFound) {
+				return err
+			}
+		}
 		return E.ErrUnprocessableEntity
 	}
+	return nil
+}
+
+func (us *UserService) ConfirmSignin(ctx context.Context, ucr user_schemas.UserConfirmSignin) error {
+	err := us.checkCode(ctx, ucr.Email, ucr.Code)
+	if err != nil {
+		return err
+	}
+
+	passwordHash, err := util.HashPassword(ucr.Password)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	addUser := user_schemas.AddUser{
+		Username:     ucr.Username,
+		Email:        ucr.Email,
+		PasswordHash: passwordHash,
+	}
+	err = us.userDB.AddUser(ctx, addUser)
+	if err != nil {
+		return err
+	}
+	// Codes are single use
+	err = us.userDB.DeleteCode(ctx, ucr.Email)
+	if err != nil {
+		logger.Error.Printf("Failure deleting confirmation code of %s: %v", ucr.Email, err)
+	}
+	return nil
+}
+
+// RequestPasswordReset mails a reset link to the user with the given email.
+// Unknown emails are not reported, so that the form does not tell which
+// emails have accounts.
+func (us *UserService) RequestPasswordReset(ctx context.Context, rr user_schemas.PasswordResetRequest, l *L.Localizer) error {
+	userInfo := user_schemas.GetUser{
+		Email: rr.Email,
+	}
+	userDB, err := us.userDB.GetUser(ctx, userInfo)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
 
-	err = us.userDB.AddUser(ucr.Email)
+	token, err := util.GenerateToken()
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	err = us.userDB.AddPasswordReset(ctx, userDB.UserID, util.HashToken(token))
 	if err != nil {
 		return err
 	}
+
+	link := strings.TrimSuffix(us.PublicURL, "/") + PasswordResetPath + "?" + url.Values{"token": {token}}.Encode()
+	mail := Mail{
+		To:      userDB.Email,
+		Subject: l.GetLocalized(L.MsgMailResetSubject),
+		Body:    l.GetLocalized(L.MsgMailResetBody, link),
+	}
+	err = us.mailer.Send(ctx, mail)
+	if err != nil {
+		logger.Error.Printf("Failure sending password reset link to %s: %v", userDB.Email, err)
+		return E.ErrInternalServer
+	}
+
 	return nil
 }
 
-func (us *UserService) GetUser(userInfo user_schemas.GetUser) (user_schemas.UserPublic, error) {
-	udb, err := us.userDB.GetUser(userInfo)
+// CheckPasswordReset reports whether a reset token can still be used.
+func (us *UserService) CheckPasswordReset(ctx context.Context, token string) error {
+	_, err := us.userDB.GetPasswordReset(ctx, util.HashToken(token))
+	return err
+}
+
+// ResetPassword sets a new password with a reset token and ends every session
+// of the user, in case the old password was stolen. The token is only spent
+// when both succeed.
+func (us *UserService) ResetPassword(ctx context.Context, pr user_schemas.PasswordReset) error {
+	passwordHash, err := util.HashPassword(pr.Password)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+
+	return us.txDB.WithTx(ctx, func(tx Tx) error {
+		userID, err := tx.UserDB().UsePasswordReset(ctx, util.HashToken(pr.Token))
+		if err != nil {
+			return err
+		}
+		err = tx.UserDB().UpdatePassword(ctx, userID, passwordHash)
+		if err != nil {
+			return err
+		}
+		return tx.UserDB().DeleteUserSessions(ctx, userID)
+	})
+}
+
+// UpdateUsername returns E.ErrConflict when the username is taken.
+func (us *UserService) UpdateUsername(ctx context.Context, data user_schemas.UpdateUsername) error {
+	return us.userDB.UpdateUsername(ctx, data.UserID, data.Username)
+}
+
+// SetBaseCurrency sets the currency analytics are converted to.
+func (us *UserService) SetBaseCurrency(ctx context.Context, data user_schemas.SetBaseCurrency) error {
+	return us.userDB.SetBaseCurrency(ctx, data)
+}
+
+// ChangePassword returns E.ErrUnprocessableEntity when the current password
+// is wrong.
+func (us *UserService) ChangePassword(ctx context.Context, data user_schemas.ChangePassword) error {
+	userDB, err := us.userDB.GetUser(ctx, user_schemas.GetUser{UserID: data.UserID})
+	if err != nil {
+		return err
+	}
+	valid, _ := util.VerifyPassword(data.CurrentPassword, userDB.PasswordHash)
+	if !valid {
+		return E.ErrUnprocessableEntity
+	}
+
+	passwordHash, err := util.HashPassword(data.NewPassword)
+	if err != nil {
+		return E.ErrInternalServer
+	}
+	return us.userDB.UpdatePassword(ctx, data.UserID, passwordHash)
+}
+
+// ChangeEmail sends a confirmation code to the new email. The email is only
+// changed by ConfirmChangeEmail, once the user proves to own it.
+func (us *UserService) ChangeEmail(ctx context.Context, data user_schemas.ChangeEmail, l *L.Localizer) error {
+	_, err := us.userDB.GetUser(ctx, user_schemas.GetUser{Email: data.Email})
+	if err == nil {
+		return E.ErrConflict
+	}
+	if !errors.Is(err, E.ErrNotFound) {
+		return err
+	}
+
+	return us.sendCode(ctx, data.Email, l, L.MsgMailChangeEmailSubject, L.MsgMailChangeEmailBody)
+}
+
+func (us *UserService) ConfirmChangeEmail(ctx context.Context, data user_schemas.ConfirmChangeEmail) error {
+	err := us.checkCode(ctx, data.Email, data.Code)
+	if err != nil {
+		return err
+	}
+
+	err = us.userDB.UpdateEmail(ctx, data.UserID, data.Email)
+	if err != nil {
+		return err
+	}
+	// Codes are single use
+	err = us.userDB.DeleteCode(ctx, data.Email)
+	if err != nil {
+		logger.Error.Printf("Failure deleting confirmation code of %s: %v", data.Email, err)
+	}
+	return nil
+}
+
+func (us *UserService) GetUser(ctx context.Context, userInfo user_schemas.GetUser) (user_schemas.UserPublic, error) {
+	udb, err := us.userDB.GetUser(ctx, userInfo)
 	if err != nil {
 		return user_schemas.UserPublic{}, err
 	}
 
 	return user_schemas.UserPublic{
-		UserID:    udb.UserID,
-		Email:     udb.Email,
-		Username:  udb.Username,
-		CreatedAt: udb.CreatedAt,
+		UserID:       udb.UserID,
+		Email:        udb.Email,
+		Username:     udb.Username,
+		Role:         udb.Role,
+		IsDisabled:   udb.IsDisabled,
+		CreatedAt:    udb.CreatedAt,
+		BaseCurrency: udb.BaseCurrency,
 	}, nil
 }
 
-func (us *UserService) GetUsersAll() ([]user_schemas.UserPublic, error) {
-	users, err := us.userDB.GetUsersAll()
+func (us *UserService) GetUsersAll(ctx context.Context) ([]user_schemas.UserPublic, error) {
+	users, err := us.userDB.GetUsersAll(ctx)
 	if err != nil {
 		return []user_schemas.UserPublic{}, err
 	}
@@ -99,37 +359,83 @@ func (us *UserService) GetUsersAll() ([]user_schemas.UserPublic, error) {
 	usersPublic := []user_schemas.UserPublic{}
 	for _, user := range users {
 		usersPublic = append(usersPublic, user_schemas.UserPublic{
-			UserID:    user.UserID,
-			Email:     user.Email,
-			Username:  user.Username,
-			CreatedAt: user.CreatedAt,
+			UserID:       user.UserID,
+			Email:        user.Email,
+			Username:     user.Username,
+			Role:         user.Role,
+			IsDisabled:   user.IsDisabled,
+			CreatedAt:    user.CreatedAt,
+			BaseCurrency: user.BaseCurrency,
 		})
 	}
 
 	return usersPublic, nil
 }
 
-func (us *UserService) LoginUser(ul user_schemas.UserLogin) (uuid.UUID, error) {
+// LoginUser checks the password and opens a session. Users with two-factor
+// authentication get a pending session instead, which Login2FA exchanges for
+// a full one.
+func (us *UserService) LoginUser(ctx context.Context, ul user_schemas.UserLogin, client user_schemas.SessionClient) (user_schemas.LoginResult, error) {
 	userInfo := user_schemas.GetUser{
 		Email: ul.Email,
 	}
-	userDB, err := us.userDB.GetUser(userInfo)
+	userDB, err := us.userDB.GetUser(ctx, userInfo)
 	if err != nil {
-		return uuid.UUID{}, err
+		if errors.Is(err, E.ErrNotFound) {
+			util.VerifyNoPassword(ul.Password)
+		}
+		return user_schemas.LoginResult{}, err
+	}
+	valid, needsRehash := util.VerifyPassword(ul.Password, userDB.PasswordHash)
+	if !valid {
+		return user_schemas.LoginResult{}, E.ErrUnprocessableEntity
 	}
-	if ul.Password != userDB.Password {
-		return uuid.UUID{}, E.ErrUnprocessableEntity
+	if userDB.IsDisabled {
+		return user_schemas.LoginResult{}, E.ErrForbidden
 	}
+	if needsRehash {
+		passwordHash, err := util.HashPassword(ul.Password)
+		if err == nil {
+			err = us.userDB.UpdatePassword(ctx, userDB.UserID, passwordHash)
+		}
+		if err != nil {
+			logger.Error.Printf("Failure rehashing password of user %d: %v", userDB.UserID, err)
+		}
+	}
+
+	totpDB, err := us.userDB.GetTOTP(ctx, userDB.UserID)
+	if err != nil && !errors.Is(err, E.ErrNotFound) {
+		return user_schemas.LoginResult{}, err
+	}
+	pending := err == nil && totpDB.Enabled
 
-	sessionUUID, err := us.userDB.AddSession(userDB.UserID)
+	addSession := user_schemas.AddSession{
+		UserID:     userDB.UserID,
+		ExpiresAt:  time.Now().Add(us.SessionLifetime),
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		Pending2FA: pending,
+	}
+	if pending {
+		addSession.ExpiresAt = time.Now().Add(PendingSessionLifetime)
+	}
+	sessionUUID, err := us.userDB.AddSession(ctx, addSession)
 	if err != nil {
-		return uuid.UUID{}, err
+		return user_schemas.LoginResult{}, err
 	}
-	return sessionUUID, nil
+	return user_schemas.LoginResult{SessionUUID: sessionUUID, Pending2FA: pending}, nil
 }
 
-func (us *UserService) GetUserBySession(sessionUUID uuid.UUID) (user_schemas.UserDB, error) {
-	sessionDB, err := us.userDB.GetSession(sessionUUID)
+// isSessionExpired checks both the absolute and the idle timeout.
+func (us *UserService) isSessionExpired(sessionDB user_schemas.SessionDB, now time.Time) bool {
+	return !now.Before(sessionDB.ExpiresAt) ||
+		(us.SessionIdleTimeout > 0 && now.Sub(sessionDB.LastSeenAt) >= us.SessionIdleTimeout)
+}
+
+// GetUserBySession returns the owner of a live session. Expired sessions are
+// deleted, used ones have their idle timeout renewed.
+func (us *UserService) GetUserBySession(ctx context.Context, sessionUUID uuid.UUID) (user_schemas.UserDB, error) {
+	sessionDB, err := us.userDB.GetSession(ctx, sessionUUID)
 	if err != nil {
 		if errors.Is(err, E.ErrNotFound) {
 			err = E.ErrUnprocessableEntity
@@ -137,19 +443,126 @@ func (us *UserService) GetUserBySession(sessionUUID uuid.UUID) (user_schemas.Use
 		return user_schemas.UserDB{}, err
 	}
 
+	// Pending sessions only allow giving the second factor
+	if sessionDB.Pending2FA {
+		return user_schemas.UserDB{}, E.ErrUnprocessableEntity
+	}
+
+	now := time.Now()
+	if us.isSessionExpired(sessionDB, now) {
+		err = us.userDB.DeleteSession(ctx, sessionUUID)
+		if err != nil && !errors.Is(err, E.ErrNotFound) {
+			return user_schemas.UserDB{}, err
+		}
+		return user_schemas.UserDB{}, E.ErrUnprocessableEntity
+	}
+	if now.Sub(sessionDB.LastSeenAt) >= sessionTouchInterval {
+		err = us.userDB.TouchSession(ctx, sessionUUID, now)
+		if err != nil {
+			return user_schemas.UserDB{}, err
+		}
+	}
+
 	userInfo := user_schemas.GetUser{
 		UserID: sessionDB.UserID,
 	}
-	userDB, err := us.userDB.GetUser(userInfo)
+	userDB, err := us.userDB.GetUser(ctx, userInfo)
 	if err != nil {
 		return user_schemas.UserDB{}, err
 	}
+	if userDB.IsDisabled {
+		return user_schemas.UserDB{}, E.ErrUnprocessableEntity
+	}
 
 	return userDB, nil
 }
 
-func (us *UserService) AddPerson(personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error) {
-	personDB, err := us.userDB.AddPerson(personInfo)
+// GetUserSessions lists the live sessions of a user. The session of
+// currentUUID is marked as current.
+func (us *UserService) GetUserSessions(ctx context.Context, userID uint, currentUUID uuid.UUID) ([]user_schemas.SessionPublic, error) {
+	sessionsDB, err := us.userDB.GetUserSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sessions := []user_schemas.SessionPublic{}
+	for _, sessionDB := range sessionsDB {
+		if us.isSessionExpired(sessionDB, now) {
+			continue
+		}
+		sessions = append(sessions, user_schemas.SessionPublic{
+			SessionID:  sessionDB.SessionID,
+			CreatedAt:  sessionDB.CreatedAt,
+			LastSeenAt: sessionDB.LastSeenAt,
+			ExpiresAt:  sessionDB.ExpiresAt,
+			UserAgent:  sessionDB.UserAgent,
+			IP:         sessionDB.IP,
+			IsCurrent:  sessionDB.SessionUUID == currentUUID,
+		})
+	}
+	return sessions, nil
+}
+
+func (us *UserService) LogoutUser(ctx context.Context, sessionUUID uuid.UUID) error {
+	err := us.userDB.DeleteSession(ctx, sessionUUID)
+	if err != nil && !errors.Is(err, E.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (us *UserService) RevokeSession(ctx context.Context, sessionInfo user_schemas.GetUserSession) error {
+	err := us.userDB.DeleteUserSession(ctx, sessionInfo)
+	if err != nil {
+		if errors.Is(err, E.ErrNotFound) {
+			err = E.ErrUnprocessableEntity
+		}
+		return err
+	}
+	return nil
+}
+
+func (us *UserService) LogoutEverywhere(ctx context.Context, userID uint) error {
+	return us.userDB.DeleteUserSessions(ctx, userID)
+}
+
+// SetUserRole changes the role of another user. Admins can not change their
+// own role, so that there is always an admin left.
+func (us *UserService) SetUserRole(ctx context.Context, actorID uint, data user_schemas.SetUserRole) error {
+	if data.UserID == actorID {
+		return E.ErrForbidden
+	}
+	return us.userDB.SetUserRole(ctx, data)
+}
+
+// SetUserDisabled disables or enables another user. Disabling also ends every
+// session of the user.
+func (us *UserService) SetUserDisabled(ctx context.Context, actorID uint, data user_schemas.SetUserDisabled) error {
+	if data.UserID == actorID {
+		return E.ErrForbidden
+	}
+	err := us.userDB.SetUserDisabled(ctx, data)
+	if err != nil {
+		return err
+	}
+	if data.IsDisabled {
+		return us.userDB.DeleteUserSessions(ctx, data.UserID)
+	}
+	return nil
+}
+
+// ForceLogout ends every session of a user.
+func (us *UserService) ForceLogout(ctx context.Context, userID uint) error {
+	_, err := us.userDB.GetUser(ctx, user_schemas.GetUser{UserID: userID})
+	if err != nil {
+		return err
+	}
+	return us.userDB.DeleteUserSessions(ctx, userID)
+}
+
+func (us *UserService) AddPerson(ctx context.Context, personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error) {
+	personDB, err := us.userDB.AddPerson(ctx, personInfo)
 	if err != nil {
 		return user_schemas.PersonDB{}, err
 	}
@@ -157,8 +570,8 @@ func (us *UserService) AddPerson(personInfo user_schemas.GetPerson) (user_schema
 	return personDB, nil
 }
 
-func (us *UserService) GetUserPersons(userInfo user_schemas.GetUser) ([]user_schemas.PersonDB, error) {
-	persons, err := us.userDB.GetUserPersons(userInfo)
+func (us *UserService) GetUserPersons(ctx context.Context, userInfo user_schemas.GetUser) ([]user_schemas.PersonDB, error) {
+	persons, err := us.userDB.GetUserPersons(ctx, userInfo)
 	if err != nil {
 		return []user_schemas.PersonDB{}, err
 	}
@@ -166,8 +579,8 @@ func (us *UserService) GetUserPersons(userInfo user_schemas.GetUser) ([]user_sch
 	return persons, nil
 }
 
-func (us *UserService) ToggleHiddenPerson(personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error) {
-	personDB, err := us.userDB.ToggleHiddenPerson(personInfo)
+func (us *UserService) ToggleHiddenPerson(ctx context.Context, personInfo user_schemas.GetPerson) (user_schemas.PersonDB, error) {
+	personDB, err := us.userDB.ToggleHiddenPerson(ctx, personInfo)
 	if err != nil {
 		return user_schemas.PersonDB{}, err
 	}
@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/mailer"
+	"github.com/bmg-c/product-diary/middleware"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+	"github.com/bmg-c/product-diary/util"
+	"github.com/google/uuid"
+)
+
+func TestAPITokens() error {
+	err := testAPITokenMiddleware()
+	if err != nil {
+		return err
+	}
+
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	fm, err := mailer.NewFileMailer(filepath.Join(t.dir, "mail"), "test@product-diary.local")
+	if err != nil {
+		return err
+	}
+	us := services.NewUserService(t.userDB, t.txDB, fm)
+
+	passwordHash, err := util.HashPassword("password")
+	if err != nil {
+		return err
+	}
+	var userIDs []uint
+	for _, email := range []string{"tokens@gmail.com", "other@gmail.com"} {
+		err = t.userDB.AddUser(ctx, user_schemas.AddUser{Username: strings.Split(email, "@")[0], Email: email, PasswordHash: passwordHash})
+		if err != nil {
+			return err
+		}
+		userDB, err := t.userDB.GetUser(ctx, user_schemas.GetUser{Email: email})
+		if err != nil {
+			return err
+		}
+		userIDs = append(userIDs, userDB.UserID)
+	}
+	userID, otherID := userIDs[0], userIDs[1]
+
+	for name, scopes := range map[string][]string{
+		"no scopes":     nil,
+		"unknown scope": {user_schemas.ScopeItemsRead, "admin"},
+	} {
+		_, err = us.CreateAPIToken(ctx, user_schemas.AddAPIToken{UserID: userID, Name: "bad", Scopes: scopes})
+		if !errors.Is(err, E.ErrUnprocessableEntity) {
+			return fmt.Errorf("Token with %s should be refused, got %v", name, err)
+		}
+	}
+
+	created, err := us.CreateAPIToken(ctx, user_schemas.AddAPIToken{
+		UserID: userID,
+		Name:   "shortcut",
+		Scopes: []string{user_schemas.ScopeItemsWrite, user_schemas.ScopeItemsRead, user_schemas.ScopeItemsRead},
+	})
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(created.Token, util.APITokenPrefix) {
+		return fmt.Errorf("Token should start with %q, got %q", util.APITokenPrefix, created.Token)
+	}
+	var stored int
+	err = t.database.DB.QueryRow(`SELECT COUNT(*) FROM api_tokens WHERE token_hash = ?`, created.Token).Scan(&stored)
+	if err != nil {
+		return err
+	}
+	if stored != 0 {
+		return fmt.Errorf("Token should only be stored hashed")
+	}
+
+	userDB, scopes, err := us.GetUserByAPIToken(ctx, created.Token)
+	if err != nil {
+		return fmt.Errorf("Fresh token should be valid, got %v", err)
+	}
+	if userDB.UserID != userID || strings.Join(scopes, " ") != "items:read items:write" {
+		return fmt.Errorf("Token resolved to user %d with scopes %v", userDB.UserID, scopes)
+	}
+	tokens, err := us.GetAPITokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if len(tokens) != 1 || tokens[0].LastUsedAt.IsZero() || !tokens[0].ExpiresAt.IsZero() {
+		return fmt.Errorf("Used token without expiry should be listed with its last use, got %+v", tokens)
+	}
+	for _, token := range []string{"", "pd_unknown", strings.TrimPrefix(created.Token, util.APITokenPrefix)} {
+		_, _, err = us.GetUserByAPIToken(ctx, token)
+		if !errors.Is(err, E.ErrUnprocessableEntity) {
+			return fmt.Errorf("Token %q should be refused, got %v", token, err)
+		}
+	}
+
+	// Expiry
+	expiring, err := us.CreateAPIToken(ctx, user_schemas.AddAPIToken{
+		UserID: userID, Name: "expiring", ExpiresInDays: 30, Scopes: []string{user_schemas.ScopeProductsWrite},
+	})
+	if err != nil {
+		return err
+	}
+	if expiring.APIToken.ExpiresAt.IsZero() {
+		return fmt.Errorf("Token with ExpiresInDays should get an expiry")
+	}
+	_, _, err = us.GetUserByAPIToken(ctx, expiring.Token)
+	if err != nil {
+		return fmt.Errorf("Token before its expiry should be valid, got %v", err)
+	}
+	_, err = t.database.DB.Exec(`UPDATE api_tokens SET expires_at = datetime('now', '-1 minute') WHERE token_id = ?`,
+		expiring.APIToken.TokenID)
+	if err != nil {
+		return err
+	}
+	_, _, err = us.GetUserByAPIToken(ctx, expiring.Token)
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Expired token should be refused, got %v", err)
+	}
+
+	// Revoking
+	err = us.RevokeAPIToken(ctx, user_schemas.GetAPIToken{UserID: otherID, TokenID: created.APIToken.TokenID})
+	if !errors.Is(err, E.ErrNotFound) {
+		return fmt.Errorf("Revoking the token of another user should not be found, got %v", err)
+	}
+	err = us.RevokeAPIToken(ctx, user_schemas.GetAPIToken{UserID: userID, TokenID: created.APIToken.TokenID})
+	if err != nil {
+		return err
+	}
+	_, _, err = us.GetUserByAPIToken(ctx, created.Token)
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Revoked token should be refused, got %v", err)
+	}
+
+	return nil
+}
+
+// testAPITokenMiddleware checks bearer authentication, scopes and that token
+// requests skip the CSRF check while cookie requests do not.
+func testAPITokenMiddleware() error {
+	resolver := testResolver{
+		sessionUUID: uuid.New(),
+		userDB:      user_schemas.UserDB{UserID: 5, Username: "token"},
+		token:       "pd_test",
+		scopes:      []string{user_schemas.ScopeItemsRead},
+	}
+	var gotUser user_schemas.UserDB
+	var gotOK bool
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		gotUser, gotOK = middleware.UserFromContext(r.Context())
+	}
+	stack := middleware.CreateStack(middleware.Authenticate(resolver), middleware.CSRF([]byte("test secret")))
+	read := stack(middleware.RequireScope(user_schemas.ScopeItemsRead, handler))
+	write := stack(middleware.RequireScope(user_schemas.ScopeItemsWrite, handler))
+	profile := stack(middleware.RequireUser(handler))
+
+	request := func(h http.Handler, authorization string, cookie bool) *httptest.ResponseRecorder {
+		gotUser, gotOK = user_schemas.UserDB{}, false
+		r := httptest.NewRequest(http.MethodPost, "/api/items/getitems", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		if cookie {
+			r.AddCookie(&http.Cookie{Name: "session", Value: resolver.sessionUUID.String()})
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request(read, "Bearer pd_test", false)
+	if w.Code != http.StatusOK || !gotOK || gotUser.UserID != resolver.userDB.UserID {
+		return fmt.Errorf("Token with the scope should reach the handler without CSRF token, got %d", w.Code)
+	}
+	w = request(write, "Bearer pd_test", false)
+	if w.Code != http.StatusForbidden || gotOK {
+		return fmt.Errorf("Token without the scope should get 403, got %d", w.Code)
+	}
+	w = request(profile, "Bearer pd_test", false)
+	if w.Code != http.StatusForbidden || gotOK {
+		return fmt.Errorf("Token should not reach routes without a scope, got %d", w.Code)
+	}
+	for _, authorization := range []string{"Bearer pd_wrong", "Basic dXNlcjpwYXNz", "Bearer "} {
+		w = request(read, authorization, true)
+		if w.Code != http.StatusUnauthorized || gotOK || w.Header().Get("WWW-Authenticate") == "" {
+			return fmt.Errorf("Authorization %q should get 401 even with a session cookie, got %d", authorization, w.Code)
+		}
+	}
+
+	// Sessions keep every scope, but still need the CSRF token
+	w = request(write, "", true)
+	if w.Code != http.StatusForbidden || gotOK {
+		return fmt.Errorf("Session without CSRF token should get 403, got %d", w.Code)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/middleware"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/google/uuid"
+)
+
+type testResolver struct {
+	sessionUUID uuid.UUID
+	userDB      user_schemas.UserDB
+	token       string
+	scopes      []string
+}
+
+func (tr testResolver) GetUserBySession(ctx context.Context, sessionUUID uuid.UUID) (user_schemas.UserDB, error) {
+	if sessionUUID != tr.sessionUUID {
+		return user_schemas.UserDB{}, E.ErrUnprocessableEntity
+	}
+	return tr.userDB, nil
+}
+
+func (tr testResolver) GetUserByAPIToken(ctx context.Context, token string) (user_schemas.UserDB, []string, error) {
+	if tr.token == "" || token != tr.token {
+		return user_schemas.UserDB{}, nil, E.ErrUnprocessableEntity
+	}
+	return tr.userDB, tr.scopes, nil
+}
+
+func TestAuthentication() error {
+	resolver := testResolver{
+		sessionUUID: uuid.New(),
+		userDB:      user_schemas.UserDB{UserID: 7, Username: "auth"},
+	}
+	var gotUser user_schemas.UserDB
+	var gotOK bool
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		gotUser, gotOK = middleware.UserFromContext(r.Context())
+	}
+	authenticate := middleware.Authenticate(resolver)
+	required := authenticate(middleware.RequireUser(handler))
+	optional := authenticate(middleware.OptionalUser(handler))
+
+	request := func(h http.Handler, sessionUUID uuid.UUID, htmx bool) *httptest.ResponseRecorder {
+		gotUser, gotOK = user_schemas.UserDB{}, false
+		r := httptest.NewRequest(http.MethodPost, "/api/items/getitems", nil)
+		if sessionUUID != (uuid.UUID{}) {
+			r.AddCookie(&http.Cookie{Name: "session", Value: sessionUUID.String()})
+		}
+		if htmx {
+			r.Header.Set("HX-Request", "true")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request(required, uuid.UUID{}, false)
+	if w.Code != http.StatusUnauthorized || gotOK {
+		return fmt.Errorf("Anonymous request to a required route should get 401, got %d", w.Code)
+	}
+	w = request(required, uuid.UUID{}, true)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("HX-Redirect") != middleware.LoginPath {
+		return fmt.Errorf("Anonymous HTMX request should be redirected to login, got %d %q",
+			w.Code, w.Header().Get("HX-Redirect"))
+	}
+	w = request(required, uuid.New(), false)
+	if w.Code != http.StatusUnauthorized {
+		return fmt.Errorf("Unknown session should get 401, got %d", w.Code)
+	}
+	w = request(required, resolver.sessionUUID, false)
+	if w.Code != http.StatusOK || !gotOK || gotUser.UserID != resolver.userDB.UserID {
+		return fmt.Errorf("Valid session should reach the handler with its user, got %d %#v", w.Code, gotUser)
+	}
+
+	w = request(optional, uuid.UUID{}, false)
+	if w.Code != http.StatusOK || gotOK {
+		return fmt.Errorf("Anonymous request to an optional route should pass without user, got %d", w.Code)
+	}
+	w = request(optional, resolver.sessionUUID, false)
+	if !gotOK || gotUser.UserID != resolver.userDB.UserID {
+		return fmt.Errorf("Optional route should see the user of a valid session")
+	}
+
+	return nil
+}
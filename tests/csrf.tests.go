@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/bmg-c/product-diary/middleware"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/google/uuid"
+)
+
+func TestCSRF() error {
+	secret := []byte("test secret")
+	resolver := testResolver{
+		sessionUUID: uuid.New(),
+		userDB:      user_schemas.UserDB{UserID: 3, Username: "csrf"},
+	}
+	otherResolver := testResolver{
+		sessionUUID: uuid.New(),
+		userDB:      user_schemas.UserDB{UserID: 4, Username: "other"},
+	}
+	var token string
+	var reached bool
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		token = middleware.CSRFTokenFromContext(r.Context())
+	})
+	stack := middleware.CreateStack(middleware.Authenticate(resolver), middleware.CSRF(secret))(handler)
+	otherStack := middleware.CreateStack(middleware.Authenticate(otherResolver), middleware.CSRF(secret))(handler)
+	foreignStack := middleware.CreateStack(middleware.Authenticate(resolver), middleware.CSRF([]byte("other")))(handler)
+
+	type request struct {
+		method  string
+		cookies []*http.Cookie
+		header  string
+		form    url.Values
+		query   url.Values
+	}
+	do := func(h http.Handler, req request) *httptest.ResponseRecorder {
+		reached = false
+		target := "/api/items/additem?" + req.query.Encode()
+		var r *http.Request
+		if req.form != nil {
+			r = httptest.NewRequest(req.method, target, strings.NewReader(req.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			r = httptest.NewRequest(req.method, target, nil)
+		}
+		for _, cookie := range req.cookies {
+			r.AddCookie(cookie)
+		}
+		if req.header != "" {
+			r.Header.Set(middleware.CSRFHeader, req.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	sessionCookie := &http.Cookie{Name: "session", Value: resolver.sessionUUID.String()}
+	otherCookie := &http.Cookie{Name: "session", Value: otherResolver.sessionUUID.String()}
+
+	// Pages hand out the token of the session
+	w := do(stack, request{method: http.MethodGet, cookies: []*http.Cookie{sessionCookie}})
+	if w.Code != http.StatusOK || !reached || token == "" {
+		return fmt.Errorf("GET should pass and get a token, got %d", w.Code)
+	}
+	sessionToken := token
+	do(otherStack, request{method: http.MethodGet, cookies: []*http.Cookie{otherCookie}})
+	otherToken := token
+	if otherToken == sessionToken {
+		return fmt.Errorf("Different sessions should get different tokens")
+	}
+
+	rejected := map[string]request{
+		"missing token": {method: http.MethodPost, cookies: []*http.Cookie{sessionCookie}},
+		"wrong token":   {method: http.MethodPost, cookies: []*http.Cookie{sessionCookie}, header: "bad"},
+		"token of another session": {
+			method: http.MethodPost, cookies: []*http.Cookie{sessionCookie}, header: otherToken,
+		},
+		"token with different case": {
+			method: http.MethodPost, cookies: []*http.Cookie{sessionCookie}, header: strings.ToUpper(sessionToken),
+		},
+		"token in query only": {method: http.MethodPost, cookies: []*http.Cookie{sessionCookie},
+			form: url.Values{}, query: url.Values{middleware.CSRFFormField: {sessionToken}}},
+		"other unsafe method": {method: http.MethodDelete, cookies: []*http.Cookie{sessionCookie}},
+		"lowercase method":    {method: "post", cookies: []*http.Cookie{sessionCookie}},
+		"token without session cookie": {
+			method: http.MethodPost, header: sessionToken,
+		},
+		"empty form token": {method: http.MethodPost, cookies: []*http.Cookie{sessionCookie},
+			form: url.Values{middleware.CSRFFormField: {""}}},
+	}
+	for name, req := range rejected {
+		w := do(stack, req)
+		if w.Code != http.StatusForbidden || reached {
+			return fmt.Errorf("CSRF: request with %s should get 403, got %d", name, w.Code)
+		}
+	}
+	w = do(foreignStack, request{method: http.MethodPost, cookies: []*http.Cookie{sessionCookie}, header: sessionToken})
+	if w.Code != http.StatusForbidden {
+		return fmt.Errorf("Token signed with another secret should get 403, got %d", w.Code)
+	}
+
+	w = do(stack, request{method: http.MethodPost, cookies: []*http.Cookie{sessionCookie}, header: sessionToken})
+	if w.Code != http.StatusOK || !reached {
+		return fmt.Errorf("POST with the header token should pass, got %d", w.Code)
+	}
+	w = do(stack, request{method: http.MethodPost, cookies: []*http.Cookie{sessionCookie},
+		form: url.Values{middleware.CSRFFormField: {sessionToken}}})
+	if w.Code != http.StatusOK || !reached {
+		return fmt.Errorf("POST with the form token should pass, got %d", w.Code)
+	}
+
+	// Anonymous visitors are bound to their CSRF cookie
+	w = do(stack, request{method: http.MethodGet})
+	var anonCookie *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "csrf" {
+			anonCookie = cookie
+		}
+	}
+	if anonCookie == nil {
+		return fmt.Errorf("Anonymous GET should set the CSRF cookie")
+	}
+	anonToken := token
+	w = do(stack, request{method: http.MethodPost, cookies: []*http.Cookie{anonCookie}, header: anonToken})
+	if w.Code != http.StatusOK {
+		return fmt.Errorf("Anonymous POST with its token should pass, got %d", w.Code)
+	}
+	w = do(stack, request{method: http.MethodPost, header: anonToken})
+	if w.Code != http.StatusForbidden {
+		return fmt.Errorf("Anonymous token without its cookie should get 403, got %d", w.Code)
+	}
+	w = do(stack, request{method: http.MethodPost, cookies: []*http.Cookie{sessionCookie, anonCookie}, header: anonToken})
+	if w.Code != http.StatusForbidden {
+		return fmt.Errorf("Anonymous token should not be valid once logged in, got %d", w.Code)
+	}
+
+	return nil
+}
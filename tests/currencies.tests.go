@@ -0,0 +1,218 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	L "github.com/bmg-c/product-diary/localization"
+	"github.com/bmg-c/product-diary/schemas"
+	"github.com/bmg-c/product-diary/schemas/currency_schemas"
+	"github.com/bmg-c/product-diary/schemas/item_schemas"
+	"github.com/bmg-c/product-diary/schemas/product_schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+)
+
+func TestCurrencies() error {
+	err := testFormatMoney()
+	if err == nil {
+		err = testRates()
+	}
+	if err != nil {
+		return err
+	}
+	re := regexp.MustCompile(schemas.DefRV.CurrencyRegex)
+	for _, currency := range currency_schemas.Currencies {
+		if !re.MatchString(currency) {
+			return fmt.Errorf("%s is not allowed by the currency format", currency)
+		}
+	}
+
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	ps := services.NewProductService(t.productDB)
+	is := services.NewItemService(t.itemDB, t.currencyDB, t.txDB)
+	cs := services.NewCurrencyService(t.currencyDB, t.txDB)
+
+	err = t.userDB.AddUser(ctx, user_schemas.AddUser{Username: "traveller", Email: "traveller@gmail.com"})
+	if err != nil {
+		return err
+	}
+	userDB, err := t.userDB.GetUser(ctx, user_schemas.GetUser{Email: "traveller@gmail.com"})
+	if err != nil {
+		return err
+	}
+	if userDB.BaseCurrency != currency_schemas.DefaultCurrency {
+		return fmt.Errorf("New users should count in %s, got %q", currency_schemas.DefaultCurrency, userDB.BaseCurrency)
+	}
+
+	// Import
+	result, err := cs.ImportRates(ctx, currency_schemas.ImportRates{UserID: userDB.UserID,
+		CSV: "date,from,to,rate\n2024-05-01,EUR,RUB,100\n2024-05-01,rub,usd,\"0,01\"\n2024-05-03,EUR,RUB,110\n"})
+	if err != nil {
+		return err
+	}
+	if result.Imported != 3 {
+		return fmt.Errorf("3 rates should be imported, got %d", result.Imported)
+	}
+	result, err = cs.ImportRates(ctx, currency_schemas.ImportRates{UserID: userDB.UserID,
+		CSV: "2024-05-01,EUR,RUB,101\n2024-05-02,EUR,EUR,1\n"})
+	if !errors.Is(err, E.ErrUnprocessableEntity) || result.BadLine != 2 {
+		return fmt.Errorf("A rate of a currency to itself should be refused on line 2, got %v on %d", err, result.BadLine)
+	}
+	// Importing the same date again replaces the rate
+	_, err = cs.ImportRates(ctx, currency_schemas.ImportRates{UserID: userDB.UserID, CSV: "2024-05-01,EUR,RUB,101\n"})
+	if err != nil {
+		return err
+	}
+	rates, err := cs.GetRates(ctx, currency_schemas.GetRates{UserID: userDB.UserID})
+	if err != nil {
+		return err
+	}
+	if len(rates) != 3 || rates[0].CurrencyFrom != currency_schemas.CurrencyEUR || rates[1].Rate != 101 {
+		return fmt.Errorf("The rate of 2024-05-01 should be replaced, got %+v", rates)
+	}
+
+	cheese, err := ps.AddProduct(ctx, product_schemas.AddProduct{
+		ProductTitle: "Cheese", ProductType: product_schemas.ProductTypeFood, UserID: userDB.UserID,
+	})
+	if err != nil {
+		return err
+	}
+	personDB, err := t.userDB.AddPerson(ctx, user_schemas.GetPerson{UserID: userDB.UserID, PersonName: "Friend"})
+	if err != nil {
+		return err
+	}
+	date := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for _, item := range []item_schemas.AddItem{
+		{ItemCost: 1000, ItemAmount: 1, ItemDate: date},
+		// 10.10 RUB
+		{ItemCost: 10, ItemAmount: 1, ItemDate: date, Currency: currency_schemas.CurrencyEUR},
+		// 11.00 RUB on the newer rate
+		{ItemCost: 10, ItemAmount: 1, ItemDate: date.AddDate(0, 0, 3), Currency: currency_schemas.CurrencyEUR},
+		// 1.00 RUB through the inverse rate
+		{ItemCost: 1, ItemAmount: 1, ItemDate: date, Currency: currency_schemas.CurrencyUSD},
+		// No rate at all
+		{ItemCost: 500, ItemAmount: 1, ItemDate: date, Currency: currency_schemas.CurrencyGBP},
+		// The friend is owed 2.02 RUB
+		{ItemCost: 2, ItemAmount: 1, ItemDate: date, Currency: currency_schemas.CurrencyEUR,
+			ItemType: item_schemas.ItemTypeFromPersonPurchase, PersonID: personDB.PersonID},
+	} {
+		item.UserID = userDB.UserID
+		item.ProductID = cheese.ProductID
+		_, err = is.AddItem(ctx, item)
+		if err != nil {
+			return err
+		}
+	}
+
+	a, err := is.GetAnalyticsRange(ctx, item_schemas.GetItemsRange{UserID: userDB.UserID,
+		ItemDateFrom: date, ItemDateTo: date.AddDate(0, 0, 3), BaseCurrency: currency_schemas.CurrencyRUB})
+	if err != nil {
+		return err
+	}
+	if a.TotalSpent != 1000+1010+1100+100+202 {
+		return fmt.Errorf("The items should cost %d in RUB, got %d", 1000+1010+1100+100+202, a.TotalSpent)
+	}
+	if !slices.Equal(a.MissingRates, []string{currency_schemas.CurrencyGBP}) {
+		return fmt.Errorf("GBP should have no rate, got %v", a.MissingRates)
+	}
+	if !slices.Equal(a.Currencies, []item_schemas.CurrencyAnalytics{{Currency: "RUB", TotalSpent: 1000},
+		{Currency: "EUR", TotalSpent: 22}, {Currency: "USD", TotalSpent: 1}, {Currency: "GBP", TotalSpent: 500}}) {
+		return fmt.Errorf("The items should be paid 10.00 RUB, 0.22 EUR, 0.01 USD and 5.00 GBP, got %+v", a.Currencies)
+	}
+	if len(a.Persons) != 1 || a.Persons[0].TotalDebt != 202 {
+		return fmt.Errorf("The debt should be 2.02 in RUB, got %+v", a.Persons)
+	}
+
+	// The same items in euros
+	a, err = is.GetAnalyticsRange(ctx, item_schemas.GetItemsRange{UserID: userDB.UserID,
+		ItemDateFrom: date, ItemDateTo: date, BaseCurrency: currency_schemas.CurrencyEUR})
+	if err != nil {
+		return err
+	}
+	if !slices.Equal(a.MissingRates, []string{currency_schemas.CurrencyUSD, currency_schemas.CurrencyGBP}) {
+		return fmt.Errorf("USD and GBP should have no rate to EUR, got %v", a.MissingRates)
+	}
+	// 1000 / 101 + 10 + 2
+	if a.TotalSpent != 22 {
+		return fmt.Errorf("The items should cost 0.22 EUR, got %d", a.TotalSpent)
+	}
+
+	err = cs.DeleteRate(ctx, currency_schemas.DeleteRate{RateID: rates[0].RateID, UserID: userDB.UserID + 1})
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Rates of other users should not be deleted, got %v", err)
+	}
+	return cs.DeleteRate(ctx, currency_schemas.DeleteRate{RateID: rates[0].RateID, UserID: userDB.UserID})
+}
+
+func testFormatMoney() error {
+	for _, c := range []struct {
+		locale   L.Locale
+		minor    int64
+		currency string
+		str      string
+	}{
+		{L.LocaleEnUS, 123450, "USD", "$1,234.50"},
+		{L.LocaleEnUS, 5, "EUR", "€0.05"},
+		{L.LocaleEnUS, 123456789, "RUB", "RUB\u00a01,234,567.89"},
+		{L.LocaleEnUS, -250, "GBP", "-£2.50"},
+		{L.LocaleRuRU, 123450, "RUB", "1\u00a0234,50\u00a0₽"},
+		{L.LocaleRuRU, 99, "USD", "0,99\u00a0$"},
+	} {
+		str := L.FormatMoney(c.locale, c.minor, c.currency)
+		if str != c.str {
+			return fmt.Errorf("%d %s should be %q, got %q", c.minor, c.currency, c.str, str)
+		}
+	}
+	return nil
+}
+
+func testRates() error {
+	day := func(d int) time.Time {
+		return time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC)
+	}
+	r := services.NewRates([]currency_schemas.RateDB{
+		{RateDate: day(10), CurrencyFrom: "EUR", CurrencyTo: "RUB", Rate: 110},
+		{RateDate: day(1), CurrencyFrom: "EUR", CurrencyTo: "RUB", Rate: 100},
+		{RateDate: day(5), CurrencyFrom: "RUB", CurrencyTo: "EUR", Rate: 0.01 / 1.05},
+	})
+	for _, c := range []struct {
+		from string
+		to   string
+		date time.Time
+		rate float64
+		ok   bool
+	}{
+		{"EUR", "RUB", day(1), 100, true},
+		{"EUR", "RUB", day(4), 100, true},
+		// The inverse rate is newer
+		{"EUR", "RUB", day(7), 105, true},
+		{"EUR", "RUB", day(20), 110, true},
+		{"RUB", "EUR", day(2), 0.01, true},
+		{"EUR", "RUB", time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC), 0, false},
+		{"USD", "RUB", day(20), 0, false},
+		{"USD", "USD", day(20), 1, true},
+	} {
+		rate, ok := r.Rate(c.from, c.to, c.date)
+		if ok != c.ok || (ok && (rate < c.rate*0.999999 || rate > c.rate*1.000001)) {
+			return fmt.Errorf("%s to %s on %s should be %v, got %v %v", c.from, c.to,
+				c.date.Format("2006-01-02"), c.rate, rate, ok)
+		}
+	}
+	converted, ok := r.Convert(333, "EUR", "RUB", day(1))
+	if !ok || converted != 33300 {
+		return fmt.Errorf("3.33 EUR should be 333.00 RUB, got %d", converted)
+	}
+	return nil
+}
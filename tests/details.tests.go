@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/schemas"
+	"github.com/bmg-c/product-diary/schemas/product_schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+)
+
+func TestProductDetails() error {
+	err := testProductDetailsValidation()
+	if err != nil {
+		return err
+	}
+
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	ps := services.NewProductService(t.productDB)
+
+	err = t.userDB.AddUser(ctx, user_schemas.AddUser{Username: "gadgeteer", Email: "gadgeteer@gmail.com"})
+	if err != nil {
+		return err
+	}
+	userDB, err := t.userDB.GetUser(ctx, user_schemas.GetUser{Email: "gadgeteer@gmail.com"})
+	if err != nil {
+		return err
+	}
+
+	// Nutrients are only kept for food
+	phone, err := ps.AddProduct(ctx, product_schemas.AddProduct{
+		ProductTitle: "Phone", Manufacturer: "Acme", ProductType: product_schemas.ProductTypeElectronics,
+		ProductCalories: 100, ProductFats: 5, UserID: userDB.UserID,
+	})
+	if err != nil {
+		return err
+	}
+	if phone.ProductCalories != 0 || phone.ProductFats != 0 {
+		return fmt.Errorf("Electronics should be stored without nutrients, got %+v", phone)
+	}
+	_, err = ps.AddProduct(ctx, product_schemas.AddProduct{
+		ProductTitle: "Apple", Manufacturer: "Fruitful", ProductType: product_schemas.ProductTypeFood,
+		ProductCalories: 52, UserID: userDB.UserID,
+	})
+	if err != nil {
+		return err
+	}
+	stored, err := ps.GetProduct(ctx, product_schemas.GetProduct{ProductID: phone.ProductID})
+	if err != nil {
+		return err
+	}
+	if stored.Manufacturer != "Acme" || stored.ProductType != product_schemas.ProductTypeElectronics ||
+		stored.CreatorName != "gadgeteer" {
+		return fmt.Errorf("Product details were not stored, got %+v", stored)
+	}
+
+	// Search covers manufacturer, type and creator
+	for query, want := range map[string]string{
+		"acme":        "Phone",
+		"fruitful":    "Apple",
+		"electronics": "Phone",
+		"электроника": "Phone",
+		"food":        "Apple",
+		"gadgeteer":   "Apple Phone",
+	} {
+		products, err := ps.GetProducts(ctx, product_schemas.GetProducts{SearchQuery: query})
+		if err != nil {
+			return err
+		}
+		titles := []string{}
+		for _, productDB := range products {
+			titles = append(titles, productDB.ProductTitle)
+		}
+		sort.Strings(titles)
+		if strings.Join(titles, " ") != want {
+			return fmt.Errorf("Search %q should find %q, got %v", query, want, titles)
+		}
+	}
+
+	// The type can not move between food and the other types
+	change := product_schemas.ChangeProduct{
+		ProductID: phone.ProductID, UserID: userDB.UserID, ProductTitle: "Phone",
+		Manufacturer: "Acme", ProductType: product_schemas.ProductTypeFood,
+	}
+	_, err = ps.ChangeProduct(ctx, change)
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Electronics should not become food, got %v", err)
+	}
+	change.ProductType = product_schemas.ProductTypeOther
+	change.Manufacturer = "Acme Corp"
+	phone, err = ps.ChangeProduct(ctx, change)
+	if err != nil {
+		return err
+	}
+	if phone.ProductType != product_schemas.ProductTypeOther || phone.Manufacturer != "Acme Corp" {
+		return fmt.Errorf("Changed product details should be returned, got %+v", phone)
+	}
+	diff, err := ps.GetRevisionDiff(ctx, product_schemas.GetRevisionDiff{ProductID: phone.ProductID, From: 1, To: 2})
+	if err != nil {
+		return err
+	}
+	expected := []product_schemas.FieldChange{
+		{Field: "manufacturer", Old: "Acme", New: "Acme Corp"},
+		{Field: "product_type", Old: "2", New: "3"},
+	}
+	if fmt.Sprint(diff.Changes) != fmt.Sprint(expected) {
+		return fmt.Errorf("Diff should be %v, got %v", expected, diff.Changes)
+	}
+
+	return nil
+}
+
+func testProductDetailsValidation() error {
+	for name, c := range map[string]struct {
+		data  product_schemas.AddProduct
+		valid bool
+	}{
+		"food":              {product_schemas.AddProduct{ProductTitle: "Bread", ProductType: product_schemas.ProductTypeFood, UserID: 1}, true},
+		"without type":      {product_schemas.AddProduct{ProductTitle: "Bread", UserID: 1}, false},
+		"unknown type":      {product_schemas.AddProduct{ProductTitle: "Bread", ProductType: 4, UserID: 1}, false},
+		"long manufacturer": {product_schemas.AddProduct{ProductTitle: "Bread", Manufacturer: strings.Repeat("m", 65), ProductType: product_schemas.ProductTypeOther, UserID: 1}, false},
+	} {
+		ve := schemas.ValidateStruct(c.data)
+		if (ve == nil) != c.valid {
+			return fmt.Errorf("Product %s should be valid %t, got %v", name, c.valid, ve)
+		}
+	}
+	return nil
+}
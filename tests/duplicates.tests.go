@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/schemas/product_schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+	"github.com/bmg-c/product-diary/util"
+)
+
+func TestDuplicateProducts() error {
+	err := testNormalizeTitle()
+	if err == nil {
+		err = testSimilarity()
+	}
+	if err != nil {
+		return err
+	}
+
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	ps := services.NewProductService(t.productDB)
+
+	err = t.userDB.AddUser(ctx, user_schemas.AddUser{Username: "dairy", Email: "dairy@gmail.com"})
+	if err != nil {
+		return err
+	}
+	userDB, err := t.userDB.GetUser(ctx, user_schemas.GetUser{Email: "dairy@gmail.com"})
+	if err != nil {
+		return err
+	}
+	milk := product_schemas.AddProduct{
+		ProductTitle: "Milk 3.2%", Manufacturer: "Farm", ProductType: product_schemas.ProductTypeFood,
+		ProductCalories: 58, ProductFats: 3.2, ProductCarbs: 4.7, ProductProteins: 2.9, UserID: userDB.UserID,
+	}
+	milkDB, err := ps.AddProduct(ctx, milk)
+	if err != nil {
+		return err
+	}
+
+	// Identical products are refused, however the title is spelled
+	copied := milk
+	copied.ProductTitle = "milk 3,2 %"
+	copied.Manufacturer = "FARM"
+	_, err = ps.AddProduct(ctx, copied)
+	if !errors.Is(err, E.ErrConflict) {
+		return fmt.Errorf("Identical product should conflict, got %v", err)
+	}
+	skimmed := copied
+	skimmed.ProductFats = 0.5
+	skimmedDB, err := ps.AddProduct(ctx, skimmed)
+	if err != nil {
+		return fmt.Errorf("Product with other nutrients should be added, got %v", err)
+	}
+
+	// Near titles are suggested, identical products conflict
+	_, err = ps.SimilarProducts(ctx, copied)
+	if !errors.Is(err, E.ErrConflict) {
+		return fmt.Errorf("Identical product should conflict before it is suggested, got %v", err)
+	}
+	for title, want := range map[string]int{
+		"Mlik 3.2%":   2,
+		"milk 3,2":    2,
+		"Cheese 3.2%": 0,
+	} {
+		similar, err := ps.SimilarProducts(ctx, product_schemas.AddProduct{ProductTitle: title, ProductType: product_schemas.ProductTypeOther})
+		if err != nil {
+			return err
+		}
+		if len(similar) != want {
+			return fmt.Errorf("Expected %d products similar to %q, got %+v", want, title, similar)
+		}
+	}
+
+	// Changes can not make a product identical to another one
+	change := product_schemas.ChangeProduct{
+		ProductID: skimmedDB.ProductID, UserID: userDB.UserID, ProductTitle: "Skimmed milk",
+		Manufacturer: "Farm", ProductType: product_schemas.ProductTypeFood,
+	}
+	_, err = ps.ChangeProduct(ctx, change)
+	if err != nil {
+		return err
+	}
+	_, err = ps.AddProduct(ctx, milk)
+	if !errors.Is(err, E.ErrConflict) {
+		return fmt.Errorf("Identical product should still conflict after a change, got %v", err)
+	}
+
+	// Deleted products do not count
+	err = ps.DeleteProduct(ctx, product_schemas.DeleteProduct{ProductID: milkDB.ProductID, UserID: userDB.UserID})
+	if err != nil {
+		return err
+	}
+	_, err = ps.AddProduct(ctx, milk)
+	if err != nil {
+		return fmt.Errorf("Product identical to a deleted one should be added, got %v", err)
+	}
+	return nil
+}
+
+func testNormalizeTitle() error {
+	for title, want := range map[string]string{
+		"Milk 3.2%":     "milk3.2",
+		"milk 3,2 %":    "milk3.2",
+		"Milk.":         "milk",
+		"Молоко, 2.5 %": "молоко2.5",
+		"  ":            "",
+	} {
+		got := product_schemas.NormalizeTitle(title)
+		if got != want {
+			return fmt.Errorf("Normalized %q should be %q, got %q", title, want, got)
+		}
+	}
+	return nil
+}
+
+func testSimilarity() error {
+	for _, c := range []struct {
+		a, b string
+		want float64
+	}{
+		{"milk", "milk", 1},
+		{"milk", "mlik", 0.75},
+		{"milk", "silk", 0.75},
+		{"milk", "", 0},
+		{"", "", 1},
+		{"abc", "xyz", 0},
+	} {
+		got := util.Similarity(c.a, c.b)
+		if got != c.want {
+			return fmt.Errorf("Similarity of %q and %q should be %v, got %v", c.a, c.b, c.want, got)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/schemas/currency_schemas"
+	"github.com/bmg-c/product-diary/schemas/item_schemas"
+	"github.com/bmg-c/product-diary/schemas/ledger_schemas"
+	"github.com/bmg-c/product-diary/schemas/product_schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+)
+
+func TestLedger() error {
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	ps := services.NewProductService(t.productDB)
+	is := services.NewItemService(t.itemDB, t.currencyDB, t.txDB)
+	ls := services.NewLedgerService(t.ledgerDB, t.itemDB, t.currencyDB, t.userDB)
+
+	users := []user_schemas.UserDB{}
+	for _, username := range []string{"lender", "stranger"} {
+		err = t.userDB.AddUser(ctx, user_schemas.AddUser{Username: username, Email: username + "@gmail.com"})
+		if err != nil {
+			return err
+		}
+		userDB, err := t.userDB.GetUser(ctx, user_schemas.GetUser{Email: username + "@gmail.com"})
+		if err != nil {
+			return err
+		}
+		users = append(users, userDB)
+	}
+	userDB := users[0]
+	alice, err := t.userDB.AddPerson(ctx, user_schemas.GetPerson{UserID: userDB.UserID, PersonName: "Alice"})
+	if err != nil {
+		return err
+	}
+	bob, err := t.userDB.AddPerson(ctx, user_schemas.GetPerson{UserID: userDB.UserID, PersonName: "Bob"})
+	if err != nil {
+		return err
+	}
+	eve, err := t.userDB.AddPerson(ctx, user_schemas.GetPerson{UserID: users[1].UserID, PersonName: "Eve"})
+	if err != nil {
+		return err
+	}
+	bread, err := ps.AddProduct(ctx, product_schemas.AddProduct{
+		ProductTitle: "Bread", ProductType: product_schemas.ProductTypeFood, UserID: userDB.UserID,
+	})
+	if err != nil {
+		return err
+	}
+
+	day := func(d int) time.Time {
+		return time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC)
+	}
+	for _, item := range []item_schemas.AddItem{
+		// Alice bought bread for the user
+		{ItemCost: 1000, ItemDate: day(1), ItemType: item_schemas.ItemTypeFromPersonPurchase, PersonID: alice.PersonID},
+		// The user bought bread for Alice
+		{ItemCost: 300, ItemDate: day(2), ItemType: item_schemas.ItemTypeToPersonPurchase, PersonID: alice.PersonID},
+		// Bought by the user for themselves, Alice is only remembered
+		{ItemCost: 5000, ItemDate: day(2), ItemType: item_schemas.ItemTypeMyPurchase, PersonID: alice.PersonID},
+		// No rate from EUR
+		{ItemCost: 700, ItemDate: day(1), ItemType: item_schemas.ItemTypeFromPersonPurchase, PersonID: bob.PersonID,
+			Currency: currency_schemas.CurrencyEUR},
+	} {
+		item.UserID = userDB.UserID
+		item.ProductID = bread.ProductID
+		item.ItemAmount = 1
+		item.PriceMode = item_schemas.PriceModeTotal
+		_, err = is.AddItem(ctx, item)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Payments go after the items of the same date
+	payments := []ledger_schemas.PaymentDB{}
+	for _, payment := range []ledger_schemas.AddPayment{
+		{PersonID: alice.PersonID, PaymentDate: day(2), PaymentType: ledger_schemas.PaymentTypeToPerson, Amount: 500,
+			Note: "Cash"},
+		{PersonID: alice.PersonID, PaymentDate: day(1), PaymentType: ledger_schemas.PaymentTypeFromPerson, Amount: 100},
+	} {
+		payment.UserID = userDB.UserID
+		payment.Currency = currency_schemas.CurrencyRUB
+		paymentDB, err := ls.AddPayment(ctx, payment)
+		if err != nil {
+			return err
+		}
+		payments = append(payments, paymentDB)
+	}
+	_, err = ls.AddPayment(ctx, ledger_schemas.AddPayment{UserID: userDB.UserID, PersonID: eve.PersonID,
+		PaymentDate: day(1), PaymentType: ledger_schemas.PaymentTypeToPerson, Amount: 100, Currency: "RUB"})
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Payments to persons of other users should be refused, got %v", err)
+	}
+
+	statement, err := ls.GetStatement(ctx, ledger_schemas.GetStatement{UserID: userDB.UserID, PersonID: alice.PersonID,
+		BaseCurrency: currency_schemas.CurrencyRUB})
+	if err != nil {
+		return err
+	}
+	balances := []int64{}
+	for _, entry := range statement.Entries {
+		balances = append(balances, entry.Balance)
+	}
+	// +1000, +100 paid by Alice, -300 bought for Alice, -500 paid to Alice
+	if !slices.Equal(balances, []int64{1000, 1100, 800, 300}) || statement.Balance != 300 {
+		return fmt.Errorf("Alice's balances should be 1000 1100 800 300, got %v and %d", balances, statement.Balance)
+	}
+	if statement.Entries[3].PaymentID != payments[0].PaymentID || statement.Entries[3].Title != "Cash" {
+		return fmt.Errorf("The payment of day 2 should be the last entry, got %+v", statement.Entries[3])
+	}
+
+	all, err := ls.GetBalances(ctx, ledger_schemas.GetBalances{UserID: userDB.UserID,
+		BaseCurrency: currency_schemas.CurrencyRUB})
+	if err != nil {
+		return err
+	}
+	if len(all.Persons) != 2 || all.Persons[0].Balance != 300 || all.Persons[1].Balance != 0 {
+		return fmt.Errorf("The user should owe Alice 300 and Bob nothing converted, got %+v", all.Persons)
+	}
+	if !slices.Equal(all.MissingRates, []string{currency_schemas.CurrencyEUR}) {
+		return fmt.Errorf("EUR should have no rate, got %v", all.MissingRates)
+	}
+
+	// Bob is settled once there is a rate
+	_, err = t.currencyDB.AddRate(ctx, currency_schemas.AddRate{UserID: userDB.UserID, RateDate: day(1),
+		CurrencyFrom: "EUR", CurrencyTo: "RUB", Rate: 100})
+	if err != nil {
+		return err
+	}
+	_, err = ls.AddPayment(ctx, ledger_schemas.AddPayment{UserID: userDB.UserID, PersonID: bob.PersonID,
+		PaymentDate: day(3), PaymentType: ledger_schemas.PaymentTypeToPerson, Amount: 70000, Currency: "RUB"})
+	if err != nil {
+		return err
+	}
+	statement, err = ls.GetStatement(ctx, ledger_schemas.GetStatement{UserID: userDB.UserID, PersonID: bob.PersonID,
+		BaseCurrency: currency_schemas.CurrencyRUB})
+	if err != nil {
+		return err
+	}
+	if statement.Balance != 0 || len(statement.MissingRates) != 0 {
+		return fmt.Errorf("Bob should be settled, got %d and %v", statement.Balance, statement.MissingRates)
+	}
+
+	err = ls.DeletePayment(ctx, ledger_schemas.DeletePayment{PaymentID: payments[0].PaymentID, UserID: users[1].UserID})
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Payments of other users should not be deleted, got %v", err)
+	}
+	err = ls.DeletePayment(ctx, ledger_schemas.DeletePayment{PaymentID: payments[0].PaymentID, UserID: userDB.UserID})
+	if err != nil {
+		return err
+	}
+	statement, err = ls.GetStatement(ctx, ledger_schemas.GetStatement{UserID: userDB.UserID, PersonID: alice.PersonID,
+		BaseCurrency: currency_schemas.CurrencyRUB})
+	if err != nil {
+		return err
+	}
+	if statement.Balance != 800 {
+		return fmt.Errorf("The user should owe Alice 800 after the payment is deleted, got %d", statement.Balance)
+	}
+
+	_, err = ls.GetStatement(ctx, ledger_schemas.GetStatement{UserID: userDB.UserID, PersonID: eve.PersonID,
+		BaseCurrency: currency_schemas.CurrencyRUB})
+	if !errors.Is(err, E.ErrNotFound) {
+		return fmt.Errorf("Statements of persons of other users should not be found, got %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	L "github.com/bmg-c/product-diary/localization"
+	"github.com/bmg-c/product-diary/mailer"
+	"github.com/bmg-c/product-diary/schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+)
+
+// readMailBody parses an .eml file written by the file mailer.
+func readMailBody(fileName string) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	msg, err := mail.ReadMessage(f)
+	if err != nil {
+		return "", err
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func TestSignin() error {
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	fm, err := mailer.NewFileMailer(filepath.Join(t.dir, "mail"), "test@product-diary.local")
+	if err != nil {
+		return err
+	}
+	us := services.NewUserService(t.userDB, t.txDB, fm)
+	l := L.NewLocilizer(L.LocaleEnUS)
+
+	email := "signin@gmail.com"
+	err = us.SigninUser(ctx, user_schemas.UserSignin{Email: email}, l)
+	if err != nil {
+		return err
+	}
+	files, err := fm.Files(email)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return fmt.Errorf("Sign in should send 1 mail, got %d", len(files))
+	}
+	body, err := readMailBody(files[0])
+	if err != nil {
+		return err
+	}
+	codeRe := regexp.MustCompile(fmt.Sprintf(`\b[A-Z0-9]{%d}\b`, schemas.DefRV.CodeLength))
+	code := codeRe.FindString(body)
+	if code == "" {
+		return fmt.Errorf("Mail does not contain a confirmation code: %q", body)
+	}
+
+	// Asking again does not replace the pending code
+	err = us.SigninUser(ctx, user_schemas.UserSignin{Email: email}, l)
+	if err != nil {
+		return err
+	}
+
+	confirm := user_schemas.UserConfirmSignin{
+		Email:    email,
+		Code:     "WRONG0",
+		Username: "signin",
+		Password: "password",
+	}
+	if code == confirm.Code {
+		confirm.Code = "WRONG1"
+	}
+	err = us.ConfirmSignin(ctx, confirm)
+	if err == nil {
+		return fmt.Errorf("Sign in with a wrong code should fail")
+	}
+	confirm.Code = code
+	err = us.ConfirmSignin(ctx, confirm)
+	if err != nil {
+		return err
+	}
+
+	_, err = us.LoginUser(ctx, user_schemas.UserLogin{Email: email, Password: "password"}, user_schemas.SessionClient{})
+	if err != nil {
+		return fmt.Errorf("Login with the chosen password failed: %v", err)
+	}
+	_, err = us.LoginUser(ctx, user_schemas.UserLogin{Email: email, Password: "wrong password"}, user_schemas.SessionClient{})
+	if err == nil {
+		return fmt.Errorf("Login with a wrong password should fail")
+	}
+
+	return nil
+}
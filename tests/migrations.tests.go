@@ -0,0 +1,319 @@
+package tests
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bmg-c/product-diary/db/migrations"
+)
+
+// migrationCase runs a migration over the rows written before it, then
+// reverts it. Rows are written with before at version - 1, up runs after the
+// migration and down after reverting it.
+type migrationCase struct {
+	version uint
+	before  []string
+	up      []migrationStep
+	down    []migrationStep
+}
+
+// migrationStep runs exec, which has to fail when refused is set, and then
+// compares the rows of query to want. Columns are joined by "|" and rows by
+// ";", NULL reads as "NULL".
+type migrationStep struct {
+	exec    string
+	refused bool
+	query   string
+	want    string
+}
+
+const (
+	migrationUser    = `INSERT INTO users (username, email, password) VALUES ('old', 'old@gmail.com', '')`
+	migrationPerson  = `INSERT INTO persons (user_id, person_name, is_hidden) VALUES (1, 'Friend', FALSE)`
+	migrationProduct = `INSERT INTO products (product_title, user_id) VALUES ('Old bread', 1)`
+	migrationItem    = `INSERT INTO items (user_id, product_id, revision_id, item_date, item_cost, item_amount)
+        VALUES (1, 1, 1, '2024-05-01', 1000, 1)`
+)
+
+var migrationCases = []migrationCase{
+	{
+		// Users sharing a username are renamed, renamed users keep their name
+		version: 6,
+		before: []string{
+			`INSERT INTO users (username, email, password) VALUES ('same', 'user0@gmail.com', '')`,
+			`INSERT INTO users (username, email, password) VALUES ('same', 'user1@gmail.com', '')`,
+			`INSERT INTO users (username, email, password) VALUES ('twelve_chars', 'user2@gmail.com', '')`,
+			`INSERT INTO users (username, email, password) VALUES ('twelve_chars', 'user3@gmail.com', '')`,
+			`INSERT INTO users (username, email, password) VALUES ('same2', 'user4@gmail.com', '')`,
+		},
+		up: []migrationStep{
+			// The second "same" would become "same2", which the fifth user has
+			{query: `SELECT username FROM users ORDER BY user_id`, want: "same;same2_1;twelve_chars;twelve_char4;same2"},
+			{exec: `INSERT INTO users (username, email, password) VALUES ('same', 'new@gmail.com', '')`, refused: true},
+		},
+		down: []migrationStep{
+			{query: `SELECT username FROM users ORDER BY user_id`, want: "same;same2_1;twelve_chars;twelve_char4;same2"},
+			{exec: `INSERT INTO users (username, email, password) VALUES ('same', 'new@gmail.com', '')`},
+		},
+	},
+	{
+		// Existing users become enabled plain users
+		version: 9,
+		before:  []string{migrationUser},
+		up: []migrationStep{
+			{query: `SELECT role, is_disabled FROM users`, want: "user|0"},
+			{exec: `UPDATE users SET role = 'owner'`, refused: true},
+		},
+		down: []migrationStep{
+			{query: `SELECT username, email FROM users`, want: "old|old@gmail.com"},
+		},
+	},
+	{
+		// Existing products get a first revision that existing items point at
+		version: 10,
+		before: []string{migrationUser, migrationProduct,
+			`INSERT INTO items (user_id, product_id, item_date) VALUES (1, 1, '2024-05-01')`},
+		up: []migrationStep{
+			{query: `SELECT r.product_id, r.revision_number, r.product_title FROM items AS i
+                INNER JOIN product_revisions AS r ON r.revision_id = i.revision_id`, want: "1|1|Old bread"},
+		},
+		down: []migrationStep{
+			{query: `SELECT item_id, product_id, date(item_date) FROM items`, want: "1|1|2024-05-01"},
+		},
+	},
+	{
+		// Existing products become food, reverted triggers still add revisions
+		version: 11,
+		before:  []string{migrationUser, migrationProduct},
+		up: []migrationStep{
+			{query: `SELECT product_type FROM products`, want: "1"},
+			{exec: `UPDATE products SET product_type = 4`, refused: true},
+		},
+		down: []migrationStep{
+			{exec: `UPDATE products SET product_title = 'Older bread'`,
+				query: `SELECT revision_number, product_title FROM product_revisions ORDER BY revision_number`,
+				want:  "1|Old bread;2|Older bread"},
+		},
+	},
+	{
+		// Identical products are kept, only the oldest one gets the fingerprint
+		version: 12,
+		before: []string{migrationUser, migrationProduct,
+			`INSERT INTO products (product_title, user_id) VALUES ('old  bread', 1)`,
+			`INSERT INTO products (product_title, user_id) VALUES ('New bread', 1)`},
+		up: []migrationStep{
+			{query: `SELECT fingerprint FROM products ORDER BY product_id`,
+				want: "oldbread||1|0|0|0|0;NULL;newbread||1|0|0|0|0"},
+			{exec: `UPDATE products SET fingerprint = 'newbread||1|0|0|0|0' WHERE product_id = 1`, refused: true},
+		},
+		down: []migrationStep{
+			{query: `SELECT product_title FROM products ORDER BY product_id`, want: "Old bread;old  bread;New bread"},
+		},
+	},
+	{
+		// Existing revisions are indexed
+		version: 13,
+		before:  []string{migrationUser, migrationProduct},
+		up: []migrationStep{
+			{query: `SELECT rowid FROM product_search WHERE product_search MATCH '"bread"* "old"* "еда"*'`, want: "1"},
+		},
+		down: []migrationStep{
+			{query: `SELECT COUNT(*) FROM sqlite_master WHERE name = 'product_search'`, want: "0"},
+			{query: `SELECT COUNT(*) FROM product_revisions`, want: "1"},
+		},
+	},
+	{
+		// Existing items count in pieces of 100 g, new revisions copy the
+		// measure
+		version: 14,
+		before: []string{migrationUser, migrationProduct,
+			`INSERT INTO items (user_id, product_id, revision_id, item_date, item_amount) VALUES (1, 1, 1, '2024-05-01', 2)`},
+		up: []migrationStep{
+			{query: `SELECT i.item_amount, i.item_unit, r.net_quantity, r.net_unit FROM items AS i
+                INNER JOIN product_revisions AS r ON r.revision_id = i.revision_id`, want: "2|5|100|1"},
+			{exec: `INSERT INTO products (product_title, net_quantity, net_unit, user_id) VALUES ('Juice', 330, 2, 1)`},
+			{exec: `UPDATE products SET product_title = 'Apple juice' WHERE product_id = 2`,
+				query: `SELECT revision_number, net_quantity, net_unit FROM product_revisions WHERE product_id = 2
+                    ORDER BY revision_number`,
+				want: "1|330|2;2|330|2"},
+		},
+		down: []migrationStep{
+			{query: `SELECT item_amount FROM items`, want: "2"},
+			{query: `SELECT product_id, revision_number, product_title FROM product_revisions ORDER BY revision_id`,
+				want: "1|1|Old bread;2|1|Juice;2|2|Apple juice"},
+		},
+	},
+	{
+		// Existing costs become prices of a unit in minor units. Going back,
+		// totals and prices of a kilogram become prices of a unit again.
+		version: 15,
+		before: []string{migrationUser, migrationProduct,
+			`INSERT INTO items (user_id, product_id, revision_id, item_date, item_cost, item_amount, item_unit)
+                VALUES (1, 1, 1, '2024-05-01', 12.346, 2, 5)`,
+			`INSERT INTO items (user_id, product_id, revision_id, item_date, item_cost, item_amount, item_unit)
+                VALUES (1, 1, 1, '2024-05-01', NULL, 1, 5)`,
+			`INSERT INTO products (product_title, net_quantity, net_unit, density, user_id) VALUES ('Juice', 500, 2, 1.2, 1)`},
+		up: []migrationStep{
+			{query: `SELECT item_cost, price_mode FROM items ORDER BY item_id`, want: "1235|1;0|1"},
+			// 10.00 for 4, then 450.00 a kilogram of 500 g, 2 pieces of
+			// 100 g, 0.5 l and 2 bottles of 500 ml
+			{exec: `INSERT INTO items (user_id, product_id, revision_id, item_date, item_cost, item_amount, item_unit, price_mode)
+                VALUES (1, 1, 1, '2024-05-02', 1000, 4, 5, 3),
+                    (1, 1, 1, '2024-05-02', 45000, 500, 1, 2),
+                    (1, 1, 1, '2024-05-02', 45000, 2, 5, 2),
+                    (1, 2, 2, '2024-05-02', 45000, 0.5, 4, 2),
+                    (1, 2, 2, '2024-05-02', 45000, 2, 5, 2)`},
+		},
+		down: []migrationStep{
+			{query: `SELECT item_cost FROM items ORDER BY item_id`, want: "12.35;0;2.5;0.45;45;540;270"},
+		},
+	},
+	{
+		// Existing users and items are in RUB
+		version: 16,
+		before:  []string{migrationUser, migrationProduct, migrationItem},
+		up: []migrationStep{
+			{query: `SELECT base_currency, currency, item_cost FROM users, items`, want: "RUB|RUB|1000"},
+			{exec: `INSERT INTO currency_rates (user_id, rate_date, currency_from, currency_to, rate)
+                VALUES (1, '2024-05-01', 'EUR', 'RUB', 100)`},
+			{exec: `INSERT INTO currency_rates (user_id, rate_date, currency_from, currency_to, rate)
+                VALUES (1, '2024-05-01', 'EUR', 'RUB', 0)`, refused: true},
+		},
+		down: []migrationStep{
+			{query: `SELECT item_cost, item_amount FROM items`, want: "1000|1"},
+			{query: `SELECT COUNT(*) FROM sqlite_master WHERE name = 'currency_rates'`, want: "0"},
+		},
+	},
+	{
+		// Payments settle debts with persons
+		version: 17,
+		before:  []string{migrationUser, migrationPerson},
+		up: []migrationStep{
+			{exec: `INSERT INTO payments (user_id, person_id, payment_date, payment_type, amount)
+                VALUES (1, 1, '2024-05-01', 2, 100)`,
+				query: `SELECT amount, currency, note FROM payments`, want: "100|RUB|"},
+			{exec: `INSERT INTO payments (user_id, person_id, payment_date, payment_type, amount)
+                VALUES (1, 1, '2024-05-01', 2, 0)`, refused: true},
+		},
+		down: []migrationStep{
+			{query: `SELECT COUNT(*) FROM sqlite_master WHERE name = 'payments'`, want: "0"},
+			{query: `SELECT person_name FROM persons`, want: "Friend"},
+		},
+	},
+	{
+		// The user has one share of an item, shares go with their item
+		version: 18,
+		before:  []string{migrationUser, migrationPerson, migrationProduct, migrationItem},
+		up: []migrationStep{
+			{exec: `INSERT INTO item_splits (item_id, person_id, share_type, share_value)
+                VALUES (1, NULL, 3, 0), (1, 1, 3, 0)`},
+			{exec: `INSERT INTO item_splits (item_id, person_id, share_type, share_value)
+                VALUES (1, NULL, 2, 100)`, refused: true},
+			{exec: `DELETE FROM items`, query: `SELECT COUNT(*) FROM item_splits`, want: "0"},
+		},
+		down: []migrationStep{
+			{query: `SELECT COUNT(*) FROM sqlite_master WHERE name = 'item_splits'`, want: "0"},
+		},
+	},
+}
+
+// TestMigrations checks how every migration with data to move moves it, up
+// and back down.
+func TestMigrations() error {
+	for _, c := range migrationCases {
+		err := testMigration(c)
+		if err != nil {
+			return fmt.Errorf("Migration %d: %w", c.version, err)
+		}
+	}
+	return nil
+}
+
+func testMigration(c migrationCase) error {
+	t, err := newMigratedDB(c.version-1, c.before...)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	err = migrations.MigrateTo(t.database.DB, c.version)
+	if err != nil {
+		return err
+	}
+	err = t.runMigrationSteps("up", c.up)
+	if err != nil {
+		return err
+	}
+	err = migrations.MigrateTo(t.database.DB, c.version-1)
+	if err != nil {
+		return err
+	}
+	return t.runMigrationSteps("down", c.down)
+}
+
+func (t *testDB) runMigrationSteps(direction string, steps []migrationStep) error {
+	for _, step := range steps {
+		if step.exec != "" {
+			_, err := t.database.DB.Exec(step.exec)
+			if step.refused && err == nil {
+				return fmt.Errorf("%s: %q should be refused", direction, step.exec)
+			}
+			if !step.refused && err != nil {
+				return fmt.Errorf("%s: %q failed: %v", direction, step.exec, err)
+			}
+		}
+		if step.query == "" {
+			continue
+		}
+		got, err := t.queryRows(step.query)
+		if err != nil {
+			return fmt.Errorf("%s: %q failed: %v", direction, step.query, err)
+		}
+		if got != step.want {
+			return fmt.Errorf("%s: %q should read %q, got %q", direction, step.query, step.want, got)
+		}
+	}
+	return nil
+}
+
+// queryRows reads the rows of query in the format of migrationStep.
+func (t *testDB) queryRows(query string) (string, error) {
+	rows, err := t.database.DB.Query(query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{}
+	for rows.Next() {
+		values := make([]any, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			return "", err
+		}
+		fields := []string{}
+		for _, value := range values {
+			switch v := value.(type) {
+			case nil:
+				fields = append(fields, "NULL")
+			case []byte:
+				fields = append(fields, string(v))
+			case float64:
+				fields = append(fields, strconv.FormatFloat(v, 'g', -1, 64))
+			default:
+				fields = append(fields, fmt.Sprint(v))
+			}
+		}
+		lines = append(lines, strings.Join(fields, "|"))
+	}
+	return strings.Join(lines, ";"), rows.Err()
+}
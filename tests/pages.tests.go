@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bmg-c/product-diary/db"
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/schemas"
+	"github.com/bmg-c/product-diary/schemas/item_schemas"
+	"github.com/bmg-c/product-diary/schemas/product_schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+)
+
+func TestPagination() error {
+	err := testCursors()
+	if err != nil {
+		return err
+	}
+
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	ps := services.NewProductService(t.productDB)
+	is := services.NewItemService(t.itemDB, t.currencyDB, t.txDB)
+
+	err = t.userDB.AddUser(ctx, user_schemas.AddUser{Username: "pager", Email: "pager@gmail.com"})
+	if err != nil {
+		return err
+	}
+	userDB, err := t.userDB.GetUser(ctx, user_schemas.GetUser{Email: "pager@gmail.com"})
+	if err != nil {
+		return err
+	}
+	productIDs := []uint{}
+	for i, title := range []string{"Milk", "Bread", "Cheese", "Apple juice", "Milk shake", "Butter", "Kefir"} {
+		productDB, err := ps.AddProduct(ctx, product_schemas.AddProduct{
+			ProductTitle: title + " pack", ProductType: product_schemas.ProductTypeFood,
+			ProductCalories: float32(100 * (i % 3)), UserID: userDB.UserID,
+		})
+		if err != nil {
+			return err
+		}
+		productIDs = append(productIDs, productDB.ProductID)
+	}
+
+	// Sorting only allows the fields of the table
+	for _, sortField := range []string{"product_id", "is_hidden", "product_title; DROP TABLE products"} {
+		ve := schemas.ValidateStruct(product_schemas.GetProducts{SortField: sortField})
+		if ve == nil {
+			return fmt.Errorf("Sorting products by %q should be invalid", sortField)
+		}
+		_, err = ps.GetProducts(ctx, product_schemas.GetProducts{SortField: sortField})
+		if !errors.Is(err, E.ErrUnprocessableEntity) {
+			return fmt.Errorf("Sorting products by %q should be refused, got %v", sortField, err)
+		}
+	}
+	ve := schemas.ValidateStruct(product_schemas.GetProducts{SortField: "product_title", SortDirection: "up"})
+	if ve == nil {
+		return fmt.Errorf("Sort direction \"up\" should be invalid")
+	}
+	_, err = ps.GetProducts(ctx, product_schemas.GetProducts{Cursor: "bm90IGEgY3Vyc29y"})
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("A broken cursor should be refused, got %v", err)
+	}
+
+	// Pages put together are the whole sorted list, ties included
+	for _, data := range []product_schemas.GetProducts{
+		{},
+		{SortField: "product_title"},
+		{SortField: "product_title", SortDirection: "desc"},
+		{SortField: "product_calories"},
+		{SortField: "product_calories", SortDirection: "desc"},
+		{SortField: "creator_name"},
+		{SearchQuery: "milk"},
+		{SearchQuery: "pack", SortField: "product_title", SortDirection: "desc"},
+	} {
+		all, err := ps.GetProducts(ctx, data)
+		if err != nil {
+			return err
+		}
+		want := []string{}
+		for _, productDB := range all {
+			want = append(want, productDB.ProductTitle)
+		}
+		got := []string{}
+		data.Limit = 2
+		for {
+			products, err := ps.GetProducts(ctx, data)
+			if err != nil {
+				return err
+			}
+			if len(products) > int(data.Limit) {
+				return fmt.Errorf("A page of %d products has %d", data.Limit, len(products))
+			}
+			if len(products) == 0 {
+				break
+			}
+			for _, productDB := range products {
+				got = append(got, productDB.ProductTitle)
+			}
+			data.Cursor = products[len(products)-1].Cursor
+		}
+		if strings.Join(got, ", ") != strings.Join(want, ", ") {
+			return fmt.Errorf("Pages of %+v should be %q, got %q", data, want, got)
+		}
+	}
+
+	sorted, err := ps.GetProducts(ctx, product_schemas.GetProducts{SortField: "product_title", Limit: 3})
+	if err != nil {
+		return err
+	}
+	titles := []string{}
+	for _, productDB := range sorted {
+		titles = append(titles, productDB.ProductTitle)
+	}
+	want := "Apple juice pack, Bread pack, Butter pack"
+	if strings.Join(titles, ", ") != want {
+		return fmt.Errorf("The first page by title should be %q, got %q", want, strings.Join(titles, ", "))
+	}
+
+	// Items are paged the same way
+	date := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i, productID := range productIDs {
+		_, err = is.AddItem(ctx, item_schemas.AddItem{
+			UserID: userDB.UserID, ProductID: productID, ItemDate: date, ItemCost: int64(1000 * (i % 4)),
+			PriceMode: item_schemas.PriceModeTotal,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	_, err = is.GetItems(ctx, item_schemas.GetItems{UserID: userDB.UserID, ItemDate: date, SortField: "item_date"})
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Sorting items by item_date should be refused, got %v", err)
+	}
+	data := item_schemas.GetItems{
+		UserID: userDB.UserID, ItemDate: date, SortField: "item_cost", SortDirection: "desc", Limit: 3,
+	}
+	costs := []string{}
+	for {
+		items, err := is.GetItems(ctx, data)
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			break
+		}
+		for _, itemParsed := range items {
+			costs = append(costs, fmt.Sprint(itemParsed.ItemCost))
+		}
+		data.Cursor = items[len(items)-1].Cursor
+	}
+	if got := strings.Join(costs, " "); got != "3000 2000 2000 1000 1000 0 0" {
+		return fmt.Errorf("Items by cost should be \"3000 2000 2000 1000 1000 0 0\", got %q", got)
+	}
+	return nil
+}
+
+func testCursors() error {
+	// SQLite returns text as bytes and integers as int64
+	for _, c := range []struct {
+		value any
+		want  string
+	}{{"Milk", "Milk"}, {2.5, "2.5"}, {int64(3), "3"}, {[]byte("Молоко"), "Молоко"}} {
+		got, id, err := db.DecodeCursor(db.EncodeCursor(c.value, 7))
+		if err != nil {
+			return err
+		}
+		if id != 7 || fmt.Sprint(got) != c.want {
+			return fmt.Errorf("Cursor of %v should decode to %s and 7, got %v and %d", c.value, c.want, got, id)
+		}
+	}
+	for _, cursor := range []string{"!!", "e30", "eyJ2IjpbMV0sImlkIjoxfQ"} {
+		_, _, err := db.DecodeCursor(cursor)
+		if !errors.Is(err, E.ErrUnprocessableEntity) {
+			return fmt.Errorf("Cursor %q should be refused, got %v", cursor, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/schemas/currency_schemas"
+	"github.com/bmg-c/product-diary/schemas/item_schemas"
+	"github.com/bmg-c/product-diary/schemas/product_schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+	"github.com/bmg-c/product-diary/util"
+)
+
+func TestPrices() error {
+	err := testMinorUnits()
+	if err != nil {
+		return err
+	}
+
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	ps := services.NewProductService(t.productDB)
+	is := services.NewItemService(t.itemDB, t.currencyDB, t.txDB)
+
+	err = t.userDB.AddUser(ctx, user_schemas.AddUser{Username: "cashier", Email: "cashier@gmail.com"})
+	if err != nil {
+		return err
+	}
+	userDB, err := t.userDB.GetUser(ctx, user_schemas.GetUser{Email: "cashier@gmail.com"})
+	if err != nil {
+		return err
+	}
+	cheese, err := ps.AddProduct(ctx, product_schemas.AddProduct{
+		ProductTitle: "Cheese", ProductType: product_schemas.ProductTypeFood,
+		NetQuantity: 200, NetUnit: product_schemas.UnitGram, Density: 1, UserID: userDB.UserID,
+	})
+	if err != nil {
+		return err
+	}
+	personDB, err := t.userDB.AddPerson(ctx, user_schemas.GetPerson{UserID: userDB.UserID, PersonName: "Friend"})
+	if err != nil {
+		return err
+	}
+
+	date := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		item  item_schemas.AddItem
+		total int64
+	}{
+		// Prices of a unit unless told otherwise
+		{item_schemas.AddItem{ItemCost: 19999, ItemAmount: 3}, 59997},
+		{item_schemas.AddItem{ItemCost: 45000, ItemAmount: 500, ItemUnit: product_schemas.UnitGram,
+			PriceMode: item_schemas.PriceModeKilogram}, 22500},
+		// 2 pieces of 200 g
+		{item_schemas.AddItem{ItemCost: 45000, ItemAmount: 2,
+			PriceMode: item_schemas.PriceModeKilogram}, 18000},
+		{item_schemas.AddItem{ItemCost: 12345, ItemAmount: 0.7, ItemUnit: product_schemas.UnitKilogram,
+			PriceMode: item_schemas.PriceModeTotal}, 12345},
+		// Thirds of a kopeck are rounded
+		{item_schemas.AddItem{ItemCost: 100, ItemAmount: 0.333, ItemUnit: product_schemas.UnitKilogram}, 33},
+		{item_schemas.AddItem{ItemCost: 1000, ItemAmount: 1, PriceMode: item_schemas.PriceModeTotal,
+			ItemType: item_schemas.ItemTypeFromPersonPurchase, PersonID: personDB.PersonID}, 1000},
+		{item_schemas.AddItem{ItemCost: 250, ItemAmount: 1,
+			ItemType: item_schemas.ItemTypeToPersonPurchase, PersonID: personDB.PersonID}, 250},
+	} {
+		c.item.UserID = userDB.UserID
+		c.item.ProductID = cheese.ProductID
+		c.item.ItemDate = date
+		itemParsed, err := is.AddItem(ctx, c.item)
+		if err != nil {
+			return err
+		}
+		total, err := is.ItemTotal(itemParsed)
+		if err != nil {
+			return err
+		}
+		if total != c.total {
+			return fmt.Errorf("%+v should cost %d, got %d", c.item, c.total, total)
+		}
+	}
+	_, err = is.ItemTotal(item_schemas.ItemParsed{PriceMode: item_schemas.PriceModeTotal + 1})
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("An unknown price mode should be refused, got %v", err)
+	}
+
+	a, err := is.GetAnalyticsRange(ctx, item_schemas.GetItemsRange{
+		UserID: userDB.UserID, ItemDateFrom: date, ItemDateTo: date, BaseCurrency: currency_schemas.DefaultCurrency,
+	})
+	if err != nil {
+		return err
+	}
+	if a.TotalSpent != 59997+22500+18000+12345+33+1000 {
+		return fmt.Errorf("The items should have cost %d, got %d", 59997+22500+18000+12345+33+1000, a.TotalSpent)
+	}
+	if len(a.Persons) != 1 || a.Persons[0].TotalDebt != 750 {
+		return fmt.Errorf("The debt to the person should be 750, got %+v", a.Persons)
+	}
+
+	// Costs sort by the total, whatever the price mode
+	items, err := is.GetItems(ctx, item_schemas.GetItems{
+		UserID: userDB.UserID, ItemDate: date, SortField: "item_cost", SortDirection: "desc",
+	})
+	if err != nil {
+		return err
+	}
+	totals := []string{}
+	for _, itemParsed := range items {
+		total, err := is.ItemTotal(itemParsed)
+		if err != nil {
+			return err
+		}
+		totals = append(totals, fmt.Sprint(total))
+	}
+	want := "59997 22500 18000 12345 1000 250 33"
+	if got := strings.Join(totals, " "); got != want {
+		return fmt.Errorf("Items by cost should be %q, got %q", want, got)
+	}
+	return nil
+}
+
+func testMinorUnits() error {
+	for _, c := range []struct {
+		str   string
+		minor int64
+	}{{"12.34", 1234}, {"12,3", 1230}, {"7", 700}, {".5", 50}, {"0.07", 7}, {" 1000.00 ", 100000}} {
+		minor, err := util.GetMinorFromString(c.str)
+		if err != nil {
+			return fmt.Errorf("%q should parse, got %v", c.str, err)
+		}
+		if minor != c.minor {
+			return fmt.Errorf("%q should be %d, got %d", c.str, c.minor, minor)
+		}
+	}
+	for _, str := range []string{"", ".", "1.234", "-1", "1e3", "1.2.3", "12,34.5", "99999999999999999999"} {
+		_, err := util.GetMinorFromString(str)
+		if !errors.Is(err, E.ErrUnprocessableEntity) {
+			return fmt.Errorf("%q should be refused, got %v", str, err)
+		}
+	}
+	for _, c := range []struct {
+		minor int64
+		str   string
+	}{{1234, "12.34"}, {5, "0.05"}, {0, "0.00"}, {-250, "-2.50"}, {math.MinInt64, "-92233720368547758.08"}} {
+		if str := util.FormatMinor(c.minor); str != c.str {
+			return fmt.Errorf("%d should be %q, got %q", c.minor, c.str, str)
+		}
+	}
+	return nil
+}
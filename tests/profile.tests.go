@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	L "github.com/bmg-c/product-diary/localization"
+	"github.com/bmg-c/product-diary/mailer"
+	"github.com/bmg-c/product-diary/schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+	"github.com/bmg-c/product-diary/util"
+)
+
+func TestProfile() error {
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	fm, err := mailer.NewFileMailer(filepath.Join(t.dir, "mail"), "test@product-diary.local")
+	if err != nil {
+		return err
+	}
+	us := services.NewUserService(t.userDB, t.txDB, fm)
+	l := L.NewLocilizer(L.LocaleEnUS)
+
+	passwordHash, err := util.HashPassword("password")
+	if err != nil {
+		return err
+	}
+	for _, user := range []user_schemas.AddUser{
+		{Username: "first", Email: "first@gmail.com", PasswordHash: passwordHash},
+		{Username: "second", Email: "second@gmail.com", PasswordHash: passwordHash},
+	} {
+		err = t.userDB.AddUser(ctx, user)
+		if err != nil {
+			return err
+		}
+	}
+	err = t.userDB.AddUser(ctx, user_schemas.AddUser{Username: "first", Email: "third@gmail.com", PasswordHash: passwordHash})
+	if !errors.Is(err, E.ErrConflict) {
+		return fmt.Errorf("Adding a user with a taken username should conflict, got %v", err)
+	}
+	first, err := t.userDB.GetUser(ctx, user_schemas.GetUser{Email: "first@gmail.com"})
+	if err != nil {
+		return err
+	}
+
+	// Username
+	err = us.UpdateUsername(ctx, user_schemas.UpdateUsername{UserID: first.UserID, Username: "second"})
+	if !errors.Is(err, E.ErrConflict) {
+		return fmt.Errorf("Taking the username of another user should conflict, got %v", err)
+	}
+	err = us.UpdateUsername(ctx, user_schemas.UpdateUsername{UserID: first.UserID, Username: "renamed"})
+	if err != nil {
+		return err
+	}
+	user, err := us.GetUser(ctx, user_schemas.GetUser{UserID: first.UserID})
+	if err != nil {
+		return err
+	}
+	if user.Username != "renamed" {
+		return fmt.Errorf("Username should be changed, got %s", user.Username)
+	}
+
+	// Password
+	err = us.ChangePassword(ctx, user_schemas.ChangePassword{
+		UserID: first.UserID, CurrentPassword: "wrong password", NewPassword: "new password",
+	})
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Changing the password with a wrong current password should fail, got %v", err)
+	}
+	err = us.ChangePassword(ctx, user_schemas.ChangePassword{
+		UserID: first.UserID, CurrentPassword: "password", NewPassword: "new password",
+	})
+	if err != nil {
+		return err
+	}
+	_, err = us.LoginUser(ctx, user_schemas.UserLogin{Email: "first@gmail.com", Password: "new password"}, user_schemas.SessionClient{})
+	if err != nil {
+		return fmt.Errorf("Login with the changed password failed: %v", err)
+	}
+
+	// Email
+	err = us.ChangeEmail(ctx, user_schemas.ChangeEmail{UserID: first.UserID, Email: "second@gmail.com"}, l)
+	if !errors.Is(err, E.ErrConflict) {
+		return fmt.Errorf("Changing to the email of another user should conflict, got %v", err)
+	}
+	newEmail := "changed@gmail.com"
+	err = us.ChangeEmail(ctx, user_schemas.ChangeEmail{UserID: first.UserID, Email: newEmail}, l)
+	if err != nil {
+		return err
+	}
+	files, err := fm.Files(newEmail)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return fmt.Errorf("Changing the email should send 1 mail to the new email, got %d", len(files))
+	}
+	body, err := readMailBody(files[0])
+	if err != nil {
+		return err
+	}
+	code := regexp.MustCompile(fmt.Sprintf(`\b[A-Z0-9]{%d}\b`, schemas.DefRV.CodeLength)).FindString(body)
+	if code == "" {
+		return fmt.Errorf("Mail does not contain a confirmation code: %q", body)
+	}
+	wrongCode := "WRONG0"
+	if code == wrongCode {
+		wrongCode = "WRONG1"
+	}
+	confirm := user_schemas.ConfirmChangeEmail{UserID: first.UserID, Email: newEmail, Code: wrongCode}
+	err = us.ConfirmChangeEmail(ctx, confirm)
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Confirming the email with a wrong code should fail, got %v", err)
+	}
+	confirm.Code = code
+	err = us.ConfirmChangeEmail(ctx, confirm)
+	if err != nil {
+		return err
+	}
+	user, err = us.GetUser(ctx, user_schemas.GetUser{UserID: first.UserID})
+	if err != nil {
+		return err
+	}
+	if user.Email != newEmail {
+		return fmt.Errorf("Email should be changed, got %s", user.Email)
+	}
+	err = us.ConfirmChangeEmail(ctx, confirm)
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Email change code should be single use, got %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/mailer"
+	"github.com/bmg-c/product-diary/ratelimit"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+)
+
+func TestRateLimit() error {
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	stores := map[string]ratelimit.Store{
+		"memory": ratelimit.NewMemoryStore(),
+		"sqlite": t.limitDB,
+	}
+	for name, store := range stores {
+		err = testGuard(store)
+		if err != nil {
+			return fmt.Errorf("Rate limit store %s: %w", name, err)
+		}
+	}
+
+	return testCodeAttempts(t)
+}
+
+func testGuard(store ratelimit.Store) error {
+	ctx := context.Background()
+	guard := ratelimit.NewGuard(store,
+		ratelimit.Limit{Burst: 3, Every: time.Hour},
+		ratelimit.LockoutPolicy{Threshold: 3, Base: time.Minute, Max: 3 * time.Minute, Reset: time.Hour},
+	)
+
+	// The bucket lets the burst through and then asks to wait for a token
+	for i := 0; i < 3; i++ {
+		wait, err := guard.Allow(ctx, "bucket")
+		if err != nil {
+			return err
+		}
+		if wait != 0 {
+			return fmt.Errorf("Request %d should be allowed, got wait %v", i+1, wait)
+		}
+	}
+	wait, err := guard.Allow(ctx, "bucket")
+	if err != nil {
+		return err
+	}
+	if wait < 59*time.Minute || wait > time.Hour {
+		return fmt.Errorf("Request after the burst should wait about an hour, got %v", wait)
+	}
+	// Other keys have their own buckets
+	wait, err = guard.Allow(ctx, "other")
+	if err != nil {
+		return err
+	}
+	if wait != 0 {
+		return fmt.Errorf("Other key should be allowed, got wait %v", wait)
+	}
+
+	// Lockouts start at the threshold and double up to the maximum
+	expected := []time.Duration{0, 0, time.Minute, 2 * time.Minute, 3 * time.Minute}
+	for i, exp := range expected {
+		wait, err = guard.Failure(ctx, "lockout")
+		if err != nil {
+			return err
+		}
+		if wait != exp {
+			return fmt.Errorf("Failure %d should lock for %v, got %v", i+1, exp, wait)
+		}
+	}
+	wait, err = guard.Allow(ctx, "lockout", "other")
+	if err != nil {
+		return err
+	}
+	if wait < 2*time.Minute || wait > 3*time.Minute {
+		return fmt.Errorf("Locked key should wait for its lockout, got %v", wait)
+	}
+
+	// Success forgets the failures
+	err = guard.Success(ctx, "lockout")
+	if err != nil {
+		return err
+	}
+	wait, err = guard.Allow(ctx, "lockout")
+	if err != nil {
+		return err
+	}
+	if wait != 0 {
+		return fmt.Errorf("Key should be allowed after success, got wait %v", wait)
+	}
+	wait, err = guard.Failure(ctx, "lockout")
+	if err != nil {
+		return err
+	}
+	if wait != 0 {
+		return fmt.Errorf("Failures should start over after success, got wait %v", wait)
+	}
+	return nil
+}
+
+// testCodeAttempts checks that a confirmation code stops working after
+// services.MaxCodeAttempts wrong guesses.
+func testCodeAttempts(t *testDB) error {
+	ctx := context.Background()
+	us := services.NewUserService(t.userDB, t.txDB, mailer.NewLogMailer())
+
+	email := "attempts@gmail.com"
+	err := t.userDB.AddCode(ctx, email, "AAAAAA")
+	if err != nil {
+		return err
+	}
+	confirm := func(code string) error {
+		return us.ConfirmSignin(ctx, user_schemas.UserConfirmSignin{
+			Email:    email,
+			Code:     code,
+			Username: "attempts",
+			Password: "password",
+		})
+	}
+	for i := 0; i < services.MaxCodeAttempts; i++ {
+		err = confirm("BBBBBB")
+		if !errors.Is(err, E.ErrUnprocessableEntity) {
+			return fmt.Errorf("Wrong code %d should be rejected, got %v", i+1, err)
+		}
+	}
+	err = confirm("AAAAAA")
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Code should be invalid after %d wrong guesses, got %v", services.MaxCodeAttempts, err)
+	}
+	count, err := t.count("users")
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		return fmt.Errorf("No user should be created, got %d", count)
+	}
+	return nil
+}
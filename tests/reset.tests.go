@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	L "github.com/bmg-c/product-diary/localization"
+	"github.com/bmg-c/product-diary/mailer"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+	"github.com/bmg-c/product-diary/util"
+)
+
+func TestPasswordReset() error {
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	fm, err := mailer.NewFileMailer(filepath.Join(t.dir, "mail"), "test@product-diary.local")
+	if err != nil {
+		return err
+	}
+	us := services.NewUserService(t.userDB, t.txDB, fm)
+	us.PublicURL = "https://diary.example/"
+	l := L.NewLocilizer(L.LocaleEnUS)
+
+	email := "reset@gmail.com"
+	passwordHash, err := util.HashPassword("old password")
+	if err != nil {
+		return err
+	}
+	err = t.userDB.AddUser(ctx, user_schemas.AddUser{Username: "reset", Email: email, PasswordHash: passwordHash})
+	if err != nil {
+		return err
+	}
+	login := func(password string) error {
+		_, err := us.LoginUser(ctx, user_schemas.UserLogin{Email: email, Password: password}, user_schemas.SessionClient{})
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		err = login("old password")
+		if err != nil {
+			return err
+		}
+	}
+
+	// Unknown emails look the same to the caller but get no mail
+	err = us.RequestPasswordReset(ctx, user_schemas.PasswordResetRequest{Email: "nobody@gmail.com"}, l)
+	if err != nil {
+		return fmt.Errorf("Reset for an unknown email should not fail, got %v", err)
+	}
+	files, err := fm.Files("nobody@gmail.com")
+	if err != nil {
+		return err
+	}
+	if len(files) != 0 {
+		return fmt.Errorf("Reset for an unknown email should not send mail")
+	}
+
+	tokenRe := regexp.MustCompile(`https://diary\.example` + services.PasswordResetPath + `\?token=([A-Za-z0-9_-]+)`)
+	requestToken := func() (string, error) {
+		err := us.RequestPasswordReset(ctx, user_schemas.PasswordResetRequest{Email: email}, l)
+		if err != nil {
+			return "", err
+		}
+		files, err := fm.Files(email)
+		if err != nil {
+			return "", err
+		}
+		if len(files) == 0 {
+			return "", fmt.Errorf("Reset should send a mail")
+		}
+		body, err := readMailBody(files[len(files)-1])
+		if err != nil {
+			return "", err
+		}
+		match := tokenRe.FindStringSubmatch(body)
+		if match == nil {
+			return "", fmt.Errorf("Mail does not contain a reset link: %q", body)
+		}
+		return match[1], nil
+	}
+	firstToken, err := requestToken()
+	if err != nil {
+		return err
+	}
+	token, err := requestToken()
+	if err != nil {
+		return err
+	}
+	if token == firstToken {
+		return fmt.Errorf("Every reset should get a new token")
+	}
+
+	// Only the hash is stored
+	var stored int
+	err = t.database.DB.QueryRow(`SELECT COUNT(*) FROM password_resets WHERE token_hash = ?`, token).Scan(&stored)
+	if err != nil {
+		return err
+	}
+	if stored != 0 {
+		return fmt.Errorf("Reset token should not be stored in plain text")
+	}
+
+	err = us.CheckPasswordReset(ctx, firstToken)
+	if !errors.Is(err, E.ErrNotFound) {
+		return fmt.Errorf("Earlier token should stop working after a new request, got %v", err)
+	}
+	err = us.CheckPasswordReset(ctx, token)
+	if err != nil {
+		return fmt.Errorf("Latest token should be valid, got %v", err)
+	}
+
+	err = us.ResetPassword(ctx, user_schemas.PasswordReset{Token: token, Password: "new password"})
+	if err != nil {
+		return err
+	}
+	sessions, err := t.count("sessions")
+	if err != nil {
+		return err
+	}
+	if sessions != 0 {
+		return fmt.Errorf("Reset should revoke all sessions, %d left", sessions)
+	}
+	if login("old password") == nil {
+		return fmt.Errorf("Old password should not work after reset")
+	}
+	err = login("new password")
+	if err != nil {
+		return fmt.Errorf("New password should work after reset, got %v", err)
+	}
+
+	err = us.ResetPassword(ctx, user_schemas.PasswordReset{Token: token, Password: "other password"})
+	if !errors.Is(err, E.ErrNotFound) {
+		return fmt.Errorf("Reset token should be single use, got %v", err)
+	}
+
+	// Tokens expire like confirmation codes, only later
+	token, err = requestToken()
+	if err != nil {
+		return err
+	}
+	_, err = t.database.DB.Exec(`UPDATE password_resets SET created_at = datetime('now', '-31 minutes')`)
+	if err != nil {
+		return err
+	}
+	err = us.ResetPassword(ctx, user_schemas.PasswordReset{Token: token, Password: "other password"})
+	if !errors.Is(err, E.ErrNotFound) {
+		return fmt.Errorf("Expired reset token should not work, got %v", err)
+	}
+	return nil
+}
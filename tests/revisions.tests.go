@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/schemas/item_schemas"
+	"github.com/bmg-c/product-diary/schemas/product_schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+)
+
+func TestProductRevisions() error {
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	ps := services.NewProductService(t.productDB)
+	is := services.NewItemService(t.itemDB, t.currencyDB, t.txDB)
+
+	var userIDs []uint
+	for _, username := range []string{"creator", "stranger"} {
+		email := username + "@gmail.com"
+		err = t.userDB.AddUser(ctx, user_schemas.AddUser{Username: username, Email: email})
+		if err != nil {
+			return err
+		}
+		userDB, err := t.userDB.GetUser(ctx, user_schemas.GetUser{Email: email})
+		if err != nil {
+			return err
+		}
+		userIDs = append(userIDs, userDB.UserID)
+	}
+	creatorID, strangerID := userIDs[0], userIDs[1]
+
+	productDB, err := ps.AddProduct(ctx, product_schemas.AddProduct{
+		ProductTitle: "Old cheese", ProductType: product_schemas.ProductTypeFood, ProductCalories: 350, ProductFats: 27, UserID: creatorID,
+	})
+	if err != nil {
+		return err
+	}
+	getProduct := product_schemas.GetProduct{ProductID: productDB.ProductID}
+	revisions, err := ps.GetProductRevisions(ctx, getProduct)
+	if err != nil {
+		return err
+	}
+	if len(revisions) != 1 || revisions[0].RevisionNumber != 1 || revisions[0].ProductTitle != "Old cheese" {
+		return fmt.Errorf("New product should have its first revision, got %+v", revisions)
+	}
+	date := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	oldItem, err := is.AddItem(ctx, item_schemas.AddItem{UserID: creatorID, ProductID: productDB.ProductID, ItemDate: date})
+	if err != nil {
+		return err
+	}
+
+	// Only the creator can change a product
+	change := product_schemas.ChangeProduct{
+		ProductID: productDB.ProductID, UserID: strangerID, ProductTitle: "Stolen cheese", ProductType: product_schemas.ProductTypeFood,
+	}
+	_, err = ps.ChangeProduct(ctx, change)
+	if !errors.Is(err, E.ErrNotFound) {
+		return fmt.Errorf("Changing the product of another user should not be found, got %v", err)
+	}
+	change.UserID = creatorID
+	change.ProductTitle = "New cheese"
+	productDB, err = ps.ChangeProduct(ctx, change)
+	if err != nil {
+		return err
+	}
+	if productDB.ProductTitle != "New cheese" {
+		return fmt.Errorf("Changed product should be returned, got %+v", productDB)
+	}
+	_, err = ps.ChangeProduct(ctx, change)
+	if err != nil {
+		return err
+	}
+	revisions, err = ps.GetProductRevisions(ctx, getProduct)
+	if err != nil {
+		return err
+	}
+	if len(revisions) != 2 || revisions[0].RevisionNumber != 2 || revisions[0].ProductTitle != "New cheese" {
+		return fmt.Errorf("Expected a second revision and none for saving without changes, got %+v", revisions)
+	}
+
+	// Items keep their revision
+	newItem, err := is.AddItem(ctx, item_schemas.AddItem{UserID: creatorID, ProductID: productDB.ProductID, ItemDate: date})
+	if err != nil {
+		return err
+	}
+	items, err := is.GetItems(ctx, item_schemas.GetItems{UserID: creatorID, ItemDate: date})
+	if err != nil {
+		return err
+	}
+	titles := map[uint]string{}
+	for _, item := range items {
+		titles[item.ItemID] = item.ProductTitle
+	}
+	if titles[oldItem.ItemID] != "Old cheese" || titles[newItem.ItemID] != "New cheese" {
+		return fmt.Errorf("Items should show the product as it was when logged, got %v", titles)
+	}
+
+	// Diffs
+	diff, err := ps.GetRevisionDiff(ctx, product_schemas.GetRevisionDiff{ProductID: productDB.ProductID, From: 1, To: 2})
+	if err != nil {
+		return err
+	}
+	expected := []product_schemas.FieldChange{{Field: "product_title", Old: "Old cheese", New: "New cheese"}}
+	if fmt.Sprint(diff.Changes) != fmt.Sprint(expected) {
+		return fmt.Errorf("Diff should be %v, got %v", expected, diff.Changes)
+	}
+	_, err = ps.GetRevisionDiff(ctx, product_schemas.GetRevisionDiff{ProductID: productDB.ProductID, From: 1, To: 3})
+	if !errors.Is(err, E.ErrNotFound) {
+		return fmt.Errorf("Diff with an unknown revision should not be found, got %v", err)
+	}
+
+	// Hidden products and their history are only shown to their creator and
+	// moderators
+	_, err = ps.SetProductHidden(ctx, product_schemas.SetProductHidden{ProductID: productDB.ProductID, IsHidden: true})
+	if err != nil {
+		return err
+	}
+	for _, c := range []struct {
+		getProduct product_schemas.GetProduct
+		found      bool
+	}{
+		{product_schemas.GetProduct{ProductID: productDB.ProductID}, false},
+		{product_schemas.GetProduct{ProductID: productDB.ProductID, UserID: strangerID}, false},
+		{product_schemas.GetProduct{ProductID: productDB.ProductID, UserID: creatorID}, true},
+		{product_schemas.GetProduct{ProductID: productDB.ProductID, IncludeHidden: true}, true},
+	} {
+		_, productErr := ps.GetProduct(ctx, c.getProduct)
+		_, revisionsErr := ps.GetProductRevisions(ctx, c.getProduct)
+		_, diffErr := ps.GetRevisionDiff(ctx, product_schemas.GetRevisionDiff{
+			ProductID: productDB.ProductID, UserID: c.getProduct.UserID, IncludeHidden: c.getProduct.IncludeHidden, From: 1, To: 2,
+		})
+		for _, err := range []error{productErr, revisionsErr, diffErr} {
+			if c.found && err != nil || !c.found && !errors.Is(err, E.ErrNotFound) {
+				return fmt.Errorf("Hidden product for %+v should be found: %t, got %v", c.getProduct, c.found, err)
+			}
+		}
+	}
+
+	// Revisions can not be changed, even by hand
+	_, err = t.database.DB.Exec(`UPDATE product_revisions SET product_calories = 1`)
+	if err == nil {
+		return fmt.Errorf("Revisions should not be updated")
+	}
+	_, err = t.database.DB.Exec(`DELETE FROM product_revisions`)
+	if err == nil {
+		return fmt.Errorf("Revisions should not be deleted")
+	}
+
+	_, err = ps.GetProductRevisions(ctx, product_schemas.GetProduct{ProductID: 1000})
+	if !errors.Is(err, E.ErrNotFound) {
+		return fmt.Errorf("Revisions of an unknown product should not be found, got %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/mailer"
+	"github.com/bmg-c/product-diary/middleware"
+	"github.com/bmg-c/product-diary/schemas/product_schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+	"github.com/bmg-c/product-diary/util"
+	"github.com/google/uuid"
+)
+
+func TestRoles() error {
+	err := testPermissions()
+	if err == nil {
+		err = testRequirePermission()
+	}
+	if err != nil {
+		return err
+	}
+
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	fm, err := mailer.NewFileMailer(filepath.Join(t.dir, "mail"), "test@product-diary.local")
+	if err != nil {
+		return err
+	}
+	us := services.NewUserService(t.userDB, t.txDB, fm)
+	ps := services.NewProductService(t.productDB)
+
+	passwordHash, err := util.HashPassword("password")
+	if err != nil {
+		return err
+	}
+	users := map[string]user_schemas.UserDB{}
+	for _, username := range []string{"admin", "moderator", "member"} {
+		email := username + "@gmail.com"
+		err = t.userDB.AddUser(ctx, user_schemas.AddUser{Username: username, Email: email, PasswordHash: passwordHash})
+		if err != nil {
+			return err
+		}
+		users[username], err = t.userDB.GetUser(ctx, user_schemas.GetUser{Email: email})
+		if err != nil {
+			return err
+		}
+		if users[username].Role != user_schemas.RoleUser {
+			return fmt.Errorf("New users should get the user role, got %q", users[username].Role)
+		}
+	}
+	adminID, moderatorID, memberID := users["admin"].UserID, users["moderator"].UserID, users["member"].UserID
+	err = t.userDB.SetUserRole(ctx, user_schemas.SetUserRole{UserID: adminID, Role: user_schemas.RoleAdmin})
+	if err != nil {
+		return err
+	}
+
+	// Managing users
+	err = us.SetUserRole(ctx, adminID, user_schemas.SetUserRole{UserID: adminID, Role: user_schemas.RoleUser})
+	if !errors.Is(err, E.ErrForbidden) {
+		return fmt.Errorf("Admins should not change their own role, got %v", err)
+	}
+	err = us.SetUserDisabled(ctx, adminID, user_schemas.SetUserDisabled{UserID: adminID, IsDisabled: true})
+	if !errors.Is(err, E.ErrForbidden) {
+		return fmt.Errorf("Admins should not disable themselves, got %v", err)
+	}
+	err = us.SetUserRole(ctx, adminID, user_schemas.SetUserRole{UserID: moderatorID, Role: user_schemas.RoleModerator})
+	if err != nil {
+		return err
+	}
+	err = us.SetUserRole(ctx, adminID, user_schemas.SetUserRole{UserID: 1000, Role: user_schemas.RoleModerator})
+	if !errors.Is(err, E.ErrNotFound) {
+		return fmt.Errorf("Changing the role of an unknown user should not be found, got %v", err)
+	}
+	moderator, err := us.GetUser(ctx, user_schemas.GetUser{UserID: moderatorID})
+	if err != nil {
+		return err
+	}
+	if moderator.Role != user_schemas.RoleModerator {
+		return fmt.Errorf("Role should be moderator, got %q", moderator.Role)
+	}
+
+	// Disabled users lose their sessions and can not log in again
+	client := user_schemas.SessionClient{UserAgent: "tests", IP: "127.0.0.1"}
+	login := user_schemas.UserLogin{Email: "member@gmail.com", Password: "password"}
+	result, err := us.LoginUser(ctx, login, client)
+	if err != nil {
+		return err
+	}
+	err = us.SetUserDisabled(ctx, adminID, user_schemas.SetUserDisabled{UserID: memberID, IsDisabled: true})
+	if err != nil {
+		return err
+	}
+	_, err = us.GetUserBySession(ctx, result.SessionUUID)
+	if err == nil {
+		return fmt.Errorf("Sessions of a disabled user should end")
+	}
+	_, err = us.LoginUser(ctx, login, client)
+	if !errors.Is(err, E.ErrForbidden) {
+		return fmt.Errorf("Disabled user should not log in, got %v", err)
+	}
+	login.Password = "wrong"
+	_, err = us.LoginUser(ctx, login, client)
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Disabled state should only be told after the password, got %v", err)
+	}
+	login.Password = "password"
+	err = us.SetUserDisabled(ctx, adminID, user_schemas.SetUserDisabled{UserID: memberID, IsDisabled: false})
+	if err != nil {
+		return err
+	}
+	result, err = us.LoginUser(ctx, login, client)
+	if err != nil {
+		return fmt.Errorf("Enabled user should log in again, got %v", err)
+	}
+
+	// Force logout
+	err = us.ForceLogout(ctx, memberID)
+	if err != nil {
+		return err
+	}
+	_, err = us.GetUserBySession(ctx, result.SessionUUID)
+	if err == nil {
+		return fmt.Errorf("Forced logout should end the sessions")
+	}
+	err = us.ForceLogout(ctx, 1000)
+	if !errors.Is(err, E.ErrNotFound) {
+		return fmt.Errorf("Forced logout of an unknown user should not be found, got %v", err)
+	}
+
+	// Hidden products
+	productDB, err := ps.AddProduct(ctx, product_schemas.AddProduct{
+		ProductTitle: "Hidden bread", ProductType: product_schemas.ProductTypeFood, UserID: memberID,
+	})
+	if err != nil {
+		return err
+	}
+	countProducts := func(includeHidden bool) (int, error) {
+		products, err := ps.GetProducts(ctx, product_schemas.GetProducts{IncludeHidden: includeHidden})
+		return len(products), err
+	}
+	productDB, err = ps.SetProductHidden(ctx, product_schemas.SetProductHidden{ProductID: productDB.ProductID, IsHidden: true})
+	if err != nil {
+		return err
+	}
+	if !productDB.IsHidden {
+		return fmt.Errorf("Product should be hidden")
+	}
+	for includeHidden, want := range map[bool]int{false: 0, true: 1} {
+		got, err := countProducts(includeHidden)
+		if err != nil {
+			return err
+		}
+		if got != want {
+			return fmt.Errorf("Expected %d products with IncludeHidden %t, got %d", want, includeHidden, got)
+		}
+	}
+	_, err = ps.SetProductHidden(ctx, product_schemas.SetProductHidden{ProductID: productDB.ProductID})
+	if err != nil {
+		return err
+	}
+	got, err := countProducts(false)
+	if err != nil {
+		return err
+	}
+	if got != 1 {
+		return fmt.Errorf("Restored product should be listed, got %d products", got)
+	}
+	_, err = ps.SetProductHidden(ctx, product_schemas.SetProductHidden{ProductID: 1000, IsHidden: true})
+	if !errors.Is(err, E.ErrNotFound) {
+		return fmt.Errorf("Hiding an unknown product should not be found, got %v", err)
+	}
+
+	return nil
+}
+
+func testPermissions() error {
+	for _, c := range []struct {
+		user       user_schemas.UserDB
+		permission user_schemas.Permission
+		want       bool
+	}{
+		{user_schemas.UserDB{Role: user_schemas.RoleUser}, user_schemas.PermModerateProducts, false},
+		{user_schemas.UserDB{Role: user_schemas.RoleUser}, user_schemas.PermManageUsers, false},
+		{user_schemas.UserDB{Role: user_schemas.RoleModerator}, user_schemas.PermModerateProducts, true},
+		{user_schemas.UserDB{Role: user_schemas.RoleModerator}, user_schemas.PermManageUsers, false},
+		{user_schemas.UserDB{Role: user_schemas.RoleAdmin}, user_schemas.PermModerateProducts, true},
+		{user_schemas.UserDB{Role: user_schemas.RoleAdmin}, user_schemas.PermManageUsers, true},
+		{user_schemas.UserDB{Role: user_schemas.RoleAdmin, IsDisabled: true}, user_schemas.PermManageUsers, false},
+		{user_schemas.UserDB{}, user_schemas.PermModerateProducts, false},
+	} {
+		if c.user.Can(c.permission) != c.want {
+			return fmt.Errorf("Permission %d of role %q (disabled %t) should be %t",
+				c.permission, c.user.Role, c.user.IsDisabled, c.want)
+		}
+	}
+	return nil
+}
+
+func testRequirePermission() error {
+	resolver := testResolver{
+		sessionUUID: uuid.New(),
+		userDB:      user_schemas.UserDB{UserID: 3, Username: "roles", Role: user_schemas.RoleModerator},
+	}
+	var reached bool
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}
+	stack := middleware.CreateStack(middleware.Authenticate(resolver))
+	moderate := stack(middleware.RequirePermission(user_schemas.PermModerateProducts, handler))
+	manage := stack(middleware.RequirePermission(user_schemas.PermManageUsers, handler))
+
+	for _, c := range []struct {
+		h      http.Handler
+		cookie bool
+		want   int
+	}{
+		{moderate, false, http.StatusUnauthorized},
+		{moderate, true, http.StatusOK},
+		{manage, true, http.StatusForbidden},
+	} {
+		reached = false
+		r := httptest.NewRequest(http.MethodGet, "/api/users/userlist/index", nil)
+		if c.cookie {
+			r.AddCookie(&http.Cookie{Name: "session", Value: resolver.sessionUUID.String()})
+		}
+		w := httptest.NewRecorder()
+		c.h.ServeHTTP(w, r)
+		if w.Code != c.want || reached != (c.want == http.StatusOK) {
+			return fmt.Errorf("Expected %d, got %d (handler reached %t)", c.want, w.Code, reached)
+		}
+	}
+	return nil
+}
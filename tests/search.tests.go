@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bmg-c/product-diary/db"
+	"github.com/bmg-c/product-diary/schemas/item_schemas"
+	"github.com/bmg-c/product-diary/schemas/product_schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+)
+
+func TestSearch() error {
+	err := testMatchQuery()
+	if err != nil {
+		return err
+	}
+
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	ps := services.NewProductService(t.productDB)
+	is := services.NewItemService(t.itemDB, t.currencyDB, t.txDB)
+
+	err = t.userDB.AddUser(ctx, user_schemas.AddUser{Username: "searcher", Email: "searcher@gmail.com"})
+	if err != nil {
+		return err
+	}
+	userDB, err := t.userDB.GetUser(ctx, user_schemas.GetUser{Email: "searcher@gmail.com"})
+	if err != nil {
+		return err
+	}
+	productIDs := map[string]uint{}
+	for _, p := range []product_schemas.AddProduct{
+		{ProductTitle: "Молоко 2,5%", Manufacturer: "Простоквашино", ProductType: product_schemas.ProductTypeFood},
+		{ProductTitle: "Milk chocolate", Manufacturer: "Choco", ProductType: product_schemas.ProductTypeFood},
+		{ProductTitle: "Chocolate milk", Manufacturer: "Dairy", ProductType: product_schemas.ProductTypeFood},
+		{ProductTitle: "Blender", Manufacturer: "Milkraft", ProductType: product_schemas.ProductTypeElectronics},
+	} {
+		p.UserID = userDB.UserID
+		productDB, err := ps.AddProduct(ctx, p)
+		if err != nil {
+			return err
+		}
+		productIDs[p.ProductTitle] = productDB.ProductID
+	}
+
+	search := func(query string) (string, error) {
+		products, err := ps.GetProducts(ctx, product_schemas.GetProducts{SearchQuery: query})
+		if err != nil {
+			return "", err
+		}
+		titles := []string{}
+		for _, productDB := range products {
+			titles = append(titles, productDB.ProductTitle)
+		}
+		return strings.Join(titles, ", "), nil
+	}
+	for query, want := range map[string]string{
+		// Prefixes, titles rank above manufacturers
+		"milk":     "Milk chocolate, Chocolate milk, Blender",
+		"choc mil": "Milk chocolate, Chocolate milk",
+		// Unicode case folding
+		"МОЛОКО":        "Молоко 2,5%",
+		"простокв":      "Молоко 2,5%",
+		"электроника":   "Blender",
+		"searcher milk": "Milk chocolate, Chocolate milk, Blender",
+		// Query syntax is not interpreted
+		`milk" OR "blender`: "",
+		"nothing":           "",
+	} {
+		got, err := search(query)
+		if err != nil {
+			return err
+		}
+		if got != want {
+			return fmt.Errorf("Search %q should find %q, got %q", query, want, got)
+		}
+	}
+
+	// The registry matches the current product, renamed creators included
+	date := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	_, err = is.AddItem(ctx, item_schemas.AddItem{UserID: userDB.UserID, ProductID: productIDs["Blender"], ItemDate: date})
+	if err != nil {
+		return err
+	}
+	_, err = ps.ChangeProduct(ctx, product_schemas.ChangeProduct{
+		ProductID: productIDs["Blender"], UserID: userDB.UserID, ProductTitle: "Mixer",
+		Manufacturer: "Milkraft", ProductType: product_schemas.ProductTypeElectronics,
+	})
+	if err != nil {
+		return err
+	}
+	err = t.userDB.UpdateUsername(ctx, userDB.UserID, "finder")
+	if err != nil {
+		return err
+	}
+	for query, want := range map[string]string{
+		"blender":      "",
+		"mixer":        "Mixer",
+		"finder mixer": "Mixer",
+		"searcher":     "",
+	} {
+		got, err := search(query)
+		if err != nil {
+			return err
+		}
+		if got != want {
+			return fmt.Errorf("Search %q after the changes should find %q, got %q", query, want, got)
+		}
+	}
+
+	// Items match the product as it was when logged
+	for query, want := range map[string]int{"blender": 1, "mixer": 0, "finder": 1, "": 1} {
+		items, err := is.GetItems(ctx, item_schemas.GetItems{UserID: userDB.UserID, ItemDate: date, SearchQuery: query})
+		if err != nil {
+			return err
+		}
+		if len(items) != want {
+			return fmt.Errorf("Item search %q should find %d items, got %d", query, want, len(items))
+		}
+	}
+	return nil
+}
+
+func testMatchQuery() error {
+	for query, want := range map[string]string{
+		"milk":         `"milk"*`,
+		" Milk 3,2% ":  `"Milk"* "3"* "2"*`,
+		`a" OR "b`:     `"a"* "OR"* "b"*`,
+		"молоко-кефир": `"молоко"* "кефир"*`,
+		"  %* ":        "",
+	} {
+		got := db.MatchQuery(query)
+		if got != want {
+			return fmt.Errorf("Match query of %q should be %q, got %q", query, want, got)
+		}
+	}
+	for query, want := range map[string]string{
+		" Milk 3,2% ": `"Milk"* OR "3"* OR "2"*`,
+		"  %* ":       "",
+	} {
+		got := db.MatchAnyQuery(query)
+		if got != want {
+			return fmt.Errorf("Match any query of %q should be %q, got %q", query, want, got)
+		}
+	}
+	return nil
+}
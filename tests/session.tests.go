@@ -0,0 +1,173 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/mailer"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+	"github.com/bmg-c/product-diary/util"
+	"github.com/google/uuid"
+)
+
+func TestSessions() error {
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	us := services.NewUserService(t.userDB, t.txDB, mailer.NewLogMailer())
+	us.SessionIdleTimeout = time.Hour
+
+	passwordHash, err := util.HashPassword("password")
+	if err != nil {
+		return err
+	}
+	for i, email := range []string{"first@gmail.com", "second@gmail.com"} {
+		err = t.userDB.AddUser(ctx, user_schemas.AddUser{
+			Username:     fmt.Sprintf("session%d", i),
+			Email:        email,
+			PasswordHash: passwordHash,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	login := func(email string) (uuid.UUID, error) {
+		result, err := us.LoginUser(ctx,
+			user_schemas.UserLogin{Email: email, Password: "password"},
+			user_schemas.SessionClient{UserAgent: "tests", IP: "127.0.0.1"})
+		return result.SessionUUID, err
+	}
+
+	// Idle timeout
+	sessionUUID, err := login("first@gmail.com")
+	if err != nil {
+		return err
+	}
+	_, err = us.GetUserBySession(ctx, sessionUUID)
+	if err != nil {
+		return fmt.Errorf("Fresh session should be valid, got %v", err)
+	}
+	_, err = t.database.DB.Exec(`UPDATE sessions SET last_seen_at = datetime('now', '-2 hours')`)
+	if err != nil {
+		return err
+	}
+	_, err = us.GetUserBySession(ctx, sessionUUID)
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Idle session should be rejected, got %v", err)
+	}
+	count, err := t.count("sessions")
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		return fmt.Errorf("Idle session should be deleted, %d sessions left", count)
+	}
+
+	// Absolute timeout
+	sessionUUID, err = login("first@gmail.com")
+	if err != nil {
+		return err
+	}
+	_, err = t.database.DB.Exec(`UPDATE sessions SET expires_at = datetime('now', '-1 minute')`)
+	if err != nil {
+		return err
+	}
+	_, err = us.GetUserBySession(ctx, sessionUUID)
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Expired session should be rejected, got %v", err)
+	}
+
+	// Sliding renewal
+	sessionUUID, err = login("first@gmail.com")
+	if err != nil {
+		return err
+	}
+	_, err = t.database.DB.Exec(`UPDATE sessions SET last_seen_at = datetime('now', '-30 minutes')`)
+	if err != nil {
+		return err
+	}
+	_, err = us.GetUserBySession(ctx, sessionUUID)
+	if err != nil {
+		return fmt.Errorf("Session used within the idle timeout should be valid, got %v", err)
+	}
+	sessionDB, err := t.userDB.GetSession(ctx, sessionUUID)
+	if err != nil {
+		return err
+	}
+	if time.Since(sessionDB.LastSeenAt) > time.Minute {
+		return fmt.Errorf("Using a session should renew it, last seen at %v", sessionDB.LastSeenAt)
+	}
+
+	// Revoking and logging out
+	otherUUID, err := login("first@gmail.com")
+	if err != nil {
+		return err
+	}
+	secondUUID, err := login("second@gmail.com")
+	if err != nil {
+		return err
+	}
+	secondUser, err := us.GetUserBySession(ctx, secondUUID)
+	if err != nil {
+		return err
+	}
+	firstUser, err := us.GetUserBySession(ctx, sessionUUID)
+	if err != nil {
+		return err
+	}
+	sessions, err := us.GetUserSessions(ctx, firstUser.UserID, sessionUUID)
+	if err != nil {
+		return err
+	}
+	if len(sessions) != 2 {
+		return fmt.Errorf("User should have 2 sessions, got %d", len(sessions))
+	}
+	var otherID uint
+	for _, session := range sessions {
+		if !session.IsCurrent {
+			otherID = session.SessionID
+		}
+	}
+	err = us.RevokeSession(ctx, user_schemas.GetUserSession{UserID: secondUser.UserID, SessionID: otherID})
+	if err == nil {
+		return fmt.Errorf("Revoking a session of another user should fail")
+	}
+	err = us.RevokeSession(ctx, user_schemas.GetUserSession{UserID: firstUser.UserID, SessionID: otherID})
+	if err != nil {
+		return err
+	}
+	_, err = us.GetUserBySession(ctx, otherUUID)
+	if err == nil {
+		return fmt.Errorf("Revoked session should be rejected")
+	}
+
+	err = us.LogoutEverywhere(ctx, firstUser.UserID)
+	if err != nil {
+		return err
+	}
+	_, err = us.GetUserBySession(ctx, sessionUUID)
+	if err == nil {
+		return fmt.Errorf("Session should be rejected after logging out everywhere")
+	}
+	err = us.LogoutUser(ctx, secondUUID)
+	if err != nil {
+		return err
+	}
+	count, err = t.count("sessions")
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		return fmt.Errorf("Logging out should delete sessions, %d sessions left", count)
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/schemas/currency_schemas"
+	"github.com/bmg-c/product-diary/schemas/item_schemas"
+	"github.com/bmg-c/product-diary/schemas/ledger_schemas"
+	"github.com/bmg-c/product-diary/schemas/product_schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+)
+
+func TestSplits() error {
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	ps := services.NewProductService(t.productDB)
+	is := services.NewItemService(t.itemDB, t.currencyDB, t.txDB)
+	ls := services.NewLedgerService(t.ledgerDB, t.itemDB, t.currencyDB, t.userDB)
+
+	users := []user_schemas.UserDB{}
+	for _, username := range []string{"splitter", "stranger"} {
+		err = t.userDB.AddUser(ctx, user_schemas.AddUser{Username: username, Email: username + "@gmail.com"})
+		if err != nil {
+			return err
+		}
+		userDB, err := t.userDB.GetUser(ctx, user_schemas.GetUser{Email: username + "@gmail.com"})
+		if err != nil {
+			return err
+		}
+		users = append(users, userDB)
+	}
+	userDB := users[0]
+	persons := []user_schemas.PersonDB{}
+	for _, person := range []user_schemas.GetPerson{
+		{UserID: userDB.UserID, PersonName: "Alice"},
+		{UserID: userDB.UserID, PersonName: "Bob"},
+		{UserID: users[1].UserID, PersonName: "Eve"},
+	} {
+		personDB, err := t.userDB.AddPerson(ctx, person)
+		if err != nil {
+			return err
+		}
+		persons = append(persons, personDB)
+	}
+	alice, bob, eve := persons[0], persons[1], persons[2]
+	pizza, err := ps.AddProduct(ctx, product_schemas.AddProduct{
+		ProductTitle: "Pizza", ProductType: product_schemas.ProductTypeFood, UserID: userDB.UserID,
+		ProductCalories: 250,
+	})
+	if err != nil {
+		return err
+	}
+
+	addItem := func(cost int64, itemType uint8, personID uint) (item_schemas.ItemParsed, error) {
+		return is.AddItem(ctx, item_schemas.AddItem{
+			UserID:     userDB.UserID,
+			ProductID:  pizza.ProductID,
+			ItemDate:   time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			ItemCost:   cost,
+			ItemAmount: 400,
+			ItemType:   itemType,
+			PersonID:   personID,
+			ItemUnit:   product_schemas.UnitGram,
+			PriceMode:  item_schemas.PriceModeTotal,
+			Currency:   currency_schemas.CurrencyRUB,
+		})
+	}
+	setSplits := func(itemID uint, splits ...item_schemas.Split) (item_schemas.ItemParsed, error) {
+		return is.SetItemSplits(ctx, item_schemas.SetItemSplits{ItemID: itemID, UserID: userDB.UserID, Splits: splits})
+	}
+	analytics := func() (item_schemas.Analytics, error) {
+		return is.GetAnalyticsRange(ctx, item_schemas.GetItemsRange{
+			UserID:       userDB.UserID,
+			ItemDateFrom: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			ItemDateTo:   time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			BaseCurrency: currency_schemas.CurrencyRUB,
+		})
+	}
+	equal := func(personID uint) item_schemas.Split {
+		return item_schemas.Split{PersonID: personID, ShareType: item_schemas.ShareTypeEqual}
+	}
+	percent := func(personID uint, value int64) item_schemas.Split {
+		return item_schemas.Split{PersonID: personID, ShareType: item_schemas.ShareTypePercent, ShareValue: value}
+	}
+	amount := func(personID uint, value int64) item_schemas.Split {
+		return item_schemas.Split{PersonID: personID, ShareType: item_schemas.ShareTypeAmount, ShareValue: value}
+	}
+
+	// Bob pays 20%, the user and Alice share the rest
+	item, err := addItem(1000, item_schemas.ItemTypeMyPurchase, 0)
+	if err != nil {
+		return err
+	}
+	item, err = setSplits(item.ItemID, equal(alice.PersonID), percent(bob.PersonID, 2000), equal(0))
+	if err != nil {
+		return err
+	}
+	if len(item.Splits) != 3 || item.Splits[0].PersonID != 0 || item.Splits[1].PersonName != "Alice" {
+		return fmt.Errorf("The share of the user should go first, got %+v", item.Splits)
+	}
+	a, err := analytics()
+	if err != nil {
+		return err
+	}
+	if a.TotalSpent != 400 || a.Currencies[0].TotalSpent != 400 || a.TotalCalories != 400 {
+		return fmt.Errorf("The user should have spent 400 and eaten 400 kcal, got %d and %v", a.TotalSpent,
+			a.TotalCalories)
+	}
+	debts := map[uint]int64{}
+	for _, personAnalytics := range a.Persons {
+		debts[personAnalytics.PersonDB.PersonID] = personAnalytics.TotalDebt
+	}
+	if len(debts) != 2 || debts[alice.PersonID] != -400 || debts[bob.PersonID] != -200 {
+		return fmt.Errorf("Alice should owe 400 and Bob 200, got %+v", a.Persons)
+	}
+
+	// Kopecks left from rounding go to the first shares
+	cheap, err := addItem(100, item_schemas.ItemTypeMyPurchase, 0)
+	if err != nil {
+		return err
+	}
+	_, err = setSplits(cheap.ItemID, equal(0), equal(alice.PersonID), equal(bob.PersonID))
+	if err != nil {
+		return err
+	}
+	statement, err := ls.GetStatement(ctx, ledger_schemas.GetStatement{UserID: userDB.UserID, PersonID: alice.PersonID,
+		BaseCurrency: currency_schemas.CurrencyRUB})
+	if err != nil {
+		return err
+	}
+	paid := []int64{}
+	for _, entry := range statement.Entries {
+		paid = append(paid, entry.Paid)
+	}
+	if !slices.Equal(paid, []int64{400, 33}) || statement.Balance != -433 {
+		return fmt.Errorf("Alice should owe 400 and 33, got %v and %d", paid, statement.Balance)
+	}
+	_, err = setSplits(cheap.ItemID, percent(0, 3334), percent(alice.PersonID, 3333), percent(bob.PersonID, 3333))
+	if err != nil {
+		return err
+	}
+	balances, err := ls.GetBalances(ctx, ledger_schemas.GetBalances{UserID: userDB.UserID,
+		BaseCurrency: currency_schemas.CurrencyRUB})
+	if err != nil {
+		return err
+	}
+	if balances.Persons[0].Balance != -433 || balances.Persons[1].Balance != -233 {
+		return fmt.Errorf("Alice should owe 433 and Bob 233, got %+v", balances.Persons)
+	}
+
+	for _, splits := range [][]item_schemas.Split{
+		// More than the total
+		{amount(alice.PersonID, 60), amount(bob.PersonID, 50), equal(0)},
+		{percent(alice.PersonID, 6000), percent(bob.PersonID, 5000), equal(0)},
+		// Less than the total without equal shares
+		{percent(0, 5000), percent(alice.PersonID, 4000)},
+		{percent(0, 3333), percent(alice.PersonID, 3333), percent(bob.PersonID, 3333)},
+		{amount(0, 50), amount(alice.PersonID, 40)},
+		// The same person twice
+		{equal(alice.PersonID), equal(alice.PersonID)},
+		// A person of another user
+		{equal(0), equal(eve.PersonID)},
+	} {
+		_, err = setSplits(cheap.ItemID, splits...)
+		if !errors.Is(err, E.ErrUnprocessableEntity) {
+			return fmt.Errorf("Splits %+v should be refused, got %v", splits, err)
+		}
+	}
+	cheap, err = setSplits(cheap.ItemID, amount(0, 50), percent(alice.PersonID, 5000))
+	if err != nil {
+		return err
+	}
+	_, err = is.SetItemSplits(ctx, item_schemas.SetItemSplits{ItemID: cheap.ItemID, UserID: users[1].UserID})
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Splits of items of other users should be refused, got %v", err)
+	}
+
+	// A change that leaves the shares not adding up is refused as a whole
+	_, err = is.ChangeItem(ctx, item_schemas.ChangeItem{ItemID: cheap.ItemID, UserID: userDB.UserID, ItemCost: 40})
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("A cost below the fixed shares should be refused, got %v", err)
+	}
+	_, err = is.ChangeItem(ctx, item_schemas.ChangeItem{ItemID: cheap.ItemID, UserID: userDB.UserID,
+		ItemType: item_schemas.ItemTypeFromPersonPurchase, PersonID: alice.PersonID})
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Only purchases of the user should be split, got %v", err)
+	}
+	cheap, err = setSplits(cheap.ItemID)
+	if err != nil {
+		return err
+	}
+	if len(cheap.Splits) != 0 || cheap.ItemCost != 100 {
+		return fmt.Errorf("The splits should be removed and the cost kept, got %+v", cheap)
+	}
+
+	borrowed, err := addItem(500, item_schemas.ItemTypeFromPersonPurchase, alice.PersonID)
+	if err != nil {
+		return err
+	}
+	_, err = setSplits(borrowed.ItemID, equal(0), equal(bob.PersonID))
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Purchases of persons should not be split, got %v", err)
+	}
+
+	err = is.DeleteItem(ctx, item_schemas.DeleteItem{ItemID: item.ItemID, UserID: userDB.UserID})
+	if err != nil {
+		return err
+	}
+	statement, err = ls.GetStatement(ctx, ledger_schemas.GetStatement{UserID: userDB.UserID, PersonID: bob.PersonID,
+		BaseCurrency: currency_schemas.CurrencyRUB})
+	if err != nil {
+		return err
+	}
+	if len(statement.Entries) != 0 {
+		return fmt.Errorf("The shares of a deleted item should be gone, got %+v", statement.Entries)
+	}
+
+	// Splits are loaded for more items than SQLite binds variables
+	_, err = t.database.DB.Exec(`INSERT INTO items (user_id, product_id, item_date, item_cost, item_amount,
+            item_type, revision_id, item_unit, price_mode, currency)
+        WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 40000)
+        SELECT user_id, product_id, item_date, item_cost, item_amount,
+            item_type, revision_id, item_unit, price_mode, currency
+        FROM items, n WHERE item_id = ?`, cheap.ItemID)
+	if err != nil {
+		return err
+	}
+	var lastID uint
+	err = t.database.DB.QueryRow(`SELECT MAX(item_id) FROM items`).Scan(&lastID)
+	if err != nil {
+		return err
+	}
+	_, err = setSplits(lastID, equal(0), equal(bob.PersonID))
+	if err != nil {
+		return err
+	}
+	items, err := t.itemDB.GetItemsRange(ctx, item_schemas.GetItemsRange{
+		UserID:       userDB.UserID,
+		ItemDateFrom: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		ItemDateTo:   time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		return fmt.Errorf("Items with splits past the variable limit should load, got %v", err)
+	}
+	splits := 0
+	for _, itemParsed := range items {
+		splits += len(itemParsed.Splits)
+	}
+	if len(items) <= 40000 || splits != 2 {
+		return fmt.Errorf("Expected over 40000 items with 2 splits, got %d items with %d splits", len(items), splits)
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/handlers"
+	"github.com/bmg-c/product-diary/mailer"
+	"github.com/bmg-c/product-diary/ratelimit"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+	"github.com/bmg-c/product-diary/util"
+	"github.com/google/uuid"
+)
+
+func TestTOTP() error {
+	err := testTOTPCodes()
+	if err != nil {
+		return err
+	}
+
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	fm, err := mailer.NewFileMailer(filepath.Join(t.dir, "mail"), "test@product-diary.local")
+	if err != nil {
+		return err
+	}
+	us := services.NewUserService(t.userDB, t.txDB, fm)
+
+	passwordHash, err := util.HashPassword("password")
+	if err != nil {
+		return err
+	}
+	err = t.userDB.AddUser(ctx, user_schemas.AddUser{Username: "totp", Email: "totp@gmail.com", PasswordHash: passwordHash})
+	if err != nil {
+		return err
+	}
+	userDB, err := t.userDB.GetUser(ctx, user_schemas.GetUser{Email: "totp@gmail.com"})
+	if err != nil {
+		return err
+	}
+	userID := userDB.UserID
+	client := user_schemas.SessionClient{UserAgent: "tests", IP: "127.0.0.1"}
+	login := func() (user_schemas.LoginResult, error) {
+		return us.LoginUser(ctx, user_schemas.UserLogin{Email: "totp@gmail.com", Password: "password"}, client)
+	}
+	codeAt := func(secret string, offset time.Duration) string {
+		code, _ := util.TOTPCode(secret, util.TOTPStep(time.Now().Add(offset)))
+		return code
+	}
+
+	// Enrollment only counts once confirmed
+	enrollment, err := us.BeginTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	result, err := login()
+	if err != nil {
+		return err
+	}
+	if result.Pending2FA {
+		return fmt.Errorf("Unconfirmed two-factor setup should not be asked at login")
+	}
+	wrongCode := "000000"
+	if codeAt(enrollment.Secret, 0) == wrongCode {
+		wrongCode = "111111"
+	}
+	_, err = us.ConfirmTOTP(ctx, user_schemas.VerifyTOTP{UserID: userID, Code: wrongCode})
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Confirming with a wrong code should fail, got %v", err)
+	}
+	recoveryCodes, err := us.ConfirmTOTP(ctx, user_schemas.VerifyTOTP{UserID: userID, Code: codeAt(enrollment.Secret, 0)})
+	if err != nil {
+		return fmt.Errorf("Confirming two-factor setup failed: %v", err)
+	}
+	if len(recoveryCodes) != services.RecoveryCodeCount {
+		return fmt.Errorf("Expected %d recovery codes, got %d", services.RecoveryCodeCount, len(recoveryCodes))
+	}
+	_, err = us.BeginTOTP(ctx, userID)
+	if !errors.Is(err, E.ErrConflict) {
+		return fmt.Errorf("Beginning setup again should conflict once enabled, got %v", err)
+	}
+
+	// The password alone only gives a pending session
+	result, err = login()
+	if err != nil {
+		return err
+	}
+	if !result.Pending2FA {
+		return fmt.Errorf("Login should ask for the second factor")
+	}
+	_, err = us.GetUserBySession(ctx, result.SessionUUID)
+	if err == nil {
+		return fmt.Errorf("Pending session should not authenticate")
+	}
+	_, err = us.Login2FA(ctx, user_schemas.Login2FA{SessionUUID: result.SessionUUID, Code: codeAt(enrollment.Secret, 0)}, client)
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Code used to confirm the setup should not be accepted again, got %v", err)
+	}
+	nextCode := codeAt(enrollment.Secret, util.TOTPPeriod)
+	sessionUUID, err := us.Login2FA(ctx, user_schemas.Login2FA{SessionUUID: result.SessionUUID, Code: nextCode}, client)
+	if err != nil {
+		return fmt.Errorf("Login with the next code failed: %v", err)
+	}
+	_, err = us.GetUserBySession(ctx, sessionUUID)
+	if err != nil {
+		return fmt.Errorf("Session after the second factor should be valid, got %v", err)
+	}
+	_, err = us.Login2FA(ctx, user_schemas.Login2FA{SessionUUID: result.SessionUUID, Code: nextCode}, client)
+	if !errors.Is(err, E.ErrNotFound) {
+		return fmt.Errorf("Pending session should be gone after use, got %v", err)
+	}
+	_, err = us.Login2FA(ctx, user_schemas.Login2FA{SessionUUID: sessionUUID, Code: nextCode}, client)
+	if !errors.Is(err, E.ErrNotFound) {
+		return fmt.Errorf("Full session should not be accepted as pending, got %v", err)
+	}
+	_, err = us.Login2FA(ctx, user_schemas.Login2FA{SessionUUID: uuid.New(), Code: nextCode}, client)
+	if !errors.Is(err, E.ErrNotFound) {
+		return fmt.Errorf("Unknown pending session should not be found, got %v", err)
+	}
+
+	// Recovery codes work once
+	result, err = login()
+	if err != nil {
+		return err
+	}
+	_, err = us.Login2FA(ctx, user_schemas.Login2FA{SessionUUID: result.SessionUUID, Code: recoveryCodes[0]}, client)
+	if err != nil {
+		return fmt.Errorf("Login with a recovery code failed: %v", err)
+	}
+	result, err = login()
+	if err != nil {
+		return err
+	}
+	_, err = us.Login2FA(ctx, user_schemas.Login2FA{SessionUUID: result.SessionUUID, Code: recoveryCodes[0]}, client)
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Used recovery code should be refused, got %v", err)
+	}
+	status, err := us.GetTOTPStatus(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !status.Enabled || status.RecoveryCodesLeft != services.RecoveryCodeCount-1 {
+		return fmt.Errorf("Expected %d recovery codes left, got %+v", services.RecoveryCodeCount-1, status)
+	}
+
+	// Pending sessions expire
+	_, err = t.database.DB.Exec(`UPDATE sessions SET expires_at = datetime('now', '-1 minute') WHERE session_uuid = ?`,
+		result.SessionUUID)
+	if err != nil {
+		return err
+	}
+	_, err = us.Login2FA(ctx, user_schemas.Login2FA{SessionUUID: result.SessionUUID, Code: recoveryCodes[1]}, client)
+	if !errors.Is(err, E.ErrNotFound) {
+		return fmt.Errorf("Expired pending session should not be found, got %v", err)
+	}
+
+	// New recovery codes replace the old ones
+	_, err = us.RegenerateRecoveryCodes(ctx, user_schemas.VerifyTOTP{UserID: userID, Code: recoveryCodes[1]})
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Recovery codes should not create new recovery codes, got %v", err)
+	}
+	_, err = us.RegenerateRecoveryCodes(ctx, user_schemas.VerifyTOTP{UserID: userID, Code: codeAt(enrollment.Secret, -util.TOTPPeriod)})
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Code older than the last used one should be refused, got %v", err)
+	}
+	_, err = us.RegenerateRecoveryCodes(ctx, user_schemas.VerifyTOTP{UserID: userID, Code: codeAt(enrollment.Secret, 2*util.TOTPPeriod)})
+	if err == nil {
+		return fmt.Errorf("Code outside of the allowed skew should be refused")
+	}
+	// Sleeping a step is too slow for the startup tests, so the last used step
+	// is moved back instead
+	_, err = t.database.DB.Exec(`UPDATE totp_secrets SET last_used_step = ? WHERE user_id = ?`,
+		util.TOTPStep(time.Now())-1, userID)
+	if err != nil {
+		return err
+	}
+	newCodes, err := us.RegenerateRecoveryCodes(ctx, user_schemas.VerifyTOTP{UserID: userID, Code: codeAt(enrollment.Secret, 0)})
+	if err != nil {
+		return fmt.Errorf("Creating new recovery codes failed: %v", err)
+	}
+	result, err = login()
+	if err != nil {
+		return err
+	}
+	_, err = us.Login2FA(ctx, user_schemas.Login2FA{SessionUUID: result.SessionUUID, Code: recoveryCodes[2]}, client)
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Replaced recovery code should be refused, got %v", err)
+	}
+
+	err = testLogin2FALockout(us)
+	if err != nil {
+		return err
+	}
+
+	// Disabling works with a recovery code, for a lost phone
+	err = us.DisableTOTP(ctx, user_schemas.VerifyTOTP{UserID: userID, Code: "AAAAA-AAAAA"})
+	if !errors.Is(err, E.ErrUnprocessableEntity) {
+		return fmt.Errorf("Disabling with a wrong code should fail, got %v", err)
+	}
+	err = us.DisableTOTP(ctx, user_schemas.VerifyTOTP{UserID: userID, Code: newCodes[0]})
+	if err != nil {
+		return fmt.Errorf("Disabling with a recovery code failed: %v", err)
+	}
+	result, err = login()
+	if err != nil {
+		return err
+	}
+	if result.Pending2FA {
+		return fmt.Errorf("Login should not ask for the second factor once disabled")
+	}
+	left, err := t.userDB.CountRecoveryCodes(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if left != 0 {
+		return fmt.Errorf("Recovery codes should be deleted with the secret, %d left", left)
+	}
+
+	return nil
+}
+
+// testLogin2FALockout checks that wrong second factors lock out the user and
+// that a new login with the password, even from another address, does not give
+// more guesses.
+func testLogin2FALockout(us *services.UserService) error {
+	guard := ratelimit.NewGuard(ratelimit.NewMemoryStore(),
+		ratelimit.Limit{Burst: 100, Every: time.Second},
+		ratelimit.LockoutPolicy{Threshold: 3, Base: time.Minute, Max: time.Hour, Reset: time.Hour},
+	)
+	uh := handlers.NewUserHandler(us, guard, guard)
+	post := func(h http.HandlerFunc, ip string, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/users/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+	pendingSession := regexp.MustCompile(`name="pending_session" value="([^"]+)"`)
+	login := func(ip string) (string, error) {
+		w := post(uh.HandleLoginLogin, ip, url.Values{"email": {"totp@gmail.com"}, "password": {"password"}})
+		match := pendingSession.FindStringSubmatch(w.Body.String())
+		if w.Code != http.StatusOK || match == nil {
+			return "", fmt.Errorf("Login should ask for the second factor, got %d", w.Code)
+		}
+		return match[1], nil
+	}
+
+	sessionUUID, err := login("192.0.2.1")
+	if err != nil {
+		return err
+	}
+	codes := []int{http.StatusUnprocessableEntity, http.StatusUnprocessableEntity, http.StatusTooManyRequests}
+	for i, want := range codes {
+		w := post(uh.HandleLogin2FA, "192.0.2.1", url.Values{"pending_session": {sessionUUID}, "code": {"AAAAA-AAAAA"}})
+		if w.Code != want {
+			return fmt.Errorf("Wrong second factor %d should get %d, got %d", i+1, want, w.Code)
+		}
+	}
+	sessionUUID, err = login("192.0.2.2")
+	if err != nil {
+		return err
+	}
+	w := post(uh.HandleLogin2FA, "192.0.2.2", url.Values{"pending_session": {sessionUUID}, "code": {"AAAAA-AAAAA"}})
+	if w.Code != http.StatusTooManyRequests {
+		return fmt.Errorf("New pending session should still be locked out, got %d", w.Code)
+	}
+	return nil
+}
+
+// testTOTPCodes checks the code generation against RFC 6238.
+func testTOTPCodes() error {
+	// "12345678901234567890" in base32
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := util.TOTPCode(secret, util.TOTPStep(time.Unix(unix, 0)))
+		if err != nil {
+			return err
+		}
+		if code != want {
+			return fmt.Errorf("TOTP code at %d should be %s, got %s", unix, want, code)
+		}
+	}
+
+	now := time.Unix(59, 0)
+	step, valid := util.VerifyTOTP(secret, "287082", now, 0)
+	if !valid || step != 1 {
+		return fmt.Errorf("Current code should be valid")
+	}
+	_, valid = util.VerifyTOTP(secret, "287082", now, step)
+	if valid {
+		return fmt.Errorf("Code of an already used step should be refused")
+	}
+	_, valid = util.VerifyTOTP(secret, "287082", now.Add(3*util.TOTPPeriod), 0)
+	if valid {
+		return fmt.Errorf("Code of an old step should be refused")
+	}
+
+	recoveryCode, err := util.GenerateRecoveryCode()
+	if err != nil {
+		return err
+	}
+	if len(util.NormalizeRecoveryCode(recoveryCode)) != util.RecoveryCodeLength {
+		return fmt.Errorf("Recovery code %s has the wrong length", recoveryCode)
+	}
+	return nil
+}
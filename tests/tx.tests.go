@@ -0,0 +1,293 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bmg-c/product-diary/db"
+	"github.com/bmg-c/product-diary/db/currency_db"
+	"github.com/bmg-c/product-diary/db/item_db"
+	"github.com/bmg-c/product-diary/db/ledger_db"
+	"github.com/bmg-c/product-diary/db/migrations"
+	"github.com/bmg-c/product-diary/db/product_db"
+	"github.com/bmg-c/product-diary/db/ratelimit_db"
+	"github.com/bmg-c/product-diary/db/tx_db"
+	"github.com/bmg-c/product-diary/db/user_db"
+	"github.com/bmg-c/product-diary/schemas/currency_schemas"
+	"github.com/bmg-c/product-diary/schemas/item_schemas"
+	"github.com/bmg-c/product-diary/schemas/ledger_schemas"
+	"github.com/bmg-c/product-diary/schemas/product_schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+)
+
+type testDB struct {
+	database   *db.Database
+	userDB     *user_db.UserDB
+	productDB  *product_db.ProductDB
+	itemDB     *item_db.ItemDB
+	currencyDB *currency_db.CurrencyDB
+	ledgerDB   *ledger_db.LedgerDB
+	txDB       *tx_db.TxDB
+	limitDB    *ratelimit_db.RateLimitDB
+	dir        string
+}
+
+// newTestDB creates a migrated database in a temporary directory.
+func newTestDB() (*testDB, error) {
+	dir, err := os.MkdirTemp("", "product-diary-test-")
+	if err != nil {
+		return nil, err
+	}
+	database, err := db.NewDatabase(filepath.Join(dir, "test.db"), 0)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	t := &testDB{database: database, dir: dir}
+	err = database.Migrate()
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+
+	stores := map[string]*db.Store{}
+	for _, tableName := range []string{"users", "codes", "sessions", "persons", "products", "items", "rate_limits", "lockouts", "password_resets", "totp_secrets", "recovery_codes", "api_tokens", "product_revisions", "product_search", "currency_rates", "payments", "item_splits"} {
+		stores[tableName], err = database.NewStore(tableName)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	t.userDB, err = user_db.NewUserDB(stores["users"], stores["codes"], stores["sessions"], stores["persons"],
+		stores["password_resets"], stores["totp_secrets"], stores["recovery_codes"], stores["api_tokens"])
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	t.productDB, err = product_db.NewProductDB(stores["products"], stores["product_revisions"], stores["users"], stores["product_search"])
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	t.itemDB, err = item_db.NewItemDB(stores["items"], stores["product_revisions"], stores["persons"], stores["product_search"],
+		stores["item_splits"])
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	t.currencyDB, err = currency_db.NewCurrencyDB(stores["currency_rates"])
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	t.ledgerDB, err = ledger_db.NewLedgerDB(stores["payments"], stores["persons"])
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	t.txDB, err = tx_db.NewTxDB(database, t.userDB, t.productDB, t.itemDB, t.currencyDB, t.ledgerDB)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	t.limitDB, err = ratelimit_db.NewRateLimitDB(stores["rate_limits"], stores["lockouts"])
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// newMigratedDB creates a database in a temporary directory migrated up to
+// version and runs queries on it, to test a later migration on the rows
+// written before it. Only the database of the testDB is set.
+func newMigratedDB(version uint, queries ...string) (*testDB, error) {
+	dir, err := os.MkdirTemp("", "product-diary-test-")
+	if err != nil {
+		return nil, err
+	}
+	database, err := db.NewDatabase(filepath.Join(dir, "test.db"), 0)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	t := &testDB{database: database, dir: dir}
+	err = migrations.MigrateTo(database.DB, version)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	for _, query := range queries {
+		_, err = database.DB.Exec(query)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *testDB) Close() {
+	t.database.Close()
+	os.RemoveAll(t.dir)
+}
+
+func (t *testDB) count(tableName string) (int, error) {
+	var count int
+	err := t.database.DB.QueryRow(`SELECT COUNT(*) FROM ` + tableName).Scan(&count)
+	return count, err
+}
+
+func TestTransactions() error {
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	// Explicit error after several writes
+	errForced := errors.New("forced failure")
+	err = t.txDB.WithTx(ctx, func(tx services.Tx) error {
+		err := tx.UserDB().AddUser(ctx, user_schemas.AddUser{
+			Username: "rollback",
+			Email:    "rollback@gmail.com",
+		})
+		if err != nil {
+			return err
+		}
+		userDB, err := tx.UserDB().GetUser(ctx, user_schemas.GetUser{Email: "rollback@gmail.com"})
+		if err != nil {
+			return err
+		}
+		_, err = tx.ProductDB().AddProduct(ctx, product_schemas.AddProduct{
+			ProductTitle: "Rollback product",
+			ProductType:  product_schemas.ProductTypeFood,
+			UserID:       userDB.UserID,
+		})
+		if err != nil {
+			return err
+		}
+		return errForced
+	})
+	if !errors.Is(err, errForced) {
+		return fmt.Errorf("WithTx should return the error of the callback, got %v", err)
+	}
+	for _, tableName := range []string{"users", "products"} {
+		count, err := t.count(tableName)
+		if err != nil {
+			return err
+		}
+		if count != 0 {
+			return fmt.Errorf("Rolled back transaction left %d rows in %s", count, tableName)
+		}
+	}
+
+	// Failing statement in the middle of the transaction
+	err = t.txDB.WithTx(ctx, func(tx services.Tx) error {
+		err := tx.UserDB().AddUser(ctx, user_schemas.AddUser{
+			Username: "partial",
+			Email:    "partial@gmail.com",
+		})
+		if err != nil {
+			return err
+		}
+		userDB, err := tx.UserDB().GetUser(ctx, user_schemas.GetUser{Email: "partial@gmail.com"})
+		if err != nil {
+			return err
+		}
+		productDB, err := tx.ProductDB().AddProduct(ctx, product_schemas.AddProduct{
+			ProductTitle: "Partial product",
+			ProductType:  product_schemas.ProductTypeFood,
+			UserID:       userDB.UserID,
+		})
+		if err != nil {
+			return err
+		}
+		// Unknown person violates the foreign key
+		_, err = tx.ItemDB().AddItem(ctx, item_schemas.AddItem{
+			UserID:    userDB.UserID,
+			ProductID: productDB.ProductID,
+			ItemDate:  time.Now(),
+			PersonID:  1000,
+		})
+		return err
+	})
+	if err == nil {
+		return fmt.Errorf("Adding an item with unknown person should fail")
+	}
+	for _, tableName := range []string{"users", "products", "items"} {
+		count, err := t.count(tableName)
+		if err != nil {
+			return err
+		}
+		if count != 0 {
+			return fmt.Errorf("Partially failed transaction left %d rows in %s", count, tableName)
+		}
+	}
+
+	// Successful transaction is committed
+	err = t.txDB.WithTx(ctx, func(tx services.Tx) error {
+		return tx.UserDB().AddUser(ctx, user_schemas.AddUser{
+			Username: "commit",
+			Email:    "commit@gmail.com",
+		})
+	})
+	if err != nil {
+		return err
+	}
+	count, err := t.count("users")
+	if err != nil {
+		return err
+	}
+	if count != 1 {
+		return fmt.Errorf("Committed transaction should leave 1 user, got %d", count)
+	}
+
+	// A payment is rolled back with the writes that follow it
+	userDB, err := t.userDB.GetUser(ctx, user_schemas.GetUser{Email: "commit@gmail.com"})
+	if err != nil {
+		return err
+	}
+	personDB, err := t.userDB.AddPerson(ctx, user_schemas.GetPerson{UserID: userDB.UserID, PersonName: "Payee"})
+	if err != nil {
+		return err
+	}
+	err = t.txDB.WithTx(ctx, func(tx services.Tx) error {
+		_, err := tx.LedgerDB().AddPayment(ctx, ledger_schemas.AddPayment{
+			UserID:      userDB.UserID,
+			PersonID:    personDB.PersonID,
+			PaymentDate: time.Now(),
+			PaymentType: ledger_schemas.PaymentTypeToPerson,
+			Amount:      1000,
+			Currency:    currency_schemas.CurrencyRUB,
+		})
+		if err != nil {
+			return err
+		}
+		// Unknown product violates the foreign key
+		_, err = tx.ItemDB().AddItem(ctx, item_schemas.AddItem{
+			UserID:    userDB.UserID,
+			ProductID: 1000,
+			ItemDate:  time.Now(),
+		})
+		return err
+	})
+	if err == nil {
+		return fmt.Errorf("Adding an item of an unknown product should fail")
+	}
+	count, err = t.count("payments")
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		return fmt.Errorf("Rolled back transaction left %d payments", count)
+	}
+
+	return nil
+}
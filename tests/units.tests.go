@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+	"github.com/bmg-c/product-diary/schemas/currency_schemas"
+	"github.com/bmg-c/product-diary/schemas/item_schemas"
+	"github.com/bmg-c/product-diary/schemas/product_schemas"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
+	"github.com/bmg-c/product-diary/services"
+)
+
+func TestUnits() error {
+	err := testConversions()
+	if err != nil {
+		return err
+	}
+
+	t, err := newTestDB()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	ctx := context.Background()
+
+	ps := services.NewProductService(t.productDB)
+	is := services.NewItemService(t.itemDB, t.currencyDB, t.txDB)
+
+	err = t.userDB.AddUser(ctx, user_schemas.AddUser{Username: "weigher", Email: "weigher@gmail.com"})
+	if err != nil {
+		return err
+	}
+	userDB, err := t.userDB.GetUser(ctx, user_schemas.GetUser{Email: "weigher@gmail.com"})
+	if err != nil {
+		return err
+	}
+	kefir, err := ps.AddProduct(ctx, product_schemas.AddProduct{
+		ProductTitle: "Kefir", ProductType: product_schemas.ProductTypeFood, ProductCalories: 200,
+		NetQuantity: 250, NetUnit: product_schemas.UnitMilliliter, Density: 1.2, UserID: userDB.UserID,
+	})
+	if err != nil {
+		return err
+	}
+	// Without a measure a piece is 100 g
+	bread, err := ps.AddProduct(ctx, product_schemas.AddProduct{
+		ProductTitle: "Bread", ProductType: product_schemas.ProductTypeFood, ProductCalories: 50, UserID: userDB.UserID,
+	})
+	if err != nil {
+		return err
+	}
+	if bread.Measure() != product_schemas.DefaultMeasure {
+		return fmt.Errorf("A product without a measure should get %+v, got %+v",
+			product_schemas.DefaultMeasure, bread.Measure())
+	}
+
+	date := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for _, item := range []item_schemas.AddItem{
+		// 600 kcal each
+		{ProductID: kefir.ProductID, ItemAmount: 300, ItemUnit: product_schemas.UnitGram},
+		{ProductID: kefir.ProductID, ItemAmount: 0.3, ItemUnit: product_schemas.UnitKilogram},
+		{ProductID: kefir.ProductID, ItemAmount: 250, ItemUnit: product_schemas.UnitMilliliter},
+		{ProductID: kefir.ProductID, ItemAmount: 0.25, ItemUnit: product_schemas.UnitLiter},
+		{ProductID: kefir.ProductID, ItemAmount: 1},
+		// 100 kcal
+		{ProductID: bread.ProductID, ItemAmount: 2},
+	} {
+		item.UserID = userDB.UserID
+		item.ItemDate = date
+		_, err = is.AddItem(ctx, item)
+		if err != nil {
+			return err
+		}
+	}
+	items, err := is.GetItems(ctx, item_schemas.GetItems{UserID: userDB.UserID, ItemDate: date})
+	if err != nil {
+		return err
+	}
+	if items[4].ItemUnit != product_schemas.UnitPiece {
+		return fmt.Errorf("Items should count in pieces unless given, got unit %d", items[4].ItemUnit)
+	}
+	a, err := is.GetAnalytics(ctx, userDB.UserID, currency_schemas.DefaultCurrency, items)
+	if err != nil {
+		return err
+	}
+	if math.Abs(float64(a.TotalCalories)-3100) > 0.01 {
+		return fmt.Errorf("The items should have 3100 kcal, got %v", a.TotalCalories)
+	}
+	return nil
+}
+
+func testConversions() error {
+	us := services.NewUnitService()
+	milk := product_schemas.Measure{NetQuantity: 900, NetUnit: product_schemas.UnitMilliliter, Density: 1.03}
+	cheese := product_schemas.Measure{NetQuantity: 200, NetUnit: product_schemas.UnitGram, Density: 1.1}
+	for _, c := range []struct {
+		amount float32
+		unit   uint8
+		m      product_schemas.Measure
+		grams  float32
+	}{
+		{150, product_schemas.UnitGram, cheese, 150},
+		{1.5, product_schemas.UnitKilogram, cheese, 1500},
+		{100, product_schemas.UnitMilliliter, milk, 103},
+		{0.5, product_schemas.UnitLiter, milk, 515},
+		{2, product_schemas.UnitPiece, cheese, 400},
+		{2, product_schemas.UnitPiece, milk, 1854},
+	} {
+		grams, err := us.ToGrams(c.amount, c.unit, c.m)
+		if err != nil {
+			return err
+		}
+		if math.Abs(float64(grams-c.grams)) > 0.01 {
+			return fmt.Errorf("%v of unit %d should be %v g, got %v", c.amount, c.unit, c.grams, grams)
+		}
+	}
+
+	for _, c := range []struct {
+		amount float32
+		from   uint8
+		to     uint8
+		m      product_schemas.Measure
+		want   float32
+	}{
+		{500, product_schemas.UnitGram, product_schemas.UnitKilogram, cheese, 0.5},
+		{1, product_schemas.UnitLiter, product_schemas.UnitMilliliter, milk, 1000},
+		{1030, product_schemas.UnitGram, product_schemas.UnitLiter, milk, 1},
+		{3, product_schemas.UnitPiece, product_schemas.UnitLiter, milk, 2.7},
+		{1, product_schemas.UnitKilogram, product_schemas.UnitPiece, cheese, 5},
+	} {
+		got, err := us.Convert(c.amount, c.from, c.to, c.m)
+		if err != nil {
+			return err
+		}
+		if math.Abs(float64(got-c.want)) > 0.001 {
+			return fmt.Errorf("%v of unit %d should be %v of unit %d, got %v", c.amount, c.from, c.want, c.to, got)
+		}
+	}
+
+	// Measures that do not convert
+	for _, c := range []struct {
+		unit uint8
+		m    product_schemas.Measure
+	}{
+		{product_schemas.UnitMilliliter, product_schemas.Measure{NetQuantity: 100, NetUnit: product_schemas.UnitGram}},
+		{product_schemas.UnitPiece, product_schemas.Measure{NetUnit: product_schemas.UnitGram, Density: 1}},
+		{product_schemas.UnitPiece, product_schemas.Measure{NetQuantity: 1, NetUnit: product_schemas.UnitPiece, Density: 1}},
+		{0, cheese},
+		{product_schemas.UnitPiece + 1, cheese},
+	} {
+		_, err := us.ToGrams(1, c.unit, c.m)
+		if !errors.Is(err, E.ErrUnprocessableEntity) {
+			return fmt.Errorf("Unit %d of %+v should not convert, got %v", c.unit, c.m, err)
+		}
+	}
+	return nil
+}
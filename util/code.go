@@ -0,0 +1,81 @@
+package util
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"strings"
+)
+
+// TokenBytes is the entropy of tokens from GenerateToken, encoded to
+// schemas.DefRV.ResetTokenLength characters.
+const TokenBytes = 32
+
+// CodeAlphabet matches schemas.DefRV.CodeRegex.
+const CodeAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// GenerateCode returns a cryptographically random confirmation code.
+func GenerateCode(length uint16) (string, error) {
+	max := big.NewInt(int64(len(CodeAlphabet)))
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = CodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// GenerateToken returns a random URL safe token for links sent by mail.
+func GenerateToken() (string, error) {
+	token := make([]byte, TokenBytes)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// HashToken returns the hex SHA-256 of a token. Tokens are random enough that
+// they do not need a salted slow hash like passwords.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RecoveryCodeLength is the length of recovery codes without the dash that
+// splits them in two for reading.
+const RecoveryCodeLength = 10
+
+// GenerateRecoveryCode returns a code like "AB3DE-F6HJK".
+func GenerateRecoveryCode() (string, error) {
+	code, err := GenerateCode(RecoveryCodeLength)
+	if err != nil {
+		return "", err
+	}
+	return code[:RecoveryCodeLength/2] + "-" + code[RecoveryCodeLength/2:], nil
+}
+
+// NormalizeRecoveryCode drops separators and case, so that codes can be typed
+// as the user likes.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// APITokenPrefix marks personal access tokens, so that leaked ones are easy
+// to recognize.
+const APITokenPrefix = "pd_"
+
+// GenerateAPIToken returns a random personal access token.
+func GenerateAPIToken() (string, error) {
+	token, err := GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	return APITokenPrefix + token, nil
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	E "github.com/bmg-c/product-diary/errorhandler"
+)
+
+// GetMinorFromString parses an amount of money like "12.34" or "12,34" into
+// minor units, 1234. Parsing is done on the digits, so no cents are lost to
+// floating point.
+func GetMinorFromString(str string) (int64, error) {
+	str = strings.TrimSpace(strings.Replace(str, ",", ".", 1))
+	whole, fraction, _ := strings.Cut(str, ".")
+	if whole == "" && fraction == "" {
+		return 0, E.ErrUnprocessableEntity
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	if len(fraction) > 2 {
+		return 0, E.ErrUnprocessableEntity
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+	for _, s := range []string{whole, fraction} {
+		for _, c := range s {
+			if c < '0' || c > '9' {
+				return 0, E.ErrUnprocessableEntity
+			}
+		}
+	}
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || major > (1<<63-1)/100-1 {
+		return 0, E.ErrUnprocessableEntity
+	}
+	minor, _ := strconv.ParseInt(fraction, 10, 64)
+	return major*100 + minor, nil
+}
+
+// FormatMinor is the reverse of GetMinorFromString, 1234 is "12.34".
+func FormatMinor(v int64) string {
+	sign := ""
+	u := uint64(v)
+	if v < 0 {
+		sign = "-"
+		u = uint64(-v)
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, u/100, u%100)
+}
@@ -0,0 +1,94 @@
+package util
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Changing any of these makes VerifyPassword report that stored hashes need
+// to be recomputed on the next successful login.
+const (
+	PasswordHashAlgorithm  = "pbkdf2_sha256"
+	PasswordHashIterations = 600000
+	PasswordSaltLength     = 16
+	PasswordKeyLength      = 32
+)
+
+// dummyPasswordHash is verified against when there is no user to compare
+// with, so that a missing account takes as long as a wrong password.
+var (
+	dummyPasswordHash     string
+	dummyPasswordHashOnce sync.Once
+)
+
+// HashPassword returns an encoded "algorithm$iterations$salt$key" string.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, PasswordSaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := pbkdf2.Key([]byte(password), salt, PasswordHashIterations, PasswordKeyLength, sha256.New)
+
+	return fmt.Sprintf("%s$%d$%s$%s",
+		PasswordHashAlgorithm,
+		PasswordHashIterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifyPassword compares password with an encoded hash in constant time. The
+// second return value is true when the hash was made with outdated parameters.
+func VerifyPassword(password string, encoded string) (bool, bool) {
+	iterations, salt, key, err := parsePasswordHash(encoded)
+	if err != nil {
+		return false, false
+	}
+	derived := pbkdf2.Key([]byte(password), salt, iterations, len(key), sha256.New)
+	if subtle.ConstantTimeCompare(derived, key) != 1 {
+		return false, false
+	}
+
+	needsRehash := iterations != PasswordHashIterations ||
+		len(salt) != PasswordSaltLength ||
+		len(key) != PasswordKeyLength
+	return true, needsRehash
+}
+
+// VerifyNoPassword burns the same amount of time as VerifyPassword.
+func VerifyNoPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = HashPassword("dummy-password")
+	})
+	VerifyPassword(password, dummyPasswordHash)
+}
+
+func parsePasswordHash(encoded string) (int, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != PasswordHashAlgorithm {
+		return 0, nil, nil, fmt.Errorf("Unknown password hash format")
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return 0, nil, nil, fmt.Errorf("Invalid password hash iterations")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return 0, nil, nil, fmt.Errorf("Invalid password hash key")
+	}
+
+	return iterations, salt, key, nil
+}
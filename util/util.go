@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/a-h/templ"
 	E "github.com/bmg-c/product-diary/errorhandler"
 	"github.com/bmg-c/product-diary/localization"
+	"github.com/bmg-c/product-diary/schemas/user_schemas"
 	"github.com/google/uuid"
 	"github.com/mattn/go-sqlite3"
 )
@@ -109,6 +112,23 @@ func DeleteUserSessionCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &cookie)
 }
 
+// GetSessionClient describes the client of the request for the session list.
+// The address is taken from the connection, forwarding headers are not trusted.
+func GetSessionClient(r *http.Request) user_schemas.SessionClient {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	return user_schemas.SessionClient{
+		UserAgent: strings.ToValidUTF8(userAgent, ""),
+		IP:        ip,
+	}
+}
+
 func SetLocaleCookie(w http.ResponseWriter, locale localization.Locale) {
 	cookie := http.Cookie{
 		Name:     "locale",
@@ -161,6 +181,16 @@ func IsErrorSQL(err error, sqlErr error) bool {
 	return false
 }
 
+// IsErrorSQLUnique tells a UNIQUE violation apart from the other constraints
+// that IsErrorSQL matches with sqlite3.ErrConstraint.
+func IsErrorSQLUnique(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	return false
+}
+
 func GetUintFromString(str string) (uint, error) {
 	ui, err := strconv.ParseUint(str, 10, 0)
 	if err != nil {